@@ -0,0 +1,406 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bodgit/sevenzip"
+)
+
+// 支持浏览的归档类型
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+	archive7z    = "7z"
+)
+
+// 最多缓存的归档索引数量
+const maxCachedArchives = 64
+
+// archiveKind 根据文件名判断归档类型，不是归档时返回空字符串
+func archiveKind(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return archiveTarGz
+	case strings.HasSuffix(lower, ".tar"):
+		return archiveTar
+	case strings.HasSuffix(lower, ".zip"), strings.HasSuffix(lower, ".jar"):
+		return archiveZip
+	case strings.HasSuffix(lower, ".7z"):
+		return archive7z
+	}
+	return ""
+}
+
+// splitArchivePath 拆分归档虚拟路径，如 /dist/build.zip!/logs/app.log
+// 返回归档文件路径和归档内路径（/ 分隔，不带前导 /，归档根目录为空字符串）
+func splitArchivePath(fullPath string) (string, string, bool) {
+	for i := 0; i < len(fullPath); i++ {
+		if fullPath[i] != '!' {
+			continue
+		}
+		if i+1 < len(fullPath) && fullPath[i+1] != '/' && fullPath[i+1] != filepath.Separator {
+			continue
+		}
+		if archiveKind(fullPath[:i]) == "" {
+			continue
+		}
+		return fullPath[:i], cleanEntryName(fullPath[i+1:]), true
+	}
+	return "", "", false
+}
+
+// cleanEntryName 规范化归档内的路径，去掉 .. 和前导 /
+func cleanEntryName(name string) string {
+	name = filepath.ToSlash(name)
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// archiveEntry 归档内的条目，实现 os.FileInfo
+type archiveEntry struct {
+	name    string // 归档内路径
+	isDir   bool
+	size    int64
+	modTime time.Time
+	offset  int64 // 数据在归档文件中的偏移（仅未压缩的 tar）
+}
+
+func (e *archiveEntry) Name() string       { return path.Base(e.name) }
+func (e *archiveEntry) Size() int64        { return e.size }
+func (e *archiveEntry) ModTime() time.Time { return e.modTime }
+func (e *archiveEntry) IsDir() bool        { return e.isDir }
+func (e *archiveEntry) Sys() interface{}   { return nil }
+
+// Mode 返回文件模式
+func (e *archiveEntry) Mode() os.FileMode {
+	if e.isDir {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+
+// archiveIndex 归档的目录索引
+type archiveIndex struct {
+	kind     string
+	size     int64     // 归档文件大小，用于判断缓存是否失效
+	modTime  time.Time // 归档文件修改时间
+	entries  map[string]*archiveEntry
+	children map[string][]*archiveEntry
+}
+
+// add 添加条目，并补全缺失的父目录
+func (idx *archiveIndex) add(entry *archiveEntry) {
+	entry.name = cleanEntryName(entry.name)
+	if entry.name == "" {
+		return
+	}
+	idx.entries[entry.name] = entry
+
+	for dir := path.Dir(entry.name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := idx.entries[dir]; ok {
+			break
+		}
+		idx.entries[dir] = &archiveEntry{name: dir, isDir: true, modTime: entry.modTime}
+	}
+}
+
+// buildChildren 建立目录到子条目的映射
+func (idx *archiveIndex) buildChildren() {
+	idx.children = make(map[string][]*archiveEntry)
+	for name, entry := range idx.entries {
+		parent := path.Dir(name)
+		if parent == "." {
+			parent = ""
+		}
+		idx.children[parent] = append(idx.children[parent], entry)
+	}
+}
+
+// archiveCache 归档索引缓存（tar.gz 需要完整解压才能列出目录，缓存后避免重复解压）
+type archiveCache struct {
+	mu      sync.Mutex
+	indexes map[string]*archiveIndex
+}
+
+// newArchiveCache 创建归档索引缓存
+func newArchiveCache() *archiveCache {
+	return &archiveCache{indexes: make(map[string]*archiveIndex)}
+}
+
+// get 获取归档索引，归档文件变化后重新建立
+func (c *archiveCache) get(archivePath string) (*archiveIndex, error) {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("not an archive file")
+	}
+
+	c.mu.Lock()
+	idx, ok := c.indexes[archivePath]
+	c.mu.Unlock()
+	if ok && idx.size == info.Size() && idx.modTime.Equal(info.ModTime()) {
+		return idx, nil
+	}
+
+	idx, err = buildArchiveIndex(archivePath, archiveKind(archivePath))
+	if err != nil {
+		return nil, err
+	}
+	idx.size = info.Size()
+	idx.modTime = info.ModTime()
+
+	c.mu.Lock()
+	if len(c.indexes) >= maxCachedArchives {
+		// 缓存已满时随机淘汰一个
+		for key := range c.indexes {
+			delete(c.indexes, key)
+			break
+		}
+	}
+	c.indexes[archivePath] = idx
+	c.mu.Unlock()
+
+	return idx, nil
+}
+
+// list 列出归档内目录的子条目
+func (c *archiveCache) list(archivePath, dir string) ([]*archiveEntry, error) {
+	idx, err := c.get(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir != "" {
+		entry, ok := idx.entries[dir]
+		if !ok {
+			return nil, &fs.PathError{Op: "readdir", Path: dir, Err: fs.ErrNotExist}
+		}
+		if !entry.isDir {
+			return nil, fmt.Errorf("not a directory")
+		}
+	}
+	return idx.children[dir], nil
+}
+
+// open 打开归档内的文件
+func (c *archiveCache) open(archivePath, name string) (File, os.FileInfo, error) {
+	idx, err := c.get(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry, ok := idx.entries[name]
+	if !ok {
+		return nil, nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.isDir {
+		return nil, nil, errIsDirectory
+	}
+
+	// 未压缩的 tar 可以直接按偏移随机读取
+	if idx.kind == archiveTar {
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &sectionFile{SectionReader: io.NewSectionReader(file, entry.offset, entry.size), file: file}, entry, nil
+	}
+
+	file, err := newStreamFile(func() (io.ReadCloser, error) {
+		return openArchiveStream(archivePath, idx.kind, name)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, entry, nil
+}
+
+// buildArchiveIndex 读取归档目录建立索引
+func buildArchiveIndex(archivePath, kind string) (*archiveIndex, error) {
+	idx := &archiveIndex{kind: kind, entries: make(map[string]*archiveEntry)}
+
+	switch kind {
+	case archiveZip:
+		zr, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+
+		for _, f := range zr.File {
+			idx.add(&archiveEntry{
+				name:    f.Name,
+				isDir:   f.FileInfo().IsDir(),
+				size:    int64(f.UncompressedSize64),
+				modTime: f.Modified,
+			})
+		}
+
+	case archive7z:
+		zr, err := sevenzip.OpenReader(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+
+		for _, f := range zr.File {
+			idx.add(&archiveEntry{
+				name:    f.Name,
+				isDir:   f.FileInfo().IsDir(),
+				size:    int64(f.UncompressedSize),
+				modTime: f.Modified,
+			})
+		}
+
+	case archiveTar, archiveTarGz:
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		var r io.Reader = file
+		if kind == archiveTarGz {
+			gz, err := gzip.NewReader(file)
+			if err != nil {
+				return nil, err
+			}
+			defer gz.Close()
+			r = gz
+		}
+
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir {
+				continue
+			}
+
+			entry := &archiveEntry{
+				name:    hdr.Name,
+				isDir:   hdr.Typeflag == tar.TypeDir,
+				size:    hdr.Size,
+				modTime: hdr.ModTime,
+			}
+			if kind == archiveTar {
+				// tar.Reader 不会预读，当前位置即为数据起始位置
+				if entry.offset, err = file.Seek(0, io.SeekCurrent); err != nil {
+					return nil, err
+				}
+			}
+			idx.add(entry)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported archive type")
+	}
+
+	idx.buildChildren()
+	return idx, nil
+}
+
+// openArchiveStream 打开归档内文件的数据流
+func openArchiveStream(archivePath, kind, name string) (io.ReadCloser, error) {
+	notFound := &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+
+	switch kind {
+	case archiveZip:
+		zr, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if cleanEntryName(f.Name) != name {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				zr.Close()
+				return nil, err
+			}
+			return &stackedReader{Reader: rc, closers: []io.Closer{rc, zr}}, nil
+		}
+		zr.Close()
+
+	case archive7z:
+		zr, err := sevenzip.OpenReader(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if cleanEntryName(f.Name) != name {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				zr.Close()
+				return nil, err
+			}
+			return &stackedReader{Reader: rc, closers: []io.Closer{rc, zr}}, nil
+		}
+		zr.Close()
+
+	case archiveTarGz:
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				gz.Close()
+				file.Close()
+				if err == io.EOF {
+					return nil, notFound
+				}
+				return nil, err
+			}
+			if hdr.Typeflag == tar.TypeReg && cleanEntryName(hdr.Name) == name {
+				return &stackedReader{Reader: tr, closers: []io.Closer{gz, file}}, nil
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported archive type")
+	}
+
+	return nil, notFound
+}
+
+// sectionFile 文件中的一段区域
+type sectionFile struct {
+	*io.SectionReader
+	file *os.File
+}
+
+// Close 关闭底层文件
+func (f *sectionFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testArchiveEntry 测试归档中的条目，content 为空且 name 以 / 结尾时为目录
+type testArchiveEntry struct {
+	name     string
+	content  string
+	linkname string // 非空时写入符号链接（仅 tar）
+	hardlink bool   // linkname 为硬链接的源而不是符号链接的目标
+	mode     int64
+}
+
+// writeTestZip 在 fullPath 写入 zip 归档
+func writeTestZip(t *testing.T, fullPath string, entries []testArchiveEntry) {
+	t.Helper()
+	f, err := os.Create(fullPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, entry := range entries {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, entry.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestTar 在 fullPath 写入 tar 归档，gz 为 true 时使用 gzip 压缩
+func writeTestTar(t *testing.T, fullPath string, gz bool, entries []testArchiveEntry) {
+	t.Helper()
+	f, err := os.Create(fullPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var w io.Writer = f
+	var gw *gzip.Writer
+	if gz {
+		gw = gzip.NewWriter(f)
+		w = gw
+	}
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Mode: entry.mode, ModTime: time.Now()}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		switch {
+		case entry.hardlink:
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = entry.linkname
+		case entry.linkname != "":
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = entry.linkname
+		case strings.HasSuffix(entry.name, "/"):
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0755
+		default:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(entry.content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, entry.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestArchiveKind(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"a.zip", archiveZip},
+		{"A.JAR", archiveZip},
+		{"a.tar", archiveTar},
+		{"a.tar.gz", archiveTarGz},
+		{"a.tgz", archiveTarGz},
+		{"a.7z", archive7z},
+		{"a.gz", ""},
+		{"a.txt", ""},
+	}
+	for _, tt := range tests {
+		if got := archiveKind(tt.name); got != tt.want {
+			t.Errorf("archiveKind(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSplitArchivePath(t *testing.T) {
+	tests := []struct {
+		fullPath    string
+		wantArchive string
+		wantEntry   string
+		wantOK      bool
+	}{
+		{"/dist/build.zip!/logs/app.log", "/dist/build.zip", "logs/app.log", true},
+		{"/dist/build.zip!", "/dist/build.zip", "", true},
+		{"/dist/build.zip!/", "/dist/build.zip", "", true},
+		{"/dist/build.tar.gz!/../../etc/passwd", "/dist/build.tar.gz", "etc/passwd", true},
+		{"/dist/wow!.zip!/a", "/dist/wow!.zip", "a", true},
+		{"/dist/a.zip!/inner.zip!/b", "/dist/a.zip", "inner.zip!/b", true},
+		{"/dist/wow!/a.txt", "", "", false},
+		{"/dist/a.txt", "", "", false},
+	}
+	for _, tt := range tests {
+		archive, entry, ok := splitArchivePath(tt.fullPath)
+		if archive != tt.wantArchive || entry != tt.wantEntry || ok != tt.wantOK {
+			t.Errorf("splitArchivePath(%q) = %q, %q, %v; want %q, %q, %v",
+				tt.fullPath, archive, entry, ok, tt.wantArchive, tt.wantEntry, tt.wantOK)
+		}
+	}
+}
+
+func TestArchiveBrowse(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	entries := []testArchiveEntry{
+		{name: "readme.txt", content: "top\n"},
+		{name: "logs/app.log", content: "one\ntwo\nerror three\n"},
+		{name: "logs/old/app.1.log", content: "old\n"},
+	}
+	writeTestZip(t, dir+"/a.zip", entries)
+	writeTestTar(t, dir+"/a.tar", false, entries)
+	writeTestTar(t, dir+"/a.tar.gz", true, entries)
+
+	for _, archive := range []string{"a.zip", "a.tar", "a.tar.gz"} {
+		t.Run(archive, func(t *testing.T) {
+			var items []FileItem
+			decodeResponse(t, doRequest(s.handleList, "GET", "/api/list?path=/"+archive+"!/&root=0", ""), 200, &items)
+			var names []string
+			for _, item := range items {
+				names = append(names, item.Name)
+			}
+			sort.Strings(names)
+			// 中间目录 logs 没有单独的条目，也应补全
+			if strings.Join(names, ",") != "logs,readme.txt" {
+				t.Errorf("root entries = %v", names)
+			}
+
+			decodeResponse(t, doRequest(s.handleList, "GET", "/api/list?path=/"+archive+"!/logs&root=0", ""), 200, &items)
+			if len(items) != 2 {
+				t.Errorf("logs entries = %+v", items)
+			}
+
+			var content FileContent
+			decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?path=/"+archive+"!/logs/app.log&root=0", ""), 200, &content)
+			if strings.Join(content.Lines, "|") != "one|two|error three" || content.FinalNewline == nil || !*content.FinalNewline {
+				t.Errorf("view lines = %q", content.Lines)
+			}
+
+			var results []SearchResult
+			decodeResponse(t, doRequest(s.handleSearch, "GET", "/api/search?path=/"+archive+"!/logs/app.log&q=error&root=0", ""), 200, &results)
+			if len(results) != 1 || results[0].LineNumber != 3 {
+				t.Errorf("search = %+v", results)
+			}
+
+			rec := doRequest(s.handleDownload, "GET", "/api/download?path=/"+archive+"!/readme.txt&root=0", "")
+			if rec.Code != 200 || rec.Body.String() != "top\n" {
+				t.Errorf("download = %d %q", rec.Code, rec.Body.String())
+			}
+
+			rec = doRequest(s.handleView, "GET", "/api/view?path=/"+archive+"!/missing.txt&root=0", "")
+			if rec.Code != 404 {
+				t.Errorf("missing entry status = %d, want 404", rec.Code)
+			}
+		})
+	}
+}
+
+func TestArchiveListMarksArchives(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	writeTestZip(t, dir+"/a.zip", []testArchiveEntry{{name: "x.txt", content: "x"}})
+	writeTestFile(t, dir, "b.txt", "b")
+
+	var items []FileItem
+	decodeResponse(t, doRequest(s.handleList, "GET", "/api/list?path=/&root=0", ""), 200, &items)
+	for _, item := range items {
+		if want := item.Name == "a.zip"; item.IsArchive != want {
+			t.Errorf("%s isArchive = %v, want %v", item.Name, item.IsArchive, want)
+		}
+	}
+}
+
+func TestArchiveCacheInvalidation(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := dir + "/a.zip"
+	writeTestZip(t, archivePath, []testArchiveEntry{{name: "a.txt", content: "a"}})
+
+	cache := newArchiveCache()
+	entries, err := cache.list(archivePath, "")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("list = %v, %v", entries, err)
+	}
+
+	// 归档修改后索引应重新建立
+	writeTestZip(t, archivePath, []testArchiveEntry{{name: "a.txt", content: "a"}, {name: "b.txt", content: "bb"}})
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(archivePath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = cache.list(archivePath, "")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("list after change = %v, %v", entries, err)
+	}
+
+	if _, err := cache.list(archivePath, "a.txt"); err == nil {
+		t.Errorf("listing a file should fail")
+	}
+	if _, _, err := cache.open(archivePath, "missing"); !os.IsNotExist(err) {
+		t.Errorf("open missing = %v", err)
+	}
+}
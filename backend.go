@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// 根目录类型
+const (
+	RootTypeLocal = "local"
+	RootTypeSFTP  = "sftp"
+)
+
+var (
+	// errRemoteUnsupported 远程根目录不支持的操作
+	errRemoteUnsupported = errors.New("operation not supported for remote root")
+	// errIsDirectory 路径是目录而不是文件
+	errIsDirectory = errors.New("path is a directory")
+	// errInvalidPath 路径包含绝对路径、. 或 .. 等不允许的路径段
+	errInvalidPath = errors.New("invalid path")
+	// errSkipped 目标已存在，按请求跳过
+	errSkipped = errors.New("skipped")
+	// errAccessDenied 路径不在根目录内，或位于只能由服务器访问的目录中
+	errAccessDenied = errors.New("access denied")
+)
+
+// File 后端打开的只读文件
+type File interface {
+	io.ReadSeekCloser
+}
+
+// FileSystem 根目录的文件系统后端
+// 所有方法接收的都是 getFullPath 生成的完整路径
+type FileSystem interface {
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Open(name string) (File, error)
+	// WriteFile 替换文件内容，保留原文件的权限和属主；backup 为 true 时将原内容保留为 name.bak
+	WriteFile(name string, data []byte, backup bool) error
+}
+
+// backupSuffix 保存时保留原内容的备份文件后缀
+const backupSuffix = ".bak"
+
+// localFS 本地文件系统后端
+type localFS struct{}
+
+// Stat 获取文件信息
+func (localFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// ReadDir 读取目录内容
+func (localFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Open 打开文件
+func (localFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+// WriteFile 写入文件
+func (localFS) WriteFile(name string, data []byte, backup bool) error {
+	return replaceLocalFile(name, backup, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// replaceLocalFile 用 write 生成的内容替换本地文件：先写入同目录下的临时文件并同步到磁盘，再重命名替换原文件，
+// 中途崩溃不会留下写了一半的文件。原文件的权限和属主保持不变，backup 为 true 时将原内容保留为 name.bak
+// write 可以读取原文件，原文件在 write 返回之后才会被替换
+func replaceLocalFile(name string, backup bool, write func(w io.Writer) error) error {
+	// 符号链接替换其指向的文件，而不是链接本身
+	if target, err := filepath.EvalSymlinks(name); err == nil {
+		name = target
+	}
+
+	info, err := os.Stat(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if info == nil {
+		return writeFileAtomic(name, nil, write)
+	}
+
+	if backup {
+		old, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = writeFileAtomic(name+backupSuffix, info, func(w io.Writer) error {
+			_, err := io.Copy(w, old)
+			return err
+		})
+		old.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// 有多个硬链接的文件只能原地写入，重命名会使其他链接仍指向旧内容
+	if _, _, nlink, ok := fileOwner(info); ok && nlink > 1 {
+		return writeFileInPlace(name, write)
+	}
+	return writeFileAtomic(name, info, write)
+}
+
+// writeFileAtomic 通过临时文件和重命名替换文件，权限和属主与 orig 一致，orig 为 nil 时使用 0644
+func writeFileAtomic(name string, orig os.FileInfo, write func(w io.Writer) error) error {
+	perm := os.FileMode(0644)
+	if orig != nil {
+		perm = orig.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	err = write(tmp)
+	if err == nil && orig != nil {
+		// 只有 root 能把文件交给其他用户，失败时文件归当前用户所有
+		if uid, gid, _, ok := fileOwner(orig); ok {
+			tmp.Chown(uid, gid)
+		}
+	}
+	// 更改属主会清除 setuid 位，因此在其后设置权限
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, name)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	syncDir(filepath.Dir(name))
+	return nil
+}
+
+// writeFileInPlace 先将新内容完整写入临时文件，再截断原文件并原地复制过去
+func writeFileInPlace(name string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, tmp)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncDir 将目录项的变化同步到磁盘，确保重命名在崩溃后仍然有效
+// 部分平台不支持同步目录，忽略错误
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// streamFile 将只能顺序读取的流包装为 File
+// 向前 Seek 通过丢弃数据实现，向后 Seek 会重新打开流
+type streamFile struct {
+	open func() (io.ReadCloser, error)
+	rc   io.ReadCloser
+	pos  int64
+}
+
+// newStreamFile 创建流式文件
+func newStreamFile(open func() (io.ReadCloser, error)) (*streamFile, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return &streamFile{open: open, rc: rc}, nil
+}
+
+// Read 读取数据
+func (f *streamFile) Read(p []byte) (int, error) {
+	n, err := f.rc.Read(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+// Seek 定位到指定位置（不支持 io.SeekEnd）
+func (f *streamFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	default:
+		return 0, errors.New("streamFile: unsupported whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("streamFile: negative position")
+	}
+
+	if offset < f.pos {
+		rc, err := f.open()
+		if err != nil {
+			return 0, err
+		}
+		f.rc.Close()
+		f.rc = rc
+		f.pos = 0
+	}
+
+	if offset > f.pos {
+		n, err := io.CopyN(io.Discard, f.rc, offset-f.pos)
+		f.pos += n
+		if err != nil && err != io.EOF {
+			return f.pos, err
+		}
+	}
+	return f.pos, nil
+}
+
+// Close 关闭流
+func (f *streamFile) Close() error {
+	return f.rc.Close()
+}
+
+// stackedReader 叠加在其他资源之上的数据流，关闭时依次释放底层资源
+type stackedReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close 关闭数据流
+func (r *stackedReader) Close() error {
+	var firstErr error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
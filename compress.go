@@ -0,0 +1,370 @@
+package main
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// 支持透明解压的压缩格式
+const (
+	compressGzip  = "gzip"
+	compressBzip2 = "bzip2"
+	compressZstd  = "zstd"
+	compressXz    = "xz"
+)
+
+const (
+	// 最多保留的解压游标数量（每个游标持有一个打开的解压流）
+	maxLineCursors = 16
+	// 游标空闲超过该时间后关闭
+	lineCursorIdleTimeout = 2 * time.Minute
+)
+
+// compressionKind 根据扩展名判断压缩格式，不是压缩文件时返回空字符串
+func compressionKind(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz":
+		return compressGzip
+	case ".bz2":
+		return compressBzip2
+	case ".zst":
+		return compressZstd
+	case ".xz":
+		return compressXz
+	}
+	return ""
+}
+
+// newDecompressor 创建解压流，关闭解压流不会关闭 r
+func newDecompressor(kind string, r io.Reader) (io.ReadCloser, error) {
+	switch kind {
+	case compressGzip:
+		return gzip.NewReader(r)
+	case compressBzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case compressZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case compressXz:
+		reader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(reader), nil
+	}
+	return nil, fmt.Errorf("unsupported compression: %s", kind)
+}
+
+// lineCursor 停在解压流某一行上的扫描器
+// 压缩流无法随机定位，翻页时从最近的游标继续读取，避免每次都从头解压
+type lineCursor struct {
+	scanner  *LineScanner
+	encoding textEncoding // 解压后内容的编码
+	ending   string       // 解压后内容开头使用的换行符
+	mixed    bool         // 开头是否混用了多种换行符
+	closer   io.Closer
+	line     int // 下一次 Scan 返回的行号（从 0 开始）
+	lastUsed time.Time
+}
+
+// compressedState 单个压缩文件的缓存状态
+type compressedState struct {
+	size       int64
+	modTime    time.Time
+	totalLines int // 解压后的总行数，-1 表示未知
+	cursors    []*lineCursor
+}
+
+// lineCursorCache 压缩文件的行数和游标缓存
+type lineCursorCache struct {
+	mu    sync.Mutex
+	files map[string]*compressedState
+}
+
+// newLineCursorCache 创建游标缓存
+func newLineCursorCache() *lineCursorCache {
+	return &lineCursorCache{files: make(map[string]*compressedState)}
+}
+
+// state 获取文件状态，文件变化后丢弃旧状态（调用方需持有锁）
+func (c *lineCursorCache) state(key string, info os.FileInfo) *compressedState {
+	st, ok := c.files[key]
+	if ok && st.size == info.Size() && st.modTime.Equal(info.ModTime()) {
+		return st
+	}
+
+	if ok {
+		for _, cursor := range st.cursors {
+			cursor.closer.Close()
+		}
+	}
+
+	st = &compressedState{size: info.Size(), modTime: info.ModTime(), totalLines: -1}
+	c.files[key] = st
+	return st
+}
+
+// totalLines 获取缓存的总行数
+func (c *lineCursorCache) totalLines(key string, info os.FileInfo) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := c.state(key, info)
+	return st.totalLines, st.totalLines >= 0
+}
+
+// setTotalLines 缓存总行数
+func (c *lineCursorCache) setTotalLines(key string, info os.FileInfo, totalLines int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.state(key, info).totalLines = totalLines
+}
+
+// take 取出不超过 line 的最近游标，没有可用游标时返回 nil
+func (c *lineCursorCache) take(key string, info os.FileInfo, line int) *lineCursor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire()
+
+	st := c.state(key, info)
+	best := -1
+	for i, cursor := range st.cursors {
+		if cursor.line <= line && (best < 0 || cursor.line > st.cursors[best].line) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+
+	cursor := st.cursors[best]
+	st.cursors = append(st.cursors[:best], st.cursors[best+1:]...)
+	return cursor
+}
+
+// put 归还游标，超出数量限制时关闭最久未使用的游标
+func (c *lineCursorCache) put(key string, info os.FileInfo, cursor *lineCursor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cursor.lastUsed = time.Now()
+	st := c.state(key, info)
+	st.cursors = append(st.cursors, cursor)
+
+	for c.count() > maxLineCursors {
+		c.evictOldest()
+	}
+}
+
+// count 统计所有游标数量（调用方需持有锁）
+func (c *lineCursorCache) count() int {
+	n := 0
+	for _, st := range c.files {
+		n += len(st.cursors)
+	}
+	return n
+}
+
+// evictOldest 关闭最久未使用的游标（调用方需持有锁）
+func (c *lineCursorCache) evictOldest() {
+	var oldest *compressedState
+	index := -1
+	for _, st := range c.files {
+		for i, cursor := range st.cursors {
+			if index < 0 || cursor.lastUsed.Before(oldest.cursors[index].lastUsed) {
+				oldest, index = st, i
+			}
+		}
+	}
+	if index < 0 {
+		return
+	}
+
+	oldest.cursors[index].closer.Close()
+	oldest.cursors = append(oldest.cursors[:index], oldest.cursors[index+1:]...)
+}
+
+// expire 关闭空闲超时的游标（调用方需持有锁）
+func (c *lineCursorCache) expire() {
+	for _, st := range c.files {
+		kept := st.cursors[:0]
+		for _, cursor := range st.cursors {
+			if time.Since(cursor.lastUsed) > lineCursorIdleTimeout {
+				cursor.closer.Close()
+				continue
+			}
+			kept = append(kept, cursor)
+		}
+		st.cursors = kept
+	}
+}
+
+// newLineCursor 在已打开的压缩文件上从头创建解压流，按 encodingName 或检测到的编码解码
+// 关闭游标只关闭解压流，不关闭 file；没有指定编码且解压后的内容是二进制时返回 *binaryError
+func (s *Server) newLineCursor(rootIndex int, file File, kind, encodingName string) (*lineCursor, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	rc, err := newDecompressor(kind, file)
+	if err != nil {
+		return nil, err
+	}
+
+	var plain io.Reader = rc
+	if encodingName == "" {
+		var binary bool
+		var mimeType string
+		if plain, binary, mimeType = peekBinary(rc); binary {
+			rc.Close()
+			return nil, &binaryError{contentType: mimeType}
+		}
+	}
+
+	reader, te, err := s.decodeText(rootIndex, plain, encodingName)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	reader, ending, mixed := peekLineEnding(reader)
+	return &lineCursor{
+		scanner:  NewLineScanner(reader),
+		encoding: te,
+		ending:   ending,
+		mixed:    mixed,
+		closer:   rc,
+	}, nil
+}
+
+// openLineCursor 另外打开本地压缩文件并创建游标，游标关闭时一并关闭文件，可以放入缓存
+func (s *Server) openLineCursor(rootIndex int, fullPath, kind, encodingName string) (*lineCursor, error) {
+	file, _, err := s.openFile(rootIndex, fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.newLineCursor(rootIndex, file, kind, encodingName)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	cursor.closer = &stackedReader{closers: []io.Closer{cursor.closer, file}}
+	return cursor, nil
+}
+
+// handleCompressedFile 处理压缩文件（解压后分页读取），file 为请求中已打开的文件，由调用方关闭
+func (s *Server) handleCompressedFile(w http.ResponseWriter, rootIndex int, file File, fullPath string, info os.FileInfo, version string, kind string, lr lineRange, config ViewConfig, encodingName string) {
+	// 指定不同编码时行数和游标分别缓存
+	key := fmt.Sprintf("%d:%s:%s", rootIndex, fullPath, encodingName)
+
+	// 统计解压后的总行数（只在首次打开时完整解压一次）
+	totalLines, ok := s.cursors.totalLines(key, info)
+	if !ok {
+		cursor, err := s.newLineCursor(rootIndex, file, kind, encodingName)
+		var binErr *binaryError
+		if errors.As(err, &binErr) {
+			s.binaryFile(w, binErr.contentType)
+			return
+		}
+		if err != nil {
+			s.handleError(w, err, errorStatus(err))
+			return
+		}
+
+		totalLines = 0
+		for cursor.scanner.Scan() {
+			totalLines++
+		}
+		err = cursor.scanner.Err()
+		cursor.closer.Close()
+		if err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		s.cursors.setTotalLines(key, info, totalLines)
+	}
+
+	// 计算总页数
+	totalPages := (totalLines + config.LinesPerPage - 1) / config.LinesPerPage
+	lr = lr.clamp(totalLines, config.LinesPerPage)
+	startLine := lr.start
+
+	// 本地文件优先从已有游标继续读取，新游标另外打开文件以便缓存；
+	// 远程文件每个打开的文件占用一个 SFTP 连接，直接使用请求中已打开的文件且不缓存游标
+	remote := s.isRemote(rootIndex)
+	var cursor *lineCursor
+	if !remote {
+		cursor = s.cursors.take(key, info, startLine)
+	}
+	if cursor == nil {
+		var err error
+		if remote {
+			cursor, err = s.newLineCursor(rootIndex, file, kind, encodingName)
+		} else {
+			cursor, err = s.openLineCursor(rootIndex, fullPath, kind, encodingName)
+		}
+		if err != nil {
+			s.handleError(w, err, errorStatus(err))
+			return
+		}
+	}
+
+	lines, long, scanned, err := ReadLines(cursor.scanner, startLine-cursor.line, lr.count)
+	cursor.line += scanned
+	if err != nil {
+		cursor.closer.Close()
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	for i := range long {
+		long[i].Line = startLine + long[i].Index + 1
+	}
+
+	// 未读到末尾的本地游标留给下一页继续使用
+	if !remote && cursor.line < totalLines {
+		s.cursors.put(key, info, cursor)
+	} else {
+		cursor.closer.Close()
+	}
+
+	response := FileContent{
+		Path:        fullPath,
+		Name:        info.Name(),
+		Size:        info.Size(),
+		IsPartial:   true,
+		TotalLines:  totalLines,
+		Lines:       lines,
+		Page:        lr.page(config.LinesPerPage),
+		From:        startLine + 1,
+		TotalPages:  totalPages,
+		LongLines:   long,
+		Compression: kind,
+		Version:     version,
+		Encoding:    cursor.encoding.name,
+		BOM:         cursor.encoding.bom,
+
+		LineEnding:       cursor.ending,
+		MixedLineEndings: cursor.mixed,
+	}
+
+	s.writeJSON(w, response)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compressTestData 按 kind 压缩数据
+func compressTestData(t *testing.T, kind string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch kind {
+	case compressGzip:
+		w = gzip.NewWriter(&buf)
+	case compressZstd:
+		w, err = zstd.NewWriter(&buf)
+	case compressXz:
+		w, err = xz.NewWriter(&buf)
+	default:
+		t.Fatalf("unsupported kind %s", kind)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompressionKind(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"app.log.gz", compressGzip},
+		{"APP.LOG.GZ", compressGzip},
+		{"data.bz2", compressBzip2},
+		{"data.zst", compressZstd},
+		{"data.xz", compressXz},
+		{"data.tar.gz", compressGzip},
+		{"data.zip", ""},
+		{"data.txt", ""},
+	}
+	for _, tt := range tests {
+		if got := compressionKind(tt.name); got != tt.want {
+			t.Errorf("compressionKind(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompressedView(t *testing.T) {
+	var text strings.Builder
+	totalLines := 2*LinesPerPage + LinesPerPage/2
+	for i := 1; i <= totalLines; i++ {
+		fmt.Fprintf(&text, "line %d\n", i)
+	}
+
+	tests := []struct {
+		name string
+		kind string
+	}{
+		{"app.log.gz", compressGzip},
+		{"app.log.zst", compressZstd},
+		{"app.log.xz", compressXz},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := newTestServer(t, nil)
+			writeTestFile(t, dir, tt.name, string(compressTestData(t, tt.kind, []byte(text.String()))))
+
+			for _, page := range []int{1, 3, 2} {
+				var content FileContent
+				decodeResponse(t, doRequest(s.handleView, "GET", fmt.Sprintf("/api/view?path=/%s&page=%d&root=0", tt.name, page), ""), 200, &content)
+				if content.Compression != tt.kind || content.TotalLines != totalLines || content.TotalPages != 3 {
+					t.Fatalf("page %d: compression=%q totalLines=%d totalPages=%d", page, content.Compression, content.TotalLines, content.TotalPages)
+				}
+				if want := fmt.Sprintf("line %d", (page-1)*LinesPerPage+1); len(content.Lines) == 0 || content.Lines[0] != want {
+					t.Errorf("page %d first line = %q, want %q", page, content.Lines, want)
+				}
+			}
+
+			var results []SearchResult
+			decodeResponse(t, doRequest(s.handleSearch, "GET", fmt.Sprintf("/api/search?path=/%s&q=line+%d&root=0", tt.name, totalLines-1), ""), 200, &results)
+			if len(results) != 1 || results[0].LineNumber != totalLines-1 {
+				t.Errorf("search = %+v", results)
+			}
+		})
+	}
+}
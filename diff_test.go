@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lines 按 | 拆分测试用的行，空字符串表示没有行
+func lines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "|")
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string // 每个操作的类型
+	}{
+		{"", "", ""},
+		{"a|b|c", "a|b|c", "   "},
+		{"a|b|c", "a|c", " - "},
+		{"a|c", "a|b|c", " + "},
+		{"a|b|c", "a|x|c", " -+ "},
+		{"", "a|b", "++"},
+		{"a|b", "", "--"},
+	}
+	for _, tt := range tests {
+		var kinds strings.Builder
+		for _, op := range diffLines(lines(tt.a), lines(tt.b)) {
+			kinds.WriteByte(op.kind)
+		}
+		if got := kinds.String(); got != tt.want {
+			t.Errorf("diffLines(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := lines("1|2|3|4|5|6|7|8|9|10|11|12|13|14|15|16|17|18|19|20")
+	b := lines("1|2|3|4|five|6|7|8|9|10|11|12|13|14|15|16|17|18|19|20|21")
+	want := strings.Join([]string{
+		"--- a",
+		"+++ b",
+		"@@ -2,7 +2,7 @@",
+		" 2",
+		" 3",
+		" 4",
+		"-5",
+		"+five",
+		" 6",
+		" 7",
+		" 8",
+		"@@ -18,3 +18,4 @@",
+		" 18",
+		" 19",
+		" 20",
+		"+21",
+		"",
+	}, "\n")
+	if got := unifiedDiff("a", "b", a, b); got != want {
+		t.Errorf("unifiedDiff =\n%s\nwant\n%s", got, want)
+	}
+	if got := unifiedDiff("a", "b", a, a); got != "" {
+		t.Errorf("identical input should produce no diff, got %q", got)
+	}
+}
+
+func TestSaveVersionConflict(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     string
+		wantCode    int
+		wantDiff    bool
+		wantContent string
+	}{
+		{"missing version", "", 428, false, "one\ntwo\n"},
+		{"stale version", "stale", 409, true, "one\ntwo\n"},
+		{"forced", "*", 200, false, "one\nTWO\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := newTestServer(t, nil)
+			fullPath := writeTestFile(t, dir, "a.txt", "one\ntwo\n")
+
+			rec := doRequest(s.handleSave, "POST", "/api/save?root=0", mustJSON(t, SaveRequest{Path: "/a.txt", Content: "one\nTWO\n", Version: tt.version}))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if tt.wantCode == 409 {
+				var conflict SaveConflict
+				decodeResponse(t, rec, 409, &conflict)
+				info, _ := os.Stat(fullPath)
+				if conflict.Version != s.pathVersion(0, fullPath, info) {
+					t.Errorf("version = %s, want %s", conflict.Version, s.pathVersion(0, fullPath, info))
+				}
+				if hasDiff := strings.Contains(conflict.Diff, "-two\n+TWO\n"); hasDiff != tt.wantDiff {
+					t.Errorf("diff = %q, want diff %v", conflict.Diff, tt.wantDiff)
+				}
+			}
+			if got := readTestFile(t, fullPath); got != tt.wantContent {
+				t.Errorf("content = %q, want %q", got, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestSaveDetectsSameSizeAndModTime(t *testing.T) {
+	s, dir := newTestServer(t, func(config *Config) {
+		config.View.MaxFileSize = 16
+	})
+	fullPath := writeTestFile(t, dir, "a.txt", "one\n")
+	modTime := time.Now().Truncate(time.Second)
+	os.Chtimes(fullPath, modTime, modTime)
+
+	rec := doRequest(s.handleView, "GET", "/api/view?root=0&path=/a.txt", "")
+	version := parseETag(rec.Header().Get("ETag"))
+
+	// 大小和修改时间都不变的修改（如远程根目录同一秒内的两次写入）同样使版本失效
+	writeTestFile(t, dir, "a.txt", "two\n")
+	os.Chtimes(fullPath, modTime, modTime)
+	body := mustJSON(t, SaveRequest{Path: "/a.txt", Content: "three\n", Version: version})
+	decodeResponse(t, doRequest(s.handleSave, "POST", "/api/save?root=0", body), 409, nil)
+
+	// 超过 maxFileSize 的文件只按大小和修改时间区分
+	large := writeTestFile(t, dir, "large.txt", strings.Repeat("x", 32))
+	info, _ := os.Stat(large)
+	if got := s.pathVersion(0, large, info); got != metaVersion(info) {
+		t.Errorf("large version = %s", got)
+	}
+}
+
+func TestDigestCache(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	fullPath := writeTestFile(t, dir, "a.txt", "one\n")
+	info, _ := os.Stat(fullPath)
+	s.pathVersion(0, fullPath, info)
+
+	// 刚修改的文件不缓存摘要
+	if _, ok := s.digests.get("0:"+fullPath, info); ok {
+		t.Error("digest of a recently modified file was cached")
+	}
+
+	// 修改时间较早的文件缓存摘要，大小或修改时间变化后不再使用
+	modTime := time.Now().Add(-time.Hour)
+	os.Chtimes(fullPath, modTime, modTime)
+	info, _ = os.Stat(fullPath)
+	version := s.pathVersion(0, fullPath, info)
+	if digest, ok := s.digests.get("0:"+fullPath, info); !ok || version != metaVersion(info)+"-"+digest {
+		t.Errorf("cached digest = %q %v, version %s", digest, ok, version)
+	}
+	writeTestFile(t, dir, "a.txt", "three\n")
+	info, _ = os.Stat(fullPath)
+	if _, ok := s.digests.get("0:"+fullPath, info); ok {
+		t.Error("digest was reused after the file changed")
+	}
+	if got := s.pathVersion(0, fullPath, info); got == version {
+		t.Errorf("version = %s after the file changed", got)
+	}
+}
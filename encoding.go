@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+const (
+	// 检测编码时读取的字节数
+	encodingSniffLen = 64 * 1024
+	// 内容不是有效的 UTF-8 且根目录未配置默认编码时使用的编码
+	defaultFallbackEncoding = "gb18030"
+)
+
+// 各编码的 BOM
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// textEncoding 文本文件的编码
+type textEncoding struct {
+	name string            // WHATWG 编码名称，如 "utf-8"、"gbk"、"utf-16le"
+	bom  bool              // 是否以 BOM 开头
+	enc  encoding.Encoding // nil 表示 UTF-8
+}
+
+// lookupEncoding 按名称查找编码，名称不区分大小写并支持常见别名（如 "gb2312"、"latin1"）
+func lookupEncoding(name string) (textEncoding, error) {
+	enc, err := htmlindex.Get(strings.TrimSpace(name))
+	if err != nil {
+		return textEncoding{}, fmt.Errorf("unsupported encoding: %s", name)
+	}
+	canonical, err := htmlindex.Name(enc)
+	if err != nil {
+		return textEncoding{}, fmt.Errorf("unsupported encoding: %s", name)
+	}
+	if canonical == "utf-8" {
+		return textEncoding{name: canonical}, nil
+	}
+	return textEncoding{name: canonical, enc: enc}, nil
+}
+
+// bomBytes 获取编码对应的 BOM
+func (te textEncoding) bomBytes() []byte {
+	switch te.name {
+	case "utf-8":
+		return bomUTF8
+	case "utf-16le":
+		return bomUTF16LE
+	case "utf-16be":
+		return bomUTF16BE
+	}
+	return nil
+}
+
+// isUTF16 判断是否为 UTF-16 编码，此时换行符不是单个字节
+func (te textEncoding) isUTF16() bool {
+	return te.name == "utf-16le" || te.name == "utf-16be"
+}
+
+// encode 将 UTF-8 文本编码为该编码的字节，原文件有 BOM 时同样写入 BOM
+func (te textEncoding) encode(text string) ([]byte, error) {
+	var buf bytes.Buffer
+	if te.bom {
+		buf.Write(te.bomBytes())
+	}
+	if te.enc == nil {
+		buf.WriteString(text)
+		return buf.Bytes(), nil
+	}
+
+	encoded, err := te.enc.NewEncoder().String(text)
+	if err != nil {
+		return nil, fmt.Errorf("content cannot be encoded as %s", te.name)
+	}
+	buf.WriteString(encoded)
+	return buf.Bytes(), nil
+}
+
+// defaultEncoding 获取根目录的默认编码
+func (s *Server) defaultEncoding(rootIndex int) string {
+	if rootIndex >= 0 && rootIndex < len(s.config.RootDirs) && s.config.RootDirs[rootIndex].Encoding != "" {
+		return s.config.RootDirs[rootIndex].Encoding
+	}
+	return defaultFallbackEncoding
+}
+
+// detectEncoding 根据文件开头的内容检测编码：先看 BOM，再判断是否像 UTF-16 或是有效的 UTF-8，
+// 都不是时使用根目录的默认编码；truncated 表示 head 只是文件的一部分
+func (s *Server) detectEncoding(rootIndex int, head []byte, truncated bool) textEncoding {
+	switch {
+	case bytes.HasPrefix(head, bomUTF8):
+		return textEncoding{name: "utf-8", bom: true}
+	case bytes.HasPrefix(head, bomUTF16LE):
+		te, _ := lookupEncoding("utf-16le")
+		te.bom = true
+		return te
+	case bytes.HasPrefix(head, bomUTF16BE):
+		te, _ := lookupEncoding("utf-16be")
+		te.bom = true
+		return te
+	}
+
+	if name := guessUTF16(head); name != "" {
+		te, _ := lookupEncoding(name)
+		return te
+	}
+
+	if truncated {
+		// 截断处可能是不完整的多字节字符
+		if i := lastRuneStart(head); !utf8.FullRune(head[i:]) {
+			head = head[:i]
+		}
+	}
+	if utf8.Valid(head) {
+		return textEncoding{name: "utf-8"}
+	}
+
+	te, err := lookupEncoding(s.defaultEncoding(rootIndex))
+	if err != nil {
+		te, _ = lookupEncoding(defaultFallbackEncoding)
+	}
+	return te
+}
+
+// lastRuneStart 获取最后一个字符的起始位置，找不到时返回 len(b)
+func lastRuneStart(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			return i
+		}
+	}
+	return len(b)
+}
+
+// guessUTF16 根据零字节的分布判断没有 BOM 的 UTF-16 文本（以 ASCII 字符为主时高位字节为零）
+func guessUTF16(head []byte) string {
+	pairs := len(head) / 2
+	if pairs < 2 {
+		return ""
+	}
+
+	var evenZeros, oddZeros int
+	for i := 0; i+1 < len(head); i += 2 {
+		if head[i] == 0 {
+			evenZeros++
+		}
+		if head[i+1] == 0 {
+			oddZeros++
+		}
+	}
+
+	switch {
+	case oddZeros*10 >= pairs*4 && evenZeros*20 < pairs:
+		return "utf-16le"
+	case evenZeros*10 >= pairs*4 && oddZeros*20 < pairs:
+		return "utf-16be"
+	}
+	return ""
+}
+
+// decodeText 检测文本的编码并返回解码为 UTF-8 的读取器（不含 BOM），name 非空时使用指定的编码
+func (s *Server) decodeText(rootIndex int, r io.Reader, name string) (io.Reader, textEncoding, error) {
+	br := bufio.NewReaderSize(r, encodingSniffLen)
+	head, err := br.Peek(encodingSniffLen)
+	if err != nil && err != io.EOF {
+		return nil, textEncoding{}, err
+	}
+
+	var te textEncoding
+	if name != "" {
+		if te, err = lookupEncoding(name); err != nil {
+			return nil, textEncoding{}, err
+		}
+		te.bom = te.bomBytes() != nil && bytes.HasPrefix(head, te.bomBytes())
+	} else {
+		te = s.detectEncoding(rootIndex, head, len(head) == encodingSniffLen)
+	}
+
+	if te.bom {
+		br.Discard(len(te.bomBytes()))
+	}
+	if te.enc == nil {
+		return br, te, nil
+	}
+	return transform.NewReader(br, te.enc.NewDecoder()), te, nil
+}
+
+// fileTextFormat 检测后端文件的编码和换行符，文件没有换行符时换行符为空字符串
+func (s *Server) fileTextFormat(rootIndex int, fullPath string) (textEncoding, string, error) {
+	file, err := s.fs(rootIndex).Open(fullPath)
+	if err != nil {
+		return textEncoding{}, "", err
+	}
+	defer file.Close()
+
+	reader, te, err := s.decodeText(rootIndex, file, "")
+	if err != nil {
+		return textEncoding{}, "", err
+	}
+	_, ending, _ := peekLineEnding(reader)
+	return te, ending, nil
+}
+
+// saveEncoding 确定保存时使用的编码：name 为空时保持原文件的编码和 BOM，否则转换为指定的编码
+// 转换为 UTF-16 时写入 BOM，转换为 UTF-8 时不写入
+func saveEncoding(original textEncoding, name string) (textEncoding, error) {
+	if name == "" {
+		return original, nil
+	}
+
+	te, err := lookupEncoding(name)
+	if err != nil {
+		return textEncoding{}, err
+	}
+	switch {
+	case te.name == original.name:
+		te.bom = original.bom
+	case te.isUTF16():
+		te.bom = true
+	}
+	return te, nil
+}
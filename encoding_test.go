@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// encodeUTF16 将文本编码为 UTF-16，bigEndian 为 false 时使用小端序
+func encodeUTF16(text string, bigEndian bool) string {
+	var b strings.Builder
+	for _, u := range utf16.Encode([]rune(text)) {
+		if bigEndian {
+			b.WriteByte(byte(u >> 8))
+			b.WriteByte(byte(u))
+		} else {
+			b.WriteByte(byte(u))
+			b.WriteByte(byte(u >> 8))
+		}
+	}
+	return b.String()
+}
+
+func TestLookupEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"UTF-8", "utf-8", false},
+		{"utf8", "utf-8", false},
+		{"GB2312", "gbk", false},
+		{" gb18030 ", "gb18030", false},
+		{"latin1", "windows-1252", false},
+		{"Shift_JIS", "shift_jis", false},
+		{"UTF-16", "utf-16le", false},
+		{"klingon", "", true},
+	}
+	for _, tt := range tests {
+		te, err := lookupEncoding(tt.name)
+		if (err != nil) != tt.wantErr || te.name != tt.want {
+			t.Errorf("lookupEncoding(%q) = %q, %v; want %q, wantErr %v", tt.name, te.name, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		name            string
+		defaultEncoding string
+		head            string
+		truncated       bool
+		want            string
+		wantBOM         bool
+	}{
+		{"ascii", "", "hello\n", false, "utf-8", false},
+		{"utf-8", "", "你好\n", false, "utf-8", false},
+		{"utf-8 bom", "", "\xef\xbb\xbfhi", false, "utf-8", true},
+		{"utf-16le bom", "", "\xff\xfeh\x00i\x00", false, "utf-16le", true},
+		{"utf-16be bom", "", "\xfe\xff\x00h\x00i", false, "utf-16be", true},
+		{"utf-16le without bom", "", encodeUTF16("hello world\n", false), false, "utf-16le", false},
+		{"utf-16be without bom", "", encodeUTF16("hello world\n", true), false, "utf-16be", false},
+		{"gbk falls back to gb18030", "", "\xc4\xe3\xba\xc3\n", false, "gb18030", false},
+		{"root default encoding", "shift_jis", "\x82\xa0\x82\xa2\n", false, "shift_jis", false},
+		{"utf-8 cut in the middle of a character", "", "ab你"[:4], true, "utf-8", false},
+		{"utf-8 cut at the end of the file", "", "ab你"[:4], false, "gb18030", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, func(config *Config) { config.RootDirs[0].Encoding = tt.defaultEncoding })
+			te := s.detectEncoding(0, []byte(tt.head), tt.truncated)
+			if te.name != tt.want || te.bom != tt.wantBOM {
+				t.Errorf("detectEncoding = %s bom=%v, want %s bom=%v", te.name, te.bom, tt.want, tt.wantBOM)
+			}
+		})
+	}
+}
+
+func TestSaveEncodingRoundTrip(t *testing.T) {
+	tests := []struct {
+		name         string
+		original     string
+		wantEncoding string
+		wantBOM      bool
+		content      string
+		saveEncoding string
+		wantSaved    string
+		wantCode     int
+	}{
+		{"gb18030", "\xc4\xe3\xba\xc3\n", "gb18030", false, "你好\n世界\n", "", "\xc4\xe3\xba\xc3\n\xca\xc0\xbd\xe7\n", 200},
+		{"gb18030 outside gbk", "\xc4\xe3\xba\xc3\n", "gb18030", false, "你好 😀\n", "", "\xc4\xe3\xba\xc3 \x949\xfc6\n", 200},
+		{"utf-8 bom is kept", "\xef\xbb\xbfa\n", "utf-8", true, "b\n", "", "\xef\xbb\xbfb\n", 200},
+		{"utf-16le bom", "\xff\xfe" + encodeUTF16("a\r\nb\r\n", false), "utf-16le", true, "你\nb\n", "", "\xff\xfe" + encodeUTF16("你\r\nb\r\n", false), 200},
+		{"utf-16be without bom", encodeUTF16("hello world\n", true), "utf-16be", false, "你好 world\n", "", encodeUTF16("你好 world\n", true), 200},
+		{"convert to utf-16be adds bom", "a\n", "utf-8", false, "你\n", "utf-16be", "\xfe\xff" + encodeUTF16("你\n", true), 200},
+		{"convert to utf-8 drops bom", "\xff\xfe" + encodeUTF16("a\n", false), "utf-16le", true, "你\n", "utf-8", "你\n", 200},
+		{"convert to gbk", "a\n", "utf-8", false, "你好\n", "gb2312", "\xc4\xe3\xba\xc3\n", 200},
+		{"not representable", "a\n", "utf-8", false, "你好\n", "latin1", "a\n", 400},
+		{"unknown encoding", "a\n", "utf-8", false, "b\n", "klingon", "a\n", 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := newTestServer(t, nil)
+			fullPath := writeTestFile(t, dir, "a.txt", tt.original)
+
+			var content FileContent
+			decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/a.txt", ""), 200, &content)
+			if content.Encoding != tt.wantEncoding || content.BOM != tt.wantBOM {
+				t.Fatalf("view encoding = %s bom=%v, want %s bom=%v", content.Encoding, content.BOM, tt.wantEncoding, tt.wantBOM)
+			}
+
+			body := mustJSON(t, SaveRequest{Path: "/a.txt", Content: tt.content, Version: "*", Encoding: tt.saveEncoding})
+			rec := doRequest(s.handleSave, "POST", "/api/save?root=0", body)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("save status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if got := readTestFile(t, fullPath); got != tt.wantSaved {
+				t.Errorf("saved bytes = %q, want %q", got, tt.wantSaved)
+			}
+			if tt.wantCode != 200 {
+				return
+			}
+
+			// 重新打开时按保存的编码解码，得到相同的文本
+			decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/a.txt", ""), 200, &content)
+			if got := strings.Join(content.Lines, "\n") + "\n"; got != tt.content {
+				t.Errorf("reopened content = %q, want %q", got, tt.content)
+			}
+		})
+	}
+}
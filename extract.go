@@ -0,0 +1,616 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 已存在文件的处理方式
+const (
+	OverwriteFail    = "fail"      // 存在冲突时拒绝解压
+	OverwriteSkip    = "skip"      // 跳过已存在的文件
+	OverwriteReplace = "overwrite" // 覆盖已存在的文件
+	OverwriteRename  = "rename"    // 另存为 "name (1).ext"（仅用于上传）
+)
+
+// 归档中符号链接的处理策略
+const (
+	SymlinkSkip  = "skip"  // 忽略符号链接
+	SymlinkError = "error" // 遇到符号链接时失败
+	SymlinkAllow = "allow" // 允许指向解压目录内部的链接
+)
+
+// 默认解压限制
+const (
+	defaultExtractMaxSize    = 4 << 30 // 4GB
+	defaultExtractMaxEntries = 100000
+)
+
+// ExtractConfig 解压限制配置（防止解压炸弹）
+type ExtractConfig struct {
+	MaxTotalSize int64 `json:"maxTotalSize,omitempty"` // 解压后总大小上限（字节）
+	MaxEntries   int   `json:"maxEntries,omitempty"`   // 条目数量上限
+}
+
+// ExtractRequest 解压请求
+type ExtractRequest struct {
+	Path      string `json:"path"`      // 归档文件路径
+	Target    string `json:"target"`    // 目标目录，默认为归档所在目录
+	Overwrite string `json:"overwrite"` // 已存在文件的处理方式：fail（默认）、skip、overwrite
+	Symlinks  string `json:"symlinks"`  // 符号链接处理策略：skip（默认）、error、allow
+}
+
+// handleExtract 处理解压归档请求，解压在后台任务中进行
+func (s *Server) handleExtract(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.handleError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ExtractRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.handleError(w, fmt.Errorf("invalid request body"), http.StatusBadRequest)
+		return
+	}
+
+	if req.Overwrite == "" {
+		req.Overwrite = OverwriteFail
+	}
+	if req.Overwrite != OverwriteFail && req.Overwrite != OverwriteSkip && req.Overwrite != OverwriteReplace {
+		s.handleError(w, fmt.Errorf("invalid overwrite mode: %s", req.Overwrite), http.StatusBadRequest)
+		return
+	}
+	if req.Symlinks == "" {
+		req.Symlinks = SymlinkSkip
+	}
+	if req.Symlinks != SymlinkSkip && req.Symlinks != SymlinkError && req.Symlinks != SymlinkAllow {
+		s.handleError(w, fmt.Errorf("invalid symlink policy: %s", req.Symlinks), http.StatusBadRequest)
+		return
+	}
+
+	rootIndex := getRootIndex(r)
+
+	// 远程根目录不支持该操作
+	if s.isRemote(rootIndex) {
+		s.handleError(w, errRemoteUnsupported, http.StatusNotImplemented)
+		return
+	}
+
+	// 构建归档文件的完整路径
+	archivePath := s.getFullPath(req.Path, rootIndex)
+
+	// 检查路径是否在根目录内
+	if !s.isPathSafe(archivePath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	kind := archiveKind(archivePath)
+	if kind != archiveZip && kind != archiveTar && kind != archiveTarGz {
+		s.handleError(w, fmt.Errorf("unsupported archive type"), http.StatusBadRequest)
+		return
+	}
+
+	// 读取归档索引，用于检查限制和冲突
+	idx, err := s.archives.get(archivePath)
+	if err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+
+	// 构建目标目录的完整路径
+	if req.Target == "" {
+		req.Target = filepath.ToSlash(filepath.Dir(s.relPath(archivePath, rootIndex)))
+	}
+	targetPath := s.getFullPath(req.Target, rootIndex)
+
+	// 检查目标目录是否在根目录内
+	if !s.isPathSafe(targetPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	if info, err := os.Stat(targetPath); err == nil && !info.IsDir() {
+		s.handleError(w, fmt.Errorf("target is not a directory"), http.StatusBadRequest)
+		return
+	}
+
+	// 检查解压限制
+	maxSize, maxEntries := s.extractLimits()
+	var totalSize int64
+	for _, entry := range idx.entries {
+		totalSize += entry.size
+	}
+	if len(idx.entries) > maxEntries {
+		s.handleError(w, fmt.Errorf("archive has too many entries (%d > %d)", len(idx.entries), maxEntries), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if totalSize > maxSize {
+		s.handleError(w, fmt.Errorf("archive is too large to extract (%d > %d bytes)", totalSize, maxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// 默认模式下，存在冲突时直接拒绝，避免解压到一半才失败
+	if req.Overwrite == OverwriteFail {
+		for name, entry := range idx.entries {
+			if entry.isDir {
+				continue
+			}
+			if _, err := os.Lstat(filepath.Join(targetPath, filepath.FromSlash(name))); err == nil {
+				s.handleError(w, fmt.Errorf("file already exists: %s", name), http.StatusConflict)
+				return
+			}
+		}
+	}
+
+	ex := &extractor{
+		target:     targetPath,
+		historyDir: s.historyDir(rootIndex),
+		stagingDir: s.stagingDir(rootIndex),
+		overwrite:  req.Overwrite,
+		symlinks:   req.Symlinks,
+		maxSize:    maxSize,
+		maxEntries: maxEntries,
+	}
+
+	// 按解压后的总大小占用配额，任务结束后重新统计实际用量
+	if err := s.reserve(rootIndex, totalSize); err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+
+	info := s.jobs.Start("extract", rootIndex, s.relPath(targetPath, rootIndex), func(job *Job) error {
+		defer s.invalidateUsage(rootIndex)
+		job.SetTotal(totalSize)
+		ex.job = job
+
+		if err := os.MkdirAll(targetPath, 0755); err != nil {
+			return err
+		}
+		// 解析目标目录自身的符号链接，后续用于检查写入位置
+		realTarget, err := filepath.EvalSymlinks(targetPath)
+		if err != nil {
+			return err
+		}
+		ex.realTarget = realTarget
+
+		if kind == archiveZip {
+			return ex.extractZip(archivePath)
+		}
+		return ex.extractTar(archivePath, kind == archiveTarGz)
+	})
+
+	s.writeJSON(w, info)
+}
+
+// extractLimits 返回解压限制，未配置时使用默认值
+func (s *Server) extractLimits() (int64, int) {
+	maxSize := s.config.Extract.MaxTotalSize
+	if maxSize <= 0 {
+		maxSize = defaultExtractMaxSize
+	}
+	maxEntries := s.config.Extract.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultExtractMaxEntries
+	}
+	return maxSize, maxEntries
+}
+
+// extractor 将归档解压到目标目录
+type extractor struct {
+	target     string // 目标目录
+	realTarget string // 解析符号链接后的目标目录
+	historyDir string // 根目录的历史版本目录，条目不能写入其中
+	stagingDir string // 根目录的上传暂存目录，条目同样不能写入其中
+	overwrite  string
+	symlinks   string
+	maxSize    int64
+	maxEntries int
+	job        *Job
+	written    int64
+	entries    int
+}
+
+// extractZip 解压 zip 归档
+func (e *extractor) extractZip(archivePath string) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if err := e.next(); err != nil {
+			return err
+		}
+
+		dest, err := e.resolve(f.Name)
+		if err != nil {
+			return err
+		}
+		if dest == "" {
+			continue
+		}
+
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = e.mkdir(dest)
+		case mode&os.ModeSymlink != 0:
+			err = e.zipSymlink(f, dest)
+		default:
+			err = e.zipFile(f, dest)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// zipFile 解压 zip 中的普通文件
+func (e *extractor) zipFile(f *zip.File, dest string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return e.writeFile(dest, rc, f.Mode())
+}
+
+// zipSymlink 解压 zip 中的符号链接（链接目标保存在文件内容中）
+func (e *extractor) zipSymlink(f *zip.File, dest string) error {
+	if e.symlinks != SymlinkAllow {
+		return e.linkPolicy(f.Name)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	linkname, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+	return e.symlink(dest, string(linkname))
+}
+
+// extractTar 解压 tar 或 tar.gz 归档
+func (e *extractor) extractTar(archivePath string, gzipped bool) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if gzipped {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := e.next(); err != nil {
+			return err
+		}
+
+		dest, err := e.resolve(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if dest == "" {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = e.mkdir(dest)
+		case tar.TypeReg:
+			err = e.writeFile(dest, tr, hdr.FileInfo().Mode())
+		case tar.TypeSymlink:
+			if e.symlinks != SymlinkAllow {
+				err = e.linkPolicy(hdr.Name)
+			} else {
+				err = e.symlink(dest, hdr.Linkname)
+			}
+		case tar.TypeLink:
+			if e.symlinks != SymlinkAllow {
+				err = e.linkPolicy(hdr.Name)
+			} else {
+				err = e.hardlink(dest, hdr.Linkname)
+			}
+		default:
+			// 设备文件、FIFO 等特殊文件一律跳过
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", hdr.Name, err)
+		}
+	}
+}
+
+// next 开始处理下一个条目，检查取消和条目数限制
+func (e *extractor) next() error {
+	if err := e.job.Context().Err(); err != nil {
+		return err
+	}
+
+	e.entries++
+	if e.entries > e.maxEntries {
+		return fmt.Errorf("archive has too many entries (limit %d)", e.maxEntries)
+	}
+	e.job.AddProgress(0, 1)
+	return nil
+}
+
+// resolve 计算条目的目标路径，拒绝绝对路径和 .. 等越界路径（zip-slip）
+// 返回空字符串表示该条目应当跳过
+func (e *extractor) resolve(name string) (string, error) {
+	slashed := filepath.ToSlash(name)
+	if strings.HasPrefix(slashed, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("illegal path in archive: %s", name)
+	}
+	for _, part := range strings.Split(slashed, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("illegal path in archive: %s", name)
+		}
+	}
+
+	clean := cleanEntryName(name)
+	if clean == "" {
+		return "", nil
+	}
+
+	dest := filepath.Join(e.target, filepath.FromSlash(clean))
+	if !isWithin(e.target, dest) {
+		return "", fmt.Errorf("illegal path in archive: %s", name)
+	}
+	if e.historyDir != "" && isWithin(e.historyDir, dest) {
+		return "", fmt.Errorf("path in archive is inside the history directory: %s", name)
+	}
+	if isWithin(e.stagingDir, dest) {
+		return "", fmt.Errorf("path in archive is inside the upload staging directory: %s", name)
+	}
+	return dest, nil
+}
+
+// checkParent 创建父目录，并确认父目录没有经由符号链接指向解压目录之外
+func (e *extractor) checkParent(dest string) error {
+	parent := filepath.Dir(dest)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+
+	realParent, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return err
+	}
+	if !isWithin(e.realTarget, realParent) {
+		return fmt.Errorf("path escapes target directory through a symlink")
+	}
+	return nil
+}
+
+// prepare 根据覆盖模式处理已存在的目标，返回 false 表示跳过
+func (e *extractor) prepare(dest string) (bool, error) {
+	if err := e.checkParent(dest); err != nil {
+		return false, err
+	}
+
+	info, err := os.Lstat(dest)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if info.IsDir() {
+		return false, fmt.Errorf("a directory with the same name already exists")
+	}
+
+	switch e.overwrite {
+	case OverwriteSkip:
+		return false, nil
+	case OverwriteReplace:
+		// 先删除再创建，避免顺着已有的符号链接或硬链接写到别处
+		return true, os.Remove(dest)
+	}
+	return false, fmt.Errorf("file already exists")
+}
+
+// mkdir 创建目录
+func (e *extractor) mkdir(dest string) error {
+	if err := e.checkParent(dest); err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(dest)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("a file with the same name already exists")
+		}
+		return nil
+	}
+	return os.Mkdir(dest, 0755)
+}
+
+// writeFile 写入普通文件，累计大小超出限制时中止
+func (e *extractor) writeFile(dest string, r io.Reader, mode os.FileMode) error {
+	ok, err := e.prepare(dest)
+	if err != nil || !ok {
+		return err
+	}
+
+	perm := mode.Perm()
+	if perm == 0 {
+		perm = 0644
+	}
+
+	file, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	// 多读一个字节用于判断是否超出限制（归档头中的大小不可信）
+	remaining := e.maxSize - e.written
+	n, err := io.Copy(&progressWriter{w: file, job: e.job}, io.LimitReader(r, remaining+1))
+	e.written += n
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > remaining {
+		err = fmt.Errorf("extracted size exceeds limit (%d bytes)", e.maxSize)
+	}
+	if err != nil {
+		os.Remove(dest)
+	}
+	return err
+}
+
+// symlink 创建符号链接，链接目标必须是不含 .. 的相对路径，并且按实际的文件系统解析后位于解压目录内
+func (e *extractor) symlink(dest, linkname string) error {
+	slashed := filepath.ToSlash(linkname)
+	if slashed == "" || strings.HasPrefix(slashed, "/") || filepath.IsAbs(linkname) || filepath.VolumeName(linkname) != "" {
+		return fmt.Errorf("symlink points outside target directory: %s", linkname)
+	}
+	for _, part := range strings.Split(slashed, "/") {
+		if part == ".." {
+			return fmt.Errorf("symlink points outside target directory: %s", linkname)
+		}
+	}
+
+	// 链接目标经过的已有链接（包括本归档先前创建的链接）按实际指向解析，只检查文本会被链式链接绕过
+	if err := e.checkParent(dest); err != nil {
+		return err
+	}
+	realParent, err := filepath.EvalSymlinks(filepath.Dir(dest))
+	if err != nil {
+		return err
+	}
+	target, err := realPath(filepath.Join(realParent, filepath.FromSlash(slashed)))
+	if err != nil {
+		return err
+	}
+	if !isWithin(e.realTarget, target) {
+		return fmt.Errorf("symlink points outside target directory: %s", linkname)
+	}
+
+	ok, err := e.prepare(dest)
+	if err != nil || !ok {
+		return err
+	}
+	return os.Symlink(linkname, dest)
+}
+
+// realPath 解析路径中已存在部分的符号链接，尚不存在的部分原样拼接；经过悬空的符号链接时返回错误
+func realPath(path string) (string, error) {
+	rest := ""
+	for {
+		real, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(real, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if _, lerr := os.Lstat(path); lerr == nil {
+			return "", fmt.Errorf("symlink target passes through a dangling link: %s", path)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(path), rest)
+		path = parent
+	}
+}
+
+// hardlink 创建硬链接，链接源必须是已解压到目标目录内的普通文件
+// 链接源的父目录按实际指向解析：os.Link 会跟随路径中的符号链接（包括本归档先前创建的链接），只检查文本会链接到目标目录之外的文件
+func (e *extractor) hardlink(dest, linkname string) error {
+	source, err := e.resolve(linkname)
+	if err != nil {
+		return err
+	}
+	if source == "" {
+		return fmt.Errorf("invalid hard link: %s", linkname)
+	}
+
+	realParent, err := filepath.EvalSymlinks(filepath.Dir(source))
+	if err != nil {
+		return err
+	}
+	realSource := filepath.Join(realParent, filepath.Base(source))
+	if !isWithin(e.realTarget, realSource) || e.isProtected(realSource) {
+		return fmt.Errorf("hard link points outside target directory: %s", linkname)
+	}
+	info, err := os.Lstat(realSource)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("hard link source is not a regular file: %s", linkname)
+	}
+
+	ok, err := e.prepare(dest)
+	if err != nil || !ok {
+		return err
+	}
+	return os.Link(realSource, dest)
+}
+
+// isProtected 判断解析后的路径是否位于历史版本目录或上传暂存目录内
+func (e *extractor) isProtected(realPath string) bool {
+	for _, dir := range []string{e.historyDir, e.stagingDir} {
+		if dir == "" {
+			continue
+		}
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = real
+		}
+		if isWithin(dir, realPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// linkPolicy 按策略处理不允许创建的链接
+func (e *extractor) linkPolicy(name string) error {
+	if e.symlinks == SymlinkError {
+		return fmt.Errorf("archive contains a link: %s", name)
+	}
+	return nil
+}
+
+// isWithin 判断 path 是否位于 dir 之内（含 dir 本身）
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
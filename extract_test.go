@@ -0,0 +1,302 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractorResolve(t *testing.T) {
+	target := t.TempDir()
+	e := &extractor{target: target}
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"a.txt", "a.txt", false},
+		{"dir/b.txt", "dir/b.txt", false},
+		{"./dir/./c.txt", "dir/c.txt", false},
+		{"dir/", "dir", false},
+		{"./", "", false},
+		{"../evil.txt", "", true},
+		{"dir/../../evil.txt", "", true},
+		{"dir/..", "", true},
+		{"/etc/passwd", "", true},
+	}
+	for _, tt := range tests {
+		got, err := e.resolve(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolve(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		want := ""
+		if tt.want != "" {
+			want = filepath.Join(target, filepath.FromSlash(tt.want))
+		}
+		if got != want {
+			t.Errorf("resolve(%q) = %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+// extractTestArchive 提交解压请求并等待任务结束
+func extractTestArchive(t *testing.T, s *Server, req ExtractRequest) JobInfo {
+	t.Helper()
+	var info JobInfo
+	decodeResponse(t, doRequest(s.handleExtract, "POST", "/api/extract?root=0", mustJSON(t, req)), 200, &info)
+	return waitJob(t, s, info.ID)
+}
+
+func TestExtractZipSlip(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive string
+		write   func(t *testing.T, fullPath string, entries []testArchiveEntry)
+	}{
+		{"zip", "slip.zip", writeTestZip},
+		{"tar", "slip.tar", func(t *testing.T, fullPath string, entries []testArchiveEntry) {
+			writeTestTar(t, fullPath, false, entries)
+		}},
+		{"tar.gz", "slip.tar.gz", func(t *testing.T, fullPath string, entries []testArchiveEntry) {
+			writeTestTar(t, fullPath, true, entries)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := newTestServer(t, nil)
+			tt.write(t, filepath.Join(dir, tt.archive), []testArchiveEntry{
+				{name: "ok.txt", content: "ok"},
+				{name: "sub/../../evil.txt", content: "evil"},
+			})
+
+			info := extractTestArchive(t, s, ExtractRequest{Path: "/" + tt.archive, Target: "/out"})
+			if info.Status != JobFailed || !strings.Contains(info.Error, "illegal path") {
+				t.Errorf("job = %s %q, want failed with illegal path", info.Status, info.Error)
+			}
+			for _, p := range []string{filepath.Join(dir, "evil.txt"), filepath.Join(filepath.Dir(dir), "evil.txt")} {
+				if _, err := os.Lstat(p); err == nil {
+					t.Errorf("%s was written outside the target", p)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractSymlinkPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		linkname   string
+		wantStatus string
+		wantLink   bool
+	}{
+		{"skip by default", "", "data.txt", JobDone, false},
+		{"error", SymlinkError, "data.txt", JobFailed, false},
+		{"allow inside", SymlinkAllow, "data.txt", JobDone, true},
+		{"allow relative outside", SymlinkAllow, "../../outside", JobFailed, false},
+		{"allow absolute outside", SymlinkAllow, "/etc/passwd", JobFailed, false},
+		{"allow dot dot inside", SymlinkAllow, "sub/../data.txt", JobFailed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := newTestServer(t, nil)
+			writeTestTar(t, filepath.Join(dir, "links.tar"), false, []testArchiveEntry{
+				{name: "data.txt", content: "data"},
+				{name: "link", linkname: tt.linkname},
+			})
+
+			info := extractTestArchive(t, s, ExtractRequest{Path: "/links.tar", Target: "/out", Symlinks: tt.policy})
+			if info.Status != tt.wantStatus {
+				t.Errorf("job = %s %q, want %s", info.Status, info.Error, tt.wantStatus)
+			}
+			_, err := os.Lstat(filepath.Join(dir, "out", "link"))
+			if (err == nil) != tt.wantLink {
+				t.Errorf("link exists = %v, want %v", err == nil, tt.wantLink)
+			}
+		})
+	}
+}
+
+func TestExtractChainedSymlinkEscape(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	writeTestFile(t, dir, "secret.txt", "secret")
+
+	// b 的目标文本上位于解压目录内，但经过 a 解析后指向解压目录的上级
+	writeTestTar(t, filepath.Join(dir, "chain.tar"), false, []testArchiveEntry{
+		{name: "a", linkname: "."},
+		{name: "b", linkname: "a/.."},
+	})
+	info := extractTestArchive(t, s, ExtractRequest{Path: "/chain.tar", Target: "/out", Symlinks: SymlinkAllow})
+	if info.Status != JobFailed {
+		t.Errorf("job = %s, want failed", info.Status)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "out", "b")); err == nil {
+		t.Errorf("chained link was created")
+	}
+
+	// 链式链接的目标解析后仍在解压目录内时允许
+	writeTestTar(t, filepath.Join(dir, "inside.tar"), false, []testArchiveEntry{
+		{name: "data.txt", content: "data"},
+		{name: "a", linkname: "."},
+		{name: "b", linkname: "a/a/data.txt"},
+	})
+	info = extractTestArchive(t, s, ExtractRequest{Path: "/inside.tar", Target: "/in", Symlinks: SymlinkAllow})
+	if info.Status != JobDone {
+		t.Errorf("job = %s %q, want done", info.Status, info.Error)
+	}
+	if got := readTestFile(t, filepath.Join(dir, "in", "b")); got != "data" {
+		t.Errorf("b = %q", got)
+	}
+
+	// 经由已有的符号链接指向解压目录之外
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "via"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "via", "escape")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestTar(t, filepath.Join(dir, "via.tar"), false, []testArchiveEntry{{name: "link", linkname: "escape/x"}})
+	info = extractTestArchive(t, s, ExtractRequest{Path: "/via.tar", Target: "/via", Symlinks: SymlinkAllow})
+	if info.Status != JobFailed {
+		t.Errorf("link through existing symlink: job = %s, want failed", info.Status)
+	}
+}
+
+func TestExtractHardlinkThroughSymlink(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	outside := t.TempDir()
+	secret := writeTestFile(t, outside, "secret.txt", "secret")
+	if err := os.MkdirAll(filepath.Join(dir, "out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "out", "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 链接源经由符号链接指向解压目录之外，或本身是符号链接
+	for _, entries := range [][]testArchiveEntry{
+		{{name: "h", linkname: "escape/secret.txt", hardlink: true}},
+		{{name: "a", linkname: "."}, {name: "h", linkname: "a/escape/secret.txt", hardlink: true}},
+		{{name: "h", linkname: "escape", hardlink: true}},
+	} {
+		writeTestTar(t, filepath.Join(dir, "h.tar"), false, entries)
+		info := extractTestArchive(t, s, ExtractRequest{Path: "/h.tar", Target: "/out", Symlinks: SymlinkAllow, Overwrite: OverwriteReplace})
+		if info.Status != JobFailed {
+			t.Errorf("%+v: job = %s, want failed", entries, info.Status)
+		}
+		if _, err := os.Lstat(filepath.Join(dir, "out", "h")); err == nil {
+			t.Errorf("%+v: hard link was created", entries)
+		}
+	}
+	if got := readTestFile(t, secret); got != "secret" {
+		t.Errorf("secret = %q", got)
+	}
+
+	// 经由解压目录内的符号链接指向解压目录内的文件时允许
+	writeTestTar(t, filepath.Join(dir, "in.tar"), false, []testArchiveEntry{
+		{name: "data.txt", content: "data"},
+		{name: "a", linkname: "."},
+		{name: "h", linkname: "a/data.txt", hardlink: true},
+	})
+	info := extractTestArchive(t, s, ExtractRequest{Path: "/in.tar", Target: "/in", Symlinks: SymlinkAllow})
+	if info.Status != JobDone {
+		t.Errorf("job = %s %q, want done", info.Status, info.Error)
+	}
+	if got := readTestFile(t, filepath.Join(dir, "in", "h")); got != "data" {
+		t.Errorf("h = %q", got)
+	}
+}
+
+func TestExtractThroughExistingSymlink(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "out"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "out", "escape")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestZip(t, filepath.Join(dir, "a.zip"), []testArchiveEntry{{name: "escape/evil.txt", content: "evil"}})
+
+	info := extractTestArchive(t, s, ExtractRequest{Path: "/a.zip", Target: "/out"})
+	if info.Status != JobFailed {
+		t.Errorf("job = %s, want failed", info.Status)
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "evil.txt")); err == nil {
+		t.Errorf("file was written through a symlink outside the target")
+	}
+}
+
+func TestExtractOverwrite(t *testing.T) {
+	tests := []struct {
+		name       string
+		overwrite  string
+		wantCode   int
+		wantStatus string
+		want       string
+	}{
+		{"fail", "", 409, "", "old"},
+		{"skip", OverwriteSkip, 200, JobDone, "old"},
+		{"overwrite", OverwriteReplace, 200, JobDone, "new"},
+		{"invalid", "rename", 400, "", "old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := newTestServer(t, nil)
+			writeTestZip(t, filepath.Join(dir, "a.zip"), []testArchiveEntry{
+				{name: "a.txt", content: "new"},
+				{name: "b.txt", content: "b"},
+			})
+			existing := writeTestFile(t, dir, "a.txt", "old")
+
+			req := mustJSON(t, ExtractRequest{Path: "/a.zip", Overwrite: tt.overwrite})
+			rec := doRequest(s.handleExtract, "POST", "/api/extract?root=0", req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if tt.wantStatus != "" {
+				var info JobInfo
+				decodeResponse(t, rec, 200, &info)
+				if info = waitJob(t, s, info.ID); info.Status != tt.wantStatus {
+					t.Errorf("job = %s %q", info.Status, info.Error)
+				}
+				if got := readTestFile(t, filepath.Join(dir, "b.txt")); got != "b" {
+					t.Errorf("b.txt = %q", got)
+				}
+			}
+			if got := readTestFile(t, existing); got != tt.want {
+				t.Errorf("a.txt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ExtractConfig
+	}{
+		{"entries", ExtractConfig{MaxEntries: 1}},
+		{"size", ExtractConfig{MaxTotalSize: 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := newTestServer(t, func(config *Config) { config.Extract = tt.config })
+			writeTestZip(t, filepath.Join(dir, "a.zip"), []testArchiveEntry{
+				{name: "a.txt", content: "aaa"},
+				{name: "b.txt", content: "bbb"},
+			})
+			rec := doRequest(s.handleExtract, "POST", "/api/extract?root=0", mustJSON(t, ExtractRequest{Path: "/a.zip"}))
+			if rec.Code != 413 {
+				t.Errorf("status = %d, want 413: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 默认下载限制
+const (
+	defaultFetchMaxSize = 1 << 30 // 1GB
+	defaultFetchTimeout = 600     // 秒
+	maxFetchRedirects   = 10
+)
+
+// FetchConfig 从 URL 下载到服务器的配置
+type FetchConfig struct {
+	AllowedHosts []string `json:"allowedHosts,omitempty"` // 允许访问的主机（可带端口，支持 "*.example.com"），为空时禁用该功能
+	MaxSize      int64    `json:"maxSize,omitempty"`      // 单个文件大小上限（字节）
+	Timeout      int      `json:"timeout,omitempty"`      // 整个下载的超时时间（秒）
+}
+
+// FetchRequest 从 URL 下载请求
+type FetchRequest struct {
+	URL       string `json:"url"`       // 下载地址（http 或 https）
+	Path      string `json:"path"`      // 目标目录
+	Name      string `json:"name"`      // 保存的文件名，默认取 URL 路径的最后一段
+	Overwrite string `json:"overwrite"` // 已存在文件的处理方式：fail（默认）、overwrite、rename
+}
+
+// fetchLimits 获取下载的大小上限和超时时间
+func (s *Server) fetchLimits() (int64, time.Duration) {
+	maxSize := s.config.Fetch.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultFetchMaxSize
+	}
+	timeout := s.config.Fetch.Timeout
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+	return maxSize, time.Duration(timeout) * time.Second
+}
+
+// hostAllowed 检查 URL 的协议和主机是否在允许列表中，防止借服务器访问任意内部地址
+func (s *Server) hostAllowed(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+
+	hostname := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	for _, allowed := range s.config.Fetch.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+
+		// 带端口的条目要求端口也一致
+		allowedHost, allowedPort, err := net.SplitHostPort(allowed)
+		if err != nil {
+			allowedHost, allowedPort = allowed, ""
+		}
+		if allowedPort != "" && allowedPort != port {
+			continue
+		}
+
+		if suffix, ok := strings.CutPrefix(allowedHost, "*."); ok {
+			if strings.HasSuffix(hostname, "."+suffix) {
+				return true
+			}
+		} else if hostname == strings.Trim(allowedHost, "[]") {
+			return true
+		}
+	}
+	return false
+}
+
+// handleFetch 处理从 URL 下载到服务器的请求，下载在后台任务中进行
+func (s *Server) handleFetch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.handleError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if len(s.config.Fetch.AllowedHosts) == 0 {
+		s.handleError(w, fmt.Errorf("fetching from URL is disabled"), http.StatusForbidden)
+		return
+	}
+
+	var req FetchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.handleError(w, fmt.Errorf("invalid request body"), http.StatusBadRequest)
+		return
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil || u.Host == "" {
+		s.handleError(w, fmt.Errorf("invalid url"), http.StatusBadRequest)
+		return
+	}
+	if !s.hostAllowed(u) {
+		s.handleError(w, fmt.Errorf("host not allowed: %s", u.Host), http.StatusForbidden)
+		return
+	}
+
+	if req.Overwrite == "" {
+		req.Overwrite = OverwriteFail
+	}
+	if req.Overwrite != OverwriteFail && req.Overwrite != OverwriteReplace && req.Overwrite != OverwriteRename {
+		s.handleError(w, fmt.Errorf("invalid overwrite mode: %s", req.Overwrite), http.StatusBadRequest)
+		return
+	}
+
+	rootIndex := getRootIndex(r)
+
+	// 远程根目录不支持该操作
+	if s.isRemote(rootIndex) {
+		s.handleError(w, errRemoteUnsupported, http.StatusNotImplemented)
+		return
+	}
+
+	// 构建目标目录的完整路径
+	dirPath := s.getFullPath(req.Path, rootIndex)
+
+	// 检查路径是否在根目录内
+	if !s.isPathSafe(dirPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	if info, err := os.Stat(dirPath); err != nil || !info.IsDir() {
+		s.handleError(w, fmt.Errorf("target directory does not exist"), http.StatusBadRequest)
+		return
+	}
+
+	// 默认使用 URL 路径的最后一段作为文件名
+	name := req.Name
+	if name == "" {
+		name = pathpkg.Base(u.Path)
+	}
+	if name == "" || name == "." || name == ".." || name == "/" || strings.ContainsAny(name, `/\`) {
+		s.handleError(w, fmt.Errorf("invalid file name, please specify name"), http.StatusBadRequest)
+		return
+	}
+
+	// 检查扩展名是否被根目录允许
+	if err := s.uploadPolicy(rootIndex).checkName(name); err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+
+	// 构建目标文件的完整路径
+	fullPath := filepath.Join(dirPath, name)
+
+	// 再次检查完整路径是否在根目录内
+	if !s.isPathSafe(fullPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	// 检查文件是否已存在
+	if info, err := os.Lstat(fullPath); err == nil {
+		switch {
+		case req.Overwrite == OverwriteRename:
+			fullPath = freeName(fullPath)
+			if !s.isPathSafe(fullPath, rootIndex) {
+				s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+				return
+			}
+		case info.IsDir():
+			s.handleError(w, fmt.Errorf("a directory with the same name already exists"), http.StatusConflict)
+			return
+		case req.Overwrite == OverwriteFail:
+			s.handleError(w, fmt.Errorf("file already exists"), http.StatusConflict)
+			return
+		}
+	}
+
+	info := s.jobs.Start("fetch", rootIndex, s.relPath(fullPath, rootIndex), func(job *Job) error {
+		return s.fetchURL(job, rootIndex, u, fullPath, req.Overwrite)
+	})
+
+	s.writeJSON(w, info)
+}
+
+// fetchURL 下载 URL 的内容：先写入同目录下的临时文件，完成并通过检查后再重命名为目标文件
+func (s *Server) fetchURL(job *Job, rootIndex int, u *url.URL, fullPath, overwrite string) error {
+	maxSize, timeout := s.fetchLimits()
+
+	client := &http.Client{
+		Timeout: timeout,
+		// 重定向的目标同样需要在允许列表中
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fmt.Errorf("too many redirects")
+			}
+			if !s.hostAllowed(req.URL) {
+				return fmt.Errorf("redirect to host not allowed: %s", req.URL.Host)
+			}
+			return nil
+		},
+	}
+
+	req, err := http.NewRequestWithContext(job.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("remote server returned %s", resp.Status)
+	}
+
+	// 服务端声明了大小时提前检查
+	if resp.ContentLength > maxSize {
+		return fmt.Errorf("%w: maximum is %d bytes", errTooLarge, maxSize)
+	}
+	if resp.ContentLength > 0 {
+		if err := s.checkFileSize(rootIndex, resp.ContentLength); err != nil {
+			return err
+		}
+		job.SetTotal(resp.ContentLength)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), "."+filepath.Base(fullPath)+".*.partial")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	// 边下载边检查大小和配额，多读一个字节用于判断是否超出上限
+	lw := s.newLimitedWriter(tmp, rootIndex)
+	_, err = io.Copy(&progressWriter{w: lw, job: job}, io.LimitReader(resp.Body, maxSize+1))
+	if err == nil && lw.written > maxSize {
+		err = fmt.Errorf("%w: maximum is %d bytes", errTooLarge, maxSize)
+	}
+	if err == nil {
+		err = s.uploadPolicy(rootIndex).checkType(lw.head)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, 0644)
+	}
+	if err == nil {
+		var finalPath string
+		if finalPath, err = s.commitFile(rootIndex, tmpPath, fullPath, overwrite); err == nil {
+			job.SetPath(s.relPath(finalPath, rootIndex))
+		}
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		lw.release()
+	}
+	return err
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fetchTestURL 提交下载请求并等待任务结束
+func fetchTestURL(t *testing.T, s *Server, req FetchRequest) JobInfo {
+	t.Helper()
+	var info JobInfo
+	decodeResponse(t, doRequest(s.handleFetch, "POST", "/api/fetch?root=0", mustJSON(t, req)), 200, &info)
+	return waitJob(t, s, info.ID)
+}
+
+// newFetchTestServer 创建使用指定下载配置的服务器
+func newFetchTestServer(t *testing.T, fetch FetchConfig) (*Server, string) {
+	t.Helper()
+	return newTestServer(t, func(config *Config) { config.Fetch = fetch })
+}
+
+func TestHostAllowed(t *testing.T) {
+	s, _ := newFetchTestServer(t, FetchConfig{AllowedHosts: []string{"example.com", "*.example.org", "files.local:8080", "[::1]"}})
+	tests := []struct {
+		rawURL string
+		want   bool
+	}{
+		{"https://example.com/a.txt", true},
+		{"http://EXAMPLE.com:8443/a.txt", true},
+		{"https://sub.example.com/a.txt", false},
+		{"https://cdn.example.org/a.txt", true},
+		{"https://example.org/a.txt", false},
+		{"https://evilexample.org/a.txt", false},
+		{"http://files.local:8080/a", true},
+		{"http://files.local/a", false},
+		{"http://[::1]:9000/a", true},
+		{"ftp://example.com/a.txt", false},
+		{"file:///etc/passwd", false},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.hostAllowed(u); got != tt.want {
+			t.Errorf("hostAllowed(%s) = %v, want %v", tt.rawURL, got, tt.want)
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("from another host"))
+	}))
+	defer other.Close()
+
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file.txt":
+			w.Write([]byte("hello world"))
+		case "/redirect":
+			http.Redirect(w, r, "/file.txt", http.StatusFound)
+		case "/redirect-away":
+			http.Redirect(w, r, other.URL+"/x.txt", http.StatusFound)
+		case "/big.bin":
+			w.Write([]byte(strings.Repeat("x", 100)))
+		case "/chunked.bin":
+			// 不声明长度，下载过程中才能发现超出上限
+			for i := 0; i < 10; i++ {
+				w.Write([]byte(strings.Repeat("x", 10)))
+				w.(http.Flusher).Flush()
+			}
+		case "/slow.txt":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer remote.Close()
+	host := strings.TrimPrefix(remote.URL, "http://")
+
+	tests := []struct {
+		name       string
+		fetch      FetchConfig
+		path       string
+		wantStatus string
+		wantError  string
+		wantFile   string
+	}{
+		{"download", FetchConfig{}, "/file.txt", JobDone, "", "hello world"},
+		{"redirect within allowed host", FetchConfig{}, "/redirect", JobDone, "", "hello world"},
+		{"redirect to other host", FetchConfig{}, "/redirect-away", JobFailed, "redirect to host not allowed", ""},
+		{"declared size too large", FetchConfig{MaxSize: 50}, "/big.bin", JobFailed, "file too large", ""},
+		{"streamed size too large", FetchConfig{MaxSize: 50}, "/chunked.bin", JobFailed, "file too large", ""},
+		{"remote error", FetchConfig{}, "/missing", JobFailed, "404", ""},
+		{"timeout", FetchConfig{Timeout: 1}, "/slow.txt", JobFailed, "Timeout", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetch := tt.fetch
+			fetch.AllowedHosts = []string{host}
+			s, dir := newFetchTestServer(t, fetch)
+
+			info := fetchTestURL(t, s, FetchRequest{URL: remote.URL + tt.path, Path: "/", Name: "saved.bin"})
+			if info.Status != tt.wantStatus || !strings.Contains(info.Error, tt.wantError) {
+				t.Fatalf("job = %s %q, want %s containing %q", info.Status, info.Error, tt.wantStatus, tt.wantError)
+			}
+			if tt.wantFile != "" {
+				if got := readTestFile(t, filepath.Join(dir, "saved.bin")); got != tt.wantFile {
+					t.Errorf("content = %q", got)
+				}
+				if info.Done != int64(len(tt.wantFile)) || info.Total != int64(len(tt.wantFile)) || info.Path != "/saved.bin" {
+					t.Errorf("progress = %d/%d path=%s", info.Done, info.Total, info.Path)
+				}
+			}
+			if matches, _ := filepath.Glob(filepath.Join(dir, ".saved.bin.*")); len(matches) != 0 {
+				t.Errorf("temporary files left: %v", matches)
+			}
+		})
+	}
+}
+
+func TestFetchValidation(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer remote.Close()
+	host := strings.TrimPrefix(remote.URL, "http://")
+
+	tests := []struct {
+		name  string
+		hosts []string
+		req   FetchRequest
+		want  int
+	}{
+		{"disabled", nil, FetchRequest{URL: remote.URL + "/a.txt"}, 403},
+		{"host not allowed", []string{"example.com"}, FetchRequest{URL: remote.URL + "/a.txt"}, 403},
+		{"invalid url", []string{host}, FetchRequest{URL: "not a url"}, 400},
+		{"no file name", []string{host}, FetchRequest{URL: remote.URL + "/"}, 400},
+		{"exists", []string{host}, FetchRequest{URL: remote.URL + "/a.txt"}, 409},
+		{"skip not supported", []string{host}, FetchRequest{URL: remote.URL + "/a.txt", Overwrite: OverwriteSkip}, 400},
+		{"target outside root", []string{host}, FetchRequest{URL: remote.URL + "/b.txt", Path: "/.."}, 403},
+		{"name with separator", []string{host}, FetchRequest{URL: remote.URL + "/b.txt", Name: "../b.txt"}, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := newFetchTestServer(t, FetchConfig{AllowedHosts: tt.hosts})
+			writeTestFile(t, dir, "a.txt", "existing")
+
+			rec := doRequest(s.handleFetch, "POST", "/api/fetch?root=0", mustJSON(t, tt.req))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestFetchRename(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new"))
+	}))
+	defer remote.Close()
+	s, dir := newFetchTestServer(t, FetchConfig{AllowedHosts: []string{strings.TrimPrefix(remote.URL, "http://")}})
+	writeTestFile(t, dir, "a.txt", "old")
+
+	info := fetchTestURL(t, s, FetchRequest{URL: remote.URL + "/a.txt", Overwrite: OverwriteRename})
+	if info.Status != JobDone || info.Path != "/a (1).txt" {
+		t.Fatalf("job = %s %q path=%s", info.Status, info.Error, info.Path)
+	}
+	if got := readTestFile(t, filepath.Join(dir, "a (1).txt")); got != "new" {
+		t.Errorf("renamed content = %q", got)
+	}
+}
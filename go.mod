@@ -1,3 +1,27 @@
 module filebrowser
 
 go 1.25.0
+
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/bodgit/sevenzip v1.6.0
+	github.com/klauspost/compress v1.17.9
+	github.com/pkg/sftp v1.13.9
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/crypto v0.43.0
+	golang.org/x/text v0.30.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/andybalholm/brotli v1.1.1 // indirect
+	github.com/bodgit/plumbing v1.3.0 // indirect
+	github.com/bodgit/windows v1.0.1 // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/hashicorp/go-multierror v1.1.1 // indirect
+	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/pierrec/lz4/v4 v4.1.21 // indirect
+	go4.org v0.0.0-20200411211856-f5505b9728dd // indirect
+	golang.org/x/sys v0.37.0 // indirect
+)
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// 判断是否为二进制内容时读取的字节数
+	binarySniffLen = 8 * 1024
+	// 十六进制视图每行的字节数
+	hexBytesPerRow = 16
+	// 十六进制视图默认和最多读取的字节数
+	hexDefaultLength = 4 * 1024
+	hexMaxLength     = 64 * 1024
+	// 字节搜索每次读取的块大小
+	hexSearchChunk = 256 * 1024
+	// 字节搜索最多返回的结果数
+	hexMaxMatches = 100
+	// 字节搜索的模式最大长度
+	hexMaxPattern = 1024
+)
+
+// errBinaryFile 文件是二进制内容，不能按行查看
+var errBinaryFile = errors.New("binary file cannot be viewed as text")
+
+// binaryError 内容是二进制时返回的错误，附带识别出的 MIME 类型
+type binaryError struct {
+	contentType string
+}
+
+// Error 返回错误信息
+func (e *binaryError) Error() string {
+	return errBinaryFile.Error()
+}
+
+// Is 使 errors.Is(err, errBinaryFile) 成立
+func (e *binaryError) Is(target error) bool {
+	return target == errBinaryFile
+}
+
+// BinaryFile 以文本方式查看或搜索二进制文件时的响应
+type BinaryFile struct {
+	Error       string `json:"error"`
+	Binary      bool   `json:"binary"`
+	ContentType string `json:"contentType"` // 根据内容识别的 MIME 类型
+}
+
+// HexRow 十六进制视图的一行
+type HexRow struct {
+	Offset int64  `json:"offset"` // 该行第一个字节的偏移
+	Hex    string `json:"hex"`    // 以空格分隔的十六进制字节
+	Text   string `json:"text"`   // 可打印的 ASCII 字符，其余显示为 .
+}
+
+// HexDump 十六进制视图响应
+type HexDump struct {
+	Path        string   `json:"path"`
+	Name        string   `json:"name"`
+	Size        int64    `json:"size"`
+	Offset      int64    `json:"offset"`      // 本段的起始偏移（按行对齐）
+	Length      int      `json:"length"`      // 本段实际读取的字节数
+	BytesPerRow int      `json:"bytesPerRow"` // 每行的字节数
+	Rows        []HexRow `json:"rows"`
+	ContentType string   `json:"contentType"` // 根据内容识别的 MIME 类型
+	Version     string   `json:"version,omitempty"`
+}
+
+// HexSearchResult 字节搜索结果
+type HexSearchResult struct {
+	Matches    []int64 `json:"matches"`              // 匹配的起始偏移
+	Truncated  bool    `json:"truncated"`            // 是否因达到数量上限而停止
+	NextOffset int64   `json:"nextOffset,omitempty"` // 继续搜索时使用的 from
+}
+
+// contentType 根据内容识别 MIME 类型，去掉参数
+func contentType(head []byte) string {
+	mimeType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	return mimeType
+}
+
+// isBinaryContent 根据文件开头的内容判断是否为二进制：UTF-16 文本除外，含有零字节
+// 或者 http.DetectContentType 识别为非文本类型时视为二进制
+func isBinaryContent(head []byte) bool {
+	if bytes.HasPrefix(head, bomUTF16LE) || bytes.HasPrefix(head, bomUTF16BE) {
+		return false
+	}
+	if name := guessUTF16(head); name != "" {
+		// 没有 BOM 时零字节的分布只是猜测，解码后还含有控制字符的是二进制
+		return hasControlUnits(head, name == "utf-16be")
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true
+	}
+
+	mimeType := contentType(head)
+	return !strings.HasPrefix(mimeType, "text/") && mimeType != "application/postscript"
+}
+
+// hasControlUnits 判断 UTF-16 文本中是否含有制表符、换行符、换页符和 ESC 以外的控制字符
+func hasControlUnits(head []byte, bigEndian bool) bool {
+	for i := 0; i+1 < len(head); i += 2 {
+		u := uint16(head[i]) | uint16(head[i+1])<<8
+		if bigEndian {
+			u = uint16(head[i])<<8 | uint16(head[i+1])
+		}
+		if u < 0x20 && u != '\t' && u != '\n' && u != '\r' && u != '\f' && u != 0x1b {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffBinary 读取文件开头判断是否为二进制内容，之后定位回文件开头
+func sniffBinary(file File) (bool, string, error) {
+	head := make([]byte, binarySniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false, "", err
+	}
+	return isBinaryContent(head[:n]), contentType(head[:n]), nil
+}
+
+// peekBinary 检查数据流开头是否为二进制内容，返回的读取器仍从头读取
+func peekBinary(r io.Reader) (io.Reader, bool, string) {
+	br := bufio.NewReaderSize(r, binarySniffLen)
+	head, _ := br.Peek(binarySniffLen)
+	return br, isBinaryContent(head), contentType(head)
+}
+
+// binaryFile 返回 415 和识别出的 MIME 类型，提示改用十六进制视图
+func (s *Server) binaryFile(w http.ResponseWriter, mimeType string) {
+	s.writeJSONStatus(w, http.StatusUnsupportedMediaType, BinaryFile{
+		Error:       errBinaryFile.Error(),
+		Binary:      true,
+		ContentType: mimeType,
+	})
+}
+
+// writeJSONStatus 以指定的状态码写入 JSON 响应
+func (s *Server) writeJSONStatus(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	s.writeJSON(w, data)
+}
+
+// handleHex 处理十六进制视图请求：从 offset 开始读取 length 个字节
+// offset 为负数时从文件末尾倒数，起始位置按行对齐
+func (s *Server) handleHex(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		s.handleError(w, fmt.Errorf("path parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	offset, err := parseInt64Param(r, "offset", 0)
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+	length, err := parseInt64Param(r, "length", hexDefaultLength)
+	if err != nil || length <= 0 {
+		s.handleError(w, fmt.Errorf("invalid length"), http.StatusBadRequest)
+		return
+	}
+	length = min(length, hexMaxLength)
+
+	rootIndex := getRootIndex(r)
+
+	// 构建完整路径
+	fullPath := s.getFullPath(path, rootIndex)
+
+	// 检查路径是否在根目录内
+	if !s.isPathSafe(fullPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	// 打开文件（支持归档内的文件）
+	file, info, err := s.openFile(rootIndex, fullPath)
+	if err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+	defer file.Close()
+
+	_, mimeType, err := sniffBinary(file)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// 负数偏移从末尾倒数，超出范围时停在文件末尾所在的行
+	size := info.Size()
+	if offset < 0 {
+		offset = max(size+offset, 0)
+	}
+	if offset > size {
+		offset = size
+	}
+	offset -= offset % hexBytesPerRow
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	buf := make([]byte, length)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, HexDump{
+		Path:        fullPath,
+		Name:        info.Name(),
+		Size:        size,
+		Offset:      offset,
+		Length:      n,
+		BytesPerRow: hexBytesPerRow,
+		Rows:        hexRows(buf[:n], offset),
+		ContentType: mimeType,
+		Version:     s.fileVersion(rootIndex, fullPath, file, info),
+	})
+}
+
+// hexRows 将数据按行格式化为十六进制和 ASCII
+func hexRows(data []byte, offset int64) []HexRow {
+	rows := make([]HexRow, 0, (len(data)+hexBytesPerRow-1)/hexBytesPerRow)
+	for start := 0; start < len(data); start += hexBytesPerRow {
+		chunk := data[start:min(start+hexBytesPerRow, len(data))]
+
+		var hexText, text strings.Builder
+		for i, b := range chunk {
+			if i > 0 {
+				hexText.WriteByte(' ')
+				// 每 8 个字节之间多留一个空格
+				if i%8 == 0 {
+					hexText.WriteByte(' ')
+				}
+			}
+			hexText.WriteString(hex.EncodeToString([]byte{b}))
+
+			if b >= 0x20 && b < 0x7f {
+				text.WriteByte(b)
+			} else {
+				text.WriteByte('.')
+			}
+		}
+
+		rows = append(rows, HexRow{Offset: offset + int64(start), Hex: hexText.String(), Text: text.String()})
+	}
+	return rows
+}
+
+// handleHexSearch 处理字节搜索请求：mode 为 hex（默认）时 q 为十六进制字节，为 text 时按原始字节搜索 q
+// 从 from 偏移开始，最多返回 100 个匹配位置
+func (s *Server) handleHexSearch(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	query := r.URL.Query().Get("q")
+
+	if path == "" {
+		s.handleError(w, fmt.Errorf("path parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	if query == "" {
+		s.handleError(w, fmt.Errorf("query parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	var pattern []byte
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "hex":
+		var err error
+		if pattern, err = parseHexPattern(query); err != nil {
+			s.handleError(w, err, http.StatusBadRequest)
+			return
+		}
+	case "text":
+		pattern = []byte(query)
+	default:
+		s.handleError(w, fmt.Errorf("invalid mode: %s", mode), http.StatusBadRequest)
+		return
+	}
+	if len(pattern) > hexMaxPattern {
+		s.handleError(w, fmt.Errorf("pattern is too long"), http.StatusBadRequest)
+		return
+	}
+
+	from, err := parseInt64Param(r, "from", 0)
+	if err != nil || from < 0 {
+		s.handleError(w, fmt.Errorf("invalid from"), http.StatusBadRequest)
+		return
+	}
+
+	rootIndex := getRootIndex(r)
+
+	// 构建完整路径
+	fullPath := s.getFullPath(path, rootIndex)
+
+	// 检查路径是否在根目录内
+	if !s.isPathSafe(fullPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	// 打开文件（支持归档内的文件）
+	file, _, err := s.openFile(rootIndex, fullPath)
+	if err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(from, io.SeekStart); err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	result, err := searchBytes(file, from, pattern, hexMaxMatches)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, result)
+}
+
+// parseHexPattern 解析十六进制字节模式，如 "de ad be ef"、"deadbeef"、"0xdeadbeef" 或 "de:ad:be:ef"
+func parseHexPattern(query string) ([]byte, error) {
+	query = strings.TrimSpace(query)
+	query = strings.TrimPrefix(strings.TrimPrefix(query, "0x"), "0X")
+	query = strings.NewReplacer(" ", "", "\t", "", ":", "").Replace(query)
+
+	pattern, err := hex.DecodeString(query)
+	if err != nil || len(pattern) == 0 {
+		return nil, fmt.Errorf("invalid hex pattern")
+	}
+	return pattern, nil
+}
+
+// searchBytes 在数据流中搜索 pattern，start 为数据流开头在文件中的偏移
+// 相邻的块之间保留 len(pattern)-1 个字节，跨块的匹配同样能被找到
+func searchBytes(r io.Reader, start int64, pattern []byte, maxMatches int) (HexSearchResult, error) {
+	result := HexSearchResult{Matches: []int64{}}
+	buf := make([]byte, 0, hexSearchChunk+len(pattern))
+	base := start // buf[0] 在文件中的偏移
+
+	for {
+		n, err := io.ReadFull(r, buf[len(buf):len(buf)+hexSearchChunk])
+		buf = buf[:len(buf)+n]
+
+		for i := 0; ; {
+			j := bytes.Index(buf[i:], pattern)
+			if j < 0 {
+				break
+			}
+			match := base + int64(i+j)
+			if len(result.Matches) == maxMatches {
+				result.Truncated = true
+				result.NextOffset = match
+				return result, nil
+			}
+			result.Matches = append(result.Matches, match)
+			i += j + 1
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+
+		// 保留末尾不足以构成完整匹配的字节
+		keep := min(len(pattern)-1, len(buf))
+		base += int64(len(buf) - keep)
+		buf = buf[:copy(buf, buf[len(buf)-keep:])]
+	}
+}
+
+// parseInt64Param 解析整数查询参数，缺省时返回 def
+func parseInt64Param(r *http.Request, name string, def int64) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", name, value)
+	}
+	return n, nil
+}
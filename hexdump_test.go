@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIsBinaryContent(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want bool
+	}{
+		{"empty", "", false},
+		{"ascii", "hello\nworld\n", false},
+		{"gbk", "\xc4\xe3\xba\xc3\n", false},
+		{"ansi colors", "\x1b[31merror\x1b[0m\n", false},
+		{"utf-16le", "\xff\xfeh\x00i\x00", false},
+		{"utf-16be without bom", encodeUTF16("hello world\n", true), false},
+		{"zero bytes that look like utf-16", strings.Repeat("\x00\x01", 50), true},
+		{"nul byte", "abc\x00def", true},
+		{"elf", "\x7fELF\x02\x01\x01\x00\x00\x00", true},
+		{"png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", true},
+		{"control bytes", "\x08\x96\x01\x12\x07testing", true},
+		{"pdf", "%PDF-1.7\n", true},
+	}
+	for _, tt := range tests {
+		if got := isBinaryContent([]byte(tt.head)); got != tt.want {
+			t.Errorf("%s: isBinaryContent = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHexRows(t *testing.T) {
+	rows := hexRows([]byte("0123456789abcdef\x00\xffA"), 32)
+	if len(rows) != 2 {
+		t.Fatalf("rows = %+v", rows)
+	}
+	if rows[0].Offset != 32 || rows[0].Hex != "30 31 32 33 34 35 36 37  38 39 61 62 63 64 65 66" || rows[0].Text != "0123456789abcdef" {
+		t.Errorf("row 0 = %+v", rows[0])
+	}
+	if rows[1].Offset != 48 || rows[1].Hex != "00 ff 41" || rows[1].Text != "..A" {
+		t.Errorf("row 1 = %+v", rows[1])
+	}
+}
+
+func TestParseHexPattern(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{"deadbeef", "\xde\xad\xbe\xef", false},
+		{"DE AD BE EF", "\xde\xad\xbe\xef", false},
+		{"0x7f454c46", "\x7fELF", false},
+		{"de:ad", "\xde\xad", false},
+		{"abc", "", true},
+		{"zz", "", true},
+		{" ", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseHexPattern(tt.query)
+		if (err != nil) != tt.wantErr || string(got) != tt.want {
+			t.Errorf("parseHexPattern(%q) = %q, %v; want %q, wantErr %v", tt.query, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSearchBytes(t *testing.T) {
+	// 匹配跨越读取块的边界
+	data := make([]byte, 2*hexSearchChunk)
+	copy(data[hexSearchChunk-2:], "\xde\xad\xbe\xef")
+	copy(data[10:], "\xde\xad\xbe\xef")
+
+	result, err := searchBytes(bytes.NewReader(data), 100, []byte("\xde\xad\xbe\xef"), hexMaxMatches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Matches) != fmt.Sprint([]int64{110, 100 + hexSearchChunk - 2}) || result.Truncated {
+		t.Errorf("matches = %+v", result)
+	}
+
+	// 重叠的匹配都会返回，超过数量上限时给出继续搜索的位置
+	result, err = searchBytes(strings.NewReader("aaaa"), 0, []byte("aa"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(result.Matches) != "[0 1]" || !result.Truncated || result.NextOffset != 2 {
+		t.Errorf("matches = %+v", result)
+	}
+}
+
+func TestViewBinaryFile(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	writeTestFile(t, dir, "core.bin", "\x7fELF\x02\x01\x01\x00"+strings.Repeat("\x00\x01", 100)+"tail")
+	writeTestFile(t, dir, "a.txt", "hello\n")
+
+	var binary BinaryFile
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/core.bin", ""), 415, &binary)
+	if !binary.Binary || binary.ContentType != "application/octet-stream" {
+		t.Errorf("view binary = %+v", binary)
+	}
+	decodeResponse(t, doRequest(s.handleSearch, "GET", "/api/search?root=0&path=/core.bin&q=tail", ""), 415, nil)
+
+	// 手动指定编码时仍按文本查看
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/core.bin&encoding=windows-1252", ""), 200, nil)
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/a.txt", ""), 200, nil)
+}
+
+func TestHexView(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	writeTestFile(t, dir, "a.bin", string(data))
+
+	tests := []struct {
+		query      string
+		wantOffset int64
+		wantLength int
+	}{
+		{"", 0, 100},
+		{"&offset=20&length=16", 16, 16},
+		{"&offset=-10", 80, 20},
+		{"&offset=1000", 96, 4},
+	}
+	for _, tt := range tests {
+		var dump HexDump
+		decodeResponse(t, doRequest(s.handleHex, "GET", "/api/hex?root=0&path=/a.bin"+tt.query, ""), 200, &dump)
+		if dump.Offset != tt.wantOffset || dump.Length != tt.wantLength || dump.Size != 100 {
+			t.Errorf("%s: offset=%d length=%d size=%d, want %d %d", tt.query, dump.Offset, dump.Length, dump.Size, tt.wantOffset, tt.wantLength)
+			continue
+		}
+		if len(dump.Rows) == 0 || dump.Rows[0].Offset != tt.wantOffset || !strings.HasPrefix(dump.Rows[0].Hex, fmt.Sprintf("%02x", tt.wantOffset)) {
+			t.Errorf("%s: rows = %+v", tt.query, dump.Rows)
+		}
+	}
+
+	decodeResponse(t, doRequest(s.handleHex, "GET", "/api/hex?root=0&path=/a.bin&length=0", ""), 400, nil)
+	decodeResponse(t, doRequest(s.handleHex, "GET", "/api/hex?root=0&path=/a.bin&offset=x", ""), 400, nil)
+}
+
+func TestHexSearch(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	writeTestFile(t, dir, "a.bin", "\x00\x01MAGIC\x00\xca\xfe\x00MAGIC")
+
+	tests := []struct {
+		query string
+		code  int
+		want  string
+	}{
+		{"q=cafe", 200, "[8]"},
+		{"q=MAGIC&mode=text", 200, "[2 11]"},
+		{"q=MAGIC&mode=text&from=3", 200, "[11]"},
+		{"q=xyz", 400, ""},
+		{"q=00&mode=regex", 400, ""},
+	}
+	for _, tt := range tests {
+		var result HexSearchResult
+		rec := doRequest(s.handleHexSearch, "GET", "/api/hexSearch?root=0&path=/a.bin&"+tt.query, "")
+		if tt.code != 200 {
+			decodeResponse(t, rec, tt.code, nil)
+			continue
+		}
+		decodeResponse(t, rec, 200, &result)
+		if got := fmt.Sprint(result.Matches); got != tt.want {
+			t.Errorf("%s: matches = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+}
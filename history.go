@@ -0,0 +1,516 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// 默认的历史版本目录（相对于根目录）
+	defaultHistoryDir = ".history"
+	// 历史版本的文件名格式，按字典序即按时间排序
+	historyIDLayout = "20060102T150405.000000000Z"
+)
+
+// HistoryConfig 文件历史版本配置（仅本地根目录）
+type HistoryConfig struct {
+	Keep        int    `json:"keep"`                  // 每个文件保留的历史版本数，0 表示不保留
+	Dir         string `json:"dir,omitempty"`         // 历史版本的存放目录，相对路径相对于根目录，默认 ".history"
+	MaxFileSize int64  `json:"maxFileSize,omitempty"` // 超过该大小的文件不保留历史版本，默认与根目录查看的 maxFileSize 相同
+}
+
+// HistoryVersion 文件的一个历史版本
+type HistoryVersion struct {
+	ID      string    `json:"id"`      // 版本标识
+	Size    int64     `json:"size"`    // 大小
+	ModTime time.Time `json:"modTime"` // 该版本原来的修改时间
+	SavedAt time.Time `json:"savedAt"` // 该版本被替换的时间
+}
+
+// HistoryContent 历史版本的内容
+type HistoryContent struct {
+	Path     string `json:"path"`
+	Version  string `json:"version"`
+	Size     int64  `json:"size"`
+	Content  string `json:"content"`  // 解码为 UTF-8 的内容
+	Encoding string `json:"encoding"` // 历史版本的字符编码
+}
+
+// RestoreRequest 恢复历史版本请求
+type RestoreRequest struct {
+	Path    string `json:"path"`
+	Version string `json:"version"` // 要恢复的历史版本标识
+}
+
+// historyConfig 获取根目录的历史版本配置，未启用或为远程根目录时返回 nil
+func (s *Server) historyConfig(rootIndex int) *HistoryConfig {
+	if rootIndex < 0 || rootIndex >= len(s.config.RootDirs) || s.isRemote(rootIndex) {
+		return nil
+	}
+	if config := s.config.RootDirs[rootIndex].History; config != nil && config.Keep > 0 {
+		return config
+	}
+	return nil
+}
+
+// historyDir 获取根目录的历史版本目录，未启用时返回空字符串
+func (s *Server) historyDir(rootIndex int) string {
+	config := s.historyConfig(rootIndex)
+	if config == nil {
+		return ""
+	}
+
+	dir := config.Dir
+	if dir == "" {
+		dir = defaultHistoryDir
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(s.config.RootDirs[rootIndex].Path, dir)
+	}
+	return filepath.Clean(dir)
+}
+
+// inHistoryDir 判断路径是否位于根目录的历史版本目录内
+func (s *Server) inHistoryDir(path string, rootIndex int) bool {
+	dir := s.historyDir(rootIndex)
+	if dir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && !strings.HasPrefix(rel, "..")
+}
+
+// historyInRoot 判断历史版本目录是否在根目录内，此时历史版本计入根目录的已用空间
+func (s *Server) historyInRoot(rootIndex int) bool {
+	rel, err := filepath.Rel(s.config.RootDirs[rootIndex].Path, s.historyDir(rootIndex))
+	return err == nil && !strings.HasPrefix(rel, "..")
+}
+
+// versionsDir 获取文件的历史版本所在目录，未启用历史版本时返回空字符串
+func (s *Server) versionsDir(rootIndex int, fullPath string) string {
+	dir := s.historyDir(rootIndex)
+	if dir == "" || s.inHistoryDir(fullPath, rootIndex) {
+		return ""
+	}
+	return filepath.Join(dir, filepath.FromSlash(s.relPath(fullPath, rootIndex)))
+}
+
+// recordHistory 在文件被替换前将其当前内容保存为历史版本，并删除超出保留数量的旧版本
+func (s *Server) recordHistory(rootIndex int, fullPath string, info os.FileInfo) error {
+	dir := s.versionsDir(rootIndex, fullPath)
+	if dir == "" || !info.Mode().IsRegular() {
+		return nil
+	}
+
+	config := s.historyConfig(rootIndex)
+	maxSize := config.MaxFileSize
+	if maxSize <= 0 {
+		maxSize = s.viewConfig(rootIndex).MaxFileSize
+	}
+	if info.Size() > maxSize {
+		return nil
+	}
+
+	src, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// 同一时刻的多个版本依次顺延，避免互相覆盖
+	savedAt := time.Now().UTC()
+	dst, err := os.OpenFile(filepath.Join(dir, savedAt.Format(historyIDLayout)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	for os.IsExist(err) {
+		savedAt = savedAt.Add(time.Nanosecond)
+		dst, err = os.OpenFile(filepath.Join(dir, savedAt.Format(historyIDLayout)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	}
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(dst.Name(), info.ModTime(), info.ModTime())
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+
+	inRoot := s.historyInRoot(rootIndex)
+	if inRoot {
+		s.addUsage(rootIndex, n)
+	}
+
+	// 删除超出保留数量的旧版本
+	versions, err := s.listHistory(rootIndex, fullPath)
+	if err != nil {
+		return nil
+	}
+	for _, version := range versions[min(config.Keep, len(versions)):] {
+		if os.Remove(filepath.Join(dir, version.ID)) == nil && inRoot {
+			s.addUsage(rootIndex, -version.Size)
+		}
+	}
+	return nil
+}
+
+// listHistory 列出文件的历史版本，最新的在前
+func (s *Server) listHistory(rootIndex int, fullPath string) ([]HistoryVersion, error) {
+	dir := s.versionsDir(rootIndex, fullPath)
+	if dir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []HistoryVersion{}
+	for _, entry := range entries {
+		// 子文件的历史版本目录与版本文件位于同一目录，按名称区分
+		savedAt, err := time.Parse(historyIDLayout, entry.Name())
+		if err != nil || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		versions = append(versions, HistoryVersion{
+			ID:      entry.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			SavedAt: savedAt,
+		})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].ID > versions[j].ID
+	})
+	return versions, nil
+}
+
+// historyVersionPath 获取历史版本文件的路径，并检查版本标识是否合法
+func (s *Server) historyVersionPath(rootIndex int, fullPath, version string) (string, error) {
+	dir := s.versionsDir(rootIndex, fullPath)
+	if dir == "" {
+		return "", fmt.Errorf("version history is not enabled for this root")
+	}
+	if _, err := time.Parse(historyIDLayout, version); err != nil {
+		return "", fmt.Errorf("invalid version: %s", version)
+	}
+
+	versionPath := filepath.Join(dir, version)
+	if _, err := os.Stat(versionPath); err != nil {
+		return "", err
+	}
+	return versionPath, nil
+}
+
+// historyRequestPath 解析历史版本请求中的文件路径
+func (s *Server) historyRequestPath(w http.ResponseWriter, rootIndex int, path string) (string, bool) {
+	if path == "" {
+		s.handleError(w, fmt.Errorf("path parameter is required"), http.StatusBadRequest)
+		return "", false
+	}
+
+	// 远程根目录不支持该操作
+	if s.isRemote(rootIndex) {
+		s.handleError(w, errRemoteUnsupported, http.StatusNotImplemented)
+		return "", false
+	}
+
+	// 构建完整路径
+	fullPath := s.getFullPath(path, rootIndex)
+
+	// 检查路径是否在根目录内
+	if !s.isPathSafe(fullPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return "", false
+	}
+
+	if s.historyDir(rootIndex) == "" {
+		s.handleError(w, fmt.Errorf("version history is not enabled for this root"), http.StatusNotFound)
+		return "", false
+	}
+	return fullPath, true
+}
+
+// handleHistory 列出文件的历史版本
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	rootIndex := getRootIndex(r)
+	fullPath, ok := s.historyRequestPath(w, rootIndex, r.URL.Query().Get("path"))
+	if !ok {
+		return
+	}
+
+	versions, err := s.listHistory(rootIndex, fullPath)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, versions)
+}
+
+// handleHistoryView 查看历史版本的内容
+func (s *Server) handleHistoryView(w http.ResponseWriter, r *http.Request) {
+	rootIndex := getRootIndex(r)
+	fullPath, ok := s.historyRequestPath(w, rootIndex, r.URL.Query().Get("path"))
+	if !ok {
+		return
+	}
+
+	version := r.URL.Query().Get("version")
+	versionPath, err := s.historyVersionPath(rootIndex, fullPath, version)
+	if err != nil {
+		s.handleError(w, err, historyErrorStatus(err))
+		return
+	}
+
+	// 指定编码时按该编码解码，否则与查看文件一样自动检测
+	encodingName := r.URL.Query().Get("encoding")
+	if encodingName != "" {
+		if _, err := lookupEncoding(encodingName); err != nil {
+			s.handleError(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	file, err := os.Open(versionPath)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if maxSize := s.viewConfig(rootIndex).MaxFileSize; info.Size() > maxSize {
+		s.handleError(w, fmt.Errorf("%w: versions larger than %d bytes cannot be viewed", errTooLarge, maxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	reader, te, err := s.decodeText(rootIndex, file, encodingName)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, HistoryContent{
+		Path:     s.relPath(fullPath, rootIndex),
+		Version:  version,
+		Size:     info.Size(),
+		Content:  string(content),
+		Encoding: te.name,
+	})
+}
+
+// handleHistoryDiff 比较历史版本与文件当前的内容
+func (s *Server) handleHistoryDiff(w http.ResponseWriter, r *http.Request) {
+	rootIndex := getRootIndex(r)
+	fullPath, ok := s.historyRequestPath(w, rootIndex, r.URL.Query().Get("path"))
+	if !ok {
+		return
+	}
+
+	version := r.URL.Query().Get("version")
+	versionPath, err := s.historyVersionPath(rootIndex, fullPath, version)
+	if err != nil {
+		s.handleError(w, err, historyErrorStatus(err))
+		return
+	}
+
+	versionInfo, err := os.Stat(versionPath)
+	if err != nil {
+		s.handleError(w, err, historyErrorStatus(err))
+		return
+	}
+
+	// 文件已被删除时视为空文件
+	maxSize := s.viewConfig(rootIndex).MaxFileSize
+	info, err := os.Stat(fullPath)
+	switch {
+	case os.IsNotExist(err):
+		info = nil
+	case err != nil:
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	case info.IsDir():
+		s.handleError(w, errIsDirectory, http.StatusBadRequest)
+		return
+	}
+	if versionInfo.Size() > maxSize || (info != nil && info.Size() > maxSize) {
+		s.handleError(w, fmt.Errorf("file too large to diff"), http.StatusBadRequest)
+		return
+	}
+
+	old, err := s.readHistoryLines(rootIndex, versionPath)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	var current []string
+	if info != nil {
+		if current, err = s.readHistoryLines(rootIndex, fullPath); err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	name := filepath.Base(fullPath)
+	s.writeJSON(w, map[string]interface{}{
+		"version": version,
+		"diff":    unifiedDiff(version+"/"+name, "current/"+name, old, current),
+	})
+}
+
+// readHistoryLines 读取并解码文件，按检测到的换行符分行，忽略换行符风格的差异
+// 末尾有换行符时保留最后的空行，末尾换行符的增删同样显示在差异中
+func (s *Server) readHistoryLines(rootIndex int, fullPath string) ([]string, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, _, err := s.decodeText(rootIndex, file, "")
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	ending, _ := detectLineEnding(content)
+	lines, finalNewline := splitLines(string(content), ending)
+	if finalNewline {
+		lines = append(lines, "")
+	}
+	return lines, nil
+}
+
+// handleHistoryRestore 将文件恢复为历史版本，恢复前的内容同样保存为历史版本
+func (s *Server) handleHistoryRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.handleError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RestoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.handleError(w, fmt.Errorf("invalid request body"), http.StatusBadRequest)
+		return
+	}
+
+	rootIndex := getRootIndex(r)
+	fullPath, ok := s.historyRequestPath(w, rootIndex, req.Path)
+	if !ok {
+		return
+	}
+
+	versionPath, err := s.historyVersionPath(rootIndex, fullPath, req.Version)
+	if err != nil {
+		s.handleError(w, err, historyErrorStatus(err))
+		return
+	}
+
+	content, err := os.ReadFile(versionPath)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// 历史版本可能早于当前的 JSON Schema，恢复的内容同样须符合
+	if err := s.validateLocalContent(rootIndex, fullPath, content); err != nil {
+		if errors.As(err, new(*schemaError)) {
+			s.writeValidationError(w, err)
+			return
+		}
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+
+	// 与保存串行化
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	// 文件已被删除时重新创建
+	var size int64
+	info, err := os.Stat(fullPath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	case info.IsDir():
+		s.handleError(w, fmt.Errorf("cannot restore over a directory"), http.StatusBadRequest)
+		return
+	default:
+		if err := s.recordHistory(rootIndex, fullPath, info); err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		size = info.Size()
+	}
+
+	// 按大小变化占用或释放配额
+	delta := int64(len(content)) - size
+	if err := s.reserve(rootIndex, delta); err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+
+	if err := s.fs(rootIndex).WriteFile(fullPath, content, false); err != nil {
+		s.reserve(rootIndex, -delta)
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "已恢复到历史版本",
+	}
+	if info, err := os.Stat(fullPath); err == nil {
+		response["version"] = s.pathVersion(rootIndex, fullPath, info)
+	}
+
+	s.writeJSON(w, response)
+}
+
+// historyErrorStatus 获取历史版本错误对应的状态码
+func historyErrorStatus(err error) int {
+	if os.IsNotExist(err) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newHistoryTestServer 创建保留 keep 个历史版本的服务器
+func newHistoryTestServer(t *testing.T, keep int, configure func(config *Config)) (*Server, string) {
+	t.Helper()
+	return newTestServer(t, func(config *Config) {
+		config.RootDirs[0].History = &HistoryConfig{Keep: keep}
+		if configure != nil {
+			configure(config)
+		}
+	})
+}
+
+// saveTestFile 强制保存文件内容
+func saveTestFile(t *testing.T, s *Server, path, content string) {
+	t.Helper()
+	body := mustJSON(t, SaveRequest{Path: path, Content: content, Version: "*"})
+	decodeResponse(t, doRequest(s.handleSave, "POST", "/api/save?root=0", body), 200, nil)
+}
+
+// listTestHistory 列出文件的历史版本
+func listTestHistory(t *testing.T, s *Server, path string) []HistoryVersion {
+	t.Helper()
+	var versions []HistoryVersion
+	decodeResponse(t, doRequest(s.handleHistory, "GET", "/api/history?root=0&path="+path, ""), 200, &versions)
+	return versions
+}
+
+func TestHistoryKeep(t *testing.T) {
+	s, dir := newHistoryTestServer(t, 2, nil)
+	writeTestFile(t, dir, "a.txt", "v1\n")
+	for _, content := range []string{"v2\n", "v3\n", "v4\n"} {
+		saveTestFile(t, s, "/a.txt", content)
+	}
+
+	// 只保留最新的两个版本，最新的在前
+	versions := listTestHistory(t, s, "/a.txt")
+	if len(versions) != 2 {
+		t.Fatalf("versions = %+v", versions)
+	}
+	for i, want := range []string{"v3\n", "v2\n"} {
+		var content HistoryContent
+		decodeResponse(t, doRequest(s.handleHistoryView, "GET", "/api/historyView?root=0&path=/a.txt&version="+versions[i].ID, ""), 200, &content)
+		if content.Content != want {
+			t.Errorf("version %d = %q, want %q", i, content.Content, want)
+		}
+	}
+
+	rec := doRequest(s.handleHistoryView, "GET", "/api/historyView?root=0&path=/a.txt&version=../../a.txt", "")
+	if rec.Code != 400 {
+		t.Errorf("invalid version status = %d, want 400", rec.Code)
+	}
+}
+
+func TestHistoryViewDecodes(t *testing.T) {
+	s, dir := newHistoryTestServer(t, 5, func(config *Config) {
+		config.RootDirs[0].View = &ViewConfig{MaxFileSize: 8}
+	})
+	writeTestFile(t, dir, "a.txt", "\xc4\xe3\xba\xc3\n")
+	saveTestFile(t, s, "/a.txt", "x\n")
+
+	// 非 UTF-8 的历史版本与查看文件一样解码
+	versions := listTestHistory(t, s, "/a.txt")
+	var content HistoryContent
+	decodeResponse(t, doRequest(s.handleHistoryView, "GET", "/api/historyView?root=0&path=/a.txt&version="+versions[0].ID, ""), 200, &content)
+	if content.Content != "你好\n" || content.Encoding != "gb18030" || content.Size != 5 {
+		t.Errorf("content = %+v", content)
+	}
+
+	// 超过根目录 maxFileSize 的历史版本不能查看
+	writeTestFile(t, dir, ".history/b.txt/20240101T000000.000000000Z", "0123456789\n")
+	rec := doRequest(s.handleHistoryView, "GET", "/api/historyView?root=0&path=/b.txt&version=20240101T000000.000000000Z", "")
+	if rec.Code != 413 {
+		t.Errorf("large version status = %d, want 413", rec.Code)
+	}
+}
+
+func TestHistoryDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		saved    string
+		deleted  bool
+		wantCode int
+		wantDiff string
+	}{
+		{"changed line", "a\nb\nc\n", "a\nB\nc\n", false, 200, " a\n-b\n+B\n c\n"},
+		{"deleted file", "a\n", "b\n", true, 200, "-a\n"},
+		{"crlf", "a\r\nb\r\nc\r\n", "a\nB\nc\n", false, 200, " a\n-b\n+B\n c\n"},
+		{"cr", "a\rb\rc\r", "a\nB\nc\n", false, 200, "-a\rb\rc\n+a\rB\rc\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := newHistoryTestServer(t, 5, nil)
+			writeTestFile(t, dir, "a.txt", tt.original)
+			saveTestFile(t, s, "/a.txt", tt.saved)
+			if tt.deleted {
+				decodeResponse(t, doRequest(s.handleDelete, "POST", "/api/delete?root=0&path=/a.txt", ""), 200, nil)
+			}
+
+			versions := listTestHistory(t, s, "/a.txt")
+			rec := doRequest(s.handleHistoryDiff, "GET", "/api/historyDiff?root=0&path=/a.txt&version="+versions[len(versions)-1].ID, "")
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if tt.wantCode != 200 {
+				return
+			}
+			var resp struct {
+				Diff string `json:"diff"`
+			}
+			decodeResponse(t, rec, 200, &resp)
+			if !strings.Contains(resp.Diff, tt.wantDiff) || strings.Contains(resp.Diff, "\r") != strings.Contains(tt.wantDiff, "\r") {
+				t.Errorf("diff = %q, want it to contain %q", resp.Diff, tt.wantDiff)
+			}
+		})
+	}
+}
+
+func TestHistoryUsesViewMaxFileSize(t *testing.T) {
+	s, dir := newHistoryTestServer(t, 5, func(config *Config) {
+		config.RootDirs[0].View = &ViewConfig{MaxFileSize: 8}
+	})
+	writeTestFile(t, dir, "small.txt", "a\n")
+	writeTestFile(t, dir, "large.txt", "0123456789\n")
+	saveTestFile(t, s, "/small.txt", "0123456789\n")
+	saveTestFile(t, s, "/large.txt", "b\n")
+
+	// 超过根目录 maxFileSize 的文件不保留历史版本
+	if versions := listTestHistory(t, s, "/large.txt"); len(versions) != 0 {
+		t.Errorf("large versions = %+v", versions)
+	}
+
+	// 当前内容超过 maxFileSize 时不比较
+	versions := listTestHistory(t, s, "/small.txt")
+	if len(versions) != 1 {
+		t.Fatalf("small versions = %+v", versions)
+	}
+	rec := doRequest(s.handleHistoryDiff, "GET", "/api/historyDiff?root=0&path=/small.txt&version="+versions[0].ID, "")
+	if rec.Code != 400 {
+		t.Errorf("diff status = %d, want 400", rec.Code)
+	}
+}
+
+func TestHistoryRestore(t *testing.T) {
+	s, dir := newHistoryTestServer(t, 5, nil)
+	fullPath := writeTestFile(t, dir, "a.txt", "v1\n")
+	saveTestFile(t, s, "/a.txt", "v2\n")
+	versions := listTestHistory(t, s, "/a.txt")
+
+	body := mustJSON(t, RestoreRequest{Path: "/a.txt", Version: versions[0].ID})
+	decodeResponse(t, doRequest(s.handleHistoryRestore, "POST", "/api/historyRestore?root=0", body), 200, nil)
+	if got := readTestFile(t, fullPath); got != "v1\n" {
+		t.Errorf("restored content = %q", got)
+	}
+
+	// 恢复前的内容同样保留为历史版本
+	if versions = listTestHistory(t, s, "/a.txt"); len(versions) != 2 {
+		t.Fatalf("versions = %+v", versions)
+	}
+	var content HistoryContent
+	decodeResponse(t, doRequest(s.handleHistoryView, "GET", "/api/historyView?root=0&path=/a.txt&version="+versions[0].ID, ""), 200, &content)
+	if content.Content != "v2\n" {
+		t.Errorf("latest version = %q, want %q", content.Content, "v2\n")
+	}
+}
+
+func TestHistoryDirProtected(t *testing.T) {
+	s, dir := newHistoryTestServer(t, 5, nil)
+	writeTestFile(t, dir, "src/a.txt", "v1\n")
+	saveTestFile(t, s, "/src/a.txt", "v2\n")
+
+	t.Run("direct access", func(t *testing.T) {
+		rec := doRequest(s.handleList, "GET", "/api/list?root=0&path=/.history", "")
+		if rec.Code != 403 {
+			t.Errorf("status = %d, want 403", rec.Code)
+		}
+	})
+
+	t.Run("pack skips the history dir", func(t *testing.T) {
+		rec := doRequest(s.handlePack, "POST", "/api/pack?root=0", mustJSON(t, PackRequest{Paths: []string{"/.history"}, Target: "/h.zip"}))
+		if rec.Code != 403 {
+			t.Errorf("packing the history dir status = %d, want 403", rec.Code)
+		}
+		info := packTestArchive(t, s, PackRequest{Paths: []string{"/"}, Target: "/out.zip"})
+		if info.Status != JobDone {
+			t.Fatalf("job = %s %q", info.Status, info.Error)
+		}
+		for _, name := range archiveNames(t, filepath.Join(dir, "out.zip")) {
+			if strings.Contains(name, ".history") {
+				t.Errorf("archive contains %s", name)
+			}
+		}
+	})
+
+	t.Run("extract into the history dir", func(t *testing.T) {
+		writeTestZip(t, filepath.Join(dir, "evil.zip"), []testArchiveEntry{{name: ".history/src/a.txt/20200101T000000.000000000Z", content: "forged"}})
+		info := extractTestArchive(t, s, ExtractRequest{Path: "/evil.zip", Target: "/"})
+		if info.Status != JobFailed || !strings.Contains(info.Error, "history") {
+			t.Errorf("job = %s %q, want failure", info.Status, info.Error)
+		}
+		if versions := listTestHistory(t, s, "/src/a.txt"); len(versions) != 1 {
+			t.Errorf("versions = %+v", versions)
+		}
+	})
+}
+
+func TestHistoryDirOutsideRoot(t *testing.T) {
+	historyDir := t.TempDir()
+	s, dir := newHistoryTestServer(t, 5, func(config *Config) {
+		config.RootDirs[0].History.Dir = historyDir
+	})
+	writeTestFile(t, dir, ".history/notes.txt", "v1\n")
+
+	// 历史版本目录在根目录之外时，根目录中同名的目录是普通目录，其中的文件同样保留历史版本
+	saveTestFile(t, s, "/.history/notes.txt", "v2\n")
+	if versions := listTestHistory(t, s, "/.history/notes.txt"); len(versions) != 1 {
+		t.Errorf("versions = %+v", versions)
+	}
+	if _, err := os.Stat(filepath.Join(historyDir, ".history", "notes.txt")); err != nil {
+		t.Errorf("version dir: %v", err)
+	}
+	var items []FileItem
+	decodeResponse(t, doRequest(s.handleList, "GET", "/api/list?root=0&path=/", ""), 200, &items)
+	if len(items) != 1 || items[0].Name != ".history" {
+		t.Errorf("items = %+v", items)
+	}
+}
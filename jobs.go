@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 后台任务状态
+const (
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// 已结束的任务保留时间
+const jobRetention = time.Hour
+
+// JobInfo 后台任务信息
+type JobInfo struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`             // 任务类型
+	Status    string    `json:"status"`           // 任务状态
+	Root      int       `json:"root"`             // 根目录索引
+	Path      string    `json:"path"`             // 任务结果所在路径（相对于根目录）
+	Total     int64     `json:"total"`            // 总字节数（未知时为 0）
+	Done      int64     `json:"done"`             // 已处理字节数
+	Entries   int       `json:"entries"`          // 已处理的条目数
+	Error     string    `json:"error,omitempty"`  // 失败原因
+	StartedAt time.Time `json:"startedAt"`        // 开始时间
+	EndedAt   time.Time `json:"endedAt,omitzero"` // 结束时间
+}
+
+// Job 后台任务
+type Job struct {
+	mu     sync.Mutex
+	info   JobInfo
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Info 返回任务信息的快照
+func (j *Job) Info() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info
+}
+
+// SetTotal 设置总字节数
+func (j *Job) SetTotal(total int64) {
+	j.mu.Lock()
+	j.info.Total = total
+	j.mu.Unlock()
+}
+
+// SetPath 更新任务结果所在路径
+func (j *Job) SetPath(path string) {
+	j.mu.Lock()
+	j.info.Path = path
+	j.mu.Unlock()
+}
+
+// AddProgress 累加已处理的字节数和条目数
+func (j *Job) AddProgress(bytes int64, entries int) {
+	j.mu.Lock()
+	j.info.Done += bytes
+	j.info.Entries += entries
+	j.mu.Unlock()
+}
+
+// Context 返回任务的上下文，任务取消时结束
+func (j *Job) Context() context.Context {
+	return j.ctx
+}
+
+// JobManager 后台任务管理器
+type JobManager struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewJobManager 创建任务管理器
+func NewJobManager() *JobManager {
+	return &JobManager{jobs: make(map[string]*Job)}
+}
+
+// Start 启动后台任务
+func (m *JobManager) Start(jobType string, rootIndex int, path string, run func(job *Job) error) JobInfo {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		info: JobInfo{
+			ID:        randomID(),
+			Type:      jobType,
+			Status:    JobRunning,
+			Root:      rootIndex,
+			Path:      path,
+			StartedAt: time.Now(),
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	m.mu.Lock()
+	m.cleanup()
+	m.jobs[job.info.ID] = job
+	m.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		err := run(job)
+
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		job.info.EndedAt = time.Now()
+		switch {
+		case err == nil:
+			job.info.Status = JobDone
+		case ctx.Err() != nil:
+			job.info.Status = JobCancelled
+		default:
+			job.info.Status = JobFailed
+			job.info.Error = err.Error()
+		}
+	}()
+
+	return job.Info()
+}
+
+// Get 获取任务
+func (m *JobManager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// Cancel 取消任务，任务不存在时返回 false
+func (m *JobManager) Cancel(id string) bool {
+	job, ok := m.Get(id)
+	if !ok {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+// List 列出所有任务（按开始时间排序）
+func (m *JobManager) List() []JobInfo {
+	m.mu.Lock()
+	infos := make([]JobInfo, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		infos = append(infos, job.Info())
+	}
+	m.mu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartedAt.Before(infos[j].StartedAt)
+	})
+	return infos
+}
+
+// cleanup 清理已结束且超过保留时间的任务（调用方需持有锁）
+func (m *JobManager) cleanup() {
+	for id, job := range m.jobs {
+		info := job.Info()
+		if info.Status != JobRunning && time.Since(info.EndedAt) > jobRetention {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// randomID 生成随机 ID（用于任务和上传）
+func randomID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// progressWriter 写入时累加任务进度，任务取消后返回错误
+type progressWriter struct {
+	w   io.Writer
+	job *Job
+}
+
+// Write 写入数据
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	if err := pw.job.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := pw.w.Write(p)
+	pw.job.AddProgress(int64(n), 0)
+	return n, err
+}
+
+// handleJobs 处理后台任务查询请求，指定 id 时返回单个任务
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		s.writeJSON(w, s.jobs.List())
+		return
+	}
+
+	job, ok := s.jobs.Get(id)
+	if !ok {
+		s.handleError(w, fmt.Errorf("job not found"), http.StatusNotFound)
+		return
+	}
+
+	s.writeJSON(w, job.Info())
+}
+
+// handleCancelJob 处理取消后台任务请求
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.handleError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		s.handleError(w, fmt.Errorf("id parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	if !s.jobs.Cancel(id) {
+		s.handleError(w, fmt.Errorf("job not found"), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "任务已取消",
+	})
+}
@@ -0,0 +1,1022 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// 每次返回的子节点或查询结果的默认数量
+	jsonDefaultLimit = 100
+	// 每次最多返回的子节点或查询结果
+	jsonMaxLimit = 1000
+	// 子节点中字符串值的预览长度（字符数），超过时截断
+	jsonPreviewLength = 256
+	// 查询结果中单个值的最大字节数，超过时只返回类型和大小
+	jsonMaxValueSize = 1024 * 1024
+)
+
+// errJSONQueryDone 查询结果已凑满，停止读取
+var errJSONQueryDone = errors.New("query done")
+
+// errJSONNodeNotFound 指定的节点不存在
+var errJSONNodeNotFound = errors.New("node not found")
+
+// JSONNode 树中的一个节点
+type JSONNode struct {
+	Key       string `json:"key,omitempty"` // 对象的键，或数组元素的下标
+	Path      string `json:"path"`          // 节点路径，展开时作为 node 参数
+	Type      string `json:"type"`          // object、array、string、number、boolean、null
+	Children  int    `json:"children"`      // 对象或数组的直接子节点数
+	Value     any    `json:"value,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"` // 字符串值是否被截断
+}
+
+// JSONTree 一个节点及其一段子节点
+type JSONTree struct {
+	Path     string     `json:"path"`
+	Name     string     `json:"name"`
+	Size     int64      `json:"size"`
+	Node     JSONNode   `json:"node"`
+	Children []JSONNode `json:"children"`
+	Offset   int        `json:"offset"`
+	More     bool       `json:"more"` // offset+len(children) 之后是否还有子节点
+	Version  string     `json:"version,omitempty"`
+}
+
+// JSONMatch 查询结果
+type JSONMatch struct {
+	Path  string          `json:"path"`
+	Type  string          `json:"type"`
+	Size  int             `json:"size"`            // 值的字节数
+	Value json.RawMessage `json:"value,omitempty"` // 超过 1MB 时省略
+}
+
+// JSONQueryResult 查询的结果
+type JSONQueryResult struct {
+	Path    string      `json:"path"`
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Query   string      `json:"query"`
+	Results []JSONMatch `json:"results"`
+	More    bool        `json:"more"` // 是否还有更多结果
+	Version string      `json:"version,omitempty"`
+}
+
+// 路径片段的类型
+const (
+	jsonSegName     = iota // .name 或 ['name']
+	jsonSegIndex           // [n]
+	jsonSegWildcard        // .* 或 [*]，jq 的 []
+	jsonSegSlice           // [start:end]
+	jsonSegFilter          // [?(@.a > 1)]
+)
+
+// jsonSegment 路径中的一段
+type jsonSegment struct {
+	kind       int
+	name       string
+	index      int
+	start, end int // 切片范围，end 为 -1 表示到末尾
+	filter     *jsonFilter
+	descendant bool // 以 .. 开头，匹配任意深度的后代
+}
+
+// jsonFilter 过滤条件：@ 之后的相对路径，以及可选的比较运算和字面值
+type jsonFilter struct {
+	path  []jsonSegment
+	op    string
+	value any
+}
+
+// parseJSONPath 解析 JSONPath（$.a.b[0]、$..name、$.items[*]、$[?(@.price < 10)]）
+// 和 jq 风格的路径（.a.b[0]、.items[]），开头的 $ 可以省略
+func parseJSONPath(expr string) ([]jsonSegment, error) {
+	p := strings.TrimSpace(expr)
+	if strings.HasPrefix(p, "$") || strings.HasPrefix(p, "@") {
+		p = p[1:]
+	}
+	if p == "." {
+		return nil, nil
+	}
+
+	var segments []jsonSegment
+	for i := 0; i < len(p); {
+		descendant := false
+		switch {
+		case strings.HasPrefix(p[i:], ".."):
+			descendant = true
+			i += 2
+		case p[i] == '.':
+			i++
+		case p[i] == '[':
+		default:
+			return nil, fmt.Errorf("invalid path %q at %d", expr, i)
+		}
+
+		var seg jsonSegment
+		if i < len(p) && p[i] == '[' {
+			end, err := matchBracket(p, i)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: %v", expr, err)
+			}
+			if seg, err = parseBracket(p[i+1 : end]); err != nil {
+				return nil, fmt.Errorf("invalid path %q: %v", expr, err)
+			}
+			i = end + 1
+		} else {
+			j := i
+			for j < len(p) && p[j] != '.' && p[j] != '[' {
+				j++
+			}
+			name := p[i:j]
+			switch name {
+			case "":
+				return nil, fmt.Errorf("invalid path %q: empty name at %d", expr, i)
+			case "*":
+				seg.kind = jsonSegWildcard
+			default:
+				seg.kind, seg.name = jsonSegName, name
+			}
+			i = j
+		}
+		seg.descendant = descendant
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
+
+// matchBracket 找到与 p[start] 处的 [ 对应的 ]，跳过引号和括号内的内容
+func matchBracket(p string, start int) (int, error) {
+	depth := 0
+	var quote byte
+	for i := start; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+			if depth == 0 {
+				if c != ']' {
+					return 0, fmt.Errorf("unbalanced brackets")
+				}
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("missing ]")
+}
+
+// parseBracket 解析方括号内的内容：*、下标、切片、带引号的键或过滤条件
+func parseBracket(s string) (jsonSegment, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "" || s == "*":
+		return jsonSegment{kind: jsonSegWildcard}, nil
+	case s[0] == '?':
+		filter, err := parseJSONFilter(strings.TrimSpace(s[1:]))
+		if err != nil {
+			return jsonSegment{}, err
+		}
+		return jsonSegment{kind: jsonSegFilter, filter: filter}, nil
+	case s[0] == '\'' || s[0] == '"':
+		name, err := unquoteJSONPath(s)
+		if err != nil {
+			return jsonSegment{}, err
+		}
+		return jsonSegment{kind: jsonSegName, name: name}, nil
+	case strings.Contains(s, ":"):
+		from, to, _ := strings.Cut(s, ":")
+		seg := jsonSegment{kind: jsonSegSlice, end: -1}
+		var err error
+		if from = strings.TrimSpace(from); from != "" {
+			if seg.start, err = strconv.Atoi(from); err != nil || seg.start < 0 {
+				return jsonSegment{}, fmt.Errorf("invalid slice: %s", s)
+			}
+		}
+		if to = strings.TrimSpace(to); to != "" {
+			if seg.end, err = strconv.Atoi(to); err != nil || seg.end < 0 {
+				return jsonSegment{}, fmt.Errorf("invalid slice: %s", s)
+			}
+		}
+		return seg, nil
+	}
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return jsonSegment{}, fmt.Errorf("invalid index: %s", s)
+	}
+	if index < 0 {
+		return jsonSegment{}, fmt.Errorf("negative indexes are not supported: %s", s)
+	}
+	return jsonSegment{kind: jsonSegIndex, index: index}, nil
+}
+
+// unquoteJSONPath 去掉单引号或双引号，处理反斜杠转义
+func unquoteJSONPath(s string) (string, error) {
+	if len(s) < 2 || s[len(s)-1] != s[0] {
+		return "", fmt.Errorf("unterminated string: %s", s)
+	}
+	if s[0] == '"' {
+		return strconv.Unquote(s)
+	}
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), nil
+}
+
+// jsonFilterOps 过滤条件支持的比较运算，较长的在前
+var jsonFilterOps = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// parseJSONFilter 解析 (@.a.b op value) 或 (@.a)，value 为 JSON 字面值或单引号字符串
+func parseJSONFilter(s string) (*jsonFilter, error) {
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return nil, fmt.Errorf("invalid filter: %s", s)
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	if !strings.HasPrefix(s, "@") {
+		return nil, fmt.Errorf("filter must start with @: %s", s)
+	}
+
+	// 找到引号和括号之外的比较运算
+	operand, op, literal := s, "", ""
+	var quote byte
+	depth := 0
+scan:
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0:
+			for _, candidate := range jsonFilterOps {
+				if strings.HasPrefix(s[i:], candidate) {
+					operand, op, literal = s[:i], candidate, strings.TrimSpace(s[i+len(candidate):])
+					break scan
+				}
+			}
+		}
+	}
+
+	path, err := parseJSONPath(strings.TrimSpace(operand))
+	if err != nil {
+		return nil, err
+	}
+	for _, seg := range path {
+		if seg.descendant || (seg.kind != jsonSegName && seg.kind != jsonSegIndex) {
+			return nil, fmt.Errorf("filter paths may only contain names and indexes: %s", operand)
+		}
+	}
+	filter := &jsonFilter{path: path, op: op}
+	if op == "" {
+		return filter, nil
+	}
+	if strings.HasPrefix(literal, "'") {
+		text, err := unquoteJSONPath(literal)
+		if err != nil {
+			return nil, err
+		}
+		filter.value = text
+		return filter, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(literal))
+	decoder.UseNumber()
+	if err := decoder.Decode(&filter.value); err != nil || decoder.More() {
+		return nil, fmt.Errorf("invalid filter value: %s", literal)
+	}
+	return filter, nil
+}
+
+// match 判断值是否符合过滤条件
+func (f *jsonFilter) match(v any) bool {
+	for _, seg := range f.path {
+		switch value := v.(type) {
+		case map[string]any:
+			child, ok := value[seg.name]
+			if seg.kind != jsonSegName || !ok {
+				return false
+			}
+			v = child
+		case []any:
+			if seg.kind != jsonSegIndex || seg.index >= len(value) {
+				return false
+			}
+			v = value[seg.index]
+		default:
+			return false
+		}
+	}
+	if f.op == "" {
+		return true
+	}
+
+	// 数字按大小比较，字符串按字节比较，其他类型只能判断是否相等
+	c, comparable := 0, false
+	if x, ok := jsonNumber(v); ok {
+		if y, ok := jsonNumber(f.value); ok {
+			c, comparable = compareFloat(x, y), true
+		}
+	} else if x, ok := v.(string); ok {
+		if y, ok := f.value.(string); ok {
+			c, comparable = strings.Compare(x, y), true
+		}
+	}
+	switch f.op {
+	case "==":
+		return (comparable && c == 0) || (!comparable && reflect.DeepEqual(v, f.value))
+	case "!=":
+		return !((comparable && c == 0) || (!comparable && reflect.DeepEqual(v, f.value)))
+	case "<":
+		return comparable && c < 0
+	case "<=":
+		return comparable && c <= 0
+	case ">":
+		return comparable && c > 0
+	case ">=":
+		return comparable && c >= 0
+	}
+	return false
+}
+
+// jsonNumber 将 json.Number 转换为浮点数
+func jsonNumber(v any) (float64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+func compareFloat(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// formatJSONPath 生成子节点的路径，键不是标识符时使用 ["..."]
+func formatJSONPath(parent, key string, index int, isIndex bool) string {
+	if isIndex {
+		return parent + "[" + strconv.Itoa(index) + "]"
+	}
+	identifier := key != ""
+	for i, r := range key {
+		if !(r == '_' || r == '$' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || (i > 0 && '0' <= r && r <= '9')) {
+			identifier = false
+			break
+		}
+	}
+	if identifier {
+		return parent + "." + key
+	}
+	return parent + "[" + strconv.Quote(key) + "]"
+}
+
+// jsonTokenType 返回值的第一个 token 对应的类型
+func jsonTokenType(tok json.Token) string {
+	switch v := tok.(type) {
+	case json.Delim:
+		if v == '{' {
+			return "object"
+		}
+		return "array"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// jsonRawType 返回 JSON 文本的类型
+func jsonRawType(raw []byte) string {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return "null"
+	}
+	switch raw[0] {
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case '"':
+		return "string"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	}
+	return "number"
+}
+
+// newJSONDecoder 创建保留数字原文的解码器
+func newJSONDecoder(r io.Reader) *json.Decoder {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	return decoder
+}
+
+// jsonReadChunk 记录原文时每次最多读取的字节数，解码器预读而尚未解析的内容因此不超过该大小
+const jsonReadChunk = 64 * 1024
+
+// jsonHeadSize 不保留原文的值保留开头的字节数，用于识别类型和跳过值之前的分隔符
+const jsonHeadSize = 64
+
+// jsonRecording 正在记录的一个值
+type jsonRecording struct {
+	start int64  // 值之前最后一个记号结束的位置，之后可能还有空白和分隔符
+	head  []byte // 原文被丢弃时保留的开头部分
+}
+
+// jsonRecorder 位于解码器之下，记录正在读取的值的原文，
+// 超过 jsonMaxValueSize 的值不再保留原文，因此查询很大的值时占用的内存有上限
+type jsonRecorder struct {
+	r          io.Reader
+	offset     int64 // 已读取的字节数
+	base       int64 // buf[0] 对应的位置
+	buf        []byte
+	recordings []*jsonRecording // 外层的值在前
+}
+
+func (r *jsonRecorder) Read(p []byte) (int, error) {
+	if len(p) > jsonReadChunk {
+		p = p[:jsonReadChunk]
+	}
+	n, err := r.r.Read(p)
+	if len(r.recordings) > 0 {
+		r.buf = append(r.buf, p[:n]...)
+	}
+	r.offset += int64(n)
+	r.trim()
+	return n, err
+}
+
+// trim 丢弃不再需要的原文：只保留从最外层仍可能不超过上限的值开始的部分
+func (r *jsonRecorder) trim() {
+	if len(r.recordings) == 0 {
+		r.buf, r.base = r.buf[:0], r.offset
+		return
+	}
+	keep := r.offset
+	for _, rec := range r.recordings {
+		if r.offset-rec.start <= jsonMaxValueSize+jsonHeadSize+jsonReadChunk {
+			keep = rec.start
+			break
+		}
+	}
+	if keep <= r.base {
+		return
+	}
+	for _, rec := range r.recordings {
+		if rec.start < keep && rec.head == nil {
+			from := rec.start - r.base
+			rec.head = append([]byte(nil), r.buf[from:min(from+jsonHeadSize, int64(len(r.buf)))]...)
+		}
+	}
+	n := copy(r.buf, r.buf[keep-r.base:])
+	r.buf, r.base = r.buf[:n], keep
+}
+
+// jsonStream 可以取得某个值原文的解码器，查询时边读取边记录匹配的值，不必先将其整个读入内存
+type jsonStream struct {
+	*json.Decoder
+	rec *jsonRecorder
+}
+
+func newJSONStream(r io.Reader) *jsonStream {
+	rec := &jsonRecorder{r: r}
+	return &jsonStream{Decoder: newJSONDecoder(rec), rec: rec}
+}
+
+// begin 开始记录下一个值
+func (s *jsonStream) begin() {
+	start := s.InputOffset()
+	if len(s.rec.recordings) == 0 || start < s.rec.base {
+		// 解码器已预读的部分不会再经过 Read；解码器可能已越过值之前的空白，此时从预读部分的开头记录
+		buffered, _ := io.ReadAll(s.Buffered())
+		s.rec.buf, s.rec.base = append(s.rec.buf[:0], buffered...), s.rec.offset-int64(len(buffered))
+		start = max(start, s.rec.base)
+	}
+	s.rec.recordings = append(s.rec.recordings, &jsonRecording{start: start})
+}
+
+// end 结束最近开始的记录，返回值的类型和字节数，不超过 jsonMaxValueSize 时同时返回原文
+func (s *jsonStream) end() (string, int, json.RawMessage) {
+	rec := s.rec.recordings[len(s.rec.recordings)-1]
+	s.rec.recordings = s.rec.recordings[:len(s.rec.recordings)-1]
+	end := s.InputOffset()
+
+	data := rec.head
+	if rec.start >= s.rec.base {
+		data = s.rec.buf[rec.start-s.rec.base : end-s.rec.base]
+	}
+	skip := 0
+	for skip < len(data) && strings.IndexByte(" \t\r\n:,", data[skip]) >= 0 {
+		skip++
+	}
+	size := int(end-rec.start) - skip
+	typ := jsonRawType(data[skip:])
+
+	var raw json.RawMessage
+	if rec.head == nil && size <= jsonMaxValueSize {
+		raw = append(raw, data[skip:]...)
+	}
+	s.rec.trim()
+	return typ, size, raw
+}
+
+// skipJSONValue 跳过下一个值，返回对象或数组的直接子节点数
+func skipJSONValue(dec *json.Decoder) (string, int, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", 0, err
+	}
+	if _, ok := tok.(json.Delim); !ok {
+		return jsonTokenType(tok), 0, nil
+	}
+	children, err := skipJSONChildren(dec, tok.(json.Delim) == '{')
+	return jsonTokenType(tok), children, err
+}
+
+// skipJSONChildren 在读取了 { 或 [ 之后跳过其余内容，返回子节点数
+func skipJSONChildren(dec *json.Decoder, object bool) (int, error) {
+	children := 0
+	for dec.More() {
+		if object {
+			if _, err := dec.Token(); err != nil {
+				return 0, err
+			}
+		}
+		if _, _, err := skipJSONValue(dec); err != nil {
+			return 0, err
+		}
+		children++
+	}
+	_, err := dec.Token()
+	return children, err
+}
+
+// seekJSONNode 将解码器移动到路径指向的值之前，路径只能包含键和下标
+func seekJSONNode(dec *json.Decoder, segments []jsonSegment) error {
+	for _, seg := range segments {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		delim, ok := tok.(json.Delim)
+		if !ok || (delim == '{') != (seg.kind == jsonSegName) {
+			return errJSONNodeNotFound
+		}
+		found := false
+		for index := 0; dec.More(); index++ {
+			if delim == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				found = key == seg.name
+			} else {
+				found = index == seg.index
+			}
+			if found {
+				break
+			}
+			if _, _, err := skipJSONValue(dec); err != nil {
+				return err
+			}
+		}
+		if !found {
+			return errJSONNodeNotFound
+		}
+	}
+	return nil
+}
+
+// readJSONTree 读取节点及其第 offset 个开始的 limit 个子节点，子节点为对象或数组时统计其子节点数
+func readJSONTree(dec *json.Decoder, path string, offset, limit int) (JSONNode, []JSONNode, bool, error) {
+	node := JSONNode{Path: path}
+	children := []JSONNode{}
+	tok, err := dec.Token()
+	if err != nil {
+		return node, nil, false, err
+	}
+	node.Type = jsonTokenType(tok)
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		node.Value, node.Truncated = previewJSONValue(tok)
+		return node, children, false, nil
+	}
+
+	more := false
+	for index := 0; dec.More(); index++ {
+		child := JSONNode{Key: strconv.Itoa(index), Path: formatJSONPath(path, "", index, true)}
+		if delim == '{' {
+			key, err := dec.Token()
+			if err != nil {
+				return node, nil, false, err
+			}
+			child.Key = key.(string)
+			child.Path = formatJSONPath(path, child.Key, 0, false)
+		}
+		node.Children++
+
+		if index < offset || index >= offset+limit {
+			more = more || index >= offset+limit
+			if _, _, err := skipJSONValue(dec); err != nil {
+				return node, nil, false, err
+			}
+			continue
+		}
+
+		tok, err := dec.Token()
+		if err != nil {
+			return node, nil, false, err
+		}
+		child.Type = jsonTokenType(tok)
+		if d, ok := tok.(json.Delim); ok {
+			if child.Children, err = skipJSONChildren(dec, d == '{'); err != nil {
+				return node, nil, false, err
+			}
+		} else {
+			child.Value, child.Truncated = previewJSONValue(tok)
+		}
+		children = append(children, child)
+	}
+	if _, err := dec.Token(); err != nil {
+		return node, nil, false, err
+	}
+	return node, children, more, nil
+}
+
+// previewJSONValue 返回标量的值，过长的字符串截断
+func previewJSONValue(tok json.Token) (any, bool) {
+	if s, ok := tok.(string); ok && utf8.RuneCountInString(s) > jsonPreviewLength {
+		return string([]rune(s)[:jsonPreviewLength]), true
+	}
+	return tok, false
+}
+
+// jsonQuery 在流式读取中求值的路径查询
+type jsonQuery struct {
+	segments []jsonSegment
+	limit    int
+	results  []JSONMatch
+	open     int         // 正在读取的匹配值的数量，读完之前不停止
+	skip     []JSONMatch // 已记录的结果，再次遇到时不重复记录
+}
+
+// next 计算子节点的状态：状态为接下来要匹配的片段下标，等于片段数表示匹配。
+// 过滤条件需要子节点的值才能判断，单独返回
+func (q *jsonQuery) next(states []int, key string, index int, isIndex bool) (next, filters []int) {
+	add := func(list []int, state int) []int {
+		for _, s := range list {
+			if s == state {
+				return list
+			}
+		}
+		return append(list, state)
+	}
+	for _, state := range states {
+		if state >= len(q.segments) {
+			continue
+		}
+		seg := q.segments[state]
+		if seg.descendant {
+			next = add(next, state)
+		}
+		switch seg.kind {
+		case jsonSegName:
+			if !isIndex && key == seg.name {
+				next = add(next, state+1)
+			}
+		case jsonSegIndex:
+			if isIndex && index == seg.index {
+				next = add(next, state+1)
+			}
+		case jsonSegWildcard:
+			next = add(next, state+1)
+		case jsonSegSlice:
+			if isIndex && index >= seg.start && (seg.end < 0 || index < seg.end) {
+				next = add(next, state+1)
+			}
+		case jsonSegFilter:
+			filters = add(filters, state)
+		}
+	}
+	return next, filters
+}
+
+// addResult 记录一个结果，返回其下标；凑满 limit+1 个后停止（多出的一个用于判断是否还有更多结果），
+// 此时仍在读取某个匹配的值时先读完该值，之后的结果不再记录，下标为 -1
+func (q *jsonQuery) addResult(path string) (int, error) {
+	for _, match := range q.skip {
+		if match.Path == path {
+			return -1, nil
+		}
+	}
+	if len(q.results) > q.limit {
+		return -1, nil
+	}
+	q.results = append(q.results, JSONMatch{Path: path})
+	if len(q.results) > q.limit {
+		if q.open == 0 {
+			return -1, errJSONQueryDone
+		}
+		return -1, nil
+	}
+	return len(q.results) - 1, nil
+}
+
+// walk 对解码器中的下一个值求值，没有需要匹配的状态时直接跳过
+func (q *jsonQuery) walk(s *jsonStream, states []int, path string) error {
+	if len(states) == 0 {
+		_, _, err := skipJSONValue(s.Decoder)
+		return err
+	}
+
+	// 值本身匹配时边读取边记录原文，其中的后代仍可能匹配；超过 jsonMaxValueSize 的值只记录类型和大小
+	rest := make([]int, 0, len(states))
+	matched := false
+	for _, state := range states {
+		if state == len(q.segments) {
+			matched = true
+		} else {
+			rest = append(rest, state)
+		}
+	}
+	if matched {
+		i, err := q.addResult(path)
+		if err != nil {
+			return err
+		}
+		if i < 0 {
+			return q.walk(s, rest, path)
+		}
+		q.open++
+		s.begin()
+		err = q.walk(s, rest, path)
+		q.open--
+		if err != nil {
+			return err
+		}
+		match := &q.results[i]
+		match.Type, match.Size, match.Value = s.end()
+		if q.open == 0 && len(q.results) > q.limit {
+			return errJSONQueryDone
+		}
+		return nil
+	}
+
+	tok, err := s.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil
+	}
+	for index := 0; s.More(); index++ {
+		key := ""
+		if delim == '{' {
+			keyTok, err := s.Token()
+			if err != nil {
+				return err
+			}
+			key = keyTok.(string)
+		}
+		childPath := formatJSONPath(path, key, index, delim == '[')
+		next, filters := q.next(states, key, index, delim == '[')
+		if len(filters) == 0 {
+			if err := q.walk(s, next, childPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// 过滤条件需要子节点的值：先按其余状态边读取边记录，读完后再判断过滤条件，
+		// 符合时按全部状态重新读取记录的原文，已记录的结果不再重复；超过 jsonMaxValueSize 的值视为不符合
+		first := len(q.results)
+		s.begin()
+		if err := q.walk(s, next, childPath); err != nil {
+			return err
+		}
+		_, _, raw := s.end()
+		if raw == nil {
+			continue
+		}
+		var value any
+		if err := newJSONDecoder(bytes.NewReader(raw)).Decode(&value); err != nil {
+			return err
+		}
+		all := next
+		for _, state := range filters {
+			if q.segments[state].filter.match(value) {
+				all = append(all, state+1)
+			}
+		}
+		if len(all) == len(next) {
+			continue
+		}
+		skip := q.skip
+		q.skip = append(q.skip[:len(q.skip):len(q.skip)], q.results[first:]...)
+		err := q.walk(newJSONStream(bytes.NewReader(raw)), all, childPath)
+		q.skip = skip
+		if err != nil {
+			return err
+		}
+	}
+	_, err = s.Token()
+	return err
+}
+
+// jsonErrorStatus 文档不是有效的 JSON 时返回 422，读取失败时返回 500
+func jsonErrorStatus(err error) int {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// handleJSON 流式读取 JSON 文件，不必将整个文档载入内存：
+// 指定 query 时返回符合 JSONPath 的值，否则返回 node 指向的节点及其一段子节点
+func (s *Server) handleJSON(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	path := query.Get("path")
+	if path == "" {
+		s.handleError(w, fmt.Errorf("path parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	offset, err := parseInt64Param(r, "offset", 0)
+	if err != nil || offset < 0 {
+		s.handleError(w, fmt.Errorf("invalid offset"), http.StatusBadRequest)
+		return
+	}
+	limit, err := parseInt64Param(r, "limit", jsonDefaultLimit)
+	if err != nil || limit <= 0 {
+		s.handleError(w, fmt.Errorf("invalid limit"), http.StatusBadRequest)
+		return
+	}
+	limit = min(limit, jsonMaxLimit)
+
+	expr := query.Get("query")
+	nodePath := query.Get("node")
+	if expr != "" && nodePath != "" {
+		s.handleError(w, fmt.Errorf("node and query cannot be used together"), http.StatusBadRequest)
+		return
+	}
+	if expr == "" && nodePath == "" {
+		nodePath = "$"
+	}
+	segments, err := parseJSONPath(expr + nodePath)
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+	if nodePath != "" {
+		for _, seg := range segments {
+			if seg.descendant || (seg.kind != jsonSegName && seg.kind != jsonSegIndex) {
+				s.handleError(w, fmt.Errorf("node must be a path of names and indexes: %s", nodePath), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	rootIndex := getRootIndex(r)
+
+	// 构建完整路径
+	fullPath := s.getFullPath(path, rootIndex)
+
+	// 检查路径是否在根目录内
+	if !s.isPathSafe(fullPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	// 打开文件（支持归档内的文件）
+	file, info, err := s.openFile(rootIndex, fullPath)
+	if err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+	defer file.Close()
+
+	// 压缩文件解压后解析
+	var reader io.Reader = file
+	if kind := compressionKind(info.Name()); kind != "" {
+		rc, err := newDecompressor(kind, file)
+		if err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		defer rc.Close()
+		reader = rc
+	}
+
+	reader, _, err = s.decodeText(rootIndex, reader, query.Get("encoding"))
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+	stream := newJSONStream(bufio.NewReaderSize(reader, 64*1024))
+	dec := stream.Decoder
+
+	if expr != "" {
+		q := &jsonQuery{segments: segments, limit: int(limit)}
+		if err := q.walk(stream, []int{0}, "$"); err != nil && err != errJSONQueryDone {
+			s.handleError(w, err, jsonErrorStatus(err))
+			return
+		}
+		result := JSONQueryResult{
+			Path:    fullPath,
+			Name:    info.Name(),
+			Size:    info.Size(),
+			Query:   expr,
+			Results: q.results,
+			Version: s.fileVersion(rootIndex, fullPath, file, info),
+		}
+		if len(result.Results) > int(limit) {
+			result.Results, result.More = result.Results[:limit], true
+		}
+		if result.Results == nil {
+			result.Results = []JSONMatch{}
+		}
+		s.writeJSON(w, result)
+		return
+	}
+
+	// 规范化节点路径，使其与子节点中的 path 一致
+	canonical := "$"
+	for _, seg := range segments {
+		canonical = formatJSONPath(canonical, seg.name, seg.index, seg.kind == jsonSegIndex)
+	}
+	if err := seekJSONNode(dec, segments); err != nil {
+		if err == errJSONNodeNotFound {
+			s.handleError(w, fmt.Errorf("node %s not found", canonical), http.StatusNotFound)
+			return
+		}
+		s.handleError(w, err, jsonErrorStatus(err))
+		return
+	}
+	node, children, more, err := readJSONTree(dec, canonical, int(offset), int(limit))
+	if err != nil {
+		s.handleError(w, err, jsonErrorStatus(err))
+		return
+	}
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+		node.Key = last.name
+		if last.kind == jsonSegIndex {
+			node.Key = strconv.Itoa(last.index)
+		}
+	}
+	s.writeJSON(w, JSONTree{
+		Path:     fullPath,
+		Name:     info.Name(),
+		Size:     info.Size(),
+		Node:     node,
+		Children: children,
+		Offset:   int(offset),
+		More:     more,
+		Version:  s.fileVersion(rootIndex, fullPath, file, info),
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testJSONDocument = `{
+  "store": {
+    "book": [
+      {"category": "reference", "author": "Nigel Rees", "title": "Sayings", "price": 8.95},
+      {"category": "fiction", "author": "Evelyn Waugh", "title": "Sword", "price": 12.99},
+      {"category": "fiction", "author": "Herman Melville", "title": "Moby Dick", "isbn": "0-553", "price": 8.99},
+      {"category": "fiction", "author": "J. R. R. Tolkien", "title": "The Lord", "isbn": "0-395", "price": 22.99}
+    ],
+    "bicycle": {"color": "red", "price": 19.95}
+  },
+  "odd key": [1, [2, 3], {"price": 0}],
+  "empty": {}
+}`
+
+func TestParseJSONPath(t *testing.T) {
+	for _, expr := range []string{"$", ".", "$.a.b[0]", ".a[]", "$..price", "$['odd key'][1:]", `$["a\"b"].*`, "$.a[?(@.b.c >= 'x]')]", "@.a[?(@.b)]"} {
+		if _, err := parseJSONPath(expr); err != nil {
+			t.Errorf("parseJSONPath(%q) = %v", expr, err)
+		}
+	}
+	for _, expr := range []string{"$a", "$.a[", "$.a[-1]", "$..", "$.a[?(@..b)]", "$.a[?(@.b == x)]", "$.a[?(b)]", "$['a]"} {
+		if _, err := parseJSONPath(expr); err == nil {
+			t.Errorf("parseJSONPath(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestJSONQuery(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	writeTestFile(t, dir, "store.json", testJSONDocument)
+
+	query := func(expr string, limit int) JSONQueryResult {
+		t.Helper()
+		var result JSONQueryResult
+		target := fmt.Sprintf("/api/json?root=0&path=/store.json&limit=%d&query=%s", limit, strings.NewReplacer(" ", "%20", "&", "%26", "+", "%2B").Replace(expr))
+		decodeResponse(t, doRequest(s.handleJSON, "GET", target, ""), 200, &result)
+		return result
+	}
+	format := func(result JSONQueryResult) string {
+		var parts []string
+		for _, match := range result.Results {
+			parts = append(parts, match.Path+"="+string(match.Value))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"$.store.book[1].author", `$.store.book[1].author="Evelyn Waugh"`},
+		{".store.book[].price", `$.store.book[0].price=8.95 $.store.book[1].price=12.99 $.store.book[2].price=8.99 $.store.book[3].price=22.99`},
+		{"$..price", `$.store.book[0].price=8.95 $.store.book[1].price=12.99 $.store.book[2].price=8.99 $.store.book[3].price=22.99 $.store.bicycle.price=19.95 $["odd key"][2].price=0`},
+		{"$.store.book[?(@.price < 10)].title", `$.store.book[0].title="Sayings" $.store.book[2].title="Moby Dick"`},
+		{"$.store.book[?(@.isbn)].author", `$.store.book[2].author="Herman Melville" $.store.book[3].author="J. R. R. Tolkien"`},
+		{"$.store.book[?(@.category == 'reference')].price", `$.store.book[0].price=8.95`},
+		{"$['odd key'][1:3]", `$["odd key"][1]=[2,3] $["odd key"][2]={"price":0}`},
+		{"$.store.bicycle.*", `$.store.bicycle.color="red" $.store.bicycle.price=19.95`},
+		{"$.missing", ``},
+	}
+	for _, tt := range tests {
+		if got := format(query(tt.expr, 100)); got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.expr, got, tt.want)
+		}
+	}
+
+	// 结果超过 limit 时停止
+	if result := query("$..price", 2); len(result.Results) != 2 || !result.More {
+		t.Errorf("limited = %+v", result)
+	}
+	// 匹配的值中的后代同样匹配
+	writeTestFile(t, dir, "nested.json", `{"a": {"b": 1, "a": {"a": [true]}}}`)
+	var nested JSONQueryResult
+	decodeResponse(t, doRequest(s.handleJSON, "GET", "/api/json?root=0&path=/nested.json&query=$..a", ""), 200, &nested)
+	if got := format(nested); got != `$.a={"b":1,"a":{"a":[true]}} $.a.a={"a":[true]} $.a.a.a=[true]` {
+		t.Errorf("$..a = %s", got)
+	}
+
+	// 超过 jsonMaxValueSize 的值只给出类型和大小，过滤条件视为不符合，其中的后代和之后的值仍然匹配
+	bigText := `"` + strings.Repeat("x", jsonMaxValueSize) + `"`
+	bigArray := "[" + bigText + ", " + bigText + "]"
+	writeTestFile(t, dir, "big.json", `{"big": `+bigArray+`, "items": [{"k": 1, "text": `+bigText+`}, {"k": 2}], "after": 3}`)
+	var big JSONQueryResult
+	decodeResponse(t, doRequest(s.handleJSON, "GET", "/api/json?root=0&path=/big.json&query=$..*", ""), 200, &big)
+	var paths []string
+	for _, match := range big.Results {
+		paths = append(paths, match.Path)
+	}
+	if got := strings.Join(paths, " "); got != "$.big $.big[0] $.big[1] $.items $.items[0] $.items[0].k $.items[0].text $.items[1] $.items[1].k $.after" {
+		t.Fatalf("$..* = %s", got)
+	}
+	if m := big.Results[0]; m.Type != "array" || m.Size != len(bigArray) || m.Value != nil {
+		t.Errorf("$.big = %s %d %d", m.Type, m.Size, len(m.Value))
+	}
+	if m := big.Results[1]; m.Type != "string" || m.Size != len(bigText) || m.Value != nil {
+		t.Errorf("$.big[0] = %s %d %d", m.Type, m.Size, len(m.Value))
+	}
+	if m := big.Results[9]; string(m.Value) != "3" {
+		t.Errorf("$.after = %s", m.Value)
+	}
+	decodeResponse(t, doRequest(s.handleJSON, "GET", "/api/json?root=0&path=/big.json&query=$.items[?(@.k)].k", ""), 200, &big)
+	if got := format(big); got != "$.items[1].k=2" {
+		t.Errorf("filter = %s", got)
+	}
+
+	decodeResponse(t, doRequest(s.handleJSON, "GET", "/api/json?root=0&path=/store.json&query=$.a[", ""), 400, nil)
+	writeTestFile(t, dir, "broken.json", `{"a": [1, 2`)
+	decodeResponse(t, doRequest(s.handleJSON, "GET", "/api/json?root=0&path=/broken.json&query=$..a", ""), 422, nil)
+}
+
+func TestJSONTree(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	writeTestFile(t, dir, "store.json", testJSONDocument)
+
+	tree := func(query string) JSONTree {
+		t.Helper()
+		var tree JSONTree
+		decodeResponse(t, doRequest(s.handleJSON, "GET", "/api/json?root=0&path=/store.json&"+query, ""), 200, &tree)
+		return tree
+	}
+
+	root := tree("")
+	if root.Node.Type != "object" || root.Node.Children != 3 || len(root.Children) != 3 {
+		t.Fatalf("root = %+v", root)
+	}
+	if c := root.Children[1]; c.Key != "odd key" || c.Path != `$["odd key"]` || c.Type != "array" || c.Children != 3 {
+		t.Errorf("odd key = %+v", c)
+	}
+
+	// 按路径展开，分段读取子节点
+	books := tree("node=$.store.book&offset=1&limit=2")
+	if books.Node.Children != 4 || len(books.Children) != 2 || !books.More || books.Children[0].Path != "$.store.book[1]" || books.Children[0].Children != 4 {
+		t.Errorf("books = %+v", books)
+	}
+	odd := tree("node=" + strings.ReplaceAll(root.Children[1].Path, " ", "%20") + "[0]")
+	if odd.Node.Type != "number" || fmt.Sprint(odd.Node.Value) != "1" || odd.Node.Key != "0" {
+		t.Errorf("odd[0] = %+v", odd.Node)
+	}
+
+	// 过长的字符串截断
+	writeTestFile(t, dir, "long.json", `{"text": "`+strings.Repeat("é", jsonPreviewLength+10)+`"}`)
+	var long JSONTree
+	decodeResponse(t, doRequest(s.handleJSON, "GET", "/api/json?root=0&path=/long.json", ""), 200, &long)
+	if c := long.Children[0]; !c.Truncated || len([]rune(c.Value.(string))) != jsonPreviewLength {
+		t.Errorf("long = %+v", c)
+	}
+
+	decodeResponse(t, doRequest(s.handleJSON, "GET", "/api/json?root=0&path=/store.json&node=$.store.nope", ""), 404, nil)
+	decodeResponse(t, doRequest(s.handleJSON, "GET", "/api/json?root=0&path=/store.json&node=$.store.book[9]", ""), 404, nil)
+	decodeResponse(t, doRequest(s.handleJSON, "GET", "/api/json?root=0&path=/store.json&node=$..book", ""), 400, nil)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 换行符风格
+const (
+	LineEndingLF   = "lf"   // \n（Unix）
+	LineEndingCRLF = "crlf" // \r\n（Windows）
+	LineEndingCR   = "cr"   // \r（早期 Mac）
+)
+
+// checkLineEnding 检查换行符名称，空字符串表示保持原文件的换行符
+func checkLineEnding(ending string) error {
+	switch ending {
+	case "", LineEndingLF, LineEndingCRLF, LineEndingCR:
+		return nil
+	}
+	return fmt.Errorf("invalid line ending: %s", ending)
+}
+
+// lineEndingText 获取换行符对应的文本
+func lineEndingText(ending string) string {
+	switch ending {
+	case LineEndingCRLF:
+		return "\r\n"
+	case LineEndingCR:
+		return "\r"
+	}
+	return "\n"
+}
+
+// detectLineEnding 统计文本中各种换行符的数量，返回最多的一种，数量相同时依次优先 lf、crlf、cr
+// 没有换行符时返回空字符串，mixed 表示混用了多种换行符
+func detectLineEnding(text []byte) (ending string, mixed bool) {
+	var lf, crlf, cr int
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\n':
+			lf++
+		case '\r':
+			if i+1 < len(text) && text[i+1] == '\n' {
+				crlf++
+				i++
+			} else if i+1 < len(text) {
+				// 末尾的 \r 可能是被截断的 \r\n，不计入
+				cr++
+			}
+		}
+	}
+
+	kinds := 0
+	for _, n := range []int{lf, crlf, cr} {
+		if n > 0 {
+			kinds++
+		}
+	}
+	switch {
+	case kinds == 0:
+		return "", false
+	case lf >= crlf && lf >= cr:
+		ending = LineEndingLF
+	case crlf >= cr:
+		ending = LineEndingCRLF
+	default:
+		ending = LineEndingCR
+	}
+	return ending, kinds > 1
+}
+
+// splitLines 将文本按 \n 和 \r\n 分行，去掉换行符；结尾的换行符不产生空行，finalNewline 表示文本是否以换行符结尾
+// 单独的 \r 属于行内容，与流式读取、搜索和行数统计一致，只用 \r 换行的文件视为一行；
+// ending 为 cr 时结尾的 \r 作为最后的换行符，保存时按 finalNewline 补回
+func splitLines(text, ending string) (lines []string, finalNewline bool) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	if strings.HasSuffix(text, "\n") || ending == LineEndingCR && strings.HasSuffix(text, "\r") {
+		text = text[:len(text)-1]
+		finalNewline = true
+	}
+	return strings.Split(text, "\n"), finalNewline
+}
+
+// convertLineEndings 将文本中的 \n 和 \r\n 统一转换为 ending 对应的换行符
+func convertLineEndings(text, ending string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if ending == LineEndingLF || ending == "" {
+		return text
+	}
+	return strings.ReplaceAll(text, "\n", lineEndingText(ending))
+}
+
+// setFinalNewline 在文本末尾补上 ending 对应的换行符，或去掉末尾的一个换行符；空文本保持不变
+func setFinalNewline(text, ending string, finalNewline bool) string {
+	hasNewline := strings.HasSuffix(text, "\n") || strings.HasSuffix(text, "\r")
+	switch {
+	case finalNewline && !hasNewline && text != "":
+		text += lineEndingText(ending)
+	case !finalNewline && hasNewline:
+		text = strings.TrimSuffix(text, "\n")
+		text = strings.TrimSuffix(text, "\r")
+	}
+	return text
+}
+
+// peekLineEnding 检测已解码文本开头的换行符，返回的读取器仍从头读取
+func peekLineEnding(r io.Reader) (io.Reader, string, bool) {
+	br := bufio.NewReaderSize(r, encodingSniffLen)
+	head, _ := br.Peek(encodingSniffLen)
+	ending, mixed := detectLineEnding(head)
+	return br, ending, mixed
+}
+
+// endsWithNewline 检查文件是否以换行符结尾，只读取最后一个字符
+func endsWithNewline(file File, size int64, te textEncoding) (bool, error) {
+	width := int64(1)
+	if te.isUTF16() {
+		width = 2
+	}
+	if size < width {
+		return false, nil
+	}
+
+	if _, err := file.Seek(size-width, io.SeekStart); err != nil {
+		return false, err
+	}
+	last := make([]byte, width)
+	if _, err := io.ReadFull(file, last); err != nil {
+		return false, err
+	}
+
+	switch te.name {
+	case "utf-16le":
+		return last[1] == 0 && (last[0] == '\n' || last[0] == '\r'), nil
+	case "utf-16be":
+		return last[0] == 0 && (last[1] == '\n' || last[1] == '\r'), nil
+	}
+	return last[0] == '\n' || last[0] == '\r', nil
+}
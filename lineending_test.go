@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectLineEnding(t *testing.T) {
+	tests := []struct {
+		text      string
+		want      string
+		wantMixed bool
+	}{
+		{"", "", false},
+		{"no newline", "", false},
+		{"a\nb\n", LineEndingLF, false},
+		{"a\r\nb\r\n", LineEndingCRLF, false},
+		{"a\rb\r", LineEndingCR, false},
+		{"a\r\nb\r\nc\n", LineEndingCRLF, true},
+		{"a\nb\r\n", LineEndingLF, true},
+		// 末尾的 \r 可能是被截断的 \r\n
+		{"a\r\nb\r", LineEndingCRLF, false},
+	}
+	for _, tt := range tests {
+		got, mixed := detectLineEnding([]byte(tt.text))
+		if got != tt.want || mixed != tt.wantMixed {
+			t.Errorf("detectLineEnding(%q) = %q, %v; want %q, %v", tt.text, got, mixed, tt.want, tt.wantMixed)
+		}
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		text         string
+		ending       string
+		want         string
+		finalNewline bool
+	}{
+		{"", "", "", false},
+		{"a", "", "a", false},
+		{"a\nb\n", LineEndingLF, "a|b", true},
+		{"a\nb", LineEndingLF, "a|b", false},
+		{"a\r\nb\r\n", LineEndingCRLF, "a|b", true},
+		{"a\n\n", LineEndingLF, "a|", true},
+		// 单独的 \r 属于行内容，cr 风格时结尾的 \r 作为最后的换行符
+		{"a\rb\r", LineEndingCR, "a\rb", true},
+		{"50%\r100%\n", LineEndingLF, "50%\r100%", true},
+	}
+	for _, tt := range tests {
+		lines, finalNewline := splitLines(tt.text, tt.ending)
+		if got := strings.Join(lines, "|"); got != tt.want || finalNewline != tt.finalNewline {
+			t.Errorf("splitLines(%q, %q) = %q, %v; want %q, %v", tt.text, tt.ending, got, finalNewline, tt.want, tt.finalNewline)
+		}
+	}
+}
+
+func TestSetFinalNewline(t *testing.T) {
+	tests := []struct {
+		text         string
+		ending       string
+		finalNewline bool
+		want         string
+	}{
+		{"a", LineEndingCRLF, true, "a\r\n"},
+		{"a\r\n", LineEndingCRLF, true, "a\r\n"},
+		{"a\r\n", LineEndingCRLF, false, "a"},
+		{"a\n\n", LineEndingLF, false, "a\n"},
+		{"", LineEndingLF, true, ""},
+	}
+	for _, tt := range tests {
+		if got := setFinalNewline(tt.text, tt.ending, tt.finalNewline); got != tt.want {
+			t.Errorf("setFinalNewline(%q, %q, %v) = %q, want %q", tt.text, tt.ending, tt.finalNewline, got, tt.want)
+		}
+	}
+}
+
+func TestSaveLineEndingRoundTrip(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name         string
+		original     string
+		wantEnding   string
+		wantFinal    bool
+		content      string
+		lineEnding   string
+		finalNewline *bool
+		wantSaved    string
+	}{
+		{"crlf is kept", "a\r\nb\r\n", LineEndingCRLF, true, "a\nc\n", "", nil, "a\r\nc\r\n"},
+		{"no final newline is kept", "a\r\nb", LineEndingCRLF, false, "a\nc", "", nil, "a\r\nc"},
+		{"cr is kept", "a\rb\r", LineEndingCR, true, "x\ny\n", "", nil, "x\ry\r"},
+		{"lf is kept", "a\nb\n", LineEndingLF, true, "a\r\nc\r\n", "", nil, "a\nc\n"},
+		{"convert to lf", "a\r\nb\r\n", LineEndingCRLF, true, "a\nb\n", LineEndingLF, nil, "a\nb\n"},
+		{"convert to crlf", "a\nb\n", LineEndingLF, true, "a\nb\n", LineEndingCRLF, nil, "a\r\nb\r\n"},
+		{"add final newline", "a\r\nb", LineEndingCRLF, false, "a\nb", "", &yes, "a\r\nb\r\n"},
+		{"remove final newline", "a\nb\n", LineEndingLF, true, "a\nb\n", "", &no, "a\nb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := newTestServer(t, nil)
+			fullPath := writeTestFile(t, dir, "a.txt", tt.original)
+
+			var content FileContent
+			decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/a.txt", ""), 200, &content)
+			if content.LineEnding != tt.wantEnding || content.FinalNewline == nil || *content.FinalNewline != tt.wantFinal {
+				t.Fatalf("view lineEnding = %q finalNewline = %v, want %q %v", content.LineEnding, content.FinalNewline, tt.wantEnding, tt.wantFinal)
+			}
+			breaks := "\r\n"
+			if tt.wantEnding == LineEndingCR {
+				breaks = "\n" // 只用 \r 换行的文件视为一行
+			}
+			for _, line := range content.Lines {
+				if strings.ContainsAny(line, breaks) {
+					t.Fatalf("view lines = %q, want no line breaks", content.Lines)
+				}
+			}
+
+			body := mustJSON(t, SaveRequest{Path: "/a.txt", Content: tt.content, Version: "*", LineEnding: tt.lineEnding, FinalNewline: tt.finalNewline})
+			decodeResponse(t, doRequest(s.handleSave, "POST", "/api/save?root=0", body), 200, nil)
+			if got := readTestFile(t, fullPath); got != tt.wantSaved {
+				t.Errorf("saved bytes = %q, want %q", got, tt.wantSaved)
+			}
+		})
+	}
+
+	t.Run("invalid line ending", func(t *testing.T) {
+		s, dir := newTestServer(t, nil)
+		writeTestFile(t, dir, "a.txt", "a\n")
+		body := mustJSON(t, SaveRequest{Path: "/a.txt", Content: "b\n", Version: "*", LineEnding: "nel"})
+		decodeResponse(t, doRequest(s.handleSave, "POST", "/api/save?root=0", body), 400, nil)
+	})
+}
+
+func TestPatchLinesKeepsCRLF(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	fullPath := writeTestFile(t, dir, "a.txt", "a\r\nb\r\nc\r\n")
+
+	body := mustJSON(t, PatchRequest{Path: "/a.txt", Version: "*", StartLine: 2, Content: "x\ny"})
+	decodeResponse(t, doRequest(s.handlePatch, "POST", "/api/patch?root=0", body), 200, nil)
+	if got, want := readTestFile(t, fullPath), "a\r\nx\r\ny\r\nc\r\n"; got != want {
+		t.Errorf("patched = %q, want %q", got, want)
+	}
+}
+
+func TestPatchLinesRejectsCR(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	fullPath := writeTestFile(t, dir, "a.txt", "a\rb\rc\r")
+
+	// 只用 \r 换行的文件视为一行，不能按行修改
+	req := PatchRequest{Path: "/a.txt", Version: "*", StartLine: 2, Content: "x"}
+	if rec := doRequest(s.handlePatch, "POST", "/api/patch?root=0", mustJSON(t, req)); rec.Code != 400 {
+		t.Fatalf("line patch status = %d, want 400", rec.Code)
+	}
+
+	req = PatchRequest{Path: "/a.txt", Version: "*", Unit: PatchUnitByte, Offset: 2, Length: 1, Content: "x"}
+	decodeResponse(t, doRequest(s.handlePatch, "POST", "/api/patch?root=0", mustJSON(t, req)), 200, nil)
+	if got := readTestFile(t, fullPath); got != "a\rx\rc\r" {
+		t.Errorf("patched = %q", got)
+	}
+}
@@ -0,0 +1,647 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 日志格式
+const (
+	LogFormatAuto   = "auto"
+	LogFormatJSON   = "json"   // 每行一个 JSON 对象（NDJSON）
+	LogFormatLogfmt = "logfmt" // key=value 形式
+)
+
+const (
+	// 自动识别格式时检查的行数
+	logSniffLines = 20
+	// 每条记录最多保留的后续行（如异常堆栈）
+	logMaxMoreLines = 200
+	// 单次最多读取 maxLines 的多少倍行数，过滤条件很少命中时避免一次读完整个文件
+	logScanFactor = 100
+)
+
+// LogFormatConfig 文本日志格式，用正则表达式的命名分组提取内容
+type LogFormatConfig struct {
+	Name       string   `json:"name"`                 // 格式名称，查看时通过 log=<name> 指定
+	Pattern    string   `json:"pattern"`              // 正则表达式，命名分组 time、level、msg 分别为时间、级别和消息，其他命名分组作为字段
+	TimeLayout string   `json:"timeLayout,omitempty"` // 时间格式（Go 的时间布局），默认尝试常见的格式
+	Files      []string `json:"files,omitempty"`      // 文件名匹配这些模式（如 "app-*.log"）时自动使用该格式
+}
+
+// builtinLogFormats 内置的文本日志格式
+var builtinLogFormats = []LogFormatConfig{
+	{
+		// 2024-05-01 12:00:00.123 [ERROR] message、2024-05-01T12:00:00Z WARN message 等
+		Name:    "text",
+		Pattern: `^\[?(?P<time>\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?)\]?\s+\[?(?P<level>(?i:trace|debug|info|notice|warn|warning|error|err|fatal|critical|crit|panic))\]?:?\s+(?P<msg>.*)$`,
+	},
+	{
+		// Apache/Nginx 访问日志（Common 和 Combined 格式）
+		Name:       "clf",
+		Pattern:    `^(?P<remote>\S+) \S+ (?P<user>\S+) \[(?P<time>[^\]]+)\] "(?P<request>[^"]*)" (?P<status>\d{3}) (?P<bytes>\S+)(?: "(?P<referer>[^"]*)" "(?P<agent>[^"]*)")?`,
+		TimeLayout: "02/Jan/2006:15:04:05 -0700",
+	},
+	{
+		// 传统 syslog：May  1 12:00:00 host app[123]: message
+		Name:       "syslog",
+		Pattern:    `^(?P<time>[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}) (?P<host>\S+) (?P<app>[^:\[\s]+)(?:\[(?P<pid>\d+)\])?: (?P<msg>.*)$`,
+		TimeLayout: "Jan _2 15:04:05",
+	},
+}
+
+// logTimeLayouts 未指定时间格式时依次尝试的格式
+var logTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02 15:04:05.999999999",
+	"2006/01/02 15:04:05.999999999",
+	"02/Jan/2006:15:04:05 -0700",
+	time.RFC1123Z,
+	time.RFC1123,
+	"Jan _2 15:04:05",
+	"2006-01-02",
+}
+
+// 常见的时间、级别和消息字段名（文本格式的命名分组同样适用）
+var (
+	logTimeKeys    = []string{"time", "ts", "timestamp", "@timestamp", "t"}
+	logLevelKeys   = []string{"level", "lvl", "severity", "levelname", "log.level"}
+	logMessageKeys = []string{"msg", "message", "@message"}
+)
+
+// 日志级别，按严重程度排列
+var logLevels = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+// LogRecord 解析后的一条日志
+type LogRecord struct {
+	Line      int               `json:"line"`                // 行号（从 1 开始）
+	Time      string            `json:"time,omitempty"`      // 原始的时间文本
+	Timestamp *time.Time        `json:"timestamp,omitempty"` // 解析后的时间
+	Level     string            `json:"level,omitempty"`     // 规范化的级别：trace、debug、info、warn、error、fatal
+	Message   string            `json:"message,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"` // 其他字段
+	Raw       string            `json:"raw,omitempty"`    // 无法解析的行的原文
+	More      []string          `json:"more,omitempty"`   // 后续无法解析的行（如异常堆栈）
+}
+
+// LogView 日志查看响应
+type LogView struct {
+	Path        string       `json:"path"`
+	Name        string       `json:"name"`
+	Size        int64        `json:"size"`
+	Format      string       `json:"format"`                // 使用的日志格式
+	Records     []*LogRecord `json:"records"`               // 符合条件的记录
+	From        int          `json:"from"`                  // 本次从第几行开始读取
+	Next        int          `json:"next,omitempty"`        // 继续读取时的起始行，已到文件末尾时省略
+	Scanned     int          `json:"scanned"`               // 本次读取的行数
+	Compression string       `json:"compression,omitempty"` // 压缩格式（内容已解压）
+	Version     string       `json:"version,omitempty"`
+	Encoding    string       `json:"encoding,omitempty"`
+}
+
+// logParser 一种日志格式的解析器
+type logParser struct {
+	name       string
+	re         *regexp.Regexp // 文本格式的正则表达式，JSON 和 logfmt 为 nil
+	timeLayout string
+	files      []string
+}
+
+// newTextLogParser 编译文本日志格式
+func newTextLogParser(config LogFormatConfig) (*logParser, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf("log format name is required")
+	}
+	re, err := regexp.Compile(config.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern for log format %s: %w", config.Name, err)
+	}
+	return &logParser{name: config.Name, re: re, timeLayout: config.TimeLayout, files: config.Files}, nil
+}
+
+// logParsers 返回根目录可用的日志格式：JSON、logfmt、根目录和全局配置的格式，最后是内置的文本格式
+func (s *Server) logParsers(rootIndex int) ([]*logParser, error) {
+	parsers := []*logParser{{name: LogFormatJSON}, {name: LogFormatLogfmt}}
+
+	var configs []LogFormatConfig
+	if rootIndex >= 0 && rootIndex < len(s.config.RootDirs) {
+		configs = append(configs, s.config.RootDirs[rootIndex].LogFormats...)
+	}
+	configs = append(configs, s.config.LogFormats...)
+	configs = append(configs, builtinLogFormats...)
+	for _, config := range configs {
+		parser, err := newTextLogParser(config)
+		if err != nil {
+			return nil, err
+		}
+		parsers = append(parsers, parser)
+	}
+	return parsers, nil
+}
+
+// selectLogParser 选择日志格式：指定名称时使用该格式，否则先按文件名匹配，再按开头几行能解析的数量选择
+func selectLogParser(parsers []*logParser, format, name string, head []string) (*logParser, error) {
+	if format != LogFormatAuto {
+		for _, parser := range parsers {
+			if parser.name == format {
+				return parser, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown log format: %s", format)
+	}
+
+	for _, parser := range parsers {
+		for _, pattern := range parser.files {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				return parser, nil
+			}
+		}
+	}
+
+	var best *logParser
+	bestScore := 0
+	for _, parser := range parsers {
+		score := 0
+		for _, line := range head {
+			if _, ok := parser.parse(line); ok {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = parser, score
+		}
+	}
+	// 至少一半的行能够解析
+	if best == nil || bestScore*2 < len(head) {
+		return nil, fmt.Errorf("unrecognized log format")
+	}
+	return best, nil
+}
+
+// parse 解析一行日志，格式不符时返回 false
+func (p *logParser) parse(line string) (*LogRecord, bool) {
+	switch p.name {
+	case LogFormatJSON:
+		return parseJSONLog(line)
+	case LogFormatLogfmt:
+		fields, ok := parseLogfmt(line)
+		if !ok {
+			return nil, false
+		}
+		return recordFromFields(fields, ""), true
+	}
+
+	match := p.re.FindStringSubmatch(line)
+	if match == nil {
+		return nil, false
+	}
+	fields := make(map[string]string)
+	for i, group := range p.re.SubexpNames() {
+		if group != "" && match[i] != "" {
+			fields[group] = match[i]
+		}
+	}
+	return recordFromFields(fields, p.timeLayout), true
+}
+
+// recordFromFields 从字段中取出时间、级别和消息，其余作为字段
+func recordFromFields(fields map[string]string, timeLayout string) *LogRecord {
+	record := &LogRecord{}
+	if key, value := takeField(fields, logTimeKeys); key != "" {
+		record.Time = value
+		if t, ok := parseLogTime(value, timeLayout); ok {
+			record.Timestamp = &t
+		}
+	}
+	if key, value := takeField(fields, logLevelKeys); key != "" {
+		record.Level = normalizeLevel(value)
+	}
+	if key, value := takeField(fields, logMessageKeys); key != "" {
+		record.Message = value
+	}
+	if len(fields) > 0 {
+		record.Fields = fields
+	}
+	return record
+}
+
+// takeField 取出并删除 keys 中第一个存在的字段
+func takeField(fields map[string]string, keys []string) (string, string) {
+	for _, key := range keys {
+		if value, ok := fields[key]; ok {
+			delete(fields, key)
+			return key, value
+		}
+	}
+	return "", ""
+}
+
+// parseJSONLog 解析一行 JSON 日志，嵌套对象展开为以 . 连接的字段名
+func parseJSONLog(line string) (*LogRecord, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") {
+		return nil, false
+	}
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, false
+	}
+
+	fields := make(map[string]string)
+	flattenJSON("", object, fields)
+	return recordFromFields(fields, ""), true
+}
+
+// flattenJSON 将 JSON 对象展开为字符串字段
+func flattenJSON(prefix string, object map[string]interface{}, fields map[string]string) {
+	for key, value := range object {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flattenJSON(key, v, fields)
+		case string:
+			fields[key] = v
+		case nil:
+			fields[key] = "null"
+		case json.Number:
+			fields[key] = v.String()
+		case bool:
+			fields[key] = strconv.FormatBool(v)
+		default:
+			data, _ := json.Marshal(v)
+			fields[key] = string(data)
+		}
+	}
+}
+
+// parseLogfmt 解析 logfmt 格式（key=value、key="quoted value"），至少包含一个字段且不能有多余的文本
+func parseLogfmt(line string) (map[string]string, bool) {
+	fields := make(map[string]string)
+	i := 0
+	for {
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '"' {
+			i++
+		}
+		if i == start || i >= len(line) || line[i] != '=' {
+			return nil, false
+		}
+		key := line[start:i]
+		i++
+
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, false
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, false
+			}
+			fields[key] = value
+			i = end + 1
+			continue
+		}
+
+		start = i
+		for i < len(line) && line[i] != ' ' {
+			i++
+		}
+		fields[key] = line[start:i]
+	}
+	return fields, len(fields) > 0
+}
+
+// parseLogTime 解析日志中的时间，没有时区时按本地时间，没有年份时取最近的一年
+func parseLogTime(value, layout string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	// Unix 时间戳（秒或毫秒）
+	if n, err := strconv.ParseFloat(value, 64); err == nil && !strings.Contains(value, "-") {
+		if n > 1e12 {
+			return time.UnixMilli(int64(n)), true
+		}
+		return time.Unix(0, int64(n*1e9)), true
+	}
+
+	layouts := logTimeLayouts
+	if layout != "" {
+		layouts = []string{layout}
+	}
+	for _, l := range layouts {
+		t, err := time.ParseInLocation(l, value, time.Local)
+		if err != nil {
+			continue
+		}
+		if t.Year() == 0 {
+			now := time.Now()
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// normalizeLevel 规范化日志级别，支持常见的别名、单字母缩写（如 glog、Android）和 pino/bunyan 的数字级别
+func normalizeLevel(level string) string {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "trace", "trc", "verbose", "t", "v", "10":
+		return "trace"
+	case "debug", "dbg", "d", "20":
+		return "debug"
+	case "info", "inf", "information", "notice", "i", "30":
+		return "info"
+	case "warn", "warning", "wrn", "w", "40":
+		return "warn"
+	case "error", "err", "eror", "e", "50":
+		return "error"
+	case "fatal", "critical", "crit", "panic", "emerg", "alert", "f", "60":
+		return "fatal"
+	}
+	return strings.ToLower(level)
+}
+
+// levelRank 级别的严重程度，未知级别返回 -1
+func levelRank(level string) int {
+	for i, l := range logLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// fieldMatch 字段条件：key:value 精确匹配，key~value 包含匹配（不区分大小写）
+type fieldMatch struct {
+	key      string
+	value    string
+	contains bool
+}
+
+// parseFieldMatch 解析 key:value 或 key~value
+func parseFieldMatch(s string) (fieldMatch, error) {
+	i := strings.IndexAny(s, ":~")
+	if i <= 0 {
+		return fieldMatch{}, fmt.Errorf("invalid field filter: %s", s)
+	}
+	return fieldMatch{key: s[:i], value: s[i+1:], contains: s[i] == '~'}, nil
+}
+
+// match 判断值是否符合条件
+func (m fieldMatch) match(value string) bool {
+	if m.contains {
+		return containsIgnoreCase(value, m.value)
+	}
+	return value == m.value
+}
+
+// logFilter 日志过滤条件
+type logFilter struct {
+	levels   map[string]bool // 允许的级别，nil 表示不限
+	minLevel int             // 最低级别，-1 表示不限
+	since    time.Time       // 不早于该时间（含）
+	until    time.Time       // 早于该时间（不含）
+	fields   []fieldMatch
+	query    string // 原文包含的文本（小写）
+}
+
+// parseLogFilter 解析过滤参数：level=warn,error 或 level=warn+、since、until、field（可重复）、q
+func parseLogFilter(r *http.Request) (*logFilter, error) {
+	query := r.URL.Query()
+	f := &logFilter{minLevel: -1, query: strings.ToLower(query.Get("q"))}
+
+	if level := strings.ToLower(query.Get("level")); level != "" {
+		if strings.HasSuffix(level, "+") {
+			if f.minLevel = levelRank(normalizeLevel(strings.TrimSuffix(level, "+"))); f.minLevel < 0 {
+				return nil, fmt.Errorf("invalid level: %s", level)
+			}
+		} else {
+			f.levels = make(map[string]bool)
+			for _, l := range strings.Split(level, ",") {
+				f.levels[normalizeLevel(l)] = true
+			}
+		}
+	}
+
+	for name, t := range map[string]*time.Time{"since": &f.since, "until": &f.until} {
+		if value := query.Get(name); value != "" {
+			parsed, ok := parseLogTime(value, "")
+			if !ok {
+				return nil, fmt.Errorf("invalid %s: %s", name, value)
+			}
+			*t = parsed
+		}
+	}
+
+	for _, field := range query["field"] {
+		m, err := parseFieldMatch(field)
+		if err != nil {
+			return nil, err
+		}
+		f.fields = append(f.fields, m)
+	}
+	return f, nil
+}
+
+// match 判断记录是否符合过滤条件
+func (f *logFilter) match(record *LogRecord) bool {
+	if f.levels != nil && !f.levels[record.Level] {
+		return false
+	}
+	if f.minLevel >= 0 && levelRank(record.Level) < f.minLevel {
+		return false
+	}
+	if !f.since.IsZero() || !f.until.IsZero() {
+		if record.Timestamp == nil {
+			return false
+		}
+		if !f.since.IsZero() && record.Timestamp.Before(f.since) {
+			return false
+		}
+		if !f.until.IsZero() && !record.Timestamp.Before(f.until) {
+			return false
+		}
+	}
+	for _, m := range f.fields {
+		if value, ok := record.Fields[m.key]; !ok || !m.match(value) {
+			return false
+		}
+	}
+	if f.query != "" && !strings.Contains(strings.ToLower(record.text()), f.query) {
+		return false
+	}
+	return true
+}
+
+// text 记录的完整文本，用于文本过滤
+func (record *LogRecord) text() string {
+	parts := []string{record.Raw, record.Time, record.Level, record.Message}
+	keys := make([]string, 0, len(record.Fields))
+	for key := range record.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts = append(parts, key+"="+record.Fields[key])
+	}
+	parts = append(parts, record.More...)
+	return strings.Join(parts, "\n")
+}
+
+// handleLogView 按日志格式解析文件，返回符合过滤条件的记录
+// 从第 from 行开始流式读取，凑满一页或读取的行数达到上限后停止，响应中的 next 为继续读取的起始行
+func (s *Server) handleLogView(w http.ResponseWriter, r *http.Request, rootIndex int, file File, fullPath string, info os.FileInfo, version string, lr lineRange, config ViewConfig, encodingName string) {
+	filter, err := parseLogFilter(r)
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+	parsers, err := s.logParsers(rootIndex)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// 压缩文件解压后解析
+	var reader io.Reader = file
+	kind := compressionKind(info.Name())
+	if kind != "" {
+		rc, err := newDecompressor(kind, file)
+		if err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		defer rc.Close()
+		reader = rc
+	}
+
+	reader, te, err := s.decodeText(rootIndex, reader, encodingName)
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// 根据开头的几行识别格式
+	br := bufio.NewReaderSize(reader, encodingSniffLen)
+	head, _ := br.Peek(encodingSniffLen)
+	parser, err := selectLogParser(parsers, r.URL.Query().Get("log"), info.Name(), sniffLogLines(head))
+	if err != nil {
+		s.handleError(w, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	scanner := NewLineScanner(br)
+	lineNumber := scanner.Skip(lr.start)
+	view := LogView{
+		Path:        fullPath,
+		Name:        info.Name(),
+		Size:        info.Size(),
+		Format:      parser.name,
+		Records:     []*LogRecord{},
+		From:        lr.start + 1,
+		Compression: kind,
+		Version:     version,
+		Encoding:    te.name,
+	}
+
+	scanLimit := config.MaxLines * logScanFactor
+	var pending *LogRecord
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if scanner.Truncated() {
+			line += TruncatedMarker
+		}
+
+		record, ok := parser.parse(line)
+		if !ok && pending != nil {
+			// 无法解析的行属于上一条记录
+			if len(pending.More) < logMaxMoreLines {
+				pending.More = append(pending.More, line)
+			}
+			continue
+		}
+
+		// 新记录开始，上一条记录已完整
+		if pending != nil && filter.match(pending) {
+			view.Records = append(view.Records, pending)
+		}
+		if len(view.Records) >= lr.count || lineNumber-lr.start > scanLimit {
+			view.Next = lineNumber
+			break
+		}
+		if !ok {
+			record = &LogRecord{Raw: line}
+		}
+		record.Line = lineNumber
+		pending = record
+	}
+	if err := scanner.Err(); err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if view.Next == 0 && pending != nil && filter.match(pending) {
+		view.Records = append(view.Records, pending)
+	}
+	view.Scanned = lineNumber - lr.start
+	if view.Next > 0 {
+		view.Scanned--
+	}
+
+	s.writeJSON(w, view)
+}
+
+// sniffLogLines 取开头的非空行用于识别格式，忽略可能不完整的最后一行
+func sniffLogLines(head []byte) []string {
+	if i := bytes.LastIndexByte(head, '\n'); i >= 0 && len(head) == encodingSniffLen {
+		head = head[:i]
+	}
+	var lines []string
+	for _, line := range strings.Split(string(head), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+		if len(lines) == logSniffLines {
+			break
+		}
+	}
+	return lines
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+		ok   bool
+	}{
+		{`level=info msg="hello world" n=1`, `map[level:info msg:hello world n:1]`, true},
+		{`a= b="x \"y\""`, `map[a: b:x "y"]`, true},
+		{`plain text line`, ``, false},
+		{`key=value trailing`, ``, false},
+		{`msg="unterminated`, ``, false},
+		{``, ``, false},
+	}
+	for _, tt := range tests {
+		fields, ok := parseLogfmt(tt.line)
+		if ok != tt.ok || (ok && fmt.Sprint(fields) != tt.want) {
+			t.Errorf("parseLogfmt(%q) = %v, %v; want %s, %v", tt.line, fields, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseLogRecords(t *testing.T) {
+	parsers, err := (&Server{config: &Config{}}).logParsers(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]*logParser)
+	for _, parser := range parsers {
+		byName[parser.name] = parser
+	}
+
+	tests := []struct {
+		format  string
+		line    string
+		level   string
+		message string
+		fields  string
+		time    string
+	}{
+		{"json", `{"ts":"2024-05-01T12:00:00Z","level":"WARN","msg":"slow","req":{"id":"a1","ms":120}}`, "warn", "slow", "map[req.id:a1 req.ms:120]", "2024-05-01T12:00:00Z"},
+		{"json", `{"time":1714564800000,"level":50,"message":"boom","ok":false}`, "error", "boom", "map[ok:false]", "2024-05-01T12:00:00Z"},
+		{"logfmt", `time=2024-05-01T12:00:00Z level=error msg="db down" host=a`, "error", "db down", "map[host:a]", "2024-05-01T12:00:00Z"},
+		{"text", `2024-05-01T12:00:00.5Z [ERROR] failed to connect`, "error", "failed to connect", "map[]", "2024-05-01T12:00:00.5Z"},
+		{"clf", `1.2.3.4 - bob [01/May/2024:12:00:00 +0000] "GET / HTTP/1.1" 500 12`, "", "", "map[bytes:12 remote:1.2.3.4 request:GET / HTTP/1.1 status:500 user:bob]", "2024-05-01T12:00:00Z"},
+	}
+	for _, tt := range tests {
+		record, ok := byName[tt.format].parse(tt.line)
+		if !ok {
+			t.Errorf("%s: %q not parsed", tt.format, tt.line)
+			continue
+		}
+		if record.Level != tt.level || record.Message != tt.message || fmt.Sprint(record.Fields) != tt.fields {
+			t.Errorf("%s: record = %+v", tt.format, record)
+		}
+		want, _ := time.Parse(time.RFC3339Nano, tt.time)
+		if record.Timestamp == nil || !record.Timestamp.Equal(want) {
+			t.Errorf("%s: timestamp = %v, want %v", tt.format, record.Timestamp, want)
+		}
+	}
+
+	// 自动识别格式
+	head := []string{`{"level":"info","msg":"a"}`, `{"level":"info","msg":"b"}`}
+	if parser, err := selectLogParser(parsers, LogFormatAuto, "app.log", head); err != nil || parser.name != LogFormatJSON {
+		t.Errorf("detected %v, %v", parser, err)
+	}
+	if _, err := selectLogParser(parsers, LogFormatAuto, "notes.txt", []string{"hello", "world"}); err == nil {
+		t.Error("plain text detected as log")
+	}
+}
+
+func TestLogView(t *testing.T) {
+	s, dir := newTestServer(t, func(config *Config) {
+		config.LogFormats = []LogFormatConfig{{
+			Name:       "custom",
+			Pattern:    `^(?P<level>[A-Z]) (?P<time>\d{8}-\d{6}) (?P<msg>.*)$`,
+			TimeLayout: "20060102-150405",
+			Files:      []string{"legacy-*.log"},
+		}}
+	})
+
+	var text strings.Builder
+	for i := 1; i <= 30; i++ {
+		level := "info"
+		if i%10 == 0 {
+			level = "error"
+		}
+		fmt.Fprintf(&text, `{"time":"2024-05-01T12:%02d:00Z","level":"%s","msg":"request %d","status":%d}`+"\n", i, level, i, 200+i%3)
+		if level == "error" {
+			text.WriteString("  at handler.go:42\n  at main.go:7\n")
+		}
+	}
+	writeTestFile(t, dir, "app.log", text.String())
+	writeTestFile(t, dir, "legacy-1.log", "I 20240501-120000 started\nE 20240501-120500 crashed\n")
+
+	view := func(query string) LogView {
+		t.Helper()
+		var view LogView
+		decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/app.log&log=auto&"+query, ""), 200, &view)
+		return view
+	}
+
+	// 按级别过滤，后续的堆栈行属于同一条记录
+	v := view("level=error")
+	if v.Format != LogFormatJSON || len(v.Records) != 3 || v.Next != 0 {
+		t.Fatalf("level=error: format=%s records=%d next=%d", v.Format, len(v.Records), v.Next)
+	}
+	if r := v.Records[1]; r.Message != "request 20" || r.Line != 22 || len(r.More) != 2 || r.Fields["status"] != "202" {
+		t.Errorf("error record = %+v", r)
+	}
+
+	// 分页：凑满一页后返回继续读取的行
+	v = view("level=warn%2B&count=2")
+	if len(v.Records) != 2 || v.Next != 25 || v.Scanned != 24 {
+		t.Fatalf("page 1: records=%d next=%d scanned=%d", len(v.Records), v.Next, v.Scanned)
+	}
+	v = view(fmt.Sprintf("level=warn%%2B&count=2&from=%d", v.Next))
+	if len(v.Records) != 1 || v.Records[0].Line != 34 || v.Next != 0 {
+		t.Errorf("page 2: records=%+v next=%d", v.Records, v.Next)
+	}
+
+	// 时间范围和字段
+	v = view("since=" + url.QueryEscape("2024-05-01T12:05:00Z") + "&until=" + url.QueryEscape("2024-05-01T12:10:00Z") + "&field=status:201")
+	if len(v.Records) != 1 || v.Records[0].Message != "request 7" {
+		t.Errorf("time and field filter = %+v", v.Records)
+	}
+	v = view("field=status~20")
+	if len(v.Records) != 30 {
+		t.Errorf("field contains filter = %d records", len(v.Records))
+	}
+	v = view("field=msg~request")
+	if len(v.Records) != 0 {
+		t.Errorf("msg is not a field: %d records", len(v.Records))
+	}
+	v = view("q=handler.go")
+	if len(v.Records) != 3 {
+		t.Errorf("q in stack lines = %d records", len(v.Records))
+	}
+
+	// 按文件名选择自定义格式
+	var legacy LogView
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/legacy-1.log&log=auto&level=error", ""), 200, &legacy)
+	if legacy.Format != "custom" || len(legacy.Records) != 1 || legacy.Records[0].Message != "crashed" {
+		t.Errorf("legacy = %+v", legacy)
+	}
+
+	// 很少命中时读取的行数达到上限后返回，从 next 继续
+	s.config.RootDirs = append(s.config.RootDirs, RootDirConfig{Name: "capped", Path: dir, View: &ViewConfig{LinesPerPage: 1, MaxLines: 1}})
+	writeTestFile(t, dir, "quiet.log", strings.Repeat("level=info msg=ok\n", 3*logScanFactor))
+	var capped LogView
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=1&path=/quiet.log&log=auto&level=fatal", ""), 200, &capped)
+	if len(capped.Records) != 0 || capped.Next != logScanFactor+1 || capped.Scanned != logScanFactor {
+		t.Errorf("capped: records=%d next=%d scanned=%d", len(capped.Records), capped.Next, capped.Scanned)
+	}
+
+	writeTestFile(t, dir, "notes.txt", "hello\nworld\n")
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/notes.txt&log=auto", ""), 422, nil)
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/app.log&log=nope", ""), 422, nil)
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/app.log&log=auto&since=yesterday", ""), 400, nil)
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/app.log&log=auto&field=status", ""), 400, nil)
+}
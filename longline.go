@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"unicode/utf8"
+
+	"golang.org/x/text/transform"
+)
+
+const (
+	// 分段读取超长行时每段的默认字节数
+	lineSliceDefaultLength = 64 * 1024
+	// 每段最多的字节数
+	lineSliceMaxLength = MaxLineLength
+)
+
+// LineSlice 超长行中的一段
+type LineSlice struct {
+	Line   int    `json:"line,omitempty"` // 行号（按行号读取时）
+	At     int64  `json:"at,omitempty"`   // 行首的字节偏移（按偏移读取时）
+	From   int64  `json:"from"`           // 本段在行中的起始字节（解码为 UTF-8 后）
+	Text   string `json:"text"`           // 本段的内容
+	Length int64  `json:"length"`         // 行的实际字节数（解码为 UTF-8 后，不含换行符）
+	More   bool   `json:"more"`           // 本段之后是否还有内容，继续读取时以 from+len(text) 作为 from
+}
+
+// handleLine 分段读取一行的内容，用于查看被截断的超长行
+// 通过 line 指定行号，或者通过 at 指定行首的字节偏移（按偏移查看大文件时）
+func (s *Server) handleLine(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		s.handleError(w, fmt.Errorf("path parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	line, err := parseInt64Param(r, "line", 0)
+	if err != nil || line < 0 {
+		s.handleError(w, fmt.Errorf("invalid line"), http.StatusBadRequest)
+		return
+	}
+	at, err := parseInt64Param(r, "at", -1)
+	if err != nil || (line == 0) == (at < 0) {
+		s.handleError(w, fmt.Errorf("exactly one of line and at is required"), http.StatusBadRequest)
+		return
+	}
+	from, err := parseInt64Param(r, "from", 0)
+	if err != nil || from < 0 {
+		s.handleError(w, fmt.Errorf("invalid from"), http.StatusBadRequest)
+		return
+	}
+	length, err := parseInt64Param(r, "length", lineSliceDefaultLength)
+	if err != nil || length <= 0 {
+		s.handleError(w, fmt.Errorf("invalid length"), http.StatusBadRequest)
+		return
+	}
+	length = min(length, lineSliceMaxLength)
+
+	rootIndex := getRootIndex(r)
+
+	// 构建完整路径
+	fullPath := s.getFullPath(path, rootIndex)
+
+	// 检查路径是否在根目录内
+	if !s.isPathSafe(fullPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	// 打开文件（支持归档内的文件）
+	file, info, err := s.openFile(rootIndex, fullPath)
+	if err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+	defer file.Close()
+
+	// 压缩文件解压后读取，只能按行号定位
+	var reader io.Reader = file
+	kind := compressionKind(info.Name())
+	if kind != "" {
+		if at >= 0 {
+			s.handleError(w, fmt.Errorf("byte offsets are not supported for compressed files"), http.StatusBadRequest)
+			return
+		}
+		rc, err := newDecompressor(kind, file)
+		if err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		defer rc.Close()
+		reader = rc
+	}
+
+	reader, te, err := s.decodeText(rootIndex, reader, r.URL.Query().Get("encoding"))
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// 按偏移定位时从行首开始解码，开头的 BOM 已由 decodeText 跳过
+	if at > 0 {
+		if te.isUTF16() {
+			s.handleError(w, fmt.Errorf("byte offsets are not supported for UTF-16 files"), http.StatusBadRequest)
+			return
+		}
+		if at >= info.Size() {
+			s.handleError(w, fmt.Errorf("offset out of range"), http.StatusBadRequest)
+			return
+		}
+		if _, err := file.Seek(at, io.SeekStart); err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		reader = file
+		if te.enc != nil {
+			reader = transform.NewReader(file, te.enc.NewDecoder())
+		}
+	}
+
+	scanner := NewLineScanner(reader)
+	if line > 1 && int64(scanner.Skip(int(line-1))) < line-1 {
+		s.handleError(w, fmt.Errorf("line %d not found", line), http.StatusNotFound)
+		return
+	}
+	if !scanner.ScanSlice(from, int(length)) {
+		if err := scanner.Err(); err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		s.handleError(w, fmt.Errorf("line %d not found", line), http.StatusNotFound)
+		return
+	}
+
+	// 段的开头落在字符中间时从下一个字符开始
+	text := scanner.Bytes()
+	for len(text) > 0 && from > 0 && !utf8.RuneStart(text[0]) {
+		text = text[1:]
+		from++
+	}
+
+	slice := LineSlice{
+		Line:   int(line),
+		From:   from,
+		Text:   string(text),
+		Length: scanner.Len(),
+		More:   scanner.Truncated(),
+	}
+	if at >= 0 {
+		slice.At = at
+	}
+	s.writeJSON(w, slice)
+}
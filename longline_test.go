@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLineScannerLongLines(t *testing.T) {
+	long := strings.Repeat("x", MaxLineLength) + "中文"
+	scanner := NewLineScanner(strings.NewReader("short\r\n" + long + "\r\nlast"))
+
+	var lines []string
+	var lengths []int64
+	var truncated []bool
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		lengths = append(lengths, scanner.Len())
+		truncated = append(truncated, scanner.Truncated())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 3 || lines[0] != "short" || lines[2] != "last" {
+		t.Fatalf("lines = %d, first %q", len(lines), lines[0])
+	}
+	if len(lines[1]) != MaxLineLength || !truncated[1] || lengths[1] != int64(len(long)) {
+		t.Errorf("long line kept %d bytes, length %d, truncated %v", len(lines[1]), lengths[1], truncated[1])
+	}
+	if truncated[0] || truncated[2] || lengths[0] != 5 || lengths[2] != 4 {
+		t.Errorf("short lines = %v %v", lengths, truncated)
+	}
+
+	// 截断处落在多字节字符中间时不保留不完整的字符
+	scanner = NewLineScanner(strings.NewReader(strings.Repeat("x", MaxLineLength-1) + "中\n"))
+	if !scanner.Scan() || len(scanner.Bytes()) != MaxLineLength-1 || !scanner.Truncated() {
+		t.Errorf("partial rune kept %d bytes", len(scanner.Bytes()))
+	}
+}
+
+func TestScanSlice(t *testing.T) {
+	tests := []struct {
+		text      string
+		from      int64
+		limit     int
+		want      string
+		truncated bool
+	}{
+		{"abcdef\n", 0, 3, "abc", true},
+		{"abcdef\n", 2, 3, "cde", true},
+		{"abcdef\n", 3, 10, "def", false},
+		{"abcdef\r\n", 4, 2, "ef", false},
+		{"abcdef", 10, 2, "", false},
+	}
+	for _, tt := range tests {
+		scanner := NewLineScanner(strings.NewReader(tt.text))
+		if !scanner.ScanSlice(tt.from, tt.limit) || scanner.Text() != tt.want || scanner.Truncated() != tt.truncated || scanner.Len() != 6 {
+			t.Errorf("ScanSlice(%q, %d, %d) = %q, truncated %v, len %d", tt.text, tt.from, tt.limit, scanner.Text(), scanner.Truncated(), scanner.Len())
+		}
+	}
+}
+
+func TestViewLongLines(t *testing.T) {
+	s, dir := newTestServer(t, func(config *Config) {
+		config.View = ViewConfig{MaxFileSize: 1024}
+	})
+	long := strings.Repeat("0123456789", MaxLineLength/10+100)
+	writeTestFile(t, dir, "min.js", "first\n"+long+"\nlast\n")
+
+	var content FileContent
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/min.js", ""), 200, &content)
+	if len(content.Lines) != 3 || content.Lines[2] != "last" || !strings.HasSuffix(content.Lines[1], TruncatedMarker) {
+		t.Fatalf("lines = %d", len(content.Lines))
+	}
+	if len(content.LongLines) != 1 || content.LongLines[0] != (LongLine{Index: 1, Line: 2, Length: int64(len(long))}) {
+		t.Errorf("longLines = %+v", content.LongLines)
+	}
+
+	// 按字节偏移读取时同样截断
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/min.js&offset=0", ""), 200, &content)
+	if len(content.LongLines) != 1 || content.LongLines[0] != (LongLine{Index: 1, Line: 2, At: 6, Length: int64(len(long))}) {
+		t.Errorf("offset longLines = %+v", content.LongLines)
+	}
+
+	// 搜索不会因为超长行而失败
+	var results []SearchResult
+	decodeResponse(t, doRequest(s.handleSearch, "GET", "/api/search?root=0&path=/min.js&q=last", ""), 200, &results)
+	if len(results) != 1 || results[0].LineNumber != 3 {
+		t.Errorf("search = %+v", results)
+	}
+
+	tests := []struct {
+		query    string
+		wantFrom int64
+		wantText string
+		wantMore bool
+	}{
+		{"line=2&length=5", 0, "01234", true},
+		{"line=2&from=13&length=4", 13, "3456", true},
+		{"at=6&from=13&length=4", 13, "3456", true},
+		{fmt.Sprintf("line=2&from=%d", len(long)-3), int64(len(long) - 3), "789", false},
+		{"line=1", 0, "first", false},
+	}
+	for _, tt := range tests {
+		var slice LineSlice
+		decodeResponse(t, doRequest(s.handleLine, "GET", "/api/line?root=0&path=/min.js&"+tt.query, ""), 200, &slice)
+		if slice.From != tt.wantFrom || slice.Text != tt.wantText || slice.More != tt.wantMore {
+			t.Errorf("%s: slice = from %d %q more %v", tt.query, slice.From, slice.Text, slice.More)
+		}
+		if tt.query != "line=1" && slice.Length != int64(len(long)) {
+			t.Errorf("%s: length = %d", tt.query, slice.Length)
+		}
+	}
+
+	decodeResponse(t, doRequest(s.handleLine, "GET", "/api/line?root=0&path=/min.js&line=10", ""), 404, nil)
+	decodeResponse(t, doRequest(s.handleLine, "GET", "/api/line?root=0&path=/min.js", ""), 400, nil)
+	decodeResponse(t, doRequest(s.handleLine, "GET", "/api/line?root=0&path=/min.js&line=1&at=0", ""), 400, nil)
+}
+
+func TestLineSliceRuneBoundary(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	writeTestFile(t, dir, "a.txt", "中文字符\n")
+
+	var slice LineSlice
+	decodeResponse(t, doRequest(s.handleLine, "GET", "/api/line?root=0&path=/a.txt&line=1&from=1&length=5", ""), 200, &slice)
+	if slice.From != 3 || slice.Text != "文" || !slice.More || slice.Length != 12 {
+		t.Errorf("slice = %+v", slice)
+	}
+}
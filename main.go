@@ -1,24 +1,36 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
+	pathpkg "path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	// 每次读取的行数，用于大文件分页
+	// 每次读取的行数，用于大文件分页（默认值，可通过 view.linesPerPage 配置）
 	LinesPerPage = 1000
-	// 最大文件大小限制（10MB），超过则使用流式读取
+	// 最大文件大小限制（10MB），超过则使用流式读取（默认值，可通过 view.maxFileSize 配置）
 	MaxFileSize = 10 * 1024 * 1024
+	// 按行范围读取时单次最多返回的行数（默认值，可通过 view.maxLines 配置）
+	MaxLinesPerRequest = 10000
+	// 最多缓存的内容摘要
+	maxDigests = 1024
+	// 修改时间早于该时长的文件才缓存摘要，之后的修改必然改变只精确到秒的修改时间
+	digestSettle = 2 * time.Second
 )
 
 // Config 配置结构
@@ -26,6 +38,18 @@ type Config struct {
 	RootDirs   []RootDirConfig   `json:"rootDirs"`
 	Port       int               `json:"port"`
 	StaticDirs []StaticDirConfig `json:"staticDirs"`
+	Extract    ExtractConfig     `json:"extract"` // 服务端解压限制
+	Fetch      FetchConfig       `json:"fetch"`   // 从 URL 下载到服务器
+	View       ViewConfig        `json:"view"`    // 查看文件时的分页设置
+
+	LogFormats []LogFormatConfig `json:"logFormats,omitempty"` // 自定义的文本日志格式
+}
+
+// ViewConfig 查看文件时的分页设置，未配置的项使用默认值
+type ViewConfig struct {
+	LinesPerPage int   `json:"linesPerPage,omitempty"` // 大文件每页的行数
+	MaxFileSize  int64 `json:"maxFileSize,omitempty"`  // 超过该大小的文件分页读取，否则一次性读取
+	MaxLines     int   `json:"maxLines,omitempty"`     // 按行范围读取时单次最多返回的行数
 }
 
 // StaticDirConfig 静态目录配置
@@ -36,8 +60,31 @@ type StaticDirConfig struct {
 
 // RootDirConfig 根目录配置
 type RootDirConfig struct {
-	Name string `json:"name"` // 显示名称
-	Path string `json:"path"` // 实际路径
+	Name string      `json:"name"`           // 显示名称
+	Path string      `json:"path"`           // 实际路径（SFTP 类型为远程路径）
+	Type string      `json:"type,omitempty"` // 类型：local（默认）或 sftp
+	SFTP *SFTPConfig `json:"sftp,omitempty"` // SFTP 连接配置
+
+	Upload   *UploadPolicy  `json:"upload,omitempty"`   // 上传和保存的大小、配额及类型限制
+	History  *HistoryConfig `json:"history,omitempty"`  // 保存和上传覆盖前保留历史版本
+	Staging  string         `json:"staging,omitempty"`  // 上传暂存目录，相对路径相对于根目录，默认 ".uploads"
+	Encoding string         `json:"encoding,omitempty"` // 内容不是 UTF-8 时使用的默认编码，默认 gb18030
+	View     *ViewConfig    `json:"view,omitempty"`     // 覆盖全局的分页设置
+
+	LogFormats []LogFormatConfig `json:"logFormats,omitempty"` // 根目录专用的文本日志格式，优先于全局配置
+	Schemas    []SchemaConfig    `json:"schemas,omitempty"`    // 按路径模式校验保存的内容是否符合 JSON Schema
+}
+
+// RootInfo 根目录列表响应（不包含连接凭据）
+type RootInfo struct {
+	Name    string        `json:"name"`
+	Path    string        `json:"path"`
+	Type    string        `json:"type"`
+	Upload  *UploadPolicy `json:"upload,omitempty"`  // 写入限制，供界面在上传前预先检查
+	Used    *int64        `json:"used,omitempty"`    // 已用空间（仅配置了配额时提供）
+	History bool          `json:"history,omitempty"` // 是否保留历史版本
+
+	LogFormats []string `json:"logFormats,omitempty"` // 可用的日志格式名称（不含自动识别）
 }
 
 // FileItem 文件项信息
@@ -48,18 +95,31 @@ type FileItem struct {
 	Size      int64     `json:"size"`
 	ModTime   time.Time `json:"modTime"`
 	Extension string    `json:"extension,omitempty"`
+	IsArchive bool      `json:"isArchive,omitempty"` // 是否为可浏览的归档文件
 }
 
 // FileContent 文件内容响应
 type FileContent struct {
-	Path       string   `json:"path"`
-	Name       string   `json:"name"`
-	Size       int64    `json:"size"`
-	IsPartial  bool     `json:"isPartial"`  // 是否为部分内容
-	TotalLines int      `json:"totalLines"` // 总行数
-	Lines      []string `json:"lines"`      // 内容行
-	Page       int      `json:"page"`       // 当前页码
-	TotalPages int      `json:"totalPages"` // 总页数
+	Path        string     `json:"path"`
+	Name        string     `json:"name"`
+	Size        int64      `json:"size"`
+	IsPartial   bool       `json:"isPartial"`             // 是否为部分内容
+	TotalLines  int        `json:"totalLines"`            // 总行数
+	Lines       []string   `json:"lines"`                 // 内容行
+	Page        int        `json:"page"`                  // 当前页码
+	From        int        `json:"from,omitempty"`        // 第一行的行号（从 1 开始）
+	LongLines   []LongLine `json:"longLines,omitempty"`   // 被截断的超长行，完整内容通过 /api/line 分段获取
+	TotalPages  int        `json:"totalPages"`            // 总页数
+	Compression string     `json:"compression,omitempty"` // 压缩格式（内容已解压）
+	Version     string     `json:"version,omitempty"`     // 文件版本，保存时用于检测并发修改
+	Encoding    string     `json:"encoding,omitempty"`    // 文件的字符编码（内容已解码为 UTF-8）
+	BOM         bool       `json:"bom,omitempty"`         // 文件是否以 BOM 开头
+
+	LineEnding       string `json:"lineEnding,omitempty"`       // 换行符：lf、crlf 或 cr（内容已去掉换行符），没有换行符时为空
+	MixedLineEndings bool   `json:"mixedLineEndings,omitempty"` // 是否混用了多种换行符（大文件只检查开头）
+	FinalNewline     *bool  `json:"finalNewline,omitempty"`     // 文件是否以换行符结尾，此时最后一行之后没有空行（压缩文件不提供）
+
+	Window *ByteWindow `json:"window,omitempty"` // 按字节偏移读取时的范围，此时 totalLines 为 -1 表示总行数仍在统计
 }
 
 // SearchResult 搜索结果
@@ -71,8 +131,21 @@ type SearchResult struct {
 
 // SaveRequest 保存文件请求
 type SaveRequest struct {
-	Path    string `json:"path"`
-	Content string `json:"content"`
+	Path     string `json:"path"`
+	Content  string `json:"content"`
+	Version  string `json:"version,omitempty"`  // 打开文件时得到的版本，也可以通过 If-Match 请求头提供
+	Backup   bool   `json:"backup,omitempty"`   // 是否将原内容保留为同目录下的 .bak 文件
+	Encoding string `json:"encoding,omitempty"` // 转换为指定的编码保存，默认保持原文件的编码
+
+	LineEnding   string `json:"lineEnding,omitempty"`   // 转换为指定的换行符（lf、crlf、cr）保存，默认保持原文件的换行符
+	FinalNewline *bool  `json:"finalNewline,omitempty"` // 指定时在末尾补上或去掉换行符，默认按内容原样保存
+}
+
+// SaveConflict 保存时文件已被修改的响应
+type SaveConflict struct {
+	Error   string `json:"error"`
+	Version string `json:"version"`        // 文件当前的版本，携带该版本重新保存即可覆盖
+	Diff    string `json:"diff,omitempty"` // 当前文件与将要保存的内容之间的差异（文件过大时省略）
 }
 
 // CreateRequest 创建文件请求
@@ -81,15 +154,84 @@ type CreateRequest struct {
 	Name string `json:"name"`
 }
 
+// UploadResult 单个上传文件的结果
+type UploadResult struct {
+	Path    string `json:"path"`              // 文件的相对路径
+	Success bool   `json:"success"`           // 是否上传成功
+	Skipped bool   `json:"skipped,omitempty"` // 文件已存在而跳过
+	SavedAs string `json:"savedAs,omitempty"` // 因重名而改用的相对路径
+	Error   string `json:"error,omitempty"`   // 失败原因
+
+	Errors []*ValidationError `json:"errors,omitempty"` // 内容不符合 JSON Schema 的位置
+}
+
 // Server 文件浏览服务器
 type Server struct {
-	config *Config
+	config   *Config
+	backends []FileSystem     // 与 RootDirs 一一对应的文件系统后端
+	archives *archiveCache    // 归档索引缓存
+	cursors  *lineCursorCache // 压缩文件的行数和游标缓存
+	jobs     *JobManager      // 后台任务
+	uploads  *uploadRegistry  // 可续传上传
+	usage    *usageTracker    // 各根目录的已用空间
+	saveMu   sync.Mutex       // 串行化文件保存
+	schemas  [][]*rootSchema  // 与 RootDirs 一一对应的 Schema 映射
+
+	lineCounts *lineCountCache // 大文件的行数缓存
+	digests    *digestCache    // 文件版本的内容摘要缓存
 }
 
 // NewServer 创建新的服务器实例
 func NewServer(config *Config) *Server {
+	backends := make([]FileSystem, len(config.RootDirs))
+	schemas := make([][]*rootSchema, len(config.RootDirs))
+
+	for _, format := range config.LogFormats {
+		if _, err := newTextLogParser(format); err != nil {
+			log.Fatalf("Invalid log format: %v", err)
+		}
+	}
+
 	// 验证所有根目录
 	for i, rootDir := range config.RootDirs {
+		if rootDir.Type == "" {
+			config.RootDirs[i].Type = RootTypeLocal
+		}
+
+		if rootDir.Encoding != "" {
+			if _, err := lookupEncoding(rootDir.Encoding); err != nil {
+				log.Fatalf("Invalid encoding for %s: %v", rootDir.Name, err)
+			}
+		}
+
+		for _, format := range rootDir.LogFormats {
+			if _, err := newTextLogParser(format); err != nil {
+				log.Fatalf("Invalid log format for %s: %v", rootDir.Name, err)
+			}
+		}
+
+		rootSchemas, err := loadRootSchemas(rootDir.Schemas)
+		if err != nil {
+			log.Fatalf("Invalid schema for %s: %v", rootDir.Name, err)
+		}
+		schemas[i] = rootSchemas
+
+		switch config.RootDirs[i].Type {
+		case RootTypeLocal:
+			backends[i] = localFS{}
+		case RootTypeSFTP:
+			backend, err := newSFTPFS(rootDir.SFTP)
+			if err != nil {
+				log.Fatalf("Invalid sftp root %s: %v", rootDir.Name, err)
+			}
+			backends[i] = backend
+			// 远程路径统一使用 / 分隔的绝对路径
+			config.RootDirs[i].Path = pathpkg.Clean("/" + rootDir.Path)
+			continue
+		default:
+			log.Fatalf("Unknown root type for %s: %s", rootDir.Name, rootDir.Type)
+		}
+
 		// 确保根目录是绝对路径
 		absPath, err := filepath.Abs(rootDir.Path)
 		if err != nil {
@@ -103,11 +245,29 @@ func NewServer(config *Config) *Server {
 		}
 	}
 
-	return &Server{config: config}
+	s := &Server{
+		config:   config,
+		backends: backends,
+		schemas:  schemas,
+		archives: newArchiveCache(),
+		cursors:  newLineCursorCache(),
+		jobs:     NewJobManager(),
+		usage:    newUsageTracker(),
+
+		lineCounts: newLineCountCache(),
+		digests:    newDigestCache(),
+	}
+	s.uploads = newUploadRegistry(func(upload *resumableUpload) {
+		s.reserve(upload.rootIndex, -upload.length)
+	})
+	return s
 }
 
 // Start 启动服务器
 func (s *Server) Start() error {
+	// 清理上次运行遗留的上传临时文件
+	go s.sweepOrphanedParts(time.Now())
+
 	// 静态文件服务 - 支持多个静态目录
 	for _, staticDir := range s.config.StaticDirs {
 		// 确保路径是绝对路径
@@ -138,18 +298,42 @@ func (s *Server) Start() error {
 	http.HandleFunc("/api/search", s.handleSearch)
 	http.HandleFunc("/api/list", s.handleList)
 	http.HandleFunc("/api/view", s.handleView)
+	http.HandleFunc("/api/lineCount", s.handleLineCount)
+	http.HandleFunc("/api/line", s.handleLine)
+	http.HandleFunc("/api/table", s.handleTable)
+	http.HandleFunc("/api/json", s.handleJSON)
+	http.HandleFunc("/api/structured", s.handleStructured)
+	http.HandleFunc("/api/validate", s.handleValidate)
+	http.HandleFunc("/api/hex", s.handleHex)
+	http.HandleFunc("/api/hexSearch", s.handleHexSearch)
 	http.HandleFunc("/api/download", s.handleDownload)
 	http.HandleFunc("/api/save", s.handleSave)
+	http.HandleFunc("/api/patch", s.handlePatch)
 	http.HandleFunc("/api/delete", s.handleDelete)
 	http.HandleFunc("/api/create", s.handleCreate)
 	http.HandleFunc("/api/createDir", s.handleCreateDir)
 	http.HandleFunc("/api/upload", s.handleUpload)
+	http.HandleFunc("/api/uploads", s.handleResumableUploads)
+	http.HandleFunc("/api/uploads/", s.handleResumableUpload)
+	http.HandleFunc("/api/extract", s.handleExtract)
+	http.HandleFunc("/api/pack", s.handlePack)
+	http.HandleFunc("/api/fetch", s.handleFetch)
+	http.HandleFunc("/api/jobs", s.handleJobs)
+	http.HandleFunc("/api/cancelJob", s.handleCancelJob)
+	http.HandleFunc("/api/history", s.handleHistory)
+	http.HandleFunc("/api/historyView", s.handleHistoryView)
+	http.HandleFunc("/api/historyDiff", s.handleHistoryDiff)
+	http.HandleFunc("/api/historyRestore", s.handleHistoryRestore)
 	http.HandleFunc("/", s.handleIndex)
 
 	addr := fmt.Sprintf(":%d", s.config.Port)
 	log.Printf("Starting file browser on http://localhost%s", addr)
 	log.Printf("Root directories: %d", len(s.config.RootDirs))
 	for _, root := range s.config.RootDirs {
+		if root.Type == RootTypeSFTP {
+			log.Printf("  - %s: sftp://%s@%s%s", root.Name, root.SFTP.User, root.SFTP.Host, root.Path)
+			continue
+		}
 		log.Printf("  - %s: %s", root.Name, root.Path)
 	}
 	log.Printf("Static directories: %d", len(s.config.StaticDirs))
@@ -205,20 +389,18 @@ func (s *Server) handleViewRedirect(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 检查文件是否存在
-	info, err := os.Stat(fullPath)
+	file, _, err := s.openFile(rootIndex, fullPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			s.handleError(w, fmt.Errorf("file not found"), http.StatusNotFound)
+		} else if err == errIsDirectory {
+			s.handleError(w, fmt.Errorf("path is a directory, not a file"), http.StatusBadRequest)
 		} else {
 			s.handleError(w, err, http.StatusInternalServerError)
 		}
 		return
 	}
-
-	if info.IsDir() {
-		s.handleError(w, fmt.Errorf("path is a directory, not a file"), http.StatusBadRequest)
-		return
-	}
+	file.Close()
 
 	// 直接返回 HTML 页面，其中包含 JavaScript 自动加载文件
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -261,8 +443,14 @@ func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 归档内的虚拟目录
+	if archivePath, dir, ok := splitArchivePath(fullPath); ok {
+		s.listArchive(w, rootIndex, archivePath, dir)
+		return
+	}
+
 	// 读取目录内容
-	entries, err := os.ReadDir(fullPath)
+	entries, err := s.fs(rootIndex).ReadDir(fullPath)
 	if err != nil {
 		s.handleError(w, err, http.StatusInternalServerError)
 		return
@@ -270,24 +458,59 @@ func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
 
 	// 构建文件列表
 	var items []FileItem
-	for _, entry := range entries {
-		info, err := entry.Info()
-		if err != nil {
+	for _, info := range entries {
+		// 隐藏根目录内的历史版本目录和上传暂存目录
+		if s.isInternalPath(s.joinPath(rootIndex, fullPath, info.Name()), rootIndex) {
 			continue
 		}
 
-		relPath, _ := filepath.Rel(s.config.RootDirs[rootIndex].Path, filepath.Join(fullPath, entry.Name()))
-
 		item := FileItem{
-			Name:    entry.Name(),
-			Path:    "/" + filepath.ToSlash(relPath),
-			IsDir:   entry.IsDir(),
+			Name:    info.Name(),
+			Path:    s.relPath(s.joinPath(rootIndex, fullPath, info.Name()), rootIndex),
+			IsDir:   info.IsDir(),
 			Size:    info.Size(),
 			ModTime: info.ModTime(),
 		}
 
-		if !entry.IsDir() {
-			item.Extension = strings.TrimPrefix(filepath.Ext(entry.Name()), ".")
+		if !info.IsDir() {
+			item.Extension = strings.TrimPrefix(filepath.Ext(info.Name()), ".")
+			item.IsArchive = !s.isRemote(rootIndex) && archiveKind(info.Name()) != ""
+		}
+
+		items = append(items, item)
+	}
+
+	s.writeJSON(w, items)
+}
+
+// listArchive 列出归档内的目录
+func (s *Server) listArchive(w http.ResponseWriter, rootIndex int, archivePath, dir string) {
+	if s.isRemote(rootIndex) {
+		s.handleError(w, errRemoteUnsupported, http.StatusNotImplemented)
+		return
+	}
+
+	entries, err := s.archives.list(archivePath, dir)
+	if err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+
+	// 归档内条目的路径形如 /dist/build.zip!/logs/app.log
+	prefix := s.relPath(archivePath, rootIndex) + "!/"
+
+	var items []FileItem
+	for _, entry := range entries {
+		item := FileItem{
+			Name:    entry.Name(),
+			Path:    prefix + entry.name,
+			IsDir:   entry.isDir,
+			Size:    entry.size,
+			ModTime: entry.modTime,
+		}
+
+		if !entry.isDir {
+			item.Extension = strings.TrimPrefix(pathpkg.Ext(entry.name), ".")
 		}
 
 		items = append(items, item)
@@ -299,11 +522,6 @@ func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
 // handleView 处理文件内容查看请求
 func (s *Server) handleView(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	if page < 1 {
-		page = 1
-	}
-
 	if path == "" {
 		s.handleError(w, fmt.Errorf("path parameter is required"), http.StatusBadRequest)
 		return
@@ -311,6 +529,14 @@ func (s *Server) handleView(w http.ResponseWriter, r *http.Request) {
 
 	rootIndex := getRootIndex(r)
 
+	// 按页读取，或者通过 from/count 读取指定的行
+	config := s.viewConfig(rootIndex)
+	lr, err := parseLineRange(r, config)
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
 	// 构建完整路径
 	fullPath := s.getFullPath(path, rootIndex)
 
@@ -320,36 +546,81 @@ func (s *Server) handleView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 检查是否为文件
-	info, err := os.Stat(fullPath)
+	// 打开文件（支持归档内的文件）
+	file, info, err := s.openFile(rootIndex, fullPath)
 	if err != nil {
-		s.handleError(w, err, http.StatusNotFound)
+		s.handleError(w, err, errorStatus(err))
 		return
 	}
+	defer file.Close()
 
-	if info.IsDir() {
-		s.handleError(w, fmt.Errorf("path is a directory"), http.StatusBadRequest)
+	// 文件版本同时通过 ETag 提供，保存时以 If-Match 回传
+	version := s.fileVersion(rootIndex, fullPath, file, info)
+	w.Header().Set("ETag", `"`+version+`"`)
+
+	// 指定编码时按该编码解码，否则自动检测
+	encodingName := r.URL.Query().Get("encoding")
+	if encodingName != "" {
+		if _, err := lookupEncoding(encodingName); err != nil {
+			s.handleError(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 二进制文件不能按行查看，应改用十六进制视图；手动指定编码时按文本处理
+	if encodingName == "" && compressionKind(info.Name()) == "" {
+		binary, mimeType, err := sniffBinary(file)
+		if err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if binary {
+			s.binaryFile(w, mimeType)
+			return
+		}
+	}
+
+	// 按日志格式解析并过滤
+	if r.URL.Query().Get("log") != "" {
+		s.handleLogView(w, r, rootIndex, file, fullPath, info, version, lr, config, encodingName)
 		return
 	}
 
-	// 检查文件大小，决定读取方式
-	if info.Size() > MaxFileSize {
-		s.handleLargeFile(w, r, fullPath, info, page)
-	} else {
-		s.handleSmallFile(w, fullPath, info)
+	// 压缩文件解压后分页读取
+	if kind := compressionKind(info.Name()); kind != "" {
+		s.handleCompressedFile(w, rootIndex, file, fullPath, info, version, kind, lr, config, encodingName)
+		return
+	}
+
+	// 检查文件大小，决定读取方式；大文件指定 offset 时按字节偏移读取，指定行范围时只读取这些行
+	offset := r.URL.Query().Get("offset")
+	switch {
+	case info.Size() > config.MaxFileSize && offset != "":
+		s.handleOffsetView(w, rootIndex, file, fullPath, info, version, offset, r.URL.Query().Get("direction"), lr.count, config, encodingName)
+	case info.Size() > config.MaxFileSize || lr.explicit:
+		s.handleLargeFile(w, rootIndex, file, fullPath, info, version, lr, config, encodingName)
+	default:
+		s.handleSmallFile(w, rootIndex, file, fullPath, info, version, encodingName)
 	}
 }
 
 // handleSmallFile 处理小文件（一次性读取）
-func (s *Server) handleSmallFile(w http.ResponseWriter, fullPath string, info os.FileInfo) {
-	content, err := os.ReadFile(fullPath)
+func (s *Server) handleSmallFile(w http.ResponseWriter, rootIndex int, file File, fullPath string, info os.FileInfo, version string, encodingName string) {
+	reader, te, err := s.decodeText(rootIndex, file, encodingName)
 	if err != nil {
 		s.handleError(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	// 分割成行
-	lines := strings.Split(string(content), "\n")
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// 按原文件的换行符分行，行内不保留 \r
+	lineEnding, mixed := detectLineEnding(content)
+	lines, finalNewline := splitLines(string(content), lineEnding)
 
 	response := FileContent{
 		Path:       fullPath,
@@ -359,57 +630,56 @@ func (s *Server) handleSmallFile(w http.ResponseWriter, fullPath string, info os
 		TotalLines: len(lines),
 		Lines:      lines,
 		Page:       1,
+		From:       1,
 		TotalPages: 1,
+		Version:    version,
+		Encoding:   te.name,
+		BOM:        te.bom,
+
+		LineEnding:       lineEnding,
+		MixedLineEndings: mixed,
+		FinalNewline:     &finalNewline,
 	}
 
 	s.writeJSON(w, response)
 }
 
-// handleLargeFile 处理大文件（流式分页读取）
-func (s *Server) handleLargeFile(w http.ResponseWriter, r *http.Request, fullPath string, info os.FileInfo, page int) {
-	file, err := os.Open(fullPath)
+// handleLargeFile 处理大文件或指定了行范围的请求（流式读取）
+func (s *Server) handleLargeFile(w http.ResponseWriter, rootIndex int, file File, fullPath string, info os.FileInfo, version string, lr lineRange, config ViewConfig, encodingName string) {
+	reader, te, err := s.decodeText(rootIndex, file, encodingName)
 	if err != nil {
 		s.handleError(w, err, http.StatusInternalServerError)
 		return
 	}
-	defer file.Close()
+	reader, lineEnding, mixed := peekLineEnding(reader)
 
-	// 统计总行数（这个操作可能比较慢，可以缓存结果）
-	totalLines := s.countLines(file)
+	// 统计总行数（结果按文件大小和修改时间缓存），在已打开的文件上统计，不再另开文件
+	key := lineCountKey(rootIndex, fullPath, te)
+	totalLines, known := s.lineCounts.peek(key, info)
+	if !known {
+		if totalLines, err = s.countFileLines(rootIndex, file, te); err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		s.lineCounts.put(key, info, totalLines)
+	}
 
 	// 计算总页数
-	totalPages := (totalLines + LinesPerPage - 1) / LinesPerPage
-	if page > totalPages {
-		page = totalPages
-	}
-	if page < 1 {
-		page = 1
-	}
+	totalPages := (totalLines + config.LinesPerPage - 1) / config.LinesPerPage
+	lr = lr.clamp(totalLines, config.LinesPerPage)
 
-	// 定位到起始位置
-	_, err = file.Seek(0, io.SeekStart)
+	// 跳过前面的行，读取范围内的行
+	lines, long, _, err := ReadLines(NewLineScanner(reader), lr.start, lr.count)
 	if err != nil {
 		s.handleError(w, err, http.StatusInternalServerError)
 		return
 	}
-
-	// 跳过前面的行
-	startLine := (page - 1) * LinesPerPage
-	currentLine := 0
-	var lines []string
-
-	scanner := NewLineScanner(file)
-	for scanner.Scan() {
-		if currentLine >= startLine+LinesPerPage {
-			break
-		}
-		if currentLine >= startLine {
-			lines = append(lines, scanner.Text())
-		}
-		currentLine++
+	for i := range long {
+		long[i].Line = lr.start + long[i].Index + 1
 	}
 
-	if err := scanner.Err(); err != nil {
+	finalNewline, err := endsWithNewline(file, info.Size(), te)
+	if err != nil {
 		s.handleError(w, err, http.StatusInternalServerError)
 		return
 	}
@@ -421,22 +691,26 @@ func (s *Server) handleLargeFile(w http.ResponseWriter, r *http.Request, fullPat
 		IsPartial:  true,
 		TotalLines: totalLines,
 		Lines:      lines,
-		Page:       page,
+		Page:       lr.page(config.LinesPerPage),
+		From:       lr.start + 1,
 		TotalPages: totalPages,
+		LongLines:  long,
+		Version:    version,
+		Encoding:   te.name,
+		BOM:        te.bom,
+
+		LineEnding:       lineEnding,
+		MixedLineEndings: mixed,
+		FinalNewline:     &finalNewline,
 	}
 
 	s.writeJSON(w, response)
 }
 
 // countLines 统计文件行数
-func (s *Server) countLines(file *os.File) int {
-	_, err := file.Seek(0, io.SeekStart)
-	if err != nil {
-		return 0
-	}
-
+func (s *Server) countLines(r io.Reader) int {
 	count := 0
-	scanner := NewLineScanner(file)
+	scanner := NewLineScanner(r)
 	for scanner.Scan() {
 		count++
 	}
@@ -462,30 +736,19 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 检查是否为文件
-	info, err := os.Stat(fullPath)
-	if err != nil {
-		s.handleError(w, err, http.StatusNotFound)
-		return
-	}
-
-	if info.IsDir() {
-		s.handleError(w, fmt.Errorf("path is a directory"), http.StatusBadRequest)
-		return
-	}
-
-	// 读取文件内容
-	content, err := os.ReadFile(fullPath)
+	// 打开文件（支持归档内的文件）
+	file, info, err := s.openFile(rootIndex, fullPath)
 	if err != nil {
-		s.handleError(w, err, http.StatusInternalServerError)
+		s.handleError(w, err, errorStatus(err))
 		return
 	}
+	defer file.Close()
 
 	// 获取文件名
-	fileName := filepath.Base(fullPath)
+	fileName := info.Name()
 
 	// 根据 MIME 类型设置 Content-Type
-	mimeType := mime.TypeByExtension(filepath.Ext(fullPath))
+	mimeType := mime.TypeByExtension(filepath.Ext(fileName))
 	if mimeType == "" {
 		mimeType = "application/octet-stream"
 	}
@@ -496,7 +759,7 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
 
 	// 写入文件内容
-	w.Write(content)
+	io.Copy(w, file)
 }
 
 // getFullPath 获取完整路径
@@ -509,7 +772,120 @@ func (s *Server) getFullPath(path string, rootIndex int) string {
 
 	// 移除开头的 /
 	path = strings.TrimPrefix(path, "/")
-	return filepath.Join(s.config.RootDirs[rootIndex].Path, path)
+	return s.joinPath(rootIndex, s.config.RootDirs[rootIndex].Path, path)
+}
+
+// joinPath 按根目录类型拼接路径（远程根目录始终使用 / 分隔）
+func (s *Server) joinPath(rootIndex int, elem ...string) string {
+	if s.isRemote(rootIndex) {
+		return pathpkg.Join(elem...)
+	}
+	return filepath.Join(elem...)
+}
+
+// relPath 将完整路径转换为相对根目录、以 / 开头的路径
+func (s *Server) relPath(fullPath string, rootIndex int) string {
+	root := s.config.RootDirs[rootIndex].Path
+	if s.isRemote(rootIndex) {
+		return pathpkg.Clean("/" + strings.TrimPrefix(fullPath, root))
+	}
+
+	relPath, _ := filepath.Rel(root, fullPath)
+	return "/" + filepath.ToSlash(relPath)
+}
+
+// viewConfig 返回根目录的分页设置：根目录的配置优先，其次是全局配置，未配置时使用默认值
+func (s *Server) viewConfig(rootIndex int) ViewConfig {
+	config := ViewConfig{LinesPerPage: LinesPerPage, MaxFileSize: MaxFileSize, MaxLines: MaxLinesPerRequest}
+	overrides := []*ViewConfig{&s.config.View}
+	if rootIndex >= 0 && rootIndex < len(s.config.RootDirs) {
+		overrides = append(overrides, s.config.RootDirs[rootIndex].View)
+	}
+	for _, o := range overrides {
+		if o == nil {
+			continue
+		}
+		if o.LinesPerPage > 0 {
+			config.LinesPerPage = o.LinesPerPage
+		}
+		if o.MaxFileSize > 0 {
+			config.MaxFileSize = o.MaxFileSize
+		}
+		if o.MaxLines > 0 {
+			config.MaxLines = o.MaxLines
+		}
+	}
+	// 单次请求至少能读取一整页
+	config.MaxLines = max(config.MaxLines, config.LinesPerPage)
+	return config
+}
+
+// isRemote 判断根目录是否为远程后端
+func (s *Server) isRemote(rootIndex int) bool {
+	if rootIndex < 0 || rootIndex >= len(s.config.RootDirs) {
+		return false
+	}
+	return s.config.RootDirs[rootIndex].Type == RootTypeSFTP
+}
+
+// openFile 打开根目录下的文件，路径可以指向归档内的文件
+func (s *Server) openFile(rootIndex int, fullPath string) (File, os.FileInfo, error) {
+	if archivePath, name, ok := splitArchivePath(fullPath); ok {
+		if s.isRemote(rootIndex) {
+			return nil, nil, errRemoteUnsupported
+		}
+		return s.archives.open(archivePath, name)
+	}
+
+	fsys := s.fs(rootIndex)
+	info, err := fsys.Stat(fullPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		return nil, nil, errIsDirectory
+	}
+
+	file, err := fsys.Open(fullPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// errorStatus 根据打开文件时的错误确定响应状态码
+func errorStatus(err error) int {
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case os.IsPermission(err):
+		return http.StatusForbidden
+	case os.IsExist(err):
+		return http.StatusConflict
+	case err == errIsDirectory, err == errInvalidPath:
+		return http.StatusBadRequest
+	case err == errAccessDenied:
+		return http.StatusForbidden
+	case errors.Is(err, errTooLarge), errors.Is(err, errQuotaExceeded), errors.Is(err, multipart.ErrMessageTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	case err == errRemoteUnsupported:
+		return http.StatusNotImplemented
+	case errors.Is(err, errSFTPBusy):
+		return http.StatusServiceUnavailable
+	case errors.As(err, new(*schemaError)):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// fs 获取根目录对应的文件系统后端
+func (s *Server) fs(rootIndex int) FileSystem {
+	if rootIndex < 0 || rootIndex >= len(s.backends) {
+		rootIndex = 0
+	}
+	return s.backends[rootIndex]
 }
 
 // getRootIndex 从 URL 查询参数获取根目录索引
@@ -529,6 +905,13 @@ func (s *Server) isPathSafe(path string, rootIndex int) bool {
 		return false
 	}
 
+	// 远程根目录按 / 分隔的路径检查
+	if s.isRemote(rootIndex) {
+		root := s.config.RootDirs[rootIndex].Path
+		cleaned := pathpkg.Clean(path)
+		return cleaned == root || root == "/" || strings.HasPrefix(cleaned, root+"/")
+	}
+
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return false
@@ -545,7 +928,12 @@ func (s *Server) isPathSafe(path string, rootIndex int) bool {
 	}
 
 	// 检查相对路径是否以 .. 开头
-	return !strings.HasPrefix(relPath, "..")
+	if strings.HasPrefix(relPath, "..") {
+		return false
+	}
+
+	// 历史版本目录只能通过历史版本接口访问，上传暂存目录只由服务器使用
+	return !s.isInternalPath(absPath, rootIndex)
 }
 
 // writeJSON 写入 JSON 响应
@@ -593,20 +981,46 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 检查是否为文件
-	info, err := os.Stat(fullPath)
+	// 打开文件（支持归档内的文件）
+	file, info, err := s.openFile(rootIndex, fullPath)
 	if err != nil {
-		s.handleError(w, err, http.StatusNotFound)
+		s.handleError(w, err, errorStatus(err))
 		return
 	}
+	defer file.Close()
 
-	if info.IsDir() {
-		s.handleError(w, fmt.Errorf("path is a directory"), http.StatusBadRequest)
+	// 压缩文件解压后搜索
+	var reader io.Reader = file
+	if kind := compressionKind(info.Name()); kind != "" {
+		rc, err := newDecompressor(kind, file)
+		if err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		defer rc.Close()
+		reader = rc
+	}
+
+	// 二进制文件只能按字节搜索；手动指定编码时按文本处理
+	encodingName := r.URL.Query().Get("encoding")
+	if encodingName == "" {
+		var binary bool
+		var mimeType string
+		if reader, binary, mimeType = peekBinary(reader); binary {
+			s.binaryFile(w, mimeType)
+			return
+		}
+	}
+
+	// 按文件的编码解码后搜索
+	reader, _, err = s.decodeText(rootIndex, reader, encodingName)
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
 		return
 	}
 
 	// 搜索文件
-	results, err := s.searchFile(fullPath, query)
+	results, err := s.searchFile(reader, query, s.viewConfig(rootIndex).LinesPerPage)
 	if err != nil {
 		s.handleError(w, err, http.StatusInternalServerError)
 		return
@@ -616,13 +1030,7 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 }
 
 // searchFile 在文件中搜索文本
-func (s *Server) searchFile(filePath, query string) ([]SearchResult, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
+func (s *Server) searchFile(file io.Reader, query string, linesPerPage int) ([]SearchResult, error) {
 	var results []SearchResult
 	lineNumber := 0
 	scanner := NewLineScanner(file)
@@ -637,7 +1045,7 @@ func (s *Server) searchFile(filePath, query string) ([]SearchResult, error) {
 		// 简单的字符串包含搜索（不区分大小写）
 		if containsIgnoreCase(line, query) {
 			// 计算所在页码
-			page := (lineNumber + LinesPerPage - 1) / LinesPerPage
+			page := (lineNumber + linesPerPage - 1) / linesPerPage
 			if page < 1 {
 				page = 1
 			}
@@ -666,8 +1074,24 @@ func containsIgnoreCase(s, substr string) bool {
 func (s *Server) handleRoots(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	// 直接返回配置的根目录列表
-	if err := json.NewEncoder(w).Encode(s.config.RootDirs); err != nil {
+	// 返回配置的根目录列表（不包含连接凭据）
+	roots := make([]RootInfo, 0, len(s.config.RootDirs))
+	for i, root := range s.config.RootDirs {
+		info := RootInfo{Name: root.Name, Path: root.Path, Type: root.Type, Upload: root.Upload, History: s.historyDir(i) != ""}
+		if parsers, err := s.logParsers(i); err == nil {
+			for _, parser := range parsers {
+				info.LogFormats = append(info.LogFormats, parser.name)
+			}
+		}
+		if root.Upload != nil && root.Upload.Quota > 0 {
+			if used, err := s.diskUsage(i); err == nil {
+				info.Used = &used
+			}
+		}
+		roots = append(roots, info)
+	}
+
+	if err := json.NewEncoder(w).Encode(roots); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
@@ -696,8 +1120,12 @@ func (s *Server) handleSave(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 串行化保存，避免在版本检查和写入之间插入其他保存
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
 	// 检查文件是否存在
-	info, err := os.Stat(fullPath)
+	info, err := s.fs(rootIndex).Stat(fullPath)
 	if err != nil {
 		s.handleError(w, err, http.StatusNotFound)
 		return
@@ -709,17 +1137,274 @@ func (s *Server) handleSave(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 检查文件版本，文件在打开后被他人修改时拒绝保存，"*" 表示不检查
+	version := requestVersion(r, req.Version)
+	if version == "" {
+		s.handleError(w, fmt.Errorf("version is required, reload the file and try again"), http.StatusPreconditionRequired)
+		return
+	}
+	if version != "*" && version != s.pathVersion(rootIndex, fullPath, info) {
+		s.saveConflict(w, rootIndex, fullPath, info, req.Content)
+		return
+	}
+
+	// 压缩文件以解压后的内容展示，不能直接写回
+	if compressionKind(info.Name()) != "" {
+		s.handleError(w, fmt.Errorf("cannot save compressed file"), http.StatusBadRequest)
+		return
+	}
+
+	// 按原文件的编码和换行符保存，或转换为指定的编码和换行符
+	if err := checkLineEnding(req.LineEnding); err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+	original, lineEnding, err := s.fileTextFormat(rootIndex, fullPath)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	te, err := saveEncoding(original, req.Encoding)
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+	if req.LineEnding != "" {
+		lineEnding = req.LineEnding
+	}
+	text := convertLineEndings(req.Content, lineEnding)
+	if req.FinalNewline != nil {
+		text = setFinalNewline(text, lineEnding, *req.FinalNewline)
+	}
+
+	// 内容须符合文件对应的 JSON Schema
+	if err := s.validateContent(rootIndex, s.relPath(fullPath, rootIndex), convertLineEndings(text, LineEndingLF)); err != nil {
+		s.writeValidationError(w, err)
+		return
+	}
+	content, err := te.encode(text)
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// 检查大小和内容类型是否符合根目录的写入限制
+	if err := s.checkFileSize(rootIndex, int64(len(content))); err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+	if err := s.uploadPolicy(rootIndex).checkType(content[:min(len(content), sniffLen)]); err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+
+	// 按大小变化占用或释放配额，备份文件替换旧备份并保留原内容
+	delta := int64(len(content)) - info.Size()
+	if req.Backup {
+		delta += info.Size()
+		if bak, err := s.fs(rootIndex).Stat(fullPath + backupSuffix); err == nil && bak.Mode().IsRegular() {
+			delta -= bak.Size()
+		}
+	}
+	if err := s.reserve(rootIndex, delta); err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+
+	// 保留当前内容作为历史版本
+	if err := s.recordHistory(rootIndex, fullPath, info); err != nil {
+		s.reserve(rootIndex, -delta)
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
 	// 写入文件
-	if err := os.WriteFile(fullPath, []byte(req.Content), 0644); err != nil {
+	if err := s.fs(rootIndex).WriteFile(fullPath, content, req.Backup); err != nil {
+		s.reserve(rootIndex, -delta)
 		s.handleError(w, err, http.StatusInternalServerError)
 		return
 	}
 
+	// 返回保存后的新版本，供继续编辑时使用
+	response := map[string]interface{}{
+		"success":  true,
+		"message":  "文件保存成功",
+		"encoding": te.name,
+	}
+	if lineEnding != "" {
+		response["lineEnding"] = lineEnding
+	}
+	if info, err := s.fs(rootIndex).Stat(fullPath); err == nil {
+		version := s.pathVersion(rootIndex, fullPath, info)
+		response["version"] = version
+		w.Header().Set("ETag", `"`+version+`"`)
+	}
+
+	s.writeJSON(w, response)
+}
+
+// saveConflict 返回 409，附带文件当前的版本以及当前内容与将要保存的内容之间的差异
+func (s *Server) saveConflict(w http.ResponseWriter, rootIndex int, fullPath string, info os.FileInfo, content string) {
+	conflict := SaveConflict{
+		Error:   "file has been modified since it was opened",
+		Version: s.pathVersion(rootIndex, fullPath, info),
+	}
+
+	if maxSize := s.viewConfig(rootIndex).MaxFileSize; info.Size() <= maxSize && int64(len(content)) <= maxSize {
+		if file, err := s.fs(rootIndex).Open(fullPath); err == nil {
+			var current []byte
+			reader, _, err := s.decodeText(rootIndex, file, "")
+			if err == nil {
+				current, err = io.ReadAll(reader)
+			}
+			file.Close()
+			if err == nil {
+				// 忽略换行符风格的差异
+				conflict.Diff = unifiedDiff("current/"+info.Name(), "yours/"+info.Name(),
+					strings.Split(convertLineEndings(string(current), LineEndingLF), "\n"),
+					strings.Split(convertLineEndings(content, LineEndingLF), "\n"))
+			}
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": "文件保存成功",
-	})
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(conflict)
+}
+
+// fileVersion 根据大小和修改时间生成文件版本，不超过 maxFileSize 的文件附带内容摘要
+// 远程根目录的修改时间只精确到秒，同一秒内大小不变的两次修改仅凭修改时间无法区分
+// 摘要从已打开的 file 读取，读取后恢复文件原来的位置；读取失败时省略摘要，保存时按版本不一致处理。
+// 摘要按大小和修改时间缓存，翻页等只读请求不必每次重新读取整个文件
+func (s *Server) fileVersion(rootIndex int, fullPath string, file File, info os.FileInfo) string {
+	version := metaVersion(info)
+	if !s.needsDigest(rootIndex, info) {
+		return version
+	}
+	key := fmt.Sprintf("%d:%s", rootIndex, fullPath)
+	if digest, ok := s.digests.get(key, info); ok {
+		return version + "-" + digest
+	}
+	digest, err := fileDigest(file)
+	if err != nil {
+		return version
+	}
+	s.digests.put(key, info, digest)
+	return version + "-" + digest
+}
+
+// pathVersion 打开文件计算版本，调用方不能同时持有该根目录的其他文件（远程根目录可能只有一个连接）
+func (s *Server) pathVersion(rootIndex int, fullPath string, info os.FileInfo) string {
+	if !s.needsDigest(rootIndex, info) {
+		return metaVersion(info)
+	}
+	file, err := s.fs(rootIndex).Open(fullPath)
+	if err != nil {
+		return metaVersion(info)
+	}
+	defer file.Close()
+	return s.fileVersion(rootIndex, fullPath, file, info)
+}
+
+// metaVersion 只根据大小和修改时间生成的版本
+func metaVersion(info os.FileInfo) string {
+	return fmt.Sprintf("%x-%x", info.Size(), info.ModTime().UnixNano())
+}
+
+// needsDigest 判断文件版本是否需要附带内容摘要，超过 maxFileSize 的大文件只按大小和修改时间区分
+func (s *Server) needsDigest(rootIndex int, info os.FileInfo) bool {
+	return info.Mode().IsRegular() && info.Size() <= s.viewConfig(rootIndex).MaxFileSize
+}
+
+// fileDigest 计算文件内容 SHA-256 摘要的前 8 个字节，读取后恢复文件原来的位置
+func fileDigest(file File) (string, error) {
+	pos, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	if _, seekErr := file.Seek(pos, io.SeekStart); err == nil {
+		err = seekErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8]), nil
+}
+
+// cachedDigest 缓存的内容摘要
+type cachedDigest struct {
+	size     int64
+	modTime  time.Time
+	digest   string
+	lastUsed time.Time
+}
+
+// digestCache 内容摘要缓存，文件的大小或修改时间变化后重新计算
+type digestCache struct {
+	mu    sync.Mutex
+	files map[string]*cachedDigest
+}
+
+// newDigestCache 创建内容摘要缓存
+func newDigestCache() *digestCache {
+	return &digestCache{files: make(map[string]*cachedDigest)}
+}
+
+// get 获取大小和修改时间都未变化的文件的摘要
+func (c *digestCache) get(key string, info os.FileInfo) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cd, ok := c.files[key]
+	if !ok || cd.size != info.Size() || !cd.modTime.Equal(info.ModTime()) {
+		return "", false
+	}
+	cd.lastUsed = time.Now()
+	return cd.digest, true
+}
+
+// put 记录刚计算的摘要；修改时间距今不足 digestSettle 时不缓存，
+// 同一秒内稍后的修改可能不改变大小和修改时间，只能靠重新计算摘要区分
+func (c *digestCache) put(key string, info os.FileInfo, digest string) {
+	now := time.Now()
+	if now.Sub(info.ModTime()) < digestSettle {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.files[key] = &cachedDigest{size: info.Size(), modTime: info.ModTime(), digest: digest, lastUsed: now}
+	for len(c.files) > maxDigests {
+		oldest := ""
+		for key, cd := range c.files {
+			if oldest == "" || cd.lastUsed.Before(c.files[oldest].lastUsed) {
+				oldest = key
+			}
+		}
+		delete(c.files, oldest)
+	}
+}
+
+// requestVersion 获取请求期望的文件版本，请求体中没有提供时使用 If-Match 请求头
+func requestVersion(r *http.Request, version string) string {
+	if version != "" {
+		return version
+	}
+	return parseETag(r.Header.Get("If-Match"))
+}
+
+// parseETag 去掉 ETag 的引号和弱校验前缀
+func parseETag(etag string) string {
+	etag = strings.TrimSpace(etag)
+	etag = strings.TrimPrefix(etag, "W/")
+	return strings.Trim(etag, `"`)
 }
 
 // handleDelete 处理删除文件请求
@@ -737,6 +1422,12 @@ func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 
 	rootIndex := getRootIndex(r)
 
+	// 远程根目录不支持该操作
+	if s.isRemote(rootIndex) {
+		s.handleError(w, errRemoteUnsupported, http.StatusNotImplemented)
+		return
+	}
+
 	// 构建完整路径
 	fullPath := s.getFullPath(path, rootIndex)
 
@@ -764,6 +1455,7 @@ func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 		s.handleError(w, err, http.StatusInternalServerError)
 		return
 	}
+	s.reserve(rootIndex, -info.Size())
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -792,6 +1484,12 @@ func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
 
 	rootIndex := getRootIndex(r)
 
+	// 远程根目录不支持该操作
+	if s.isRemote(rootIndex) {
+		s.handleError(w, errRemoteUnsupported, http.StatusNotImplemented)
+		return
+	}
+
 	// 构建目录的完整路径
 	dirPath := s.getFullPath(req.Path, rootIndex)
 
@@ -810,6 +1508,12 @@ func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 检查扩展名是否被根目录允许
+	if err := s.uploadPolicy(rootIndex).checkName(req.Name); err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+
 	// 检查文件是否已存在
 	if _, err := os.Stat(fullPath); err == nil {
 		s.handleError(w, fmt.Errorf("file already exists"), http.StatusConflict)
@@ -849,6 +1553,12 @@ func (s *Server) handleCreateDir(w http.ResponseWriter, r *http.Request) {
 
 	rootIndex := getRootIndex(r)
 
+	// 远程根目录不支持该操作
+	if s.isRemote(rootIndex) {
+		s.handleError(w, errRemoteUnsupported, http.StatusNotImplemented)
+		return
+	}
+
 	// 构建父目录的完整路径
 	dirPath := s.getFullPath(req.Path, rootIndex)
 
@@ -893,72 +1603,386 @@ func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 根目录无效时直接拒绝，不能退回到第一个根目录暂存数据
 	rootIndex := getRootIndex(r)
+	if rootIndex < 0 || rootIndex >= len(s.config.RootDirs) {
+		s.handleError(w, fmt.Errorf("invalid root index: %d", rootIndex), http.StatusBadRequest)
+		return
+	}
 
-	// 解析表单，获取文件和路径
-	err := r.ParseMultipartForm(32 << 20) // 32MB 最大内存
+	// 远程根目录不支持该操作
+	if s.isRemote(rootIndex) {
+		s.handleError(w, errRemoteUnsupported, http.StatusNotImplemented)
+		return
+	}
+
+	// 目标目录须在文件之前提交，暂存任何文件前先检查它是否在根目录内
+	var path, dirPath string
+	checkPath := func(form *uploadForm) error {
+		path = form.value("path")
+		if path == "" {
+			path = "/"
+		}
+		dirPath = s.getFullPath(path, rootIndex)
+		if !s.isPathSafe(dirPath, rootIndex) {
+			return errAccessDenied
+		}
+		return nil
+	}
+
+	// 逐段读取表单，文件超过大小上限或配额时立即中止
+	form, err := s.readUploadForm(r, rootIndex, checkPath)
 	if err != nil {
-		s.handleError(w, err, http.StatusBadRequest)
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			// 表单格式错误
+			status = http.StatusBadRequest
+		}
+		s.handleError(w, err, status)
 		return
 	}
+	defer form.remove()
 
-	path := r.FormValue("path")
-	if path == "" {
-		path = "/"
+	if len(form.files) == 0 {
+		if err := checkPath(form); err != nil {
+			s.handleError(w, err, errorStatus(err))
+			return
+		}
+	} else if value := form.value("path"); value != "" && value != path {
+		// 文件之后才出现的 path 字段没有经过检查
+		s.discardUploadForm(form, rootIndex)
+		s.handleError(w, fmt.Errorf("path must precede files in the form"), http.StatusBadRequest)
+		return
 	}
 
-	// 构建目标目录的完整路径
-	dirPath := s.getFullPath(path, rootIndex)
+	// 获取上传的文件，relativePath 与 file 按顺序一一对应（上传文件夹时为文件在文件夹内的相对路径）
+	files := form.files
+	if len(files) == 0 {
+		s.handleError(w, fmt.Errorf("no file uploaded"), http.StatusBadRequest)
+		return
+	}
+	relativePaths := form.values["relativePath"]
 
-	// 检查路径是否在根目录内
-	if !s.isPathSafe(dirPath, rootIndex) {
-		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+	// 已存在文件的处理方式
+	overwrite := form.value("overwrite")
+	if overwrite == "" {
+		overwrite = OverwriteFail
+	}
+	if overwrite != OverwriteFail && overwrite != OverwriteSkip && overwrite != OverwriteReplace && overwrite != OverwriteRename {
+		s.discardUploadForm(form, rootIndex)
+		s.handleError(w, fmt.Errorf("invalid overwrite mode: %s", overwrite), http.StatusBadRequest)
 		return
 	}
 
-	// 获取上传的文件
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		s.handleError(w, err, http.StatusBadRequest)
+	// lastModified 为客户端文件的修改时间（毫秒时间戳），提供时保留到写入的文件上
+	modTimes := make([]time.Time, len(files))
+	for i, value := range form.values["lastModified"] {
+		if i >= len(files) || value == "" {
+			continue
+		}
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			s.discardUploadForm(form, rootIndex)
+			s.handleError(w, fmt.Errorf("invalid lastModified: %s", value), http.StatusBadRequest)
+			return
+		}
+		modTimes[i] = time.UnixMilli(ms)
+	}
+
+	results := make([]UploadResult, 0, len(files))
+	failed := 0
+	var lastErr error
+	for i, file := range files {
+		name := file.filename
+		if i < len(relativePaths) && relativePaths[i] != "" {
+			name = relativePaths[i]
+		}
+
+		result := UploadResult{Path: name, Success: true}
+		savedAs, err := s.saveUploadedFile(rootIndex, dirPath, name, file, overwrite, modTimes[i])
+		result.SavedAs = savedAs
+		if err != nil {
+			// 没有写入根目录的文件不占用配额
+			s.reserve(rootIndex, -file.size)
+		}
+		if err == errSkipped {
+			result.Skipped = true
+			err = nil
+		}
+		if err != nil {
+			// 错误信息中只保留相对路径，不暴露服务器上的完整路径
+			var pathErr *os.PathError
+			if errors.As(err, &pathErr) {
+				pathErr.Path = name
+			}
+			result.Success = false
+			result.Error = err.Error()
+			var schemaErr *schemaError
+			if errors.As(err, &schemaErr) {
+				result.Errors = schemaErr.errors
+			}
+			failed++
+			lastErr = err
+		}
+		results = append(results, result)
+	}
+
+	// 单个文件上传失败时直接返回错误状态
+	if len(files) == 1 && lastErr != nil {
+		if errors.As(lastErr, new(*schemaError)) {
+			s.writeValidationError(w, lastErr)
+			return
+		}
+		s.handleError(w, lastErr, errorStatus(lastErr))
 		return
 	}
-	defer file.Close()
+
+	message := "文件上传成功"
+	if len(files) > 1 {
+		message = fmt.Sprintf("上传完成：成功 %d 个，失败 %d 个", len(files)-failed, failed)
+	}
+
+	s.writeJSON(w, map[string]interface{}{
+		"success": failed == 0,
+		"message": message,
+		"results": results,
+	})
+}
+
+// saveUploadedFile 将上传的文件保存到 dirPath 下的相对路径 name，按需创建中间目录
+// 因重名而另存为其他名称时返回实际使用的相对路径
+func (s *Server) saveUploadedFile(rootIndex int, dirPath, name string, src *spooledFile, overwrite string, modTime time.Time) (string, error) {
+	segments, err := splitRelativePath(name)
+	if err != nil {
+		return "", err
+	}
+
+	// 检查扩展名和内容类型是否被根目录允许
+	policy := s.uploadPolicy(rootIndex)
+	if err := policy.checkName(segments[len(segments)-1]); err != nil {
+		return "", err
+	}
+	if err := policy.checkType(src.head); err != nil {
+		return "", err
+	}
+
+	// 逐级创建中间目录，每一级都检查是否仍在根目录内
+	parent := dirPath
+	for _, segment := range segments[:len(segments)-1] {
+		parent = filepath.Join(parent, segment)
+		if err := s.ensureUploadDir(rootIndex, parent); err != nil {
+			return "", err
+		}
+	}
 
 	// 构建目标文件的完整路径
-	fullPath := filepath.Join(dirPath, header.Filename)
+	fullPath := filepath.Join(parent, segments[len(segments)-1])
 
 	// 再次检查完整路径是否在根目录内
 	if !s.isPathSafe(fullPath, rootIndex) {
-		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
-		return
+		return "", os.ErrPermission
 	}
 
-	// 检查文件是否已存在
-	if _, err := os.Stat(fullPath); err == nil {
-		s.handleError(w, fmt.Errorf("file already exists"), http.StatusConflict)
-		return
+	file, err := src.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// 覆盖已有文件时与保存串行化，避免在保存的版本检查和写入之间替换文件
+	if overwrite == OverwriteReplace {
+		s.saveMu.Lock()
+		defer s.saveMu.Unlock()
+	}
+
+	info, err := os.Lstat(fullPath)
+	switch {
+	case os.IsNotExist(err):
+		// 目标不存在，直接创建
+	case err != nil:
+		return "", err
+	case overwrite == OverwriteRename:
+		// 改用其他名称，下面创建文件时处理
+	case info.IsDir():
+		return "", fmt.Errorf("a directory with the same name already exists")
+	case overwrite == OverwriteSkip:
+		return "", errSkipped
+	case overwrite == OverwriteReplace:
+		// 覆盖的内容须符合文件对应的 JSON Schema
+		if err := s.validateLocalFile(rootIndex, fullPath, src.tmpPath); err != nil {
+			return "", err
+		}
+		// 与保存一样替换符号链接指向的文件，保留该文件的权限
+		target, info, err := s.replaceTarget(rootIndex, fullPath, info)
+		if err != nil {
+			return "", err
+		}
+		if err := s.recordHistory(rootIndex, target, info); err != nil {
+			return "", err
+		}
+		if err := replaceFile(target, file, info.Mode().Perm(), modTime); err != nil {
+			return "", err
+		}
+		// 被替换的文件不再占用配额
+		s.reserve(rootIndex, -info.Size())
+		return "", nil
 	}
 
-	// 创建目标文件
-	dst, err := os.Create(fullPath)
+	// 创建目标文件，文件已存在时按覆盖方式失败或改用其他名称
+	dst, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	savedAs := ""
+	for n := 1; os.IsExist(err) && overwrite == OverwriteRename; n++ {
+		fullPath = numberedName(filepath.Join(parent, segments[len(segments)-1]), n)
+		savedAs = strings.Join(append(segments[:len(segments)-1:len(segments)-1], filepath.Base(fullPath)), "/")
+		dst, err = os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	}
 	if err != nil {
-		s.handleError(w, err, http.StatusInternalServerError)
-		return
+		return "", err
 	}
-	defer dst.Close()
 
 	// 复制文件内容
 	_, err = io.Copy(dst, file)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && !modTime.IsZero() {
+		err = os.Chtimes(fullPath, modTime, modTime)
+	}
 	if err != nil {
-		s.handleError(w, err, http.StatusInternalServerError)
-		return
+		os.Remove(fullPath)
+		return "", err
 	}
+	return savedAs, nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": "文件上传成功",
-	})
+// replaceTarget 获取覆盖时实际替换的文件：目标是符号链接时替换它指向的文件而不是链接本身
+// info 为 Lstat 得到的信息；链接指向根目录之外、受保护的目录或目录时拒绝
+func (s *Server) replaceTarget(rootIndex int, fullPath string, info os.FileInfo) (string, os.FileInfo, error) {
+	if info.Mode()&os.ModeSymlink == 0 {
+		return fullPath, info, nil
+	}
+
+	target, err := filepath.EvalSymlinks(fullPath)
+	if err != nil {
+		return "", nil, err
+	}
+	// 根目录本身可能经由符号链接，按解析后的根目录换算回根目录下的路径再检查
+	realRoot, err := filepath.EvalSymlinks(s.config.RootDirs[rootIndex].Path)
+	if err != nil {
+		return "", nil, err
+	}
+	rel, err := filepath.Rel(realRoot, target)
+	if err != nil || !isWithin(realRoot, target) || !s.isPathSafe(filepath.Join(s.config.RootDirs[rootIndex].Path, rel), rootIndex) {
+		return "", nil, os.ErrPermission
+	}
+
+	info, err = os.Stat(target)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.IsDir() {
+		return "", nil, errIsDirectory
+	}
+	return target, info, nil
+}
+
+// replaceFile 原子地替换文件：先写入同目录下的临时文件，再重命名覆盖目标，保留原文件权限
+func replaceFile(fullPath string, r io.Reader, perm os.FileMode, modTime time.Time) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), "."+filepath.Base(fullPath)+".*.upload")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, perm)
+	}
+	if err == nil && !modTime.IsZero() {
+		err = os.Chtimes(tmpPath, modTime, modTime)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, fullPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// numberedName 生成带序号的文件名，如 "report.txt" 的第 1 个为 "report (1).txt"
+func numberedName(fullPath string, n int) string {
+	dir, base := filepath.Split(fullPath)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	// 以 . 开头且没有其他扩展名的文件（如 .bashrc）整体作为文件名
+	if stem == "" {
+		stem, ext = base, ""
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, n, ext))
+}
+
+// ensureUploadDir 创建上传用的目录，已存在的目录不能经由符号链接指向根目录之外
+func (s *Server) ensureUploadDir(rootIndex int, dir string) error {
+	if !s.isPathSafe(dir, rootIndex) {
+		return os.ErrPermission
+	}
+
+	info, err := os.Lstat(dir)
+	if os.IsNotExist(err) {
+		if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		realDir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		realRoot, err := filepath.EvalSymlinks(s.config.RootDirs[rootIndex].Path)
+		if err != nil {
+			return err
+		}
+		if !isWithin(realRoot, realDir) {
+			return os.ErrPermission
+		}
+		info, err = os.Stat(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s: a file with the same name already exists", filepath.Base(dir))
+	}
+	return nil
+}
+
+// splitRelativePath 拆分上传文件的相对路径，拒绝绝对路径和 . 或 .. 路径段
+func splitRelativePath(name string) ([]string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") {
+		return nil, errInvalidPath
+	}
+
+	segments := strings.Split(name, "/")
+	for _, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." {
+			return nil, errInvalidPath
+		}
+	}
+	return segments, nil
 }
 
 // noCacheWrapper 包装 HTTP 处理器，添加禁用缓存的响应头
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer 创建以临时目录为唯一根目录的服务器，configure 可以修改配置
+func newTestServer(t *testing.T, configure func(config *Config)) (*Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	config := &Config{RootDirs: []RootDirConfig{{Name: "test", Path: dir}}}
+	if configure != nil {
+		configure(config)
+	}
+	return NewServer(config), dir
+}
+
+// writeTestFile 在目录下写入文件，自动创建上级目录
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	fullPath := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fullPath
+}
+
+// readTestFile 读取文件内容
+func readTestFile(t *testing.T, fullPath string) string {
+	t.Helper()
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// doRequest 直接调用处理函数，body 为字符串时作为请求体
+func doRequest(handler http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(method, target, reader))
+	return rec
+}
+
+// decodeResponse 检查状态码并解码 JSON 响应
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, status, rec.Body.String())
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("decode response: %v: %s", err, rec.Body.String())
+		}
+	}
+}
+
+// mustJSON 将值编码为 JSON 字符串
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// waitJob 等待后台任务结束并返回最终状态
+func waitJob(t *testing.T, s *Server, id string) JobInfo {
+	t.Helper()
+	job, ok := s.jobs.Get(id)
+	if !ok {
+		t.Fatalf("job %s not found", id)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if info := job.Info(); info.Status != JobRunning {
+			return info
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return JobInfo{}
+}
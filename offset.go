@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// 按偏移读取时向前查找行首每次读取的字节数
+	backwardChunk = 64 * 1024
+	// 最多缓存的行数统计结果
+	maxLineCounts = 256
+)
+
+// 按偏移读取的方向
+const (
+	DirectionForward  = "forward"
+	DirectionBackward = "backward"
+)
+
+// ByteWindow 按字节偏移读取时返回的范围，偏移都是原始文件中的字节位置且位于行首
+type ByteWindow struct {
+	Start     int64 `json:"start"`               // 第一行的起始偏移，向前继续读取时作为 offset 并指定 direction=backward
+	End       int64 `json:"end"`                 // 最后一行之后的偏移，向后继续读取时作为 offset
+	AtStart   bool  `json:"atStart"`             // 是否已到文件开头
+	AtEnd     bool  `json:"atEnd"`               // 是否已到文件末尾
+	FirstLine int   `json:"firstLine,omitempty"` // 第一行的行号（从 1 开始），未知时省略
+}
+
+// lineCount 单个文件的行数统计
+type lineCount struct {
+	size     int64
+	modTime  time.Time
+	total    int
+	err      error
+	done     chan struct{} // 统计结束后关闭
+	lastUsed time.Time
+}
+
+// finished 判断统计是否已结束
+func (lc *lineCount) finished() bool {
+	select {
+	case <-lc.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// lineCountCache 文件行数缓存，统计在后台进行，文件变化后重新统计
+type lineCountCache struct {
+	mu    sync.Mutex
+	files map[string]*lineCount
+}
+
+// newLineCountCache 创建行数缓存
+func newLineCountCache() *lineCountCache {
+	return &lineCountCache{files: make(map[string]*lineCount)}
+}
+
+// entry 获取文件的统计，没有统计、文件已变化或上次统计失败时在后台开始统计
+func (c *lineCountCache) entry(key string, info os.FileInfo, count func() (int, error)) *lineCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lc, ok := c.files[key]
+	stale := ok && (lc.size != info.Size() || !lc.modTime.Equal(info.ModTime()) || (lc.finished() && lc.err != nil))
+	if !ok || stale {
+		lc = &lineCount{size: info.Size(), modTime: info.ModTime(), done: make(chan struct{})}
+		c.files[key] = lc
+		go func() {
+			lc.total, lc.err = count()
+			close(lc.done)
+		}()
+		c.evict()
+	}
+	lc.lastUsed = time.Now()
+	return lc
+}
+
+// evict 超出数量限制时丢弃最久未使用的已完成统计（调用方需持有锁）
+func (c *lineCountCache) evict() {
+	for len(c.files) > maxLineCounts {
+		oldest := ""
+		for key, lc := range c.files {
+			if lc.finished() && (oldest == "" || lc.lastUsed.Before(c.files[oldest].lastUsed)) {
+				oldest = key
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		delete(c.files, oldest)
+	}
+}
+
+// get 获取已统计的行数，统计尚未完成时返回 false
+func (c *lineCountCache) get(key string, info os.FileInfo, count func() (int, error)) (int, bool) {
+	lc := c.entry(key, info, count)
+	if !lc.finished() || lc.err != nil {
+		return -1, false
+	}
+	return lc.total, true
+}
+
+// peek 获取已统计完成的行数，不开始新的统计
+func (c *lineCountCache) peek(key string, info os.FileInfo) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lc, ok := c.files[key]
+	if !ok || lc.size != info.Size() || !lc.modTime.Equal(info.ModTime()) || !lc.finished() || lc.err != nil {
+		return -1, false
+	}
+	lc.lastUsed = time.Now()
+	return lc.total, true
+}
+
+// put 记录请求中直接统计的行数，后台统计仍在进行时保留后台统计
+func (c *lineCountCache) put(key string, info os.FileInfo, total int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if lc, ok := c.files[key]; ok && !lc.finished() {
+		return
+	}
+	done := make(chan struct{})
+	close(done)
+	c.files[key] = &lineCount{size: info.Size(), modTime: info.ModTime(), total: total, done: done, lastUsed: time.Now()}
+	c.evict()
+}
+
+// lineCountKey 行数缓存的键，指定不同编码时分别统计
+func lineCountKey(rootIndex int, fullPath string, te textEncoding) string {
+	return fmt.Sprintf("%d:%s:%s", rootIndex, fullPath, te.name)
+}
+
+// countFileLines 在已打开的文件上从头统计行数，结束后恢复原来的读取位置，
+// 使请求不必再次打开文件（SFTP 根目录每个打开的文件占用一个连接）：
+// UTF-16 按解码后的内容统计，其他编码的换行符都是单个 \n 字节，直接统计原始字节
+func (s *Server) countFileLines(rootIndex int, file File, te textEncoding) (total int, err error) {
+	pos, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if _, seekErr := file.Seek(pos, io.SeekStart); err == nil {
+			err = seekErr
+		}
+	}()
+
+	if te.isUTF16() {
+		reader, _, err := s.decodeText(rootIndex, file, te.name)
+		if err != nil {
+			return 0, err
+		}
+		return s.countLines(reader), nil
+	}
+	return countRawLines(file)
+}
+
+// countPathLines 打开文件统计行数，用于请求结束后仍在后台进行的统计
+func (s *Server) countPathLines(rootIndex int, fullPath string, te textEncoding) (int, error) {
+	file, _, err := s.openFile(rootIndex, fullPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return s.countFileLines(rootIndex, file, te)
+}
+
+// countRawLines 统计 \n 的数量，最后一行没有换行符时同样计为一行
+func countRawLines(r io.Reader) (int, error) {
+	buf := make([]byte, 256*1024)
+	count := 0
+	var last byte
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			count += bytes.Count(buf[:n], []byte{'\n'})
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if last != 0 && last != '\n' {
+		count++
+	}
+	return count, nil
+}
+
+// parseOffset 解析 offset 参数：end 表示文件末尾，负数从末尾倒数，结果限制在 [0, size]
+func parseOffset(value string, size int64) (int64, error) {
+	if value == "end" {
+		return size, nil
+	}
+	offset, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset: %s", value)
+	}
+	if offset < 0 {
+		offset += size
+	}
+	return min(max(offset, 0), size), nil
+}
+
+// readByteAt 读取 offset 处的一个字节
+func readByteAt(file File, offset int64) (byte, error) {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	var b [1]byte
+	if _, err := io.ReadFull(file, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// nextLineStart 将偏移对齐到行首：已在行首时不变，否则移到下一行的开头
+func nextLineStart(file File, offset, size int64) (int64, error) {
+	if offset <= 0 || offset >= size {
+		return offset, nil
+	}
+	b, err := readByteAt(file, offset-1)
+	if err != nil || b == '\n' {
+		return offset, err
+	}
+
+	// readByteAt 之后文件位于 offset 处
+	br := bufio.NewReaderSize(file, backwardChunk)
+	for {
+		chunk, err := br.ReadSlice('\n')
+		offset += int64(len(chunk))
+		switch err {
+		case nil, io.EOF:
+			return offset, nil
+		case bufio.ErrBufferFull:
+			continue
+		default:
+			return 0, err
+		}
+	}
+}
+
+// prevLinesStart 查找以 end（行首）结束的最后 count 行的起始偏移，向前分块读取，不会读取 end 之后的内容
+func prevLinesStart(file File, end int64, count int) (int64, error) {
+	limit := end
+	if end > 0 {
+		// 忽略结束处前一行自己的换行符
+		b, err := readByteAt(file, end-1)
+		if err != nil {
+			return 0, err
+		}
+		if b == '\n' {
+			limit--
+		}
+	}
+
+	buf := make([]byte, backwardChunk)
+	found := 0
+	for pos := limit; pos > 0; {
+		n := min(int64(len(buf)), pos)
+		pos -= n
+		if _, err := file.Seek(pos, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(file, buf[:n]); err != nil {
+			return 0, err
+		}
+		for i := n - 1; i >= 0; i-- {
+			if buf[i] == '\n' {
+				found++
+				if found == count {
+					return pos + i + 1, nil
+				}
+			}
+		}
+	}
+	return 0, nil
+}
+
+// rawLine 按字节偏移读取的一行原始字节
+type rawLine struct {
+	data      []byte // 行的内容（含换行符），超过 MaxLineLength 时只保留开头且不含换行符
+	start     int64  // 行首的偏移
+	length    int64  // 行的实际字节数（不含换行符）
+	truncated bool
+}
+
+// readRawLines 从 start 开始读取最多 count 行原始字节，不超过 end；返回读取的行以及最后一行之后的偏移
+func readRawLines(file File, start, end int64, count int) ([]rawLine, int64, error) {
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+
+	br := bufio.NewReaderSize(io.LimitReader(file, end-start), backwardChunk)
+	var lines []rawLine
+	pos := start
+	for len(lines) < count {
+		line := rawLine{start: pos}
+		var last, prev byte
+		var err error
+		for {
+			var chunk []byte
+			chunk, err = br.ReadSlice('\n')
+			if room := MaxLineLength - len(line.data); room > 0 {
+				line.data = append(line.data, chunk[:min(room, len(chunk))]...)
+			}
+			line.length += int64(len(chunk))
+			if len(chunk) > 0 {
+				prev, last = last, chunk[len(chunk)-1]
+				if len(chunk) > 1 {
+					prev = chunk[len(chunk)-2]
+				}
+			}
+			if err != bufio.ErrBufferFull {
+				break
+			}
+		}
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		if line.length == 0 {
+			break
+		}
+
+		pos += line.length
+		if last == '\n' {
+			line.length--
+			if prev == '\r' {
+				line.length--
+			}
+		}
+		line.truncated = int64(len(line.data)) < line.length
+		if line.truncated {
+			line.data = trimPartialRune(line.data)
+		}
+		lines = append(lines, line)
+		if err == io.EOF {
+			break
+		}
+	}
+	return lines, pos, nil
+}
+
+// decodeLine 去掉行尾的换行符并解码为 UTF-8
+func (te textEncoding) decodeLine(raw []byte) string {
+	raw = bytes.TrimSuffix(raw, []byte{'\n'})
+	raw = bytes.TrimSuffix(raw, []byte{'\r'})
+	if te.enc == nil {
+		return string(raw)
+	}
+	decoded, err := te.enc.NewDecoder().Bytes(raw)
+	if err != nil {
+		return string(raw)
+	}
+	return string(decoded)
+}
+
+// handleOffsetView 按字节偏移读取大文件：从 offset 所在位置（对齐到下一行的行首）向后读取，
+// 或者读取 offset 之前的若干行，无需统计总行数即可打开文件末尾；总行数在后台统计
+func (s *Server) handleOffsetView(w http.ResponseWriter, rootIndex int, file File, fullPath string, info os.FileInfo, version string, offsetValue, direction string, count int, config ViewConfig, encodingName string) {
+	_, te, err := s.decodeText(rootIndex, file, encodingName)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// UTF-16 的换行符不是单个字节，按页读取
+	if te.isUTF16() {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		s.handleLargeFile(w, rootIndex, file, fullPath, info, version, lineRange{count: config.LinesPerPage}, config, te.name)
+		return
+	}
+
+	size := info.Size()
+	offset, err := parseOffset(offsetValue, size)
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	offset, err = nextLineStart(file, offset, size)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	start, end := offset, size
+	switch direction {
+	case "", DirectionForward:
+	case DirectionBackward:
+		if start, err = prevLinesStart(file, offset, count); err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		end = offset
+	default:
+		s.handleError(w, fmt.Errorf("invalid direction: %s", direction), http.StatusBadRequest)
+		return
+	}
+
+	rawLines, end, err := readRawLines(file, start, end, count)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// 换行符按本次读取的内容检测
+	var head []byte
+	for _, line := range rawLines {
+		head = append(head, line.data...)
+		if len(head) >= encodingSniffLen {
+			break
+		}
+	}
+	lineEnding, mixed := detectLineEnding(head)
+	finalNewline, err := endsWithNewline(file, size, te)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	lines := make([]string, len(rawLines))
+	var long []LongLine
+	for i, line := range rawLines {
+		raw := line.data
+		if i == 0 && start == 0 && te.bom {
+			raw = bytes.TrimPrefix(raw, te.bomBytes())
+		}
+		lines[i] = te.decodeLine(raw)
+		if line.truncated {
+			lines[i] += TruncatedMarker
+			long = append(long, LongLine{Index: i, At: line.start, Length: line.length})
+		}
+	}
+
+	// 总行数在后台统计，统计完成前为 -1，之后由 /api/lineCount 取得；
+	// 后台统计自行打开文件，不在本请求中等待
+	totalLines, known := s.lineCounts.get(lineCountKey(rootIndex, fullPath, te), info, func() (int, error) {
+		return s.countPathLines(rootIndex, fullPath, te)
+	})
+
+	window := &ByteWindow{Start: start, End: end, AtStart: start == 0, AtEnd: end >= size}
+	switch {
+	case window.AtStart:
+		window.FirstLine = 1
+	case window.AtEnd && known:
+		window.FirstLine = totalLines - len(lines) + 1
+	}
+	if window.FirstLine > 0 {
+		for i := range long {
+			long[i].Line = window.FirstLine + long[i].Index
+		}
+	}
+
+	response := FileContent{
+		Path:       fullPath,
+		Name:       info.Name(),
+		Size:       size,
+		IsPartial:  true,
+		TotalLines: totalLines,
+		Lines:      lines,
+		LongLines:  long,
+		Version:    version,
+		Encoding:   te.name,
+		BOM:        te.bom,
+		Window:     window,
+
+		LineEnding:       lineEnding,
+		MixedLineEndings: mixed,
+		FinalNewline:     &finalNewline,
+	}
+	if known {
+		response.TotalPages = (totalLines + config.LinesPerPage - 1) / config.LinesPerPage
+	}
+
+	s.writeJSON(w, response)
+}
+
+// handleLineCount 获取文件的总行数，尚未统计时在后台开始统计并返回 pending
+func (s *Server) handleLineCount(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		s.handleError(w, fmt.Errorf("path parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	rootIndex := getRootIndex(r)
+
+	// 构建完整路径
+	fullPath := s.getFullPath(path, rootIndex)
+
+	// 检查路径是否在根目录内
+	if !s.isPathSafe(fullPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	// 打开文件（支持归档内的文件）
+	file, info, err := s.openFile(rootIndex, fullPath)
+	if err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+	_, te, err := s.decodeText(rootIndex, file, r.URL.Query().Get("encoding"))
+	file.Close()
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// 文件已关闭，后台统计自行打开文件
+	totalLines, known := s.lineCounts.get(lineCountKey(rootIndex, fullPath, te), info, func() (int, error) {
+		return s.countPathLines(rootIndex, fullPath, te)
+	})
+	s.writeJSON(w, map[string]interface{}{
+		"totalLines": totalLines,
+		"pending":    !known,
+	})
+}
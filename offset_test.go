@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineAlignment(t *testing.T) {
+	_, dir := newTestServer(t, nil)
+	text := "aa\nbbb\n\ncccc\nd"
+	file, err := os.Open(writeTestFile(t, dir, "a.txt", text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	size := int64(len(text))
+
+	next := []struct {
+		offset, want int64
+	}{
+		{0, 0}, {1, 3}, {3, 3}, {4, 7}, {7, 7}, {8, 8}, {9, 13}, {13, 13}, {14, 14},
+	}
+	for _, tt := range next {
+		if got, err := nextLineStart(file, tt.offset, size); err != nil || got != tt.want {
+			t.Errorf("nextLineStart(%d) = %d, %v; want %d", tt.offset, got, err, tt.want)
+		}
+	}
+
+	prev := []struct {
+		end   int64
+		count int
+		want  int64
+	}{
+		{14, 1, 13}, {14, 2, 8}, {14, 3, 7}, {14, 10, 0}, {13, 1, 8}, {8, 2, 3}, {3, 1, 0}, {0, 1, 0},
+	}
+	for _, tt := range prev {
+		if got, err := prevLinesStart(file, tt.end, tt.count); err != nil || got != tt.want {
+			t.Errorf("prevLinesStart(%d, %d) = %d, %v; want %d", tt.end, tt.count, got, err, tt.want)
+		}
+	}
+}
+
+func TestCountRawLines(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0}, {"a", 1}, {"a\n", 1}, {"a\nb", 2}, {"\n\n", 2},
+	}
+	for _, tt := range tests {
+		if got, err := countRawLines(strings.NewReader(tt.text)); err != nil || got != tt.want {
+			t.Errorf("countRawLines(%q) = %d, %v; want %d", tt.text, got, err, tt.want)
+		}
+	}
+}
+
+func TestOffsetView(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	var sb strings.Builder
+	totalLines := 0
+	for sb.Len() <= MaxFileSize {
+		totalLines++
+		fmt.Fprintf(&sb, "line %07d\r\n", totalLines)
+	}
+	writeTestFile(t, dir, "big.log", sb.String())
+	size := int64(sb.Len())
+	lineLen := int64(len("line 0000001\r\n"))
+
+	view := func(query string) FileContent {
+		t.Helper()
+		var content FileContent
+		decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/big.log&"+query, ""), 200, &content)
+		if content.Window == nil {
+			t.Fatalf("%s: no window in response", query)
+		}
+		return content
+	}
+
+	// 从末尾向前读取
+	content := view("offset=end&direction=backward")
+	if len(content.Lines) != LinesPerPage || content.Lines[len(content.Lines)-1] != fmt.Sprintf("line %07d", totalLines) {
+		t.Fatalf("end lines = %d, last %q", len(content.Lines), content.Lines[len(content.Lines)-1])
+	}
+	if !content.Window.AtEnd || content.Window.AtStart || content.Window.End != size || content.Window.Start != size-int64(LinesPerPage)*lineLen {
+		t.Errorf("end window = %+v", content.Window)
+	}
+	if content.LineEnding != LineEndingCRLF || content.FinalNewline == nil || !*content.FinalNewline {
+		t.Errorf("lineEnding = %q finalNewline = %v", content.LineEnding, content.FinalNewline)
+	}
+
+	// 继续向前读取与上一段相接
+	prev := view(fmt.Sprintf("offset=%d&direction=backward", content.Window.Start))
+	if prev.Window.End != content.Window.Start || prev.Lines[len(prev.Lines)-1] != fmt.Sprintf("line %07d", totalLines-LinesPerPage) {
+		t.Errorf("previous window = %+v, last %q", prev.Window, prev.Lines[len(prev.Lines)-1])
+	}
+
+	// 偏移位于行中间时从下一行开始
+	content = view(fmt.Sprintf("offset=%d", 10*lineLen+3))
+	if content.Window.Start != 11*lineLen || content.Lines[0] != "line 0000012" || content.Window.FirstLine != 0 {
+		t.Errorf("middle window = %+v, first %q", content.Window, content.Lines[0])
+	}
+	if content.Window.End != content.Window.Start+int64(LinesPerPage)*lineLen {
+		t.Errorf("middle window end = %d", content.Window.End)
+	}
+
+	content = view("offset=0")
+	if content.Window.FirstLine != 1 || !content.Window.AtStart || content.Lines[0] != "line 0000001" {
+		t.Errorf("start window = %+v", content.Window)
+	}
+
+	// 总行数由偏移读取开始在后台统计，完成后返回
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		content = view("offset=-1&direction=backward")
+		if content.TotalLines >= 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("line count did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if content.TotalLines != totalLines || content.Window.FirstLine != totalLines-LinesPerPage+1 {
+		t.Errorf("totalLines = %d firstLine = %d", content.TotalLines, content.Window.FirstLine)
+	}
+	var count struct {
+		TotalLines int  `json:"totalLines"`
+		Pending    bool `json:"pending"`
+	}
+	decodeResponse(t, doRequest(s.handleLineCount, "GET", "/api/lineCount?root=0&path=/big.log", ""), 200, &count)
+	if count.Pending || count.TotalLines != totalLines {
+		t.Errorf("lineCount = %+v, want %d", count, totalLines)
+	}
+
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/big.log&offset=x", ""), 400, nil)
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/big.log&offset=0&direction=up", ""), 400, nil)
+
+	// 小文件忽略 offset，返回全部内容
+	writeTestFile(t, dir, "small.txt", "a\nb\n")
+	var small FileContent
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/small.txt&offset=end", ""), 200, &small)
+	if small.Window != nil || len(small.Lines) != 2 {
+		t.Errorf("small file = %+v", small)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// fileOwner 当前平台没有属主信息
+func fileOwner(info os.FileInfo) (uid, gid int, nlink uint64, ok bool) {
+	return 0, 0, 0, false
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PackRequest 打包请求
+type PackRequest struct {
+	Paths  []string `json:"paths"`           // 要打包的文件和目录
+	Target string   `json:"target"`          // 生成的归档路径，扩展名决定格式（.zip、.tar.gz、.tgz）
+	Level  *int     `json:"level,omitempty"` // 压缩级别 0-9，0 表示不压缩，默认使用标准级别
+}
+
+// packSource 打包的源文件或目录
+type packSource struct {
+	fullPath string
+	name     string // 在归档中的顶层名称
+}
+
+// handlePack 处理打包请求，在目标位置生成 zip 或 tar.gz 归档
+func (s *Server) handlePack(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.handleError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.handleError(w, fmt.Errorf("invalid request body"), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Paths) == 0 {
+		s.handleError(w, fmt.Errorf("paths is required"), http.StatusBadRequest)
+		return
+	}
+
+	level := flate.DefaultCompression
+	if req.Level != nil {
+		if *req.Level < 0 || *req.Level > 9 {
+			s.handleError(w, fmt.Errorf("compression level must be between 0 and 9"), http.StatusBadRequest)
+			return
+		}
+		level = *req.Level
+	}
+
+	rootIndex := getRootIndex(r)
+
+	// 远程根目录不支持该操作
+	if s.isRemote(rootIndex) {
+		s.handleError(w, errRemoteUnsupported, http.StatusNotImplemented)
+		return
+	}
+
+	// 构建目标归档的完整路径
+	targetPath := s.getFullPath(req.Target, rootIndex)
+
+	// 检查路径是否在根目录内
+	if !s.isPathSafe(targetPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	kind := archiveKind(targetPath)
+	if kind != archiveZip && kind != archiveTarGz {
+		s.handleError(w, fmt.Errorf("target must be a .zip, .tar.gz or .tgz file"), http.StatusBadRequest)
+		return
+	}
+
+	// 检查目标是否已存在
+	if _, err := os.Stat(targetPath); err == nil {
+		s.handleError(w, fmt.Errorf("file already exists"), http.StatusConflict)
+		return
+	}
+
+	if info, err := os.Stat(filepath.Dir(targetPath)); err != nil || !info.IsDir() {
+		s.handleError(w, fmt.Errorf("target directory does not exist"), http.StatusBadRequest)
+		return
+	}
+
+	// 检查所有源路径
+	sources := make([]packSource, 0, len(req.Paths))
+	names := make(map[string]bool)
+	for _, p := range req.Paths {
+		fullPath := s.getFullPath(p, rootIndex)
+		if !s.isPathSafe(fullPath, rootIndex) {
+			s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+			return
+		}
+
+		if _, err := os.Lstat(fullPath); err != nil {
+			s.handleError(w, err, http.StatusNotFound)
+			return
+		}
+
+		name := filepath.Base(fullPath)
+		if names[name] {
+			s.handleError(w, fmt.Errorf("duplicate name in selection: %s", name), http.StatusBadRequest)
+			return
+		}
+		names[name] = true
+		sources = append(sources, packSource{fullPath: fullPath, name: name})
+	}
+
+	info := s.jobs.Start("pack", rootIndex, s.relPath(targetPath, rootIndex), func(job *Job) error {
+		return s.packArchive(job, rootIndex, sources, targetPath, kind, level)
+	})
+
+	s.writeJSON(w, info)
+}
+
+// packArchive 生成归档：先写入同目录下的临时文件，完成后以独占方式发布，避免出现不完整的归档，
+// 也不会覆盖打包期间在目标位置新建的文件
+// 归档大小事先未知，写入时按字节检查大小上限并占用配额，失败时释放
+func (s *Server) packArchive(job *Job, rootIndex int, sources []packSource, targetPath, kind string, level int) error {
+	tmp, err := os.CreateTemp(filepath.Dir(targetPath), "."+filepath.Base(targetPath)+".*.partial")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	lw := s.newLimitedWriter(tmp, rootIndex)
+
+	// 跳过正在写入的临时文件，以及只能由服务器访问的历史版本目录和上传暂存目录
+	skip := func(path string) bool {
+		return path == tmpPath || s.isInternalPath(path, rootIndex)
+	}
+
+	// 统计总大小用于显示进度
+	var total int64
+	for _, src := range sources {
+		filepath.WalkDir(src.fullPath, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && skip(path) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if err == nil && d.Type().IsRegular() {
+				if info, err := d.Info(); err == nil {
+					total += info.Size()
+				}
+			}
+			return nil
+		})
+	}
+	job.SetTotal(total)
+
+	if kind == archiveZip {
+		err = writeZip(job, lw, sources, skip, level)
+	} else {
+		err = writeTarGz(job, lw, sources, skip, level)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, 0644)
+	}
+	if err == nil {
+		if err = publishFile(tmpPath, targetPath); os.IsExist(err) {
+			err = fmt.Errorf("file already exists")
+		}
+	}
+	if err != nil {
+		lw.release()
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// walkSources 遍历所有源文件，跳过 skip 返回 true 的文件和目录，name 为归档内的 / 分隔路径
+func walkSources(job *Job, sources []packSource, skip func(path string) bool, fn func(path, name string, info os.FileInfo) error) error {
+	for _, src := range sources {
+		err := filepath.Walk(src.fullPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := job.Context().Err(); err != nil {
+				return err
+			}
+			if skip(path) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			rel, err := filepath.Rel(src.fullPath, path)
+			if err != nil {
+				return err
+			}
+			name := src.name
+			if rel != "." {
+				name += "/" + filepath.ToSlash(rel)
+			}
+
+			if err := fn(path, name, info); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			job.AddProgress(0, 1)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile 将文件内容写入归档并累计进度
+func copyFile(job *Job, w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(&progressWriter{w: w, job: job}, file)
+	return err
+}
+
+// writeZip 写入 zip 归档
+func writeZip(job *Job, out io.Writer, sources []packSource, skip func(path string) bool, level int) error {
+	zw := zip.NewWriter(out)
+	zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+
+	err := walkSources(job, sources, skip, func(path, name string, info os.FileInfo) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+
+		switch {
+		case info.IsDir():
+			header.Name += "/"
+			_, err = zw.CreateHeader(header)
+			return err
+		case info.Mode()&os.ModeSymlink != 0:
+			// 符号链接按 zip 约定保存链接目标
+			linkname, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			header.Method = zip.Store
+			w, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(w, linkname)
+			return err
+		case !info.Mode().IsRegular():
+			return nil
+		}
+
+		header.Method = zip.Deflate
+		if level == 0 {
+			header.Method = zip.Store
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		return copyFile(job, w, path)
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeTarGz 写入 tar.gz 归档
+func writeTarGz(job *Job, out io.Writer, sources []packSource, skip func(path string) bool, level int) error {
+	gz, err := gzip.NewWriterLevel(out, level)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gz)
+
+	err = walkSources(job, sources, skip, func(path, name string, info os.FileInfo) error {
+		var linkname string
+		if info.Mode()&os.ModeSymlink != 0 {
+			var err error
+			if linkname, err = os.Readlink(path); err != nil {
+				return err
+			}
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, linkname)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() && !strings.HasSuffix(header.Name, "/") {
+			header.Name += "/"
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(job, tw, path)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
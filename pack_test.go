@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// packTestArchive 提交打包请求并等待任务结束
+func packTestArchive(t *testing.T, s *Server, req PackRequest) JobInfo {
+	t.Helper()
+	var info JobInfo
+	decodeResponse(t, doRequest(s.handlePack, "POST", "/api/pack?root=0", mustJSON(t, req)), 200, &info)
+	return waitJob(t, s, info.ID)
+}
+
+// archiveNames 列出归档内的所有条目
+func archiveNames(t *testing.T, archivePath string) []string {
+	t.Helper()
+	idx, err := buildArchiveIndex(archivePath, archiveKind(archivePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range idx.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	for _, target := range []string{"/out.zip", "/out.tar.gz", "/out.tgz"} {
+		t.Run(target, func(t *testing.T) {
+			s, dir := newTestServer(t, nil)
+			writeTestFile(t, dir, "src/a.txt", "aaa")
+			writeTestFile(t, dir, "src/sub/b.txt", "bbb")
+			writeTestFile(t, dir, "c.txt", "ccc")
+			if err := os.Symlink("a.txt", filepath.Join(dir, "src", "link")); err != nil {
+				t.Fatal(err)
+			}
+
+			level := 0
+			info := packTestArchive(t, s, PackRequest{Paths: []string{"/src", "/c.txt"}, Target: target, Level: &level})
+			if info.Status != JobDone {
+				t.Fatalf("job = %s %q", info.Status, info.Error)
+			}
+			if info.Total != 9 || info.Done != 9 {
+				t.Errorf("progress = %d/%d, want 9/9", info.Done, info.Total)
+			}
+
+			// tar 中的符号链接不会出现在浏览索引中，解压后再检查
+			var names []string
+			for _, name := range archiveNames(t, filepath.Join(dir, target)) {
+				if name != "src/link" {
+					names = append(names, name)
+				}
+			}
+			want := "c.txt,src,src/a.txt,src/sub,src/sub/b.txt"
+			if got := strings.Join(names, ","); got != want {
+				t.Errorf("entries = %s, want %s", got, want)
+			}
+
+			// 解压后内容和符号链接应与原文件一致
+			extracted := extractTestArchive(t, s, ExtractRequest{Path: target, Target: "/unpacked", Symlinks: SymlinkAllow})
+			if extracted.Status != JobDone {
+				t.Fatalf("extract = %s %q", extracted.Status, extracted.Error)
+			}
+			if got := readTestFile(t, filepath.Join(dir, "unpacked", "src", "sub", "b.txt")); got != "bbb" {
+				t.Errorf("b.txt = %q", got)
+			}
+			if link, err := os.Readlink(filepath.Join(dir, "unpacked", "src", "link")); err != nil || link != "a.txt" {
+				t.Errorf("link = %q, %v", link, err)
+			}
+		})
+	}
+}
+
+func TestPackTargetInsideSource(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	writeTestFile(t, dir, "src/a.txt", "aaa")
+
+	info := packTestArchive(t, s, PackRequest{Paths: []string{"/src"}, Target: "/src/self.zip"})
+	if info.Status != JobDone {
+		t.Fatalf("job = %s %q", info.Status, info.Error)
+	}
+	// 正在写入的临时文件不应被打包进自身
+	if got := strings.Join(archiveNames(t, filepath.Join(dir, "src", "self.zip")), ","); got != "src,src/a.txt" {
+		t.Errorf("entries = %s", got)
+	}
+}
+
+func TestPackValidation(t *testing.T) {
+	level := 10
+	tests := []struct {
+		name string
+		req  PackRequest
+		want int
+	}{
+		{"no paths", PackRequest{Target: "/out.zip"}, 400},
+		{"bad level", PackRequest{Paths: []string{"/a.txt"}, Target: "/out.zip", Level: &level}, 400},
+		{"unsupported format", PackRequest{Paths: []string{"/a.txt"}, Target: "/out.7z"}, 400},
+		{"target exists", PackRequest{Paths: []string{"/a.txt"}, Target: "/exists.zip"}, 409},
+		{"missing target dir", PackRequest{Paths: []string{"/a.txt"}, Target: "/nodir/out.zip"}, 400},
+		{"missing source", PackRequest{Paths: []string{"/missing"}, Target: "/out.zip"}, 404},
+		{"source outside root", PackRequest{Paths: []string{"/../etc"}, Target: "/out.zip"}, 403},
+		{"duplicate names", PackRequest{Paths: []string{"/a.txt", "/sub/a.txt"}, Target: "/out.zip"}, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := newTestServer(t, nil)
+			writeTestFile(t, dir, "a.txt", "a")
+			writeTestFile(t, dir, "sub/a.txt", "a")
+			writeTestFile(t, dir, "exists.zip", "")
+
+			rec := doRequest(s.handlePack, "POST", "/api/pack?root=0", mustJSON(t, tt.req))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestPackDoesNotOverwriteNewTarget(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	writeTestFile(t, dir, "src/a.txt", "a")
+	// 目标在请求检查之后、归档完成之前被创建
+	target := writeTestFile(t, dir, "out.zip", "mine")
+
+	job := &Job{ctx: context.Background()}
+	sources := []packSource{{fullPath: filepath.Join(dir, "src"), name: "src"}}
+	err := s.packArchive(job, 0, sources, target, archiveZip, 6)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("err = %v, want already exists", err)
+	}
+	if got := readTestFile(t, target); got != "mine" {
+		t.Errorf("target = %q, want it untouched", got)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.partial")); len(matches) != 0 {
+		t.Errorf("temp files left: %v", matches)
+	}
+}
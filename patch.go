@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// 局部修改的操作
+const (
+	PatchReplace = "replace"
+	PatchInsert  = "insert"
+	PatchDelete  = "delete"
+)
+
+// 局部修改的范围单位
+const (
+	PatchUnitLine = "line"
+	PatchUnitByte = "byte"
+)
+
+// errPatchRange 修改范围超出了文件内容
+var errPatchRange = errors.New("range is out of bounds")
+
+// PatchRequest 局部修改文件请求，只替换指定的行或字节范围，适用于无法整体加载的大文件
+type PatchRequest struct {
+	Path      string `json:"path"`
+	Version   string `json:"version,omitempty"` // 打开文件时得到的版本，也可以通过 If-Match 请求头提供
+	Op        string `json:"op"`                // 操作：replace（默认）、insert、delete
+	Unit      string `json:"unit"`              // 范围单位：line（默认）或 byte
+	StartLine int    `json:"startLine"`         // 起始行号（从 1 开始），insert 时插入到该行之前
+	EndLine   int    `json:"endLine"`           // 结束行号（包含），默认与起始行相同
+	Offset    int64  `json:"offset"`            // 起始字节偏移，insert 时插入到该位置
+	Length    int64  `json:"length"`            // 替换或删除的字节数
+	Content   string `json:"content"`           // 新内容（delete 时忽略）
+
+	newline string // 文件使用的换行符，新内容按该换行符写入
+}
+
+// handlePatch 处理局部修改文件请求：原文件流式复制到同目录的临时文件，途中替换指定范围，再替换原文件
+func (s *Server) handlePatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.handleError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.handleError(w, fmt.Errorf("invalid request body"), http.StatusBadRequest)
+		return
+	}
+
+	if req.Path == "" {
+		s.handleError(w, fmt.Errorf("path is required"), http.StatusBadRequest)
+		return
+	}
+	if err := req.normalize(); err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	rootIndex := getRootIndex(r)
+
+	// 远程根目录不支持该操作
+	if s.isRemote(rootIndex) {
+		s.handleError(w, errRemoteUnsupported, http.StatusNotImplemented)
+		return
+	}
+
+	// 构建完整路径
+	fullPath := s.getFullPath(req.Path, rootIndex)
+
+	// 检查路径是否在根目录内
+	if !s.isPathSafe(fullPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	// 与保存串行化
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+	if info.IsDir() {
+		s.handleError(w, errIsDirectory, http.StatusBadRequest)
+		return
+	}
+
+	// 检查文件版本，"*" 表示不检查
+	version := requestVersion(r, req.Version)
+	if version == "" {
+		s.handleError(w, fmt.Errorf("version is required, reload the file and try again"), http.StatusPreconditionRequired)
+		return
+	}
+	current := s.pathVersion(rootIndex, fullPath, info)
+	if version != "*" && version != current {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(SaveConflict{
+			Error:   "file has been modified since it was opened",
+			Version: current,
+		})
+		return
+	}
+
+	// 压缩文件以解压后的内容展示，不能直接修改
+	if compressionKind(info.Name()) != "" {
+		s.handleError(w, fmt.Errorf("cannot patch compressed file"), http.StatusBadRequest)
+		return
+	}
+
+	// 新内容按文件的编码和换行符写入，UTF-16 的换行符不是单个字节，只能按字节范围修改
+	te, lineEnding, err := s.fileTextFormat(rootIndex, fullPath)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if te.isUTF16() && req.Unit == PatchUnitLine {
+		s.handleError(w, fmt.Errorf("line ranges are not supported for %s files", te.name), http.StatusBadRequest)
+		return
+	}
+	// 只用 \r 换行的文件在各视图中都视为一行，按行修改没有意义
+	if lineEnding == LineEndingCR && req.Unit == PatchUnitLine {
+		s.handleError(w, fmt.Errorf("line ranges are not supported for files with cr line endings"), http.StatusBadRequest)
+		return
+	}
+	te.bom = false
+	req.newline = lineEndingText(lineEnding)
+	if req.Unit == PatchUnitLine {
+		req.Content = convertLineEndings(req.Content, lineEnding)
+	}
+	content, err := te.encode(req.Content)
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+	req.Content = string(content)
+
+	if req.Unit == PatchUnitByte && req.Offset+req.Length > info.Size() {
+		s.handleError(w, errPatchRange, http.StatusBadRequest)
+		return
+	}
+
+	// 对应 JSON Schema 的文件先在内存中生成修改后的内容，校验通过后再写入
+	var patched []byte
+	if s.matchSchema(rootIndex, s.relPath(fullPath, rootIndex)) != nil {
+		var buf bytes.Buffer
+		err := s.checkSchemaSize(rootIndex, info.Size())
+		if err == nil {
+			err = patchFile(&buf, fullPath, &req)
+		}
+		if err == nil {
+			err = s.validateLocalContent(rootIndex, fullPath, buf.Bytes())
+		}
+		if err != nil {
+			s.writePatchError(w, err)
+			return
+		}
+		patched = buf.Bytes()
+	}
+
+	// 保留当前内容作为历史版本
+	if err := s.recordHistory(rootIndex, fullPath, info); err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	var delta int64
+	err = replaceLocalFile(fullPath, false, func(dst io.Writer) error {
+		cw := &countingWriter{w: dst}
+		var err error
+		if patched != nil {
+			_, err = cw.Write(patched)
+		} else {
+			err = patchFile(cw, fullPath, &req)
+		}
+		if err != nil {
+			return err
+		}
+
+		// 按修改后的大小检查写入限制并占用配额，失败时临时文件被丢弃
+		if err := s.checkFileSize(rootIndex, cw.n); err != nil {
+			return err
+		}
+		if err := s.uploadPolicy(rootIndex).checkType(cw.head); err != nil {
+			return err
+		}
+		if err := s.reserve(rootIndex, cw.n-info.Size()); err != nil {
+			return err
+		}
+		delta = cw.n - info.Size()
+		return nil
+	})
+	if err != nil {
+		s.reserve(rootIndex, -delta)
+		s.writePatchError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "文件修改成功",
+	}
+	if info, err := os.Stat(fullPath); err == nil {
+		version := s.pathVersion(rootIndex, fullPath, info)
+		response["version"] = version
+		w.Header().Set("ETag", `"`+version+`"`)
+	}
+
+	s.writeJSON(w, response)
+}
+
+// writePatchError 返回局部修改失败的错误，不符合 Schema 时与保存一样返回 422 和错误列表
+func (s *Server) writePatchError(w http.ResponseWriter, err error) {
+	switch {
+	case err == errPatchRange:
+		s.handleError(w, err, http.StatusBadRequest)
+	case errors.As(err, new(*schemaError)):
+		s.writeValidationError(w, err)
+	default:
+		s.handleError(w, err, errorStatus(err))
+	}
+}
+
+// patchFile 读取原文件，按请求的单位将修改后的内容写入 dst
+func patchFile(dst io.Writer, fullPath string, req *PatchRequest) error {
+	src, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if req.Unit == PatchUnitByte {
+		return patchBytes(dst, src, req)
+	}
+	return patchLines(dst, src, req)
+}
+
+// normalize 检查请求并补全默认值
+func (req *PatchRequest) normalize() error {
+	if req.Op == "" {
+		req.Op = PatchReplace
+	}
+	if req.Op != PatchReplace && req.Op != PatchInsert && req.Op != PatchDelete {
+		return fmt.Errorf("invalid op: %s", req.Op)
+	}
+	if req.Op == PatchDelete {
+		req.Content = ""
+	}
+
+	switch req.Unit {
+	case "", PatchUnitLine:
+		req.Unit = PatchUnitLine
+		if req.StartLine < 1 {
+			return fmt.Errorf("startLine must be at least 1")
+		}
+		if req.Op == PatchInsert || req.EndLine == 0 {
+			req.EndLine = req.StartLine
+		}
+		if req.EndLine < req.StartLine {
+			return fmt.Errorf("endLine must not be less than startLine")
+		}
+	case PatchUnitByte:
+		if req.Offset < 0 || req.Length < 0 {
+			return fmt.Errorf("offset and length must not be negative")
+		}
+		if req.Op == PatchInsert {
+			req.Length = 0
+		}
+	default:
+		return fmt.Errorf("invalid unit: %s", req.Unit)
+	}
+	return nil
+}
+
+// patchBytes 复制原文件，将 [Offset, Offset+Length) 替换为新内容
+func patchBytes(dst io.Writer, src io.ReadSeeker, req *PatchRequest) error {
+	if _, err := io.CopyN(dst, src, req.Offset); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(dst, req.Content); err != nil {
+		return err
+	}
+	if _, err := src.Seek(req.Offset+req.Length, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.Copy(dst, src)
+	return err
+}
+
+// patchLines 复制原文件，将 [StartLine, EndLine] 行替换为新内容，insert 时插入到 StartLine 之前
+// 新内容按行写入，修改到文件末尾时保持原文件末尾是否有换行符；行的划分与 splitLines 相同
+func patchLines(dst io.Writer, src io.Reader, req *PatchRequest) error {
+	br := bufio.NewReaderSize(src, 64*1024)
+
+	// 复制目标范围之前的行，最后一个换行符暂不写入
+	hw := &newlineHoldWriter{w: dst}
+	newline := true
+	for line := 1; line < req.StartLine; line++ {
+		nl, err := transferLine(br, hw)
+		if err == io.EOF {
+			return errPatchRange
+		}
+		if err != nil {
+			return err
+		}
+		newline = nl
+	}
+
+	// 跳过被替换或删除的行
+	if req.Op != PatchInsert {
+		for line := req.StartLine; line <= req.EndLine; line++ {
+			nl, err := transferLine(br, nil)
+			if err == io.EOF {
+				return errPatchRange
+			}
+			if err != nil {
+				return err
+			}
+			newline = nl
+		}
+	}
+
+	// 修改到文件末尾时，原文件最后一行没有换行符则修改后也不以换行符结尾
+	_, err := br.Peek(1)
+	noFinalNewline := err == io.EOF && !newline
+
+	content := req.Content
+	switch {
+	case content != "":
+		eol := req.newline
+		if eol == "" {
+			eol = "\n"
+		}
+		if !strings.HasSuffix(content, eol) {
+			content += eol
+		}
+		if noFinalNewline {
+			if req.Op == PatchInsert {
+				content = eol + content
+			}
+			content = strings.TrimSuffix(content, eol)
+		}
+	case noFinalNewline && req.Op != PatchInsert:
+		// 删除了最后一行，前一行成为最后一行
+		hw.dropNewline()
+	}
+	if _, err := hw.w.Write(hw.pending); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(dst, content); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, br)
+	return err
+}
+
+// newlineHoldWriter 暂缓写入结尾的换行符，删除最后一行时可以去掉前一行的换行符
+type newlineHoldWriter struct {
+	w       io.Writer
+	pending []byte // 尚未写入的结尾换行符，最多保留两个字节
+}
+
+// Write 写入数据，结尾的换行符留到下次写入
+func (hw *newlineHoldWriter) Write(p []byte) (int, error) {
+	text := bytes.TrimRight(p, "\r\n")
+	if len(text) > 0 {
+		if _, err := hw.w.Write(hw.pending); err != nil {
+			return 0, err
+		}
+		hw.pending = hw.pending[:0]
+		if _, err := hw.w.Write(text); err != nil {
+			return 0, err
+		}
+	}
+	hw.pending = append(hw.pending, p[len(text):]...)
+	if n := len(hw.pending) - 2; n > 0 {
+		if _, err := hw.w.Write(hw.pending[:n]); err != nil {
+			return 0, err
+		}
+		hw.pending = append(hw.pending[:0], hw.pending[n:]...)
+	}
+	return len(p), nil
+}
+
+// dropNewline 去掉暂缓写入的最后一个换行符
+func (hw *newlineHoldWriter) dropNewline() {
+	hw.pending = bytes.TrimSuffix(hw.pending, []byte("\n"))
+	hw.pending = bytes.TrimSuffix(hw.pending, []byte("\r"))
+}
+
+// transferLine 读取一行（含换行符）写入 dst，dst 为 nil 时丢弃，不受行长度限制
+// 返回该行是否以换行符结尾；没有更多内容时返回 io.EOF
+func transferLine(br *bufio.Reader, dst io.Writer) (bool, error) {
+	n := 0
+	for {
+		chunk, err := br.ReadSlice('\n')
+		n += len(chunk)
+		if dst != nil && len(chunk) > 0 {
+			if _, err := dst.Write(chunk); err != nil {
+				return false, err
+			}
+		}
+
+		switch err {
+		case nil:
+			return true, nil
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			if n == 0 {
+				return false, io.EOF
+			}
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+}
+
+// countingWriter 统计写入的字节数并保留开头的内容用于检测类型
+type countingWriter struct {
+	w    io.Writer
+	n    int64
+	head []byte
+}
+
+// Write 写入数据
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if len(cw.head) < sniffLen {
+		cw.head = append(cw.head, p[:min(len(p), sniffLen-len(cw.head))]...)
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPatchRequestNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     PatchRequest
+		want    PatchRequest
+		wantErr bool
+	}{
+		{"defaults", PatchRequest{StartLine: 3}, PatchRequest{Op: PatchReplace, Unit: PatchUnitLine, StartLine: 3, EndLine: 3}, false},
+		{"insert ignores endLine", PatchRequest{Op: PatchInsert, StartLine: 2, EndLine: 5}, PatchRequest{Op: PatchInsert, Unit: PatchUnitLine, StartLine: 2, EndLine: 2}, false},
+		{"delete drops content", PatchRequest{Op: PatchDelete, StartLine: 1, EndLine: 2, Content: "x"}, PatchRequest{Op: PatchDelete, Unit: PatchUnitLine, StartLine: 1, EndLine: 2}, false},
+		{"byte insert drops length", PatchRequest{Op: PatchInsert, Unit: PatchUnitByte, Offset: 4, Length: 2}, PatchRequest{Op: PatchInsert, Unit: PatchUnitByte, Offset: 4}, false},
+		{"missing startLine", PatchRequest{}, PatchRequest{}, true},
+		{"endLine before startLine", PatchRequest{StartLine: 3, EndLine: 2}, PatchRequest{}, true},
+		{"negative offset", PatchRequest{Unit: PatchUnitByte, Offset: -1}, PatchRequest{}, true},
+		{"unknown op", PatchRequest{Op: "append", StartLine: 1}, PatchRequest{}, true},
+		{"unknown unit", PatchRequest{Unit: "word", StartLine: 1}, PatchRequest{}, true},
+	}
+	for _, tt := range tests {
+		req := tt.req
+		err := req.normalize()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: normalize() = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && req != tt.want {
+			t.Errorf("%s: normalize() = %+v, want %+v", tt.name, req, tt.want)
+		}
+	}
+}
+
+func TestPatchLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		req     PatchRequest
+		want    string
+		wantErr error
+	}{
+		{"replace line", "a\nb\nc\n", PatchRequest{Op: PatchReplace, StartLine: 2, EndLine: 2, Content: "B"}, "a\nB\nc\n", nil},
+		{"replace range with more lines", "a\nb\nc\n", PatchRequest{Op: PatchReplace, StartLine: 2, EndLine: 3, Content: "X\nY\nZ\n"}, "a\nX\nY\nZ\n", nil},
+		{"insert before first line", "a\nb\n", PatchRequest{Op: PatchInsert, StartLine: 1, EndLine: 1, Content: "z"}, "z\na\nb\n", nil},
+		{"insert after last line", "a\nb\n", PatchRequest{Op: PatchInsert, StartLine: 3, EndLine: 3, Content: "z"}, "a\nb\nz\n", nil},
+		{"delete middle line", "a\nb\nc\n", PatchRequest{Op: PatchDelete, StartLine: 2, EndLine: 2}, "a\nc\n", nil},
+		{"delete last line", "a\nb\nc\n", PatchRequest{Op: PatchDelete, StartLine: 3, EndLine: 3}, "a\nb\n", nil},
+		{"crlf newline", "a\r\nb\r\n", PatchRequest{Op: PatchReplace, StartLine: 1, EndLine: 1, Content: "A\r\n"}, "A\r\nb\r\n", nil},
+		{"line past end", "a\nb\n", PatchRequest{Op: PatchReplace, StartLine: 3, EndLine: 3, Content: "x"}, "", errPatchRange},
+		{"range past end", "a\nb\n", PatchRequest{Op: PatchDelete, StartLine: 2, EndLine: 4}, "", errPatchRange},
+
+		// 原文件末尾没有换行符
+		{"no final newline: replace last line", "a\nb", PatchRequest{Op: PatchReplace, StartLine: 2, EndLine: 2, Content: "B\n"}, "a\nB", nil},
+		{"no final newline: replace with more lines", "a\nb", PatchRequest{Op: PatchReplace, StartLine: 2, EndLine: 2, Content: "X\nY"}, "a\nX\nY", nil},
+		{"no final newline: replace middle line", "a\nb", PatchRequest{Op: PatchReplace, StartLine: 1, EndLine: 1, Content: "A"}, "A\nb", nil},
+		{"no final newline: insert after last line", "a\nb", PatchRequest{Op: PatchInsert, StartLine: 3, EndLine: 3, Content: "z"}, "a\nb\nz", nil},
+		{"no final newline: insert before last line", "a\nb", PatchRequest{Op: PatchInsert, StartLine: 2, EndLine: 2, Content: "z"}, "a\nz\nb", nil},
+		{"no final newline: delete last line", "a\nb", PatchRequest{Op: PatchDelete, StartLine: 2, EndLine: 2}, "a", nil},
+		{"no final newline: delete last line after blank line", "a\n\nb", PatchRequest{Op: PatchDelete, StartLine: 3, EndLine: 3}, "a\n", nil},
+		{"no final newline: delete last line with crlf", "a\r\nb", PatchRequest{Op: PatchDelete, StartLine: 2, EndLine: 2}, "a", nil},
+		{"no final newline: delete everything", "a\nb", PatchRequest{Op: PatchDelete, StartLine: 1, EndLine: 2}, "", nil},
+		{"no final newline: insert nothing", "a\nb", PatchRequest{Op: PatchInsert, StartLine: 3, EndLine: 3}, "a\nb", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dst bytes.Buffer
+			err := patchLines(&dst, strings.NewReader(tt.src), &tt.req)
+			if err != tt.wantErr {
+				t.Fatalf("patchLines error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && dst.String() != tt.want {
+				t.Errorf("patchLines = %q, want %q", dst.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchLinesLongLine(t *testing.T) {
+	// 超过缓冲区大小的行也能完整复制
+	long := strings.Repeat("x", 200*1024)
+	var dst bytes.Buffer
+	req := PatchRequest{Op: PatchReplace, StartLine: 2, EndLine: 2, Content: "B"}
+	if err := patchLines(&dst, strings.NewReader(long+"\nb\n"+long), &req); err != nil {
+		t.Fatal(err)
+	}
+	if dst.String() != long+"\nB\n"+long {
+		t.Errorf("long lines were not copied intact")
+	}
+}
+
+func TestPatchBytes(t *testing.T) {
+	tests := []struct {
+		name string
+		req  PatchRequest
+		want string
+	}{
+		{"replace", PatchRequest{Offset: 2, Length: 3, Content: "XY"}, "01XY56789"},
+		{"insert", PatchRequest{Offset: 4, Content: "--"}, "0123--456789"},
+		{"delete", PatchRequest{Offset: 0, Length: 5}, "56789"},
+		{"append", PatchRequest{Offset: 10, Content: "!"}, "0123456789!"},
+	}
+	for _, tt := range tests {
+		var dst bytes.Buffer
+		if err := patchBytes(&dst, strings.NewReader("0123456789"), &tt.req); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if dst.String() != tt.want {
+			t.Errorf("%s: patchBytes = %q, want %q", tt.name, dst.String(), tt.want)
+		}
+	}
+}
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		req      PatchRequest
+		wantCode int
+		want     string
+	}{
+		{"byte range", "0123456789", PatchRequest{Unit: PatchUnitByte, Offset: 8, Length: 2, Content: "!"}, 200, "01234567!"},
+		{"byte range past end", "0123456789", PatchRequest{Unit: PatchUnitByte, Offset: 8, Length: 3}, 400, "0123456789"},
+		{"line past end", "a\nb\n", PatchRequest{StartLine: 5, Content: "x"}, 400, "a\nb\n"},
+		{"stale version", "a\n", PatchRequest{StartLine: 1, Content: "b", Version: "stale"}, 409, "a\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := newTestServer(t, nil)
+			fullPath := writeTestFile(t, dir, "a.txt", tt.original)
+
+			req := tt.req
+			req.Path = "/a.txt"
+			if req.Version == "" {
+				req.Version = "*"
+			}
+			rec := doRequest(s.handlePatch, "POST", "/api/patch?root=0", mustJSON(t, req))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if got := readTestFile(t, fullPath); got != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+			if tt.wantCode == 200 {
+				info, _ := os.Stat(fullPath)
+				if got := rec.Header().Get("ETag"); got != `"`+s.pathVersion(0, fullPath, info)+`"` {
+					t.Errorf("ETag = %s", got)
+				}
+			}
+		})
+	}
+}
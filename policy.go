@@ -0,0 +1,410 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// errTooLarge 文件超过根目录允许的最大大小
+	errTooLarge = errors.New("file too large")
+	// errQuotaExceeded 写入后将超出根目录的配额
+	errQuotaExceeded = errors.New("quota exceeded")
+	// errTypeNotAllowed 文件扩展名或内容类型不被根目录允许
+	errTypeNotAllowed = errors.New("file type not allowed")
+)
+
+const (
+	// 已用空间缓存的有效期，过期后重新统计以纳入其他途径的修改
+	usageCacheTTL = 10 * time.Minute
+	// 上传表单中非文件字段的总大小上限
+	maxFormValueBytes = 10 << 20
+	// 检测内容类型时读取的字节数
+	sniffLen = 512
+)
+
+// UploadPolicy 根目录的写入限制，适用于上传和保存
+type UploadPolicy struct {
+	MaxFileSize       int64    `json:"maxFileSize,omitempty"`       // 单个文件的最大字节数，0 表示不限制
+	Quota             int64    `json:"quota,omitempty"`             // 根目录下所有文件的总字节数上限，0 表示不限制
+	AllowedExtensions []string `json:"allowedExtensions,omitempty"` // 允许的扩展名（如 ".txt"、".tar.gz"），为空表示不限制
+	BlockedExtensions []string `json:"blockedExtensions,omitempty"` // 禁止的扩展名
+	AllowedTypes      []string `json:"allowedTypes,omitempty"`      // 允许的 MIME 类型（按内容检测，支持 "image/*"），为空表示不限制
+	BlockedTypes      []string `json:"blockedTypes,omitempty"`      // 禁止的 MIME 类型
+}
+
+// checkName 检查文件名的扩展名是否被允许
+func (p *UploadPolicy) checkName(name string) error {
+	if p == nil {
+		return nil
+	}
+
+	if len(p.AllowedExtensions) > 0 && !matchExtension(name, p.AllowedExtensions) {
+		return errTypeNotAllowed
+	}
+	if matchExtension(name, p.BlockedExtensions) {
+		return errTypeNotAllowed
+	}
+	return nil
+}
+
+// checkType 根据文件开头的内容检测 MIME 类型并检查是否被允许
+func (p *UploadPolicy) checkType(head []byte) error {
+	if p == nil || (len(p.AllowedTypes) == 0 && len(p.BlockedTypes) == 0) {
+		return nil
+	}
+
+	mimeType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	if len(p.AllowedTypes) > 0 && !matchType(mimeType, p.AllowedTypes) {
+		return errTypeNotAllowed
+	}
+	if matchType(mimeType, p.BlockedTypes) {
+		return errTypeNotAllowed
+	}
+	return nil
+}
+
+// matchExtension 不区分大小写地检查文件名是否以列表中的任一扩展名结尾
+func matchExtension(name string, extensions []string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range extensions {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchType 检查 MIME 类型是否匹配列表中的任一项，"image/*" 匹配所有图片类型
+func matchType(mimeType string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mimeType, prefix+"/") {
+				return true
+			}
+		} else if mimeType == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadPolicy 获取根目录的写入限制，未配置时返回 nil
+func (s *Server) uploadPolicy(rootIndex int) *UploadPolicy {
+	if rootIndex < 0 || rootIndex >= len(s.config.RootDirs) {
+		return nil
+	}
+	return s.config.RootDirs[rootIndex].Upload
+}
+
+// rootUsage 根目录已用空间的缓存
+type rootUsage struct {
+	used       int64
+	computedAt time.Time
+}
+
+// usageTracker 各根目录的已用空间，写入时按字节累加，删除时扣减
+type usageTracker struct {
+	mu    sync.Mutex
+	roots map[int]*rootUsage
+}
+
+// newUsageTracker 创建已用空间缓存
+func newUsageTracker() *usageTracker {
+	return &usageTracker{roots: make(map[int]*rootUsage)}
+}
+
+// diskUsage 获取根目录的已用空间，缓存不存在或过期时重新统计
+func (s *Server) diskUsage(rootIndex int) (int64, error) {
+	s.usage.mu.Lock()
+	usage, ok := s.usage.roots[rootIndex]
+	if ok && time.Since(usage.computedAt) < usageCacheTTL {
+		used := usage.used
+		s.usage.mu.Unlock()
+		return used, nil
+	}
+	s.usage.mu.Unlock()
+
+	used, err := s.walkUsage(rootIndex, s.config.RootDirs[rootIndex].Path)
+	if err != nil {
+		return 0, err
+	}
+
+	s.usage.mu.Lock()
+	s.usage.roots[rootIndex] = &rootUsage{used: used, computedAt: time.Now()}
+	s.usage.mu.Unlock()
+	return used, nil
+}
+
+// walkUsage 统计目录下所有普通文件的总大小
+func (s *Server) walkUsage(rootIndex int, dir string) (int64, error) {
+	if !s.isRemote(rootIndex) {
+		var total int64
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// 无权访问的子目录不计入
+				return nil
+			}
+			if d.Type().IsRegular() {
+				if info, err := d.Info(); err == nil {
+					total += info.Size()
+				}
+			}
+			return nil
+		})
+		return total, err
+	}
+
+	entries, err := s.fs(rootIndex).ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		switch {
+		case entry.IsDir():
+			size, err := s.walkUsage(rootIndex, s.joinPath(rootIndex, dir, entry.Name()))
+			if err == nil {
+				total += size
+			}
+		case entry.Mode().IsRegular():
+			total += entry.Size()
+		}
+	}
+	return total, nil
+}
+
+// reserve 占用 n 字节配额，超出配额时返回 errQuotaExceeded；n 为负数时释放配额
+func (s *Server) reserve(rootIndex int, n int64) error {
+	policy := s.uploadPolicy(rootIndex)
+	if policy == nil || policy.Quota <= 0 || n == 0 {
+		return nil
+	}
+
+	if _, err := s.diskUsage(rootIndex); err != nil {
+		return err
+	}
+
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+
+	usage, ok := s.usage.roots[rootIndex]
+	if !ok {
+		return nil
+	}
+	if n > 0 && usage.used+n > policy.Quota {
+		return errQuotaExceeded
+	}
+	usage.used += n
+	if usage.used < 0 {
+		usage.used = 0
+	}
+	return nil
+}
+
+// addUsage 记录不受配额限制的空间变化（如历史版本），使已用空间保持准确
+func (s *Server) addUsage(rootIndex int, n int64) {
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+
+	if usage, ok := s.usage.roots[rootIndex]; ok {
+		usage.used = max(usage.used+n, 0)
+	}
+}
+
+// invalidateUsage 丢弃根目录的已用空间缓存，下次使用时重新统计
+func (s *Server) invalidateUsage(rootIndex int) {
+	s.usage.mu.Lock()
+	delete(s.usage.roots, rootIndex)
+	s.usage.mu.Unlock()
+}
+
+// limitedWriter 写入时检查单个文件的大小上限并占用配额，超出时立即返回错误
+type limitedWriter struct {
+	w         io.Writer
+	s         *Server
+	rootIndex int
+	maxSize   int64 // 0 表示不限制
+	written   int64 // 已写入并占用配额的字节数
+	head      []byte
+}
+
+// newLimitedWriter 按根目录的写入限制创建写入器
+func (s *Server) newLimitedWriter(w io.Writer, rootIndex int) *limitedWriter {
+	lw := &limitedWriter{w: w, s: s, rootIndex: rootIndex}
+	if policy := s.uploadPolicy(rootIndex); policy != nil {
+		lw.maxSize = policy.MaxFileSize
+	}
+	return lw
+}
+
+// Write 写入数据
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if lw.maxSize > 0 && lw.written+int64(len(p)) > lw.maxSize {
+		return 0, errTooLarge
+	}
+	if err := lw.s.reserve(lw.rootIndex, int64(len(p))); err != nil {
+		return 0, err
+	}
+
+	// 保留开头的内容用于检测类型
+	if len(lw.head) < sniffLen {
+		lw.head = append(lw.head, p[:min(len(p), sniffLen-len(lw.head))]...)
+	}
+
+	n, err := lw.w.Write(p)
+	lw.written += int64(n)
+	if n < len(p) {
+		lw.s.reserve(lw.rootIndex, int64(n-len(p)))
+	}
+	return n, err
+}
+
+// release 释放已占用的配额（写入失败或文件被丢弃时调用）
+func (lw *limitedWriter) release() {
+	lw.s.reserve(lw.rootIndex, -lw.written)
+	lw.written = 0
+}
+
+// spooledFile 上传表单中暂存到临时文件的文件
+type spooledFile struct {
+	filename string // 客户端提供的文件名
+	tmpPath  string // 临时文件路径
+	size     int64
+	head     []byte // 文件开头的内容，用于检测类型
+}
+
+// Open 打开暂存的文件
+func (f *spooledFile) Open() (io.ReadCloser, error) {
+	return os.Open(f.tmpPath)
+}
+
+// uploadForm 逐段读取的上传表单
+type uploadForm struct {
+	values map[string][]string
+	files  []*spooledFile
+}
+
+// value 获取表单字段的第一个值
+func (f *uploadForm) value(key string) string {
+	if values := f.values[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// remove 删除所有暂存的临时文件
+func (f *uploadForm) remove() {
+	for _, file := range f.files {
+		os.Remove(file.tmpPath)
+	}
+}
+
+// readUploadForm 逐段读取上传表单，文件边读边检查大小和配额，超出时立即停止读取
+// 读到第一个文件前调用 beforeFiles 检查已读取的字段，返回错误时不暂存任何文件
+// 暂存的文件已占用配额，调用方需对未写入根目录的文件调用 release
+func (s *Server) readUploadForm(r *http.Request, rootIndex int, beforeFiles func(form *uploadForm) error) (*uploadForm, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	form := &uploadForm{values: make(map[string][]string)}
+	valueBytes := 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			s.discardUploadForm(form, rootIndex)
+			return nil, err
+		}
+
+		if part.FileName() == "" {
+			// 普通字段
+			data, err := io.ReadAll(io.LimitReader(part, int64(maxFormValueBytes-valueBytes+1)))
+			valueBytes += len(data)
+			if err == nil && valueBytes > maxFormValueBytes {
+				err = multipart.ErrMessageTooLarge
+			}
+			if err != nil {
+				s.discardUploadForm(form, rootIndex)
+				return nil, err
+			}
+			form.values[part.FormName()] = append(form.values[part.FormName()], string(data))
+			continue
+		}
+
+		if part.FormName() != "file" {
+			continue
+		}
+
+		if len(form.files) == 0 && beforeFiles != nil {
+			if err := beforeFiles(form); err != nil {
+				return nil, err
+			}
+		}
+
+		file, err := s.spoolPart(part, rootIndex)
+		if err != nil {
+			s.discardUploadForm(form, rootIndex)
+			return nil, err
+		}
+		form.files = append(form.files, file)
+	}
+}
+
+// spoolPart 将文件段写入根目录暂存目录中的临时文件
+// 暂存的数据与可续传上传一样占用根目录所在的磁盘，边写边检查大小和配额；异常退出后遗留的文件在启动时清理
+func (s *Server) spoolPart(part *multipart.Part, rootIndex int) (*spooledFile, error) {
+	tmp, err := s.createPart(rootIndex, 0600)
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := tmp.Name()
+
+	lw := s.newLimitedWriter(tmp, rootIndex)
+	_, err = io.Copy(lw, part)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		lw.release()
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	return &spooledFile{filename: part.FileName(), tmpPath: tmpPath, size: lw.written, head: lw.head}, nil
+}
+
+// discardUploadForm 删除暂存的文件并释放占用的配额
+func (s *Server) discardUploadForm(form *uploadForm, rootIndex int) {
+	for _, file := range form.files {
+		s.reserve(rootIndex, -file.size)
+	}
+	form.remove()
+}
+
+// checkFileSize 检查文件大小是否超过根目录的上限
+func (s *Server) checkFileSize(rootIndex int, size int64) error {
+	if policy := s.uploadPolicy(rootIndex); policy != nil && policy.MaxFileSize > 0 && size > policy.MaxFileSize {
+		return fmt.Errorf("%w: maximum is %d bytes", errTooLarge, policy.MaxFileSize)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadPolicyCheckName(t *testing.T) {
+	policy := &UploadPolicy{
+		AllowedExtensions: []string{".txt", "tar.gz", ".PNG"},
+		BlockedExtensions: []string{".secret.txt"},
+	}
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"a.txt", false},
+		{"A.TXT", false},
+		{"backup.tar.gz", false},
+		{"image.png", false},
+		{"a.gz", true},
+		{"a.exe", true},
+		{"noext", true},
+		{"keys.secret.txt", true},
+	}
+	for _, tt := range tests {
+		if err := policy.checkName(tt.name); (err != nil) != tt.wantErr {
+			t.Errorf("checkName(%q) = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	var none *UploadPolicy
+	if err := none.checkName("a.exe"); err != nil {
+		t.Errorf("nil policy should allow everything: %v", err)
+	}
+}
+
+func TestUploadPolicyCheckType(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00", 16)
+	tests := []struct {
+		name    string
+		policy  UploadPolicy
+		head    string
+		wantErr bool
+	}{
+		{"allowed wildcard", UploadPolicy{AllowedTypes: []string{"image/*"}}, png, false},
+		{"not in allowed", UploadPolicy{AllowedTypes: []string{"image/*"}}, "plain text", true},
+		{"exact allowed", UploadPolicy{AllowedTypes: []string{"text/plain"}}, "plain text", false},
+		{"blocked", UploadPolicy{BlockedTypes: []string{"text/html"}}, "<html><body>x</body></html>", true},
+		{"not blocked", UploadPolicy{BlockedTypes: []string{"text/html"}}, "plain text", false},
+		{"no type rules", UploadPolicy{AllowedExtensions: []string{".txt"}}, "<html></html>", false},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.checkType([]byte(tt.head)); (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkType = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   UploadPolicy
+		existing string
+		content  string
+		wantCode int
+	}{
+		{"within limits", UploadPolicy{MaxFileSize: 10, Quota: 20}, "12345", "1234567890", 200},
+		{"file too large", UploadPolicy{MaxFileSize: 10}, "", "12345678901", 413},
+		{"quota exceeded", UploadPolicy{Quota: 20}, "1234567890", "12345678901", 413},
+		{"extension blocked", UploadPolicy{BlockedExtensions: []string{".txt"}}, "", "x", 415},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := tt.policy
+			s, dir := newTestServer(t, func(config *Config) { config.RootDirs[0].Upload = &policy })
+			if tt.existing != "" {
+				writeTestFile(t, dir, "existing.bin", tt.existing)
+			}
+
+			rec := uploadTestFiles(t, s, nil, testUploadFile{filename: "new.txt", content: tt.content})
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+
+			// 暂存文件在根目录内，请求结束后应被删除
+			if matches, _ := filepath.Glob(filepath.Join(dir, ".upload.*.part")); len(matches) != 0 {
+				t.Errorf("spooled files left: %v", matches)
+			}
+
+			// 失败的上传应释放占用的配额
+			used, err := s.diskUsage(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := int64(len(tt.existing))
+			if tt.wantCode == 200 {
+				want += int64(len(tt.content))
+			}
+			if policy.Quota > 0 && used != want {
+				t.Errorf("used = %d, want %d", used, want)
+			}
+		})
+	}
+}
+
+func TestPackQuota(t *testing.T) {
+	s, dir := newTestServer(t, func(config *Config) { config.RootDirs[0].Upload = &UploadPolicy{Quota: 1100} })
+	writeTestFile(t, dir, "src/data.bin", strings.Repeat("x", 1000))
+
+	// 不压缩时归档大于剩余的 100 字节配额
+	level := 0
+	info := packTestArchive(t, s, PackRequest{Paths: []string{"/src"}, Target: "/out.zip", Level: &level})
+	if info.Status != JobFailed || !strings.Contains(info.Error, errQuotaExceeded.Error()) {
+		t.Errorf("job = %s %q, want quota failure", info.Status, info.Error)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".out.zip.*")); len(matches) != 0 {
+		t.Errorf("temporary files left: %v", matches)
+	}
+	if used, err := s.diskUsage(0); err != nil || used != 1000 {
+		t.Errorf("used = %d, %v; want 1000", used, err)
+	}
+}
@@ -0,0 +1,649 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 可续传上传协议（兼容 tus.io 1.0.0 核心协议及 creation、termination、expiration 扩展）
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	// 上传超过该时间没有新数据即视为放弃
+	uploadExpiry = 24 * time.Hour
+	// 清理过期上传的间隔
+	uploadSweepInterval = 10 * time.Minute
+)
+
+// resumableUpload 进行中的可续传上传
+type resumableUpload struct {
+	mu        sync.Mutex
+	id        string
+	rootIndex int
+	partPath  string // 上传中的临时文件（位于根目录的暂存目录内）
+	finalPath string // 完成后的文件路径
+	length    int64  // 文件总大小
+	offset    int64  // 已接收的字节数
+	overwrite string // 目标已存在时的处理方式：fail、overwrite、rename
+	modTime   time.Time
+	done      bool // 已完成（保留登记以便客户端查询最终状态）
+	expires   time.Time
+}
+
+// uploadRegistry 可续传上传登记表
+type uploadRegistry struct {
+	mu      sync.Mutex
+	uploads map[string]*resumableUpload
+	expired func(upload *resumableUpload) // 未完成的上传过期后调用，用于释放配额
+}
+
+// newUploadRegistry 创建登记表，并在后台定期清理过期的上传
+func newUploadRegistry(expired func(upload *resumableUpload)) *uploadRegistry {
+	reg := &uploadRegistry{uploads: make(map[string]*resumableUpload), expired: expired}
+	go func() {
+		for range time.Tick(uploadSweepInterval) {
+			reg.sweep()
+		}
+	}()
+	return reg
+}
+
+// get 获取上传
+func (reg *uploadRegistry) get(id string) (*resumableUpload, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	upload, ok := reg.uploads[id]
+	return upload, ok
+}
+
+// add 登记上传
+func (reg *uploadRegistry) add(upload *resumableUpload) {
+	reg.mu.Lock()
+	reg.uploads[upload.id] = upload
+	reg.mu.Unlock()
+}
+
+// remove 移除上传登记
+func (reg *uploadRegistry) remove(id string) {
+	reg.mu.Lock()
+	delete(reg.uploads, id)
+	reg.mu.Unlock()
+}
+
+// sweep 删除过期上传的临时文件
+func (reg *uploadRegistry) sweep() {
+	reg.mu.Lock()
+	var expired []*resumableUpload
+	for id, upload := range reg.uploads {
+		if upload.mu.TryLock() {
+			if time.Now().After(upload.expires) {
+				expired = append(expired, upload)
+				delete(reg.uploads, id)
+			}
+			upload.mu.Unlock()
+		}
+	}
+	reg.mu.Unlock()
+
+	for _, upload := range expired {
+		if upload.done {
+			continue
+		}
+		os.Remove(upload.partPath)
+		reg.expired(upload)
+		log.Printf("Expired abandoned upload %s (%s)", upload.id, upload.finalPath)
+	}
+}
+
+// defaultStagingDir 默认的上传暂存目录（相对于根目录），与历史版本目录一样不出现在文件列表中，也不能通过普通接口访问
+const defaultStagingDir = ".uploads"
+
+// partNamePattern 暂存目录中上传临时文件的名称：<上传 ID>.part
+var partNamePattern = regexp.MustCompile(`^[0-9a-f]{16}\.part$`)
+
+// stagingDir 获取根目录的上传暂存目录，可以通过根目录的 staging 配置移到其他位置
+func (s *Server) stagingDir(rootIndex int) string {
+	root := s.config.RootDirs[rootIndex]
+	dir := root.Staging
+	if dir == "" {
+		dir = defaultStagingDir
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root.Path, dir)
+	}
+	return filepath.Clean(dir)
+}
+
+// inStagingDir 判断路径是否位于本地根目录的上传暂存目录内
+func (s *Server) inStagingDir(path string, rootIndex int) bool {
+	if s.isRemote(rootIndex) {
+		return false
+	}
+	rel, err := filepath.Rel(s.stagingDir(rootIndex), path)
+	return err == nil && !strings.HasPrefix(rel, "..")
+}
+
+// isInternalPath 判断路径是否位于历史版本目录或上传暂存目录内，这些目录只能由服务器自身访问
+func (s *Server) isInternalPath(path string, rootIndex int) bool {
+	return s.inHistoryDir(path, rootIndex) || s.inStagingDir(path, rootIndex)
+}
+
+// createPart 在根目录的暂存目录中创建新的上传临时文件
+func (s *Server) createPart(rootIndex int, perm os.FileMode) (*os.File, error) {
+	dir := s.stagingDir(rootIndex)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(filepath.Join(dir, randomID()+".part"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+}
+
+// sweepOrphanedParts 删除本地根目录暂存目录中上次运行遗留的上传临时文件
+// 登记表只保存在内存中，重启后这些上传无法继续；只删除 before 之前修改的文件，不影响启动后新建的上传
+func (s *Server) sweepOrphanedParts(before time.Time) {
+	for i, root := range s.config.RootDirs {
+		if s.isRemote(i) {
+			continue
+		}
+
+		dir := s.stagingDir(i)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		removed := 0
+		for _, d := range entries {
+			if !d.Type().IsRegular() || !partNamePattern.MatchString(d.Name()) {
+				continue
+			}
+			if info, err := d.Info(); err != nil || !info.ModTime().Before(before) {
+				continue
+			}
+			if os.Remove(filepath.Join(dir, d.Name())) == nil {
+				removed++
+			}
+		}
+
+		if removed > 0 {
+			s.invalidateUsage(i)
+			log.Printf("Removed %d orphaned upload files in %s", removed, root.Name)
+		}
+	}
+}
+
+// parseUploadMetadata 解析 Upload-Metadata 头（逗号分隔的 "key base64值" 对）
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata: %s", key)
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
+
+// handleResumableUploads 处理可续传上传的创建请求（POST）和能力查询（OPTIONS）
+func (s *Server) handleResumableUploads(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		s.createUpload(w, r)
+	default:
+		s.handleError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+	}
+}
+
+// createUpload 创建上传，Upload-Metadata 中需包含 filename，可选 path（目标目录）、relativePath（文件夹内的相对路径）、
+// overwrite（目标已存在时的处理方式）和 lastModified（客户端文件的修改时间，毫秒时间戳）
+func (s *Server) createUpload(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		s.handleError(w, fmt.Errorf("invalid Upload-Length"), http.StatusBadRequest)
+		return
+	}
+
+	meta, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	filename := meta["filename"]
+	if filename == "" {
+		s.handleError(w, fmt.Errorf("filename metadata is required"), http.StatusBadRequest)
+		return
+	}
+
+	path := meta["path"]
+	if path == "" {
+		path = "/"
+	}
+
+	// 分块上传无法跳过，只支持失败、覆盖和重命名
+	overwrite := meta["overwrite"]
+	if overwrite == "" {
+		overwrite = OverwriteFail
+	}
+	if overwrite != OverwriteFail && overwrite != OverwriteReplace && overwrite != OverwriteRename {
+		s.handleError(w, fmt.Errorf("invalid overwrite mode: %s", overwrite), http.StatusBadRequest)
+		return
+	}
+
+	var modTime time.Time
+	if value := meta["lastModified"]; value != "" {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			s.handleError(w, fmt.Errorf("invalid lastModified: %s", value), http.StatusBadRequest)
+			return
+		}
+		modTime = time.UnixMilli(ms)
+	}
+
+	rootIndex := getRootIndex(r)
+
+	// 远程根目录不支持该操作
+	if s.isRemote(rootIndex) {
+		s.handleError(w, errRemoteUnsupported, http.StatusNotImplemented)
+		return
+	}
+
+	// 构建目标目录的完整路径
+	dirPath := s.getFullPath(path, rootIndex)
+
+	// 检查路径是否在根目录内
+	if !s.isPathSafe(dirPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	// 文件名只取最后一段，不能是空、. 或 ..（否则目标会变成目录本身）
+	segments, err := splitRelativePath(filepath.Base(filename))
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+	name := segments[len(segments)-1]
+
+	// 上传文件夹时 relativePath 为文件在文件夹内的相对路径，按需创建中间目录
+	if relative := meta["relativePath"]; relative != "" {
+		segments, err := splitRelativePath(relative)
+		if err != nil {
+			s.handleError(w, err, http.StatusBadRequest)
+			return
+		}
+		for _, segment := range segments[:len(segments)-1] {
+			dirPath = filepath.Join(dirPath, segment)
+			if err := s.ensureUploadDir(rootIndex, dirPath); err != nil {
+				s.handleError(w, err, errorStatus(err))
+				return
+			}
+		}
+		name = segments[len(segments)-1]
+	}
+
+	// 构建目标文件的完整路径
+	fullPath := filepath.Join(dirPath, name)
+
+	// 再次检查完整路径是否在根目录内
+	if !s.isPathSafe(fullPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	// 检查扩展名和大小是否符合根目录的写入限制
+	if err := s.uploadPolicy(rootIndex).checkName(name); err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+	if err := s.checkFileSize(rootIndex, length); err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+
+	// 检查文件是否已存在
+	if info, err := os.Lstat(fullPath); err == nil {
+		switch {
+		case overwrite == OverwriteRename:
+			fullPath = freeName(fullPath)
+			if !s.isPathSafe(fullPath, rootIndex) {
+				s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+				return
+			}
+		case info.IsDir():
+			s.handleError(w, fmt.Errorf("a directory with the same name already exists"), http.StatusConflict)
+			return
+		case overwrite == OverwriteFail:
+			s.handleError(w, fmt.Errorf("file already exists"), http.StatusConflict)
+			return
+		}
+	}
+
+	// 创建时按总大小占用配额，上传放弃或失败时释放
+	if err := s.reserve(rootIndex, length); err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+
+	// 在暂存目录中创建临时文件，文件名中的 ID 即上传 ID
+	part, err := s.createPart(rootIndex, 0644)
+	if err != nil {
+		s.reserve(rootIndex, -length)
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	partPath := part.Name()
+	id := strings.TrimSuffix(filepath.Base(partPath), ".part")
+	part.Close()
+
+	upload := &resumableUpload{
+		id:        id,
+		rootIndex: rootIndex,
+		partPath:  partPath,
+		finalPath: fullPath,
+		length:    length,
+		overwrite: overwrite,
+		modTime:   modTime,
+		expires:   time.Now().Add(uploadExpiry),
+	}
+
+	// 空文件直接完成
+	if length == 0 {
+		if err := s.finishUpload(upload); err != nil {
+			s.handleError(w, err, errorStatus(err))
+			return
+		}
+	}
+	s.uploads.add(upload)
+
+	// 重命名后的文件名通过响应头告知客户端
+	w.Header().Set("Upload-Saved-As", filepath.Base(fullPath))
+	w.Header().Set("Location", "/api/uploads/"+id)
+	w.Header().Set("Upload-Expires", upload.expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleResumableUpload 处理单个上传的进度查询（HEAD）、数据追加（PATCH）和终止（DELETE）
+func (s *Server) handleResumableUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/uploads/")
+	upload, ok := s.uploads.get(id)
+	if !ok {
+		s.handleError(w, fmt.Errorf("upload not found"), http.StatusNotFound)
+		return
+	}
+
+	// 同一上传不允许并发写入
+	if !upload.mu.TryLock() {
+		s.handleError(w, fmt.Errorf("upload is in use"), http.StatusLocked)
+		return
+	}
+	defer upload.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.length, 10))
+		w.Header().Set("Upload-Expires", upload.expires.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch:
+		s.patchUpload(w, r, upload)
+	case http.MethodDelete:
+		s.uploads.remove(upload.id)
+		if !upload.done {
+			os.Remove(upload.partPath)
+			s.reserve(upload.rootIndex, -upload.length)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.handleError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+	}
+}
+
+// patchUpload 在指定偏移处追加数据，收齐后将临时文件重命名为目标文件
+func (s *Server) patchUpload(w http.ResponseWriter, r *http.Request, upload *resumableUpload) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		s.handleError(w, fmt.Errorf("content type must be application/offset+octet-stream"), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		s.handleError(w, fmt.Errorf("invalid Upload-Offset"), http.StatusBadRequest)
+		return
+	}
+	if offset != upload.offset {
+		s.handleError(w, fmt.Errorf("offset mismatch: expected %d", upload.offset), http.StatusConflict)
+		return
+	}
+
+	// 已完成的上传没有可写入的数据
+	if upload.done {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	part, err := os.OpenFile(upload.partPath, os.O_WRONLY, 0)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// 连接中断时已写入的部分仍然有效，客户端可通过 HEAD 查询后继续
+	if _, err = part.Seek(offset, io.SeekStart); err == nil {
+		var n int64
+		n, err = io.Copy(part, io.LimitReader(r.Body, upload.length-offset))
+		upload.offset += n
+	}
+	if closeErr := part.Close(); err == nil {
+		err = closeErr
+	}
+	upload.expires = time.Now().Add(uploadExpiry)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if upload.offset == upload.length && !upload.done {
+		if err := s.finishUpload(upload); err != nil {
+			s.uploads.remove(upload.id)
+			s.handleError(w, err, errorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.offset, 10))
+	w.Header().Set("Upload-Expires", upload.expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishUpload 检查内容类型，同步临时文件并原子地重命名为目标文件，失败时删除临时文件并释放配额
+func (s *Server) finishUpload(upload *resumableUpload) error {
+	part, err := os.OpenFile(upload.partPath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+
+	head := make([]byte, sniffLen)
+	n, err := part.ReadAt(head, 0)
+	if err == io.EOF {
+		err = nil
+	}
+	if err == nil {
+		err = s.uploadPolicy(upload.rootIndex).checkType(head[:n])
+	}
+	if err == nil {
+		err = part.Sync()
+	}
+	if closeErr := part.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil && !upload.modTime.IsZero() {
+		err = os.Chtimes(upload.partPath, upload.modTime, upload.modTime)
+	}
+	var finalPath string
+	if err == nil {
+		finalPath, err = s.commitFile(upload.rootIndex, upload.partPath, upload.finalPath, upload.overwrite)
+	}
+	if err != nil {
+		os.Remove(upload.partPath)
+		s.reserve(upload.rootIndex, -upload.length)
+		return err
+	}
+
+	upload.finalPath = finalPath
+	upload.done = true
+	return nil
+}
+
+// commitFile 将写好的临时文件发布为目标文件，返回实际使用的路径
+// 目标在写入期间可能已被创建，按覆盖方式处理：fail 返回错误，rename 改用带序号的名称，overwrite 保留原文件权限后替换
+// 不覆盖时以独占方式发布，检查之后才出现的同名文件也不会被覆盖
+func (s *Server) commitFile(rootIndex int, tmpPath, finalPath, overwrite string) (string, error) {
+	// 覆盖已有文件时与保存串行化，避免在保存的版本检查和写入之间替换文件
+	if overwrite == OverwriteReplace {
+		s.saveMu.Lock()
+		defer s.saveMu.Unlock()
+	}
+
+	if info, err := os.Lstat(finalPath); err == nil && overwrite == OverwriteReplace && !info.IsDir() {
+		// 与保存一样替换符号链接指向的文件，保留该文件的权限
+		target, info, err := s.replaceTarget(rootIndex, finalPath, info)
+		if err != nil {
+			return "", err
+		}
+		if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+			return "", err
+		}
+		// 覆盖的内容须符合文件对应的 JSON Schema
+		if err := s.validateLocalFile(rootIndex, finalPath, tmpPath); err != nil {
+			return "", err
+		}
+		if err := s.recordHistory(rootIndex, target, info); err != nil {
+			return "", err
+		}
+		if err := moveFile(tmpPath, target); err != nil {
+			return "", err
+		}
+		// 被替换的文件不再占用配额
+		s.reserve(rootIndex, -info.Size())
+		return finalPath, nil
+	}
+
+	candidate := finalPath
+	for n := 1; ; n++ {
+		if !s.isPathSafe(candidate, rootIndex) {
+			return "", os.ErrPermission
+		}
+		err := publishFile(tmpPath, candidate)
+		if err == nil {
+			return candidate, nil
+		}
+		if !os.IsExist(err) || overwrite != OverwriteRename {
+			return "", err
+		}
+		candidate = numberedName(finalPath, n)
+	}
+}
+
+// moveFile 将临时文件重命名为目标文件；暂存目录与目标目录不在同一文件系统时（如目标位于挂载点下），复制后再删除临时文件
+func moveFile(tmpPath, finalPath string) error {
+	err := os.Rename(tmpPath, finalPath)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	src, err := os.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+	info, err := src.Stat()
+	if err == nil {
+		err = replaceFile(finalPath, src, info.Mode().Perm(), info.ModTime())
+	}
+	src.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(tmpPath)
+}
+
+// publishFile 以独占方式将临时文件发布为目标文件，目标已存在时返回 os.ErrExist 而不是覆盖
+// 先建立硬链接再删除临时文件；不能建立硬链接时（如跨文件系统）以 O_EXCL 创建目标后复制
+func publishFile(tmpPath, finalPath string) error {
+	err := os.Link(tmpPath, finalPath)
+	if err == nil {
+		return os.Remove(tmpPath)
+	}
+	if os.IsExist(err) {
+		return err
+	}
+
+	src, err := os.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(finalPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(finalPath, info.ModTime(), info.ModTime())
+	}
+	if err != nil {
+		os.Remove(finalPath)
+		return err
+	}
+	return os.Remove(tmpPath)
+}
+
+// freeName 返回第一个不存在的带序号文件名
+func freeName(fullPath string) string {
+	for n := 1; ; n++ {
+		candidate := numberedName(fullPath, n)
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// uploadMetadata 编码 Upload-Metadata 头
+func uploadMetadata(pairs ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+" "+base64.StdEncoding.EncodeToString([]byte(pairs[i+1])))
+	}
+	return strings.Join(parts, ",")
+}
+
+// createTestUpload 发送创建可续传上传的请求
+func createTestUpload(t *testing.T, s *Server, length int, metadata string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/uploads?root=0", nil)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", strconv.Itoa(length))
+	req.Header.Set("Upload-Metadata", metadata)
+	rec := httptest.NewRecorder()
+	s.handleResumableUploads(rec, req)
+	return rec
+}
+
+// patchTestUpload 在指定偏移处追加数据
+func patchTestUpload(s *Server, location string, offset int, data string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PATCH", location, strings.NewReader(data))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	rec := httptest.NewRecorder()
+	s.handleResumableUpload(rec, req)
+	return rec
+}
+
+func TestParseUploadMetadata(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"filename YS50eHQ=", map[string]string{"filename": "a.txt"}, false},
+		{"filename YS50eHQ=, path Lw==,empty", map[string]string{"filename": "a.txt", "path": "/", "empty": ""}, false},
+		{"filename !!!", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseUploadMetadata(tt.header)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseUploadMetadata(%q) error = %v", tt.header, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseUploadMetadata(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+		for key, value := range tt.want {
+			if got[key] != value {
+				t.Errorf("parseUploadMetadata(%q)[%s] = %q, want %q", tt.header, key, got[key], value)
+			}
+		}
+	}
+}
+
+func TestResumableUploadOffsets(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rec := createTestUpload(t, s, 10, uploadMetadata("filename", "data.txt", "path", "/sub/..", "lastModified", strconv.FormatInt(modTime.UnixMilli(), 10)))
+	if rec.Code != 201 {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+	id := strings.TrimPrefix(location, "/api/uploads/")
+	partPath := filepath.Join(dir, defaultStagingDir, id+".part")
+	if _, err := os.Stat(partPath); err != nil {
+		t.Fatalf("part file: %v", err)
+	}
+
+	steps := []struct {
+		name       string
+		offset     int
+		data       string
+		wantCode   int
+		wantOffset string
+	}{
+		{"first chunk", 0, "abcd", 204, "4"},
+		{"stale offset", 0, "abcd", 409, ""},
+		{"offset ahead", 6, "gh", 409, ""},
+		{"second chunk", 4, "efg", 204, "7"},
+		{"extra data is cut at length", 7, "hijKLM", 204, "10"},
+		{"already complete", 10, "", 204, "10"},
+	}
+	for _, step := range steps {
+		rec := patchTestUpload(s, location, step.offset, step.data)
+		if rec.Code != step.wantCode {
+			t.Fatalf("%s: status = %d, want %d: %s", step.name, rec.Code, step.wantCode, rec.Body.String())
+		}
+		if step.wantOffset != "" && rec.Header().Get("Upload-Offset") != step.wantOffset {
+			t.Errorf("%s: Upload-Offset = %s, want %s", step.name, rec.Header().Get("Upload-Offset"), step.wantOffset)
+		}
+	}
+
+	head := httptest.NewRecorder()
+	s.handleResumableUpload(head, httptest.NewRequest("HEAD", location, nil))
+	if head.Header().Get("Upload-Offset") != "10" || head.Header().Get("Upload-Length") != "10" {
+		t.Errorf("HEAD offset=%s length=%s", head.Header().Get("Upload-Offset"), head.Header().Get("Upload-Length"))
+	}
+
+	fullPath := filepath.Join(dir, "data.txt")
+	if got := readTestFile(t, fullPath); got != "abcdefghij" {
+		t.Errorf("content = %q", got)
+	}
+	if info, err := os.Stat(fullPath); err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("modTime = %v, want %v", info.ModTime(), modTime)
+	}
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Errorf("part file still exists")
+	}
+}
+
+func TestResumableUploadCreate(t *testing.T) {
+	tests := []struct {
+		name      string
+		length    int
+		metadata  string
+		wantCode  int
+		wantSaved string
+	}{
+		{"empty file completes immediately", 0, uploadMetadata("filename", "empty.txt"), 201, "empty.txt"},
+		{"missing filename", 1, uploadMetadata("path", "/"), 400, ""},
+		{"exists fails by default", 1, uploadMetadata("filename", "a.txt"), 409, ""},
+		{"exists renamed", 1, uploadMetadata("filename", "a.txt", "overwrite", OverwriteRename), 201, "a (1).txt"},
+		{"exists overwritten", 1, uploadMetadata("filename", "a.txt", "overwrite", OverwriteReplace), 201, "a.txt"},
+		{"skip is not supported", 1, uploadMetadata("filename", "a.txt", "overwrite", OverwriteSkip), 400, ""},
+		{"outside root", 1, uploadMetadata("filename", "x.txt", "path", "/../.."), 403, ""},
+		{"folder upload", 1, uploadMetadata("filename", "x.txt", "relativePath", "dir/sub/x.txt"), 201, "x.txt"},
+		{"folder upload escaping", 1, uploadMetadata("filename", "x.txt", "relativePath", "../x.txt"), 400, ""},
+		{"dot file name", 1, uploadMetadata("filename", ".", "overwrite", OverwriteRename), 400, ""},
+		{"dot dot file name", 1, uploadMetadata("filename", "..", "overwrite", OverwriteRename), 400, ""},
+		{"root file name", 1, uploadMetadata("filename", "/", "overwrite", OverwriteRename), 400, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, dir := newTestServer(t, nil)
+			writeTestFile(t, dir, "a.txt", "old")
+
+			rec := createTestUpload(t, s, tt.length, tt.metadata)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if got := rec.Header().Get("Upload-Saved-As"); got != tt.wantSaved {
+				t.Errorf("Upload-Saved-As = %q, want %q", got, tt.wantSaved)
+			}
+		})
+	}
+}
+
+func TestResumableUploadTerminate(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	rec := createTestUpload(t, s, 10, uploadMetadata("filename", "data.txt"))
+	location := rec.Header().Get("Location")
+	patchTestUpload(s, location, 0, "abc")
+
+	del := httptest.NewRecorder()
+	s.handleResumableUpload(del, httptest.NewRequest("DELETE", location, nil))
+	if del.Code != 204 {
+		t.Fatalf("DELETE status = %d", del.Code)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, defaultStagingDir, "*.part")); len(matches) != 0 {
+		t.Errorf("part files left: %v", matches)
+	}
+	if rec := patchTestUpload(s, location, 3, "def"); rec.Code != 404 {
+		t.Errorf("PATCH after DELETE status = %d, want 404", rec.Code)
+	}
+}
+
+func TestSweepOrphanedParts(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	old := time.Now().Add(-time.Hour)
+	files := []struct {
+		name     string
+		modTime  time.Time
+		wantKept bool
+	}{
+		{".uploads/0123456789abcdef.part", old, false},
+		{".uploads/fedcba9876543210.part", time.Now().Add(time.Hour), true},
+		{".uploads/notes.part", old, true},
+		{".uploads/sub/0123456789abcdef.part", old, true},
+		{"0123456789abcdef.part", old, true},
+		{".data.txt.0123456789abcdef.part", old, true},
+	}
+	for _, f := range files {
+		fullPath := writeTestFile(t, dir, f.name, "x")
+		if err := os.Chtimes(fullPath, f.modTime, f.modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.sweepOrphanedParts(time.Now())
+	for _, f := range files {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f.name)))
+		if (err == nil) != f.wantKept {
+			t.Errorf("%s kept = %v, want %v", f.name, err == nil, f.wantKept)
+		}
+	}
+}
+
+func TestStagingDirHidden(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	rec := createTestUpload(t, s, 10, uploadMetadata("filename", "data.txt"))
+	if rec.Code != 201 {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, defaultStagingDir)); err != nil {
+		t.Fatalf("staging dir: %v", err)
+	}
+
+	var items []FileItem
+	decodeResponse(t, doRequest(s.handleList, "GET", "/api/list?root=0&path=/", ""), 200, &items)
+	for _, item := range items {
+		if item.Name == defaultStagingDir {
+			t.Errorf("staging dir listed: %+v", item)
+		}
+	}
+	if rec := doRequest(s.handleList, "GET", "/api/list?root=0&path=/"+defaultStagingDir, ""); rec.Code != 403 {
+		t.Errorf("list staging status = %d, want 403", rec.Code)
+	}
+}
+
+func TestStagingDirConfigured(t *testing.T) {
+	staging := t.TempDir()
+	s, dir := newTestServer(t, func(config *Config) {
+		config.RootDirs[0].Staging = staging
+	})
+
+	// 暂存目录移到根目录之外后，根目录中同名的目录是普通目录
+	old := time.Now().Add(-time.Hour)
+	userPart := writeTestFile(t, dir, ".uploads/0123456789abcdef.part", "user data")
+	if err := os.Chtimes(userPart, old, old); err != nil {
+		t.Fatal(err)
+	}
+	s.sweepOrphanedParts(time.Now())
+	if got := readTestFile(t, userPart); got != "user data" {
+		t.Errorf("user file = %q", got)
+	}
+	var items []FileItem
+	decodeResponse(t, doRequest(s.handleList, "GET", "/api/list?root=0&path=/.uploads", ""), 200, &items)
+	if len(items) != 1 {
+		t.Errorf("items = %+v", items)
+	}
+
+	rec := createTestUpload(t, s, 4, uploadMetadata("filename", "data.txt"))
+	if rec.Code != 201 {
+		t.Fatalf("create status = %d: %s", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+	partPath := filepath.Join(staging, strings.TrimPrefix(location, "/api/uploads/")+".part")
+	if _, err := os.Stat(partPath); err != nil {
+		t.Fatalf("part file: %v", err)
+	}
+	if rec := patchTestUpload(s, location, 0, "abcd"); rec.Code != 204 {
+		t.Fatalf("patch status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := readTestFile(t, filepath.Join(dir, "data.txt")); got != "abcd" {
+		t.Errorf("uploaded = %q", got)
+	}
+}
+
+func TestCommitFile(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	writeTemp := func(content string) string {
+		t.Helper()
+		part, err := s.createPart(0, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.WriteString(content)
+		part.Close()
+		return part.Name()
+	}
+
+	// 检查之后才出现的文件不会被覆盖
+	existing := writeTestFile(t, dir, "a.txt", "theirs")
+	tmpPath := writeTemp("ours")
+	if _, err := s.commitFile(0, tmpPath, existing, OverwriteFail); !os.IsExist(err) {
+		t.Errorf("fail err = %v", err)
+	}
+	if got := readTestFile(t, existing); got != "theirs" {
+		t.Errorf("content after fail = %q", got)
+	}
+
+	// rename 跳过所有已被占用的名称
+	writeTestFile(t, dir, "a (1).txt", "taken")
+	finalPath, err := s.commitFile(0, tmpPath, existing, OverwriteRename)
+	if err != nil || filepath.Base(finalPath) != "a (2).txt" || readTestFile(t, finalPath) != "ours" {
+		t.Errorf("rename = %s, %v", finalPath, err)
+	}
+	if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+		t.Errorf("temp file left: %v", err)
+	}
+
+	// 覆盖符号链接时替换它指向的文件并保留权限
+	target := writeTestFile(t, dir, "real.txt", "old")
+	os.Chmod(target, 0600)
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Symlink("real.txt", link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.commitFile(0, writeTemp("new"), link, OverwriteReplace); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link replaced: %v", err)
+	}
+	if info, err := os.Stat(target); err != nil || info.Mode().Perm() != 0600 || readTestFile(t, target) != "new" {
+		t.Errorf("target = %v, %v", info.Mode(), err)
+	}
+}
@@ -2,105 +2,232 @@ package main
 
 import (
 	"bufio"
+	"fmt"
 	"io"
-	"os"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
 )
 
-// LineScanner 优化的行扫描器
+const (
+	// MaxLineLength 扫描时每行最多保留的字节数，超出的部分被截断（仍会完整读过）
+	MaxLineLength = 1024 * 1024
+	// TruncatedMarker 追加在被截断的行末尾的标记
+	TruncatedMarker = "…"
+)
+
+// LineScanner 优化的行扫描器，行的长度不受限制：超过 MaxLineLength 的部分被截断，同时记录行的实际长度
 type LineScanner struct {
-	scanner *bufio.Scanner
-	line    string
+	reader    *bufio.Reader
+	line      []byte
+	length    int64
+	truncated bool
+	err       error
+}
+
+// LongLine 被截断的行
+type LongLine struct {
+	Index  int   `json:"index"`          // 在 lines 中的下标
+	Line   int   `json:"line,omitempty"` // 行号（从 1 开始），按字节偏移读取且行号未知时省略
+	At     int64 `json:"at,omitempty"`   // 按字节偏移读取时行首的偏移
+	Length int64 `json:"length"`         // 行的实际字节数（不含换行符）
 }
 
 // NewLineScanner 创建新的行扫描器
 func NewLineScanner(r io.Reader) *LineScanner {
-	scanner := bufio.NewScanner(r)
-	// 设置更大的缓冲区以处理更长的行
-	buf := make([]byte, 0, 64*1024) // 64KB 初始缓冲区
-	scanner.Buffer(buf, 1024*1024)  // 最大 1MB 行长度
-	return &LineScanner{scanner: scanner}
+	return &LineScanner{reader: bufio.NewReaderSize(r, 64*1024)} // 64KB 缓冲区
 }
 
 // Scan 扫描下一行
 func (ls *LineScanner) Scan() bool {
-	ok := ls.scanner.Scan()
-	if ok {
-		ls.line = ls.scanner.Text()
+	return ls.scan(0, MaxLineLength)
+}
+
+// ScanSlice 扫描下一行，只保留从 from 开始的最多 limit 个字节
+func (ls *LineScanner) ScanSlice(from int64, limit int) bool {
+	return ls.scan(from, limit)
+}
+
+// Skip 跳过最多 n 行，不保留内容，返回实际跳过的行数
+func (ls *LineScanner) Skip(n int) int {
+	skipped := 0
+	for skipped < n && ls.scan(0, 0) {
+		skipped++
+	}
+	return skipped
+}
+
+// scan 读取下一行并保留 [from, from+limit) 范围内的字节，行尾的 \n 和 \r\n 不计入行的内容
+func (ls *LineScanner) scan(from int64, limit int) bool {
+	if ls.err != nil {
+		return false
+	}
+	ls.line = ls.line[:0]
+	ls.length = 0
+
+	read := false
+	var last, prev byte // 行的最后两个字节
+	for {
+		chunk, err := ls.reader.ReadSlice('\n')
+		if len(chunk) > 0 {
+			read = true
+			// 保留与 [from, from+limit) 重叠的部分
+			lo := min(max(from-ls.length, 0), int64(len(chunk)))
+			hi := min(max(from+int64(limit)-ls.length, 0), int64(len(chunk)))
+			ls.line = append(ls.line, chunk[lo:hi]...)
+			ls.length += int64(len(chunk))
+			if len(chunk) > 1 {
+				prev = chunk[len(chunk)-2]
+			} else {
+				prev = last
+			}
+			last = chunk[len(chunk)-1]
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			if !read {
+				return false
+			}
+			break
+		}
+		if err != nil {
+			ls.err = err
+			return false
+		}
+		break
+	}
+
+	// 去掉换行符
+	if last == '\n' {
+		ls.length--
+		if prev == '\r' {
+			ls.length--
+		}
+	} else if last == '\r' {
+		ls.length--
+	}
+	if end := ls.length - from; end < int64(len(ls.line)) {
+		ls.line = ls.line[:max(end, 0)]
+	}
+
+	// 截断时不保留不完整的字符
+	ls.truncated = from+int64(len(ls.line)) < ls.length
+	if ls.truncated {
+		ls.line = trimPartialRune(ls.line)
+	}
+	return true
+}
+
+// trimPartialRune 去掉末尾不完整的 UTF-8 字符
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
 	}
-	return ok
+	return b
 }
 
-// Text 返回当前行的文本
+// Text 返回当前行的文本（被截断时只有保留的部分）
 func (ls *LineScanner) Text() string {
-	return ls.line
+	return string(ls.line)
 }
 
 // Bytes 返回当前行的字节
 func (ls *LineScanner) Bytes() []byte {
-	return ls.scanner.Bytes()
+	return ls.line
 }
 
-// Err 返回扫描过程中的错误
-func (ls *LineScanner) Err() error {
-	return ls.scanner.Err()
+// Len 返回当前行的实际字节数（不含换行符）
+func (ls *LineScanner) Len() int64 {
+	return ls.length
 }
 
-// ReadLines 从指定位置读取指定行数（内存优化的版本）
-func ReadLines(filePath string, startLine, count int) ([]string, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
+// Truncated 判断当前行是否被截断
+func (ls *LineScanner) Truncated() bool {
+	return ls.truncated
+}
 
-	scanner := NewLineScanner(file)
-	lines := make([]string, 0, count)
-	currentLine := 0
+// Err 返回扫描过程中的错误
+func (ls *LineScanner) Err() error {
+	return ls.err
+}
 
-	for scanner.Scan() {
-		if currentLine >= startLine+count {
-			break
-		}
-		if currentLine >= startLine {
-			lines = append(lines, scanner.Text())
+// ReadLines 跳过 skip 行后读取最多 count 行（内存优化的版本），同时返回扫描过的行数
+// 被截断的行末尾加上 TruncatedMarker，并记录在 long 中（Line 由调用方填写）
+// 扫描器停在读取的最后一行之后，可以继续读取后面的行
+func ReadLines(scanner *LineScanner, skip, count int) (lines []string, long []LongLine, scanned int, err error) {
+	lines = make([]string, 0, min(count, LinesPerPage))
+
+	scanned = scanner.Skip(skip)
+	for scanned < skip+count && scanner.Scan() {
+		line := scanner.Text()
+		if scanner.Truncated() {
+			long = append(long, LongLine{Index: len(lines), Length: scanner.Len()})
+			line += TruncatedMarker
 		}
-		currentLine++
+		lines = append(lines, line)
+		scanned++
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, err
+		return nil, nil, scanned, err
 	}
 
-	return lines, nil
+	return lines, long, scanned, nil
 }
 
-// CountLinesFast 快速统计文件行数（使用缓冲读取）
-func CountLinesFast(filePath string) (int, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return 0, err
-	}
-	defer file.Close()
+// lineRange 要读取的行范围：按页读取，或者通过 from/count 指定
+type lineRange struct {
+	start    int  // 第一行（从 0 开始）
+	count    int  // 行数
+	explicit bool // 是否由 from/count 指定
+}
 
-	count := 0
-	buf := make([]byte, 32*1024) // 32KB 缓冲区
-	newline := []byte("\n")[0]
+// parseLineRange 解析 page、from 和 count 参数，count 不超过 maxLines
+func parseLineRange(r *http.Request, config ViewConfig) (lineRange, error) {
+	query := r.URL.Query()
+	from, count := query.Get("from"), query.Get("count")
+	if from == "" && count == "" {
+		page, _ := strconv.Atoi(query.Get("page"))
+		if page < 1 {
+			page = 1
+		}
+		return lineRange{start: (page - 1) * config.LinesPerPage, count: config.LinesPerPage}, nil
+	}
 
-	for {
-		n, err := file.Read(buf)
-		if err != nil && err != io.EOF {
-			return 0, err
+	lr := lineRange{count: config.LinesPerPage, explicit: true}
+	if from != "" {
+		n, err := strconv.Atoi(from)
+		if err != nil || n < 1 {
+			return lineRange{}, fmt.Errorf("invalid from: %s", from)
 		}
-		if n == 0 {
-			break
+		lr.start = n - 1
+	}
+	if count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return lineRange{}, fmt.Errorf("invalid count: %s", count)
 		}
+		lr.count = min(n, config.MaxLines)
+	}
+	return lr, nil
+}
 
-		for _, b := range buf[:n] {
-			if b == newline {
-				count++
-			}
-		}
+// clamp 按页读取时页码超出范围则读取最后一页
+func (lr lineRange) clamp(totalLines, linesPerPage int) lineRange {
+	if !lr.explicit && lr.start >= totalLines {
+		lr.start = max(totalLines-1, 0) / linesPerPage * linesPerPage
 	}
+	return lr
+}
 
-	return count, nil
+// page 第一行所在的页码
+func (lr lineRange) page(linesPerPage int) int {
+	return lr.start/linesPerPage + 1
 }
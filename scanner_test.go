@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReadLines(t *testing.T) {
+	scanner := NewLineScanner(strings.NewReader("a\nb\nc\nd\ne\n"))
+
+	lines, _, scanned, err := ReadLines(scanner, 1, 2)
+	if err != nil || strings.Join(lines, "|") != "b|c" || scanned != 3 {
+		t.Fatalf("ReadLines = %q, %d, %v", lines, scanned, err)
+	}
+
+	// 扫描器停在读取的最后一行之后，可以继续读取
+	lines, _, scanned, err = ReadLines(scanner, 0, 10)
+	if err != nil || strings.Join(lines, "|") != "d|e" || scanned != 2 {
+		t.Errorf("ReadLines continued = %q, %d, %v", lines, scanned, err)
+	}
+}
+
+func TestViewConfig(t *testing.T) {
+	s, _ := newTestServer(t, func(config *Config) {
+		config.View = ViewConfig{LinesPerPage: 200, MaxLines: 50}
+		config.RootDirs = append(config.RootDirs, RootDirConfig{Name: "small", Path: config.RootDirs[0].Path, View: &ViewConfig{MaxFileSize: 1024}})
+	})
+
+	if got, want := s.viewConfig(0), (ViewConfig{LinesPerPage: 200, MaxFileSize: MaxFileSize, MaxLines: 200}); got != want {
+		t.Errorf("viewConfig(0) = %+v, want %+v", got, want)
+	}
+	if got, want := s.viewConfig(1), (ViewConfig{LinesPerPage: 200, MaxFileSize: 1024, MaxLines: 200}); got != want {
+		t.Errorf("viewConfig(1) = %+v, want %+v", got, want)
+	}
+}
+
+func TestViewLineWindow(t *testing.T) {
+	s, dir := newTestServer(t, func(config *Config) {
+		config.RootDirs[0].View = &ViewConfig{LinesPerPage: 10, MaxFileSize: 100, MaxLines: 25}
+	})
+	var text strings.Builder
+	for i := 1; i <= 55; i++ {
+		fmt.Fprintf(&text, "line %d\n", i)
+	}
+	writeTestFile(t, dir, "big.txt", text.String())
+	writeTestFile(t, dir, "small.txt", "a\nb\nc\n")
+	writeTestFile(t, dir, "big.txt.gz", string(compressTestData(t, compressGzip, []byte(text.String()))))
+
+	tests := []struct {
+		path      string
+		query     string
+		wantFirst string
+		wantLen   int
+		wantPage  int
+		wantFrom  int
+	}{
+		// 按根目录配置的页大小分页
+		{"big.txt", "page=2", "line 11", 10, 2, 11},
+		{"big.txt", "page=100", "line 51", 5, 6, 51},
+		{"big.txt", "from=20&count=3", "line 20", 3, 2, 20},
+		// count 不超过 maxLines
+		{"big.txt", "from=1&count=1000", "line 1", 25, 1, 1},
+		{"big.txt", "from=54", "line 54", 2, 6, 54},
+		{"big.txt", "count=2", "line 1", 2, 1, 1},
+		{"small.txt", "from=2&count=1", "b", 1, 1, 2},
+		{"big.txt.gz", "from=33&count=4", "line 33", 4, 4, 33},
+		{"big.txt.gz", "from=5&count=2", "line 5", 2, 1, 5},
+	}
+	for _, tt := range tests {
+		var content FileContent
+		decodeResponse(t, doRequest(s.handleView, "GET", fmt.Sprintf("/api/view?root=0&path=/%s&%s", tt.path, tt.query), ""), 200, &content)
+		if len(content.Lines) != tt.wantLen || content.Lines[0] != tt.wantFirst || content.Page != tt.wantPage || content.From != tt.wantFrom {
+			t.Errorf("%s?%s: first=%q len=%d page=%d from=%d", tt.path, tt.query, content.Lines, len(content.Lines), content.Page, content.From)
+		}
+		if content.TotalLines != 55 && tt.path != "small.txt" {
+			t.Errorf("%s?%s: totalLines = %d", tt.path, tt.query, content.TotalLines)
+		}
+	}
+
+	// 超出末尾的行范围返回空内容
+	var content FileContent
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/big.txt&from=100", ""), 200, &content)
+	if len(content.Lines) != 0 {
+		t.Errorf("past end lines = %q", content.Lines)
+	}
+
+	for _, query := range []string{"from=0", "from=x", "count=0", "count=-1"} {
+		decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?root=0&path=/big.txt&"+query, ""), 400, nil)
+	}
+
+	// 搜索结果的页码按根目录的页大小计算
+	var results []SearchResult
+	decodeResponse(t, doRequest(s.handleSearch, "GET", "/api/search?root=0&path=/big.txt&q=line+42", ""), 200, &results)
+	if len(results) != 1 || results[0].Page != 5 {
+		t.Errorf("search = %+v", results)
+	}
+}
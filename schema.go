@@ -0,0 +1,1041 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	pathpkg "path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxSchemaErrors 一次校验最多返回的错误数
+const maxSchemaErrors = 100
+
+// SchemaConfig 将根目录下匹配路径模式的文件映射到 JSON Schema，保存和上传覆盖时校验内容
+type SchemaConfig struct {
+	Pattern string `json:"pattern"`          // 相对根目录的路径模式，如 "conf/*.yaml"、"**/app.json"；不含 / 时只匹配文件名
+	Schema  string `json:"schema"`           // JSON Schema 文件的路径（JSON 格式）
+	Format  string `json:"format,omitempty"` // 内容格式：json、yaml 或 toml，默认按扩展名判断，无法判断时为 json
+}
+
+// rootSchema 已编译的 Schema 映射
+type rootSchema struct {
+	config SchemaConfig
+	schema *schema
+}
+
+// schemaError 内容无法解析或不符合 Schema
+type schemaError struct {
+	errors []*ValidationError
+}
+
+func (e *schemaError) Error() string {
+	msg := "content does not match schema: " + e.errors[0].Error()
+	if len(e.errors) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(e.errors)-1)
+	}
+	return msg
+}
+
+// loadRootSchemas 读取并编译根目录配置的所有 Schema
+func loadRootSchemas(configs []SchemaConfig) ([]*rootSchema, error) {
+	var schemas []*rootSchema
+	for _, config := range configs {
+		if config.Pattern == "" || config.Schema == "" {
+			return nil, fmt.Errorf("schema mapping requires pattern and schema")
+		}
+		if _, err := pathpkg.Match(config.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", config.Pattern, err)
+		}
+		if config.Format != "" && config.Format != StructuredJSON && config.Format != StructuredYAML && config.Format != StructuredTOML {
+			return nil, fmt.Errorf("invalid format %q for %s", config.Format, config.Pattern)
+		}
+		data, err := os.ReadFile(config.Schema)
+		if err != nil {
+			return nil, err
+		}
+		compiled, err := compileSchema(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", config.Schema, err)
+		}
+		schemas = append(schemas, &rootSchema{config: config, schema: compiled})
+	}
+	return schemas, nil
+}
+
+// matchGlob 判断 / 分隔的相对路径是否匹配模式，** 匹配任意层目录
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := pathpkg.Match(pattern, pathpkg.Base(name))
+		return ok
+	}
+	var match func(p, n []string) bool
+	match = func(p, n []string) bool {
+		for len(p) > 0 {
+			if p[0] == "**" {
+				for i := 0; i <= len(n); i++ {
+					if match(p[1:], n[i:]) {
+						return true
+					}
+				}
+				return false
+			}
+			if len(n) == 0 {
+				return false
+			}
+			if ok, _ := pathpkg.Match(p[0], n[0]); !ok {
+				return false
+			}
+			p, n = p[1:], n[1:]
+		}
+		return len(n) == 0
+	}
+	return match(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(strings.Trim(name, "/"), "/"))
+}
+
+// matchSchema 返回文件（相对根目录的路径）对应的第一个 Schema，没有时返回 nil
+func (s *Server) matchSchema(rootIndex int, relPath string) *rootSchema {
+	if rootIndex < 0 || rootIndex >= len(s.schemas) {
+		return nil
+	}
+	for _, rs := range s.schemas[rootIndex] {
+		if matchGlob(rs.config.Pattern, relPath) {
+			return rs
+		}
+	}
+	return nil
+}
+
+// format 返回按 Schema 校验时使用的内容格式
+func (rs *rootSchema) format(relPath string) string {
+	if rs.config.Format != "" {
+		return rs.config.Format
+	}
+	if format := structuredFormat(relPath); format != "" {
+		return format
+	}
+	return StructuredJSON
+}
+
+// validate 解析内容并按 Schema 校验，失败时返回 *schemaError，错误附带在内容中的位置
+func (rs *rootSchema) validate(relPath, text string) error {
+	format := rs.format(relPath)
+	value, err := parseStructured(format, text)
+	if err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			return &schemaError{errors: []*ValidationError{verr}}
+		}
+		return err
+	}
+	errs := rs.schema.validate(value)
+	if len(errs) == 0 {
+		return nil
+	}
+	locateErrors(format, text, errs)
+	return &schemaError{errors: errs}
+}
+
+// validateContent 按文件对应的 Schema 校验将要写入的内容，没有对应的 Schema 时不校验
+func (s *Server) validateContent(rootIndex int, relPath, text string) error {
+	rs := s.matchSchema(rootIndex, relPath)
+	if rs == nil {
+		return nil
+	}
+	return rs.validate(relPath, text)
+}
+
+// validateLocalFile 按目标文件对应的 Schema 校验将要覆盖它的本地文件（上传覆盖时使用）
+func (s *Server) validateLocalFile(rootIndex int, targetPath, srcPath string) error {
+	relPath := s.relPath(targetPath, rootIndex)
+	rs := s.matchSchema(rootIndex, relPath)
+	if rs == nil {
+		return nil
+	}
+
+	file, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := s.checkSchemaSize(rootIndex, info.Size()); err != nil {
+		return err
+	}
+	return s.validateEncoded(rootIndex, relPath, rs, file)
+}
+
+// validateLocalContent 按目标文件对应的 Schema 校验将要写入它的已编码内容（局部修改和恢复历史版本时使用）
+func (s *Server) validateLocalContent(rootIndex int, targetPath string, content []byte) error {
+	relPath := s.relPath(targetPath, rootIndex)
+	rs := s.matchSchema(rootIndex, relPath)
+	if rs == nil {
+		return nil
+	}
+
+	if err := s.checkSchemaSize(rootIndex, int64(len(content))); err != nil {
+		return err
+	}
+	return s.validateEncoded(rootIndex, relPath, rs, bytes.NewReader(content))
+}
+
+// checkSchemaSize 检查需要按 Schema 校验的内容大小，校验时要将整个文件读入内存
+func (s *Server) checkSchemaSize(rootIndex int, size int64) error {
+	if maxSize := s.viewConfig(rootIndex).MaxFileSize; size > maxSize {
+		return fmt.Errorf("%w: files validated against a schema are limited to %d bytes", errTooLarge, maxSize)
+	}
+	return nil
+}
+
+// validateEncoded 按检测到的编码解码内容，换行符统一为 \n 后校验
+func (s *Server) validateEncoded(rootIndex int, relPath string, rs *rootSchema, r io.Reader) error {
+	reader, _, err := s.decodeText(rootIndex, r, "")
+	if err != nil {
+		return err
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return rs.validate(relPath, convertLineEndings(string(content), LineEndingLF))
+}
+
+// locateErrors 根据错误的路径找到值在内容中的行号和列号，路径不存在时使用最近的上级
+func locateErrors(format, text string, errs []*ValidationError) {
+	var locate func(path []jsonSegment) (int, int)
+	switch format {
+	case StructuredJSON:
+		p := &jsonSpanParser{src: text}
+		root, err := p.value()
+		if err != nil {
+			return
+		}
+		locate = func(path []jsonSegment) (int, int) {
+			node, pos := root, root.start
+			for _, seg := range path {
+				index := -1
+				switch {
+				case seg.kind == jsonSegName && node.kind == '{':
+					for i, key := range node.keys {
+						if key == seg.name {
+							index = i
+						}
+					}
+				case seg.kind == jsonSegIndex && node.kind == '[' && seg.index < len(node.members):
+					index = seg.index
+				}
+				if index < 0 {
+					break
+				}
+				pos = memberStart(node, index)
+				node = node.members[index]
+			}
+			return offsetPosition(text, pos)
+		}
+
+	case StructuredYAML:
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(text), &doc); err != nil || len(doc.Content) == 0 {
+			return
+		}
+		locate = func(path []jsonSegment) (int, int) {
+			node := doc.Content[0]
+			at := node
+			for _, seg := range path {
+				for node.Kind == yaml.AliasNode {
+					node = node.Alias
+				}
+				var next *yaml.Node
+				switch {
+				case seg.kind == jsonSegName && node.Kind == yaml.MappingNode:
+					for i := 0; i+1 < len(node.Content); i += 2 {
+						if node.Content[i].Value == seg.name {
+							at, next = node.Content[i], node.Content[i+1]
+						}
+					}
+				case seg.kind == jsonSegIndex && node.Kind == yaml.SequenceNode && seg.index < len(node.Content):
+					next = node.Content[seg.index]
+					at = next
+				}
+				if next == nil {
+					break
+				}
+				node = next
+			}
+			return at.Line, at.Column
+		}
+
+	case StructuredTOML:
+		doc, err := scanTOML(text)
+		if err != nil {
+			return
+		}
+		locate = func(path []jsonSegment) (int, int) {
+			for _, entry := range doc.entries {
+				if hasPathPrefix(path, entry.path) {
+					return offsetPosition(text, entry.start)
+				}
+			}
+			var table *tomlTable
+			for _, t := range doc.tables {
+				if hasPathPrefix(path, t.path) && (table == nil || len(t.path) > len(table.path)) {
+					table = t
+				}
+			}
+			if table == nil {
+				return 0, 0
+			}
+			return offsetPosition(text, table.start)
+		}
+	default:
+		return
+	}
+
+	for _, e := range errs {
+		path, err := parseJSONPath(e.Path)
+		if err != nil {
+			continue
+		}
+		e.Line, e.Column = locate(path)
+	}
+}
+
+// schema 编译后的 JSON Schema，支持 draft-07 和 2020-12 中常用的校验关键字
+type schema struct {
+	always *bool // 布尔形式的 Schema
+
+	ref      *schema
+	types    []string
+	enum     []any
+	cnst     any
+	hasConst bool
+
+	properties        map[string]*schema
+	patternProperties []patternSchema
+	additional        *schema
+	propertyNames     *schema
+	required          []string
+	dependentRequired map[string][]string
+	dependentSchemas  map[string]*schema
+	minProperties     int
+	maxProperties     int
+
+	prefixItems []*schema
+	items       *schema
+	contains    *schema
+	minItems    int
+	maxItems    int
+	uniqueItems bool
+
+	minLength int
+	maxLength int
+	pattern   *regexp.Regexp
+
+	minimum          *big.Rat
+	maximum          *big.Rat
+	exclusiveMinimum *big.Rat
+	exclusiveMaximum *big.Rat
+	multipleOf       *big.Rat
+
+	allOf []*schema
+	anyOf []*schema
+	oneOf []*schema
+	not   *schema
+	ifS   *schema
+	thenS *schema
+	elseS *schema
+}
+
+type patternSchema struct {
+	re     *regexp.Regexp
+	schema *schema
+}
+
+// schemaCompiler 按 JSON Pointer 编译子 Schema，$ref 指向同一位置时共用编译结果，允许递归引用
+type schemaCompiler struct {
+	root     any
+	compiled map[string]*schema
+}
+
+// compileSchema 编译 JSON Schema，只支持文档内的 $ref（如 #/$defs/port）
+func compileSchema(data []byte) (*schema, error) {
+	root, err := parseStructured(StructuredJSON, string(data))
+	if err != nil {
+		return nil, err
+	}
+	c := &schemaCompiler{root: root, compiled: map[string]*schema{}}
+	return c.compile("#")
+}
+
+// resolve 返回 JSON Pointer 指向的值
+func (c *schemaCompiler) resolve(pointer string) (any, error) {
+	value := c.root
+	if pointer == "#" {
+		return value, nil
+	}
+	if !strings.HasPrefix(pointer, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q, only local references are supported", pointer)
+	}
+	for _, token := range strings.Split(pointer[2:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		switch v := value.(type) {
+		case map[string]any:
+			child, ok := v[token]
+			if !ok {
+				return nil, fmt.Errorf("$ref %q not found", pointer)
+			}
+			value = child
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("$ref %q not found", pointer)
+			}
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("$ref %q not found", pointer)
+		}
+	}
+	return value, nil
+}
+
+func (c *schemaCompiler) compile(pointer string) (*schema, error) {
+	if s, ok := c.compiled[pointer]; ok {
+		return s, nil
+	}
+	raw, err := c.resolve(pointer)
+	if err != nil {
+		return nil, err
+	}
+	s := &schema{minProperties: -1, maxProperties: -1, minItems: -1, maxItems: -1, minLength: -1, maxLength: -1}
+	c.compiled[pointer] = s
+
+	if b, ok := raw.(bool); ok {
+		s.always = &b
+		return s, nil
+	}
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or boolean", pointer)
+	}
+	child := func(keys ...string) string {
+		p := pointer
+		for _, key := range keys {
+			p += "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+		}
+		return p
+	}
+	sub := func(key string) (*schema, error) {
+		if _, ok := m[key]; !ok {
+			return nil, nil
+		}
+		return c.compile(child(key))
+	}
+	subList := func(key string) ([]*schema, error) {
+		list, ok := m[key].([]any)
+		if !ok {
+			if _, exists := m[key]; exists {
+				return nil, fmt.Errorf("%s: must be an array", child(key))
+			}
+			return nil, nil
+		}
+		schemas := make([]*schema, len(list))
+		for i := range list {
+			if schemas[i], err = c.compile(child(key, strconv.Itoa(i))); err != nil {
+				return nil, err
+			}
+		}
+		return schemas, nil
+	}
+	number := func(key string) (*big.Rat, error) {
+		v, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("%s: must be a number", child(key))
+		}
+		r, ok := new(big.Rat).SetString(string(n))
+		if !ok {
+			return nil, fmt.Errorf("%s: invalid number %s", child(key), n)
+		}
+		return r, nil
+	}
+	count := func(key string) (int, error) {
+		r, err := number(key)
+		if r == nil || err != nil {
+			return -1, err
+		}
+		if !r.IsInt() || r.Sign() < 0 {
+			return -1, fmt.Errorf("%s: must be a non-negative integer", child(key))
+		}
+		return int(r.Num().Int64()), nil
+	}
+	strs := func(v any) []string {
+		list, _ := v.([]any)
+		var result []string
+		for _, item := range list {
+			if str, ok := item.(string); ok {
+				result = append(result, str)
+			}
+		}
+		return result
+	}
+
+	if ref, ok := m["$ref"].(string); ok {
+		if s.ref, err = c.compile(ref); err != nil {
+			return nil, err
+		}
+	}
+	switch t := m["type"].(type) {
+	case string:
+		s.types = []string{t}
+	case []any:
+		s.types = strs(t)
+	}
+	for _, t := range s.types {
+		switch t {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return nil, fmt.Errorf("%s: unknown type %q", pointer, t)
+		}
+	}
+	if list, ok := m["enum"].([]any); ok {
+		s.enum = list
+	}
+	s.cnst, s.hasConst = m["const"]
+
+	// 对象
+	if props, ok := m["properties"].(map[string]any); ok {
+		s.properties = map[string]*schema{}
+		for key := range props {
+			if s.properties[key], err = c.compile(child("properties", key)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if props, ok := m["patternProperties"].(map[string]any); ok {
+		for _, key := range sortedKeys(props) {
+			re, err := regexp.Compile(key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid pattern: %v", child("patternProperties", key), err)
+			}
+			compiled, err := c.compile(child("patternProperties", key))
+			if err != nil {
+				return nil, err
+			}
+			s.patternProperties = append(s.patternProperties, patternSchema{re: re, schema: compiled})
+		}
+	}
+	if s.additional, err = sub("additionalProperties"); err != nil {
+		return nil, err
+	}
+	if s.propertyNames, err = sub("propertyNames"); err != nil {
+		return nil, err
+	}
+	s.required = strs(m["required"])
+	// draft-07 的 dependencies 按值的类型分为 dependentRequired 和 dependentSchemas
+	for _, key := range []string{"dependencies", "dependentRequired", "dependentSchemas"} {
+		deps, ok := m[key].(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, name := range sortedKeys(deps) {
+			if list, ok := deps[name].([]any); ok {
+				if s.dependentRequired == nil {
+					s.dependentRequired = map[string][]string{}
+				}
+				s.dependentRequired[name] = strs(list)
+				continue
+			}
+			if s.dependentSchemas == nil {
+				s.dependentSchemas = map[string]*schema{}
+			}
+			if s.dependentSchemas[name], err = c.compile(child(key, name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if s.minProperties, err = count("minProperties"); err != nil {
+		return nil, err
+	}
+	if s.maxProperties, err = count("maxProperties"); err != nil {
+		return nil, err
+	}
+
+	// 数组：draft-07 中数组形式的 items 相当于 prefixItems，之后的元素由 additionalItems 校验
+	if _, ok := m["items"].([]any); ok {
+		if s.prefixItems, err = subList("items"); err != nil {
+			return nil, err
+		}
+		if s.items, err = sub("additionalItems"); err != nil {
+			return nil, err
+		}
+	} else {
+		if s.prefixItems, err = subList("prefixItems"); err != nil {
+			return nil, err
+		}
+		if s.items, err = sub("items"); err != nil {
+			return nil, err
+		}
+	}
+	if s.contains, err = sub("contains"); err != nil {
+		return nil, err
+	}
+	if s.minItems, err = count("minItems"); err != nil {
+		return nil, err
+	}
+	if s.maxItems, err = count("maxItems"); err != nil {
+		return nil, err
+	}
+	s.uniqueItems, _ = m["uniqueItems"].(bool)
+
+	// 字符串
+	if s.minLength, err = count("minLength"); err != nil {
+		return nil, err
+	}
+	if s.maxLength, err = count("maxLength"); err != nil {
+		return nil, err
+	}
+	if pattern, ok := m["pattern"].(string); ok {
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern: %v", child("pattern"), err)
+		}
+	}
+
+	// 数字：draft-04 中 exclusiveMinimum 和 exclusiveMaximum 为布尔值，表示 minimum 和 maximum 不含边界
+	if s.minimum, err = number("minimum"); err != nil {
+		return nil, err
+	}
+	if s.maximum, err = number("maximum"); err != nil {
+		return nil, err
+	}
+	if exclusive, ok := m["exclusiveMinimum"].(bool); ok {
+		if exclusive {
+			s.minimum, s.exclusiveMinimum = nil, s.minimum
+		}
+	} else if s.exclusiveMinimum, err = number("exclusiveMinimum"); err != nil {
+		return nil, err
+	}
+	if exclusive, ok := m["exclusiveMaximum"].(bool); ok {
+		if exclusive {
+			s.maximum, s.exclusiveMaximum = nil, s.maximum
+		}
+	} else if s.exclusiveMaximum, err = number("exclusiveMaximum"); err != nil {
+		return nil, err
+	}
+	if s.multipleOf, err = number("multipleOf"); err != nil {
+		return nil, err
+	}
+	if s.multipleOf != nil && s.multipleOf.Sign() <= 0 {
+		return nil, fmt.Errorf("%s: must be greater than 0", child("multipleOf"))
+	}
+
+	// 组合
+	if s.allOf, err = subList("allOf"); err != nil {
+		return nil, err
+	}
+	if s.anyOf, err = subList("anyOf"); err != nil {
+		return nil, err
+	}
+	if s.oneOf, err = subList("oneOf"); err != nil {
+		return nil, err
+	}
+	if s.not, err = sub("not"); err != nil {
+		return nil, err
+	}
+	if s.ifS, err = sub("if"); err != nil {
+		return nil, err
+	}
+	if s.thenS, err = sub("then"); err != nil {
+		return nil, err
+	}
+	if s.elseS, err = sub("else"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// schemaValidation 一次校验收集到的错误
+type schemaValidation struct {
+	errors []*ValidationError
+}
+
+func (v *schemaValidation) addf(path []jsonSegment, format string, args ...any) {
+	if len(v.errors) < maxSchemaErrors {
+		v.errors = append(v.errors, &ValidationError{Path: segmentsPath(path), Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// validate 校验值（parseStructured 解码的结果），返回所有不符合的位置
+func (s *schema) validate(value any) []*ValidationError {
+	v := &schemaValidation{}
+	s.check(v, value, nil)
+	return v.errors
+}
+
+// valid 判断值是否符合 Schema，不记录错误
+func (s *schema) valid(value any, path []jsonSegment) bool {
+	v := &schemaValidation{}
+	s.check(v, value, path)
+	return len(v.errors) == 0
+}
+
+// schemaType 返回值的 JSON 类型，整数返回 integer
+func schemaType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if r, ok := new(big.Rat).SetString(string(v)); ok && r.IsInt() {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// jsonEqual 按 JSON 的语义比较两个值，数字按数值比较
+func jsonEqual(a, b any) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		rx, ok1 := new(big.Rat).SetString(string(x))
+		ry, ok2 := new(big.Rat).SetString(string(y))
+		return ok1 && ok2 && rx.Cmp(ry) == 0
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, value := range x {
+			other, ok := y[key]
+			if !ok || !jsonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// childPath 返回子节点的路径，不修改 path
+func childPath(path []jsonSegment, seg jsonSegment) []jsonSegment {
+	return append(path[:len(path):len(path)], seg)
+}
+
+func (s *schema) check(v *schemaValidation, value any, path []jsonSegment) {
+	if s.always != nil {
+		if !*s.always {
+			v.addf(path, "no value is allowed here")
+		}
+		return
+	}
+	if s.ref != nil {
+		s.ref.check(v, value, path)
+	}
+
+	kind := schemaType(value)
+	if len(s.types) > 0 {
+		ok := false
+		for _, t := range s.types {
+			if t == kind || (t == "number" && kind == "integer") {
+				ok = true
+			}
+		}
+		if !ok {
+			v.addf(path, "expected %s, got %s", strings.Join(s.types, " or "), kind)
+			return
+		}
+	}
+	if s.enum != nil {
+		ok := false
+		for _, item := range s.enum {
+			if jsonEqual(value, item) {
+				ok = true
+			}
+		}
+		if !ok {
+			text, _ := json.Marshal(s.enum)
+			v.addf(path, "must be one of %s", text)
+		}
+	}
+	if s.hasConst && !jsonEqual(value, s.cnst) {
+		text, _ := json.Marshal(s.cnst)
+		v.addf(path, "must be %s", text)
+	}
+
+	switch x := value.(type) {
+	case map[string]any:
+		s.checkObject(v, x, path)
+	case []any:
+		s.checkArray(v, x, path)
+	case string:
+		n := utf8.RuneCountInString(x)
+		if s.minLength >= 0 && n < s.minLength {
+			v.addf(path, "length must be at least %d", s.minLength)
+		}
+		if s.maxLength >= 0 && n > s.maxLength {
+			v.addf(path, "length must be at most %d", s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(x) {
+			v.addf(path, "must match pattern %s", s.pattern)
+		}
+	case json.Number:
+		s.checkNumber(v, x, path)
+	}
+
+	for _, sub := range s.allOf {
+		sub.check(v, value, path)
+	}
+	if len(s.anyOf) > 0 {
+		ok := false
+		for _, sub := range s.anyOf {
+			if sub.valid(value, path) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			v.addf(path, "must match at least one schema in anyOf")
+		}
+	}
+	if len(s.oneOf) > 0 {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.valid(value, path) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			v.addf(path, "must match exactly one schema in oneOf, matched %d", matched)
+		}
+	}
+	if s.not != nil && s.not.valid(value, path) {
+		v.addf(path, "must not match the schema in not")
+	}
+	if s.ifS != nil {
+		if s.ifS.valid(value, path) {
+			if s.thenS != nil {
+				s.thenS.check(v, value, path)
+			}
+		} else if s.elseS != nil {
+			s.elseS.check(v, value, path)
+		}
+	}
+}
+
+func (s *schema) checkObject(v *schemaValidation, object map[string]any, path []jsonSegment) {
+	for _, key := range s.required {
+		if _, ok := object[key]; !ok {
+			v.addf(path, "missing required property %q", key)
+		}
+	}
+	if s.minProperties >= 0 && len(object) < s.minProperties {
+		v.addf(path, "must have at least %d properties", s.minProperties)
+	}
+	if s.maxProperties >= 0 && len(object) > s.maxProperties {
+		v.addf(path, "must have at most %d properties", s.maxProperties)
+	}
+	for _, key := range sortedKeys(object) {
+		value := object[key]
+		here := childPath(path, jsonSegment{kind: jsonSegName, name: key})
+		if s.propertyNames != nil && !s.propertyNames.valid(key, here) {
+			v.addf(here, "property name %q is not allowed", key)
+		}
+		matched := false
+		if sub, ok := s.properties[key]; ok {
+			sub.check(v, value, here)
+			matched = true
+		}
+		for _, ps := range s.patternProperties {
+			if ps.re.MatchString(key) {
+				ps.schema.check(v, value, here)
+				matched = true
+			}
+		}
+		if !matched && s.additional != nil {
+			if s.additional.always != nil && !*s.additional.always {
+				v.addf(here, "property %q is not allowed", key)
+			} else {
+				s.additional.check(v, value, here)
+			}
+		}
+		if deps, ok := s.dependentRequired[key]; ok {
+			for _, dep := range deps {
+				if _, ok := object[dep]; !ok {
+					v.addf(path, "property %q is required when %q is present", dep, key)
+				}
+			}
+		}
+		if sub, ok := s.dependentSchemas[key]; ok {
+			sub.check(v, object, path)
+		}
+	}
+}
+
+func (s *schema) checkArray(v *schemaValidation, items []any, path []jsonSegment) {
+	if s.minItems >= 0 && len(items) < s.minItems {
+		v.addf(path, "must have at least %d items", s.minItems)
+	}
+	if s.maxItems >= 0 && len(items) > s.maxItems {
+		v.addf(path, "must have at most %d items", s.maxItems)
+	}
+	contains := false
+	for i, item := range items {
+		here := childPath(path, jsonSegment{kind: jsonSegIndex, index: i})
+		switch {
+		case i < len(s.prefixItems):
+			s.prefixItems[i].check(v, item, here)
+		case s.items != nil:
+			s.items.check(v, item, here)
+		}
+		if s.contains != nil && !contains {
+			contains = s.contains.valid(item, here)
+		}
+	}
+	if s.contains != nil && !contains {
+		v.addf(path, "must contain at least one matching item")
+	}
+	if s.uniqueItems {
+		for i := range items {
+			for j := i + 1; j < len(items); j++ {
+				if jsonEqual(items[i], items[j]) {
+					v.addf(childPath(path, jsonSegment{kind: jsonSegIndex, index: j}), "duplicates item %d", i)
+				}
+			}
+		}
+	}
+}
+
+func (s *schema) checkNumber(v *schemaValidation, n json.Number, path []jsonSegment) {
+	r, ok := new(big.Rat).SetString(string(n))
+	if !ok {
+		return
+	}
+	if s.minimum != nil && r.Cmp(s.minimum) < 0 {
+		v.addf(path, "must be >= %s", s.minimum.RatString())
+	}
+	if s.maximum != nil && r.Cmp(s.maximum) > 0 {
+		v.addf(path, "must be <= %s", s.maximum.RatString())
+	}
+	if s.exclusiveMinimum != nil && r.Cmp(s.exclusiveMinimum) <= 0 {
+		v.addf(path, "must be > %s", s.exclusiveMinimum.RatString())
+	}
+	if s.exclusiveMaximum != nil && r.Cmp(s.exclusiveMaximum) >= 0 {
+		v.addf(path, "must be < %s", s.exclusiveMaximum.RatString())
+	}
+	if s.multipleOf != nil && !new(big.Rat).Quo(r, s.multipleOf).IsInt() {
+		v.addf(path, "must be a multiple of %s", s.multipleOf.RatString())
+	}
+}
+
+// ValidateRequest 校验请求：按文件对应的 Schema 校验尚未保存的内容
+type ValidateRequest struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+	Format  string `json:"format,omitempty"` // 没有对应的 Schema 时按该格式检查语法，默认按扩展名判断
+}
+
+// ValidateResult 校验结果
+type ValidateResult struct {
+	Valid   bool               `json:"valid"`
+	Format  string             `json:"format,omitempty"`  // 解析内容使用的格式，为空表示不需要校验
+	Pattern string             `json:"pattern,omitempty"` // 匹配的 Schema 映射的路径模式
+	Errors  []*ValidationError `json:"errors,omitempty"`
+}
+
+// handleValidate 校验编辑中的内容但不保存：有对应的 Schema 时按 Schema 校验，否则只检查 JSON、YAML 和 TOML 的语法
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.handleError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ValidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.handleError(w, fmt.Errorf("invalid request body"), http.StatusBadRequest)
+		return
+	}
+	if req.Path == "" {
+		s.handleError(w, fmt.Errorf("path is required"), http.StatusBadRequest)
+		return
+	}
+
+	rootIndex := getRootIndex(r)
+
+	// 构建完整路径
+	fullPath := s.getFullPath(req.Path, rootIndex)
+
+	// 检查路径是否在根目录内
+	if !s.isPathSafe(fullPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	relPath := s.relPath(fullPath, rootIndex)
+	text := convertLineEndings(req.Content, LineEndingLF)
+	result := ValidateResult{Valid: true}
+	var err error
+	if rs := s.matchSchema(rootIndex, relPath); rs != nil {
+		result.Format, result.Pattern = rs.format(relPath), rs.config.Pattern
+		err = rs.validate(relPath, text)
+	} else {
+		result.Format = req.Format
+		if result.Format == "" {
+			result.Format = structuredFormat(relPath)
+		}
+		if result.Format != "" {
+			_, err = parseStructured(result.Format, text)
+		}
+	}
+
+	var schemaErr *schemaError
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &schemaErr):
+		result.Valid, result.Errors = false, schemaErr.errors
+	case errors.As(err, &validationErr):
+		result.Valid, result.Errors = false, []*ValidationError{validationErr}
+	case err != nil:
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, result)
+}
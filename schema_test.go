@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.json", "/a/b/c.json", true},
+		{"app.yaml", "/conf/app.yaml", true},
+		{"conf/*.yaml", "/conf/app.yaml", true},
+		{"conf/*.yaml", "/conf/sub/app.yaml", false},
+		{"conf/**/*.yaml", "/conf/app.yaml", true},
+		{"conf/**/*.yaml", "/conf/a/b/app.yaml", true},
+		{"**/app.json", "/app.json", true},
+		{"**/app.json", "/x/y/app.json", true},
+		{"**/app.json", "/x/y/app.json.bak", false},
+		{"/conf/*.toml", "/conf/a.toml", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	const schemaDoc = `{
+  "type": "object",
+  "required": ["name", "port"],
+  "properties": {
+    "name": {"type": "string", "minLength": 2, "pattern": "^[a-z]+$"},
+    "port": {"type": "integer", "minimum": 1, "maximum": 65535},
+    "ratio": {"type": "number", "multipleOf": 0.1, "exclusiveMaximum": 1},
+    "mode": {"enum": ["dev", "prod"]},
+    "tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true, "maxItems": 3},
+    "server": {"$ref": "#/definitions/server"},
+    "backend": {"oneOf": [{"required": ["url"]}, {"required": ["socket"]}]}
+  },
+  "additionalProperties": false,
+  "definitions": {
+    "server": {"type": "object", "properties": {"tls": {"type": "boolean"}}, "dependentRequired": {"tls": ["cert"]}}
+  }
+}`
+	compiled, err := compileSchema([]byte(schemaDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		doc  string
+		want []string // 期望的错误路径，为空表示有效
+	}{
+		{`{"name": "demo", "port": 80}`, nil},
+		{`{"name": "demo", "port": 80, "ratio": 0.3, "mode": "dev", "tags": ["a", "b"], "server": {"tls": true, "cert": "x"}, "backend": {"url": "u"}}`, nil},
+		{`{"name": "demo"}`, []string{"$"}},
+		{`{"name": "D", "port": 80}`, []string{"$.name", "$.name"}},
+		{`{"name": "demo", "port": 8.5}`, []string{"$.port"}},
+		{`{"name": "demo", "port": 70000}`, []string{"$.port"}},
+		{`{"name": "demo", "port": 80, "ratio": 0.35}`, []string{"$.ratio"}},
+		{`{"name": "demo", "port": 80, "ratio": 1}`, []string{"$.ratio"}},
+		{`{"name": "demo", "port": 80, "mode": "test"}`, []string{"$.mode"}},
+		{`{"name": "demo", "port": 80, "tags": ["a", "a"]}`, []string{"$.tags[1]"}},
+		{`{"name": "demo", "port": 80, "tags": ["a", 1]}`, []string{"$.tags[1]"}},
+		{`{"name": "demo", "port": 80, "server": {"tls": true}}`, []string{"$.server"}},
+		{`{"name": "demo", "port": 80, "backend": {"url": "u", "socket": "s"}}`, []string{"$.backend"}},
+		{`{"name": "demo", "port": 80, "extra": 1}`, []string{"$.extra"}},
+	}
+	for _, tt := range tests {
+		value, err := parseStructured(StructuredJSON, tt.doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range compiled.validate(value) {
+			got = append(got, e.Path)
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: errors at %v, want %v", tt.doc, got, tt.want)
+		}
+	}
+
+	for _, doc := range []string{
+		`{"type": "strange"}`,
+		`{"$ref": "#/definitions/missing"}`,
+		`{"$ref": "http://example.com/schema.json"}`,
+		`{"pattern": "("}`,
+	} {
+		if _, err := compileSchema([]byte(doc)); err == nil {
+			t.Errorf("compileSchema(%s) succeeded", doc)
+		}
+	}
+}
+
+func TestSchemaErrorLocation(t *testing.T) {
+	compiled, err := compileSchema([]byte(`{"properties": {"server": {"properties": {"port": {"type": "integer"}}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &rootSchema{schema: compiled}
+
+	tests := []struct {
+		name, text   string
+		line, column int
+	}{
+		{"a.json", "{\n  \"server\": {\n    \"port\": \"80\"\n  }\n}\n", 3, 5},
+		{"a.yaml", "# 配置\nserver:\n  port: eighty\n", 3, 3},
+		{"a.toml", "[server]\nhost = \"h\"\nport = \"80\"\n", 3, 1},
+	}
+	for _, tt := range tests {
+		err := rs.validate("/"+tt.name, tt.text)
+		var schemaErr *schemaError
+		if !errors.As(err, &schemaErr) || len(schemaErr.errors) != 1 {
+			t.Errorf("%s: err = %v", tt.name, err)
+			continue
+		}
+		got := schemaErr.errors[0]
+		if got.Path != "$.server.port" || got.Line != tt.line || got.Column != tt.column {
+			t.Errorf("%s: error = %+v, want line %d column %d", tt.name, got, tt.line, tt.column)
+		}
+	}
+}
+
+// newSchemaTestServer 创建 *.json 和 conf/*.yaml 需要 name 字段的测试服务器
+func newSchemaTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	schemaPath := writeTestFile(t, t.TempDir(), "schema.json", `{"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}}}`)
+	return newTestServer(t, func(config *Config) {
+		config.RootDirs[0].Schemas = []SchemaConfig{
+			{Pattern: "*.json", Schema: schemaPath},
+			{Pattern: "conf/*.yaml", Schema: schemaPath},
+		}
+	})
+}
+
+func TestSaveValidatesSchema(t *testing.T) {
+	s, dir := newSchemaTestServer(t)
+	fullPath := writeTestFile(t, dir, "app.json", `{"name": "demo"}`)
+	writeTestFile(t, dir, "conf/app.yaml", "name: demo\n")
+	writeTestFile(t, dir, "app.yaml", "")
+
+	save := func(path, content string, status int, v any) {
+		t.Helper()
+		body := mustJSON(t, SaveRequest{Path: path, Content: content, Version: "*"})
+		decodeResponse(t, doRequest(s.handleSave, "POST", "/api/save?root=0", body), status, v)
+	}
+
+	var failed ValidationFailure
+	save("/app.json", "{\n  \"name\": 1\n}", 422, &failed)
+	if len(failed.Errors) != 1 || failed.Errors[0].Path != "$.name" || failed.Errors[0].Line != 2 {
+		t.Errorf("failed = %+v", failed)
+	}
+	save("/app.json", "{\n  \"name\": ", 422, &failed)
+	if len(failed.Errors) != 1 || failed.Errors[0].Line == 0 {
+		t.Errorf("syntax error = %+v", failed)
+	}
+	if got := readTestFile(t, fullPath); got != `{"name": "demo"}` {
+		t.Errorf("content after rejected save = %q", got)
+	}
+
+	save("/app.json", `{"name": "prod"}`, 200, nil)
+	save("/conf/app.yaml", "port: 80\n", 422, nil)
+	save("/conf/app.yaml", "name: demo\n", 200, nil)
+	save("/app.yaml", "port: 80\n", 200, nil)
+
+	// 结构化编辑同样校验结果
+	var structured ValidationFailure
+	decodeResponse(t, doRequest(s.handleStructured, "POST", "/api/structured?root=0",
+		`{"path": "/app.json", "version": "*", "ops": [{"op": "delete", "path": "$.name"}], "dryRun": true}`), 422, &structured)
+	if len(structured.Errors) != 1 || structured.Errors[0].Path != "$" {
+		t.Errorf("structured = %+v", structured)
+	}
+}
+
+func TestUploadOverwriteValidatesSchema(t *testing.T) {
+	s, dir := newSchemaTestServer(t)
+	existing := writeTestFile(t, dir, "app.json", `{"name": "demo"}`)
+	fields := map[string]string{"overwrite": OverwriteReplace}
+
+	var failed ValidationFailure
+	decodeResponse(t, uploadTestFiles(t, s, fields, testUploadFile{filename: "app.json", content: `{"name": 1}`}), 422, &failed)
+	if len(failed.Errors) != 1 || failed.Errors[0].Path != "$.name" {
+		t.Errorf("failed = %+v", failed)
+	}
+
+	var resp uploadResponse
+	decodeResponse(t, uploadTestFiles(t, s, fields,
+		testUploadFile{filename: "app.json", content: `{}`},
+		testUploadFile{filename: "new.json", content: `{}`},
+	), 200, &resp)
+	if resp.Results[0].Success || len(resp.Results[0].Errors) != 1 || !resp.Results[1].Success {
+		t.Errorf("results = %+v", resp.Results)
+	}
+	if got := readTestFile(t, existing); got != `{"name": "demo"}` {
+		t.Errorf("content after rejected upload = %q", got)
+	}
+
+	decodeResponse(t, uploadTestFiles(t, s, fields, testUploadFile{filename: "app.json", content: `{"name": "prod"}`}), 200, nil)
+	if got := readTestFile(t, existing); got != `{"name": "prod"}` {
+		t.Errorf("content after upload = %q", got)
+	}
+}
+
+func TestHandleValidate(t *testing.T) {
+	s, _ := newSchemaTestServer(t)
+
+	validate := func(req ValidateRequest) ValidateResult {
+		t.Helper()
+		var result ValidateResult
+		decodeResponse(t, doRequest(s.handleValidate, "POST", "/api/validate?root=0", mustJSON(t, req)), 200, &result)
+		return result
+	}
+
+	if r := validate(ValidateRequest{Path: "/conf/a.yaml", Content: "port: 80\r\n"}); r.Valid || r.Format != StructuredYAML || r.Pattern != "conf/*.yaml" || len(r.Errors) != 1 {
+		t.Errorf("schema = %+v", r)
+	}
+	if r := validate(ValidateRequest{Path: "/a.json", Content: `{"name": "x"}`}); !r.Valid || r.Pattern != "*.json" {
+		t.Errorf("valid = %+v", r)
+	}
+	if r := validate(ValidateRequest{Path: "/a.toml", Content: "a = "}); r.Valid || r.Format != StructuredTOML || r.Pattern != "" || len(r.Errors) != 1 || r.Errors[0].Line != 1 {
+		t.Errorf("syntax = %+v", r)
+	}
+	if r := validate(ValidateRequest{Path: "/notes.txt", Content: "{"}); !r.Valid || r.Format != "" {
+		t.Errorf("plain = %+v", r)
+	}
+	if r := validate(ValidateRequest{Path: "/notes.txt", Content: "{", Format: StructuredJSON}); r.Valid {
+		t.Errorf("format = %+v", r)
+	}
+
+	rec := doRequest(s.handleValidate, "POST", "/api/validate?root=0", `{"path": "/../x.json", "content": "{}"}`)
+	if rec.Code != 403 {
+		t.Errorf("escape status = %d", rec.Code)
+	}
+}
+
+func TestLoadRootSchemasInvalid(t *testing.T) {
+	dir := t.TempDir()
+	good := writeTestFile(t, dir, "good.json", `{"type": "object"}`)
+	bad := writeTestFile(t, dir, "bad.json", `{"type": `)
+	for _, config := range []SchemaConfig{
+		{Pattern: "*.json"},
+		{Pattern: "[", Schema: good},
+		{Pattern: "*.json", Schema: good, Format: "xml"},
+		{Pattern: "*.json", Schema: filepath.Join(dir, "missing.json")},
+		{Pattern: "*.json", Schema: bad},
+	} {
+		if _, err := loadRootSchemas([]SchemaConfig{config}); err == nil {
+			t.Errorf("loadRootSchemas(%+v) succeeded", config)
+		}
+	}
+}
+
+func TestPatchAndRestoreValidateSchema(t *testing.T) {
+	schemaPath := writeTestFile(t, t.TempDir(), "schema.json", `{"type": "object", "required": ["name"]}`)
+	s, dir := newTestServer(t, func(config *Config) {
+		config.RootDirs[0].Schemas = []SchemaConfig{{Pattern: "*.json", Schema: schemaPath}}
+		config.RootDirs[0].History = &HistoryConfig{Keep: 5}
+	})
+	fullPath := writeTestFile(t, dir, "app.json", "{\n  \"port\": 80\n}\n")
+
+	// 局部修改的结果不符合 Schema 时不写入，也不产生历史版本
+	patch := func(content string, status int, v any) {
+		t.Helper()
+		body := mustJSON(t, PatchRequest{Path: "/app.json", Version: "*", StartLine: 2, Content: content})
+		decodeResponse(t, doRequest(s.handlePatch, "POST", "/api/patch?root=0", body), status, v)
+	}
+	var failed ValidationFailure
+	patch(`  "port": 81`, 422, &failed)
+	if len(failed.Errors) != 1 || failed.Errors[0].Path != "$" {
+		t.Errorf("patch failed = %+v", failed)
+	}
+	if got := readTestFile(t, fullPath); got != "{\n  \"port\": 80\n}\n" {
+		t.Errorf("content after rejected patch = %q", got)
+	}
+	if versions := listTestHistory(t, s, "/app.json"); len(versions) != 0 {
+		t.Errorf("versions after rejected patch = %+v", versions)
+	}
+	patch(`  "name": "demo"`, 200, nil)
+
+	// 不符合 Schema 的历史版本不能恢复
+	versions := listTestHistory(t, s, "/app.json")
+	if len(versions) != 1 {
+		t.Fatalf("versions = %+v", versions)
+	}
+	failed = ValidationFailure{}
+	body := mustJSON(t, RestoreRequest{Path: "/app.json", Version: versions[0].ID})
+	decodeResponse(t, doRequest(s.handleHistoryRestore, "POST", "/api/historyRestore?root=0", body), 422, &failed)
+	if len(failed.Errors) != 1 || failed.Errors[0].Path != "$" {
+		t.Errorf("restore failed = %+v", failed)
+	}
+	if got := readTestFile(t, fullPath); got != "{\n  \"name\": \"demo\"\n}\n" {
+		t.Errorf("content after rejected restore = %q", got)
+	}
+}
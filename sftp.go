@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// 默认每个 SFTP 根目录的最大连接数
+	defaultSFTPMaxConns = 4
+	// 默认连接超时时间
+	defaultSFTPTimeout = 10 * time.Second
+	// 空闲连接超过该时间后不再复用
+	sftpIdleTimeout = 5 * time.Minute
+	// 连接池已满时等待空闲连接的最长时间
+	sftpAcquireTimeout = 30 * time.Second
+)
+
+// errSFTPBusy 等待连接池中的连接超时
+var errSFTPBusy = errors.New("sftp connection pool is busy, try again later")
+
+// SFTPConfig SFTP 根目录连接配置
+type SFTPConfig struct {
+	Host           string `json:"host"`                     // 主机地址
+	Port           int    `json:"port,omitempty"`           // 端口，默认 22
+	User           string `json:"user"`                     // 用户名
+	Password       string `json:"password,omitempty"`       // 密码（可选）
+	KeyFile        string `json:"keyFile,omitempty"`        // 私钥文件（可选）
+	KeyPassphrase  string `json:"keyPassphrase,omitempty"`  // 私钥密码（可选）
+	KnownHostsFile string `json:"knownHostsFile,omitempty"` // known_hosts 文件，默认 ~/.ssh/known_hosts
+	MaxConns       int    `json:"maxConns,omitempty"`       // 最大连接数
+	Timeout        int    `json:"timeout,omitempty"`        // 连接超时（秒）
+}
+
+// sftpConn 一个 SSH 连接及其上的 SFTP 会话
+type sftpConn struct {
+	ssh      *ssh.Client
+	client   *sftp.Client
+	lastUsed time.Time
+}
+
+// Close 关闭连接
+func (c *sftpConn) Close() {
+	c.client.Close()
+	c.ssh.Close()
+}
+
+// sftpFS 基于 SFTP 的远程文件系统后端，带连接池和断线重连
+type sftpFS struct {
+	config         *SFTPConfig
+	slots          chan struct{}
+	acquireTimeout time.Duration // 连接数已达上限时等待空闲连接的超时时间
+
+	mu   sync.Mutex
+	idle []*sftpConn
+}
+
+// newSFTPFS 创建 SFTP 后端（连接在首次使用时建立）
+func newSFTPFS(config *SFTPConfig) (*sftpFS, error) {
+	if config == nil || config.Host == "" || config.User == "" {
+		return nil, fmt.Errorf("sftp host and user are required")
+	}
+	if config.Password == "" && config.KeyFile == "" {
+		return nil, fmt.Errorf("sftp password or keyFile is required")
+	}
+
+	maxConns := config.MaxConns
+	if maxConns <= 0 {
+		maxConns = defaultSFTPMaxConns
+	}
+
+	return &sftpFS{
+		config:         config,
+		slots:          make(chan struct{}, maxConns),
+		acquireTimeout: sftpAcquireTimeout,
+	}, nil
+}
+
+// clientConfig 构建 SSH 客户端配置
+func (f *sftpFS) clientConfig() (*ssh.ClientConfig, error) {
+	var auths []ssh.AuthMethod
+
+	if f.config.KeyFile != "" {
+		key, err := os.ReadFile(f.config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var signer ssh.Signer
+		if f.config.KeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(f.config.KeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse private key: %v", err)
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+
+	if f.config.Password != "" {
+		auths = append(auths, ssh.Password(f.config.Password))
+	}
+
+	knownHostsFile := f.config.KnownHostsFile
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("load known_hosts: %v", err)
+	}
+
+	timeout := defaultSFTPTimeout
+	if f.config.Timeout > 0 {
+		timeout = time.Duration(f.config.Timeout) * time.Second
+	}
+
+	return &ssh.ClientConfig{
+		User:            f.config.User,
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	}, nil
+}
+
+// dial 建立新连接
+func (f *sftpFS) dial() (*sftpConn, error) {
+	clientConfig, err := f.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	port := f.config.Port
+	if port == 0 {
+		port = 22
+	}
+	addr := net.JoinHostPort(f.config.Host, strconv.Itoa(port))
+
+	sshClient, err := ssh.Dial("tcp", addr, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, err
+	}
+
+	return &sftpConn{ssh: sshClient, client: client}, nil
+}
+
+// acquire 从连接池获取连接，必要时新建；连接数已达上限时最多等待 acquireTimeout
+func (f *sftpFS) acquire() (*sftpConn, error) {
+	timer := time.NewTimer(f.acquireTimeout)
+	defer timer.Stop()
+	select {
+	case f.slots <- struct{}{}:
+	case <-timer.C:
+		return nil, errSFTPBusy
+	}
+
+	f.mu.Lock()
+	for len(f.idle) > 0 {
+		conn := f.idle[len(f.idle)-1]
+		f.idle = f.idle[:len(f.idle)-1]
+		if time.Since(conn.lastUsed) < sftpIdleTimeout {
+			f.mu.Unlock()
+			return conn, nil
+		}
+		conn.Close()
+	}
+	f.mu.Unlock()
+
+	conn, err := f.dial()
+	if err != nil {
+		<-f.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+// release 归还连接，连接已损坏时直接关闭
+func (f *sftpFS) release(conn *sftpConn, err error) {
+	if isConnError(err) {
+		conn.Close()
+	} else {
+		conn.lastUsed = time.Now()
+		f.mu.Lock()
+		f.idle = append(f.idle, conn)
+		f.mu.Unlock()
+	}
+	<-f.slots
+}
+
+// withClient 使用池中的连接执行操作，连接断开时重连并重试一次
+func (f *sftpFS) withClient(fn func(client *sftp.Client) error) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var conn *sftpConn
+		conn, err = f.acquire()
+		if err != nil {
+			return err
+		}
+
+		err = fn(conn.client)
+		f.release(conn, err)
+		if !isConnError(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// isConnError 判断错误是否意味着连接已不可用
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var statusErr *sftp.StatusError
+	if errors.As(err, &statusErr) {
+		return false
+	}
+
+	return !errors.Is(err, fs.ErrNotExist) &&
+		!errors.Is(err, fs.ErrPermission) &&
+		!errors.Is(err, fs.ErrExist)
+}
+
+// Stat 获取文件信息
+func (f *sftpFS) Stat(name string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := f.withClient(func(client *sftp.Client) error {
+		var err error
+		info, err = client.Stat(name)
+		return err
+	})
+	return info, err
+}
+
+// ReadDir 读取目录内容
+func (f *sftpFS) ReadDir(name string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	err := f.withClient(func(client *sftp.Client) error {
+		var err error
+		infos, err = client.ReadDir(name)
+		return err
+	})
+	return infos, err
+}
+
+// Open 打开文件，连接在文件关闭后归还连接池
+func (f *sftpFS) Open(name string) (File, error) {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var conn *sftpConn
+		conn, err = f.acquire()
+		if err != nil {
+			return nil, err
+		}
+
+		var file *sftp.File
+		file, err = conn.client.Open(name)
+		if err == nil {
+			return &sftpFile{File: file, fs: f, conn: conn}, nil
+		}
+
+		f.release(conn, err)
+		if !isConnError(err) {
+			break
+		}
+	}
+	return nil, err
+}
+
+// WriteFile 写入同目录下的临时文件后再重命名，保留原文件的权限和属主
+func (f *sftpFS) WriteFile(name string, data []byte, backup bool) error {
+	return f.withClient(func(client *sftp.Client) error {
+		// 符号链接替换其指向的文件，而不是链接本身
+		if target, err := client.RealPath(name); err == nil {
+			if linfo, err := client.Lstat(name); err == nil && linfo.Mode()&os.ModeSymlink != 0 {
+				name = target
+			}
+		}
+
+		info, err := client.Stat(name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if info == nil {
+			return sftpWriteAtomic(client, name, bytes.NewReader(data), nil)
+		}
+
+		if backup {
+			old, err := client.Open(name)
+			if err != nil {
+				return err
+			}
+			err = sftpWriteAtomic(client, name+backupSuffix, old, info)
+			old.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return sftpWriteAtomic(client, name, bytes.NewReader(data), info)
+	})
+}
+
+// sftpWriteAtomic 通过临时文件和重命名替换远程文件，权限和属主与 orig 一致，orig 为 nil 时使用 0644
+func sftpWriteAtomic(client *sftp.Client, name string, r io.Reader, orig os.FileInfo) error {
+	perm := os.FileMode(0644)
+	if orig != nil {
+		perm = orig.Mode().Perm()
+	}
+
+	tmpPath := pathpkg.Join(pathpkg.Dir(name), fmt.Sprintf(".%s.%d.tmp", pathpkg.Base(name), time.Now().UnixNano()))
+	tmp, err := client.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tmp, r)
+	if err == nil && orig != nil {
+		// 只有 root 能把文件交给其他用户，失败时文件归当前用户所有
+		if st, ok := orig.Sys().(*sftp.FileStat); ok {
+			tmp.Chown(int(st.UID), int(st.GID))
+		}
+	}
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		// 服务器不支持 fsync 扩展时跳过同步
+		var statusErr *sftp.StatusError
+		if syncErr := tmp.Sync(); syncErr != nil && !(errors.As(syncErr, &statusErr) && statusErr.FxCode() == sftp.ErrSSHFxOpUnsupported) {
+			err = syncErr
+		}
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = sftpReplace(client, tmpPath, name)
+	}
+	if err != nil {
+		client.Remove(tmpPath)
+	}
+	return err
+}
+
+// sftpReplace 用 tmpPath 替换 name
+// 标准的 SFTP 重命名不能覆盖已存在的文件，优先使用 posix-rename 扩展；
+// 服务器不支持时先把原文件移到备份名，新文件就位后再删除备份，失败时恢复原文件
+func sftpReplace(client *sftp.Client, tmpPath, name string) error {
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		err := client.PosixRename(tmpPath, name)
+		var statusErr *sftp.StatusError
+		if !(errors.As(err, &statusErr) && statusErr.FxCode() == sftp.ErrSSHFxOpUnsupported) {
+			return err
+		}
+	}
+
+	backupPath := pathpkg.Join(pathpkg.Dir(name), fmt.Sprintf(".%s.%d.old", pathpkg.Base(name), time.Now().UnixNano()))
+	if err := client.Rename(name, backupPath); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return client.Rename(tmpPath, name)
+		}
+		return err
+	}
+	if err := client.Rename(tmpPath, name); err != nil {
+		client.Rename(backupPath, name)
+		return err
+	}
+	client.Remove(backupPath)
+	return nil
+}
+
+// sftpFile 远程文件，记录读取过程中的连接错误
+type sftpFile struct {
+	*sftp.File
+	fs      *sftpFS
+	conn    *sftpConn
+	connErr error
+	once    sync.Once
+}
+
+// Read 读取数据
+func (f *sftpFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) && isConnError(err) {
+		f.connErr = err
+	}
+	return n, err
+}
+
+// Close 关闭文件并归还连接
+func (f *sftpFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(func() {
+		f.fs.release(f.conn, f.connErr)
+	})
+	return err
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	testSFTPUser     = "tester"
+	testSFTPPassword = "secret"
+)
+
+// testSFTPServer 进程内的 SSH 服务器，SFTP 子系统由 pkg/sftp 的请求服务器提供，文件保存在内存中
+type testSFTPServer struct {
+	listener net.Listener
+	hostKey  ssh.Signer
+	handlers sftp.Handlers
+
+	mu    sync.Mutex
+	conns []net.Conn
+	dials int
+}
+
+// startTestSFTPServer 在回环地址上启动 SFTP 服务器，测试结束时关闭
+func startTestSFTPServer(t *testing.T) *testSFTPServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &testSFTPServer{listener: listener, hostKey: signer, handlers: sftp.InMemHandler()}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if meta.User() == testSFTPUser && string(password) == testSFTPPassword {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	config.AddHostKey(signer)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			srv.mu.Lock()
+			srv.conns = append(srv.conns, conn)
+			srv.dials++
+			srv.mu.Unlock()
+			go srv.serve(conn, config)
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		srv.dropConnections()
+	})
+	return srv
+}
+
+// serve 处理一个 SSH 连接，只接受 sftp 子系统
+func (srv *testSFTPServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					server := sftp.NewRequestServer(channel, srv.handlers)
+					go func() {
+						server.Serve()
+						server.Close()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+// dropConnections 断开所有已建立的连接，模拟网络中断
+func (srv *testSFTPServer) dropConnections() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, conn := range srv.conns {
+		conn.Close()
+	}
+	srv.conns = nil
+}
+
+// dialCount 获取服务器接受的连接数
+func (srv *testSFTPServer) dialCount() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.dials
+}
+
+// port 获取监听的端口
+func (srv *testSFTPServer) port() int {
+	return srv.listener.Addr().(*net.TCPAddr).Port
+}
+
+// knownHosts 写入 known_hosts 文件，key 为 nil 时写入服务器的主机密钥
+func (srv *testSFTPServer) knownHosts(t *testing.T, key ssh.PublicKey) string {
+	t.Helper()
+	if key == nil {
+		key = srv.hostKey.PublicKey()
+	}
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{srv.listener.Addr().String()}, key)
+	if err := os.WriteFile(path, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// config 生成连接该服务器的 SFTP 配置
+func (srv *testSFTPServer) config(knownHostsFile string) *SFTPConfig {
+	return &SFTPConfig{
+		Host:           "127.0.0.1",
+		Port:           srv.port(),
+		User:           testSFTPUser,
+		Password:       testSFTPPassword,
+		KnownHostsFile: knownHostsFile,
+		MaxConns:       2,
+		Timeout:        5,
+	}
+}
+
+// put 通过独立的客户端在服务器上创建文件
+func (srv *testSFTPServer) put(t *testing.T, files map[string]string) {
+	t.Helper()
+	conn, err := ssh.Dial("tcp", srv.listener.Addr().String(), &ssh.ClientConfig{
+		User:            testSFTPUser,
+		Auth:            []ssh.AuthMethod{ssh.Password(testSFTPPassword)},
+		HostKeyCallback: ssh.FixedHostKey(srv.hostKey.PublicKey()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for name, content := range files {
+		if err := client.MkdirAll(filepath.ToSlash(filepath.Dir(name))); err != nil {
+			t.Fatal(err)
+		}
+		f, err := client.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+}
+
+// newSFTPTestServer 创建以 SFTP 服务器上 /data 为根目录的服务器
+func newSFTPTestServer(t *testing.T, srv *testSFTPServer) *Server {
+	t.Helper()
+	return NewServer(&Config{RootDirs: []RootDirConfig{{
+		Name: "remote",
+		Type: RootTypeSFTP,
+		Path: "/data",
+		SFTP: srv.config(srv.knownHosts(t, nil)),
+	}}})
+}
+
+func TestSFTPRoot(t *testing.T) {
+	srv := startTestSFTPServer(t)
+	srv.put(t, map[string]string{
+		"/data/readme.txt":      "hello\nsftp world\n",
+		"/data/logs/app.log":    "line 1\nline 2\nerror here\n",
+		"/data/conf/app.json":   "{\"port\": 80}\n",
+		"/outside/secret.txt":   "secret",
+		"/data/conf/notes.conf": "a=1\nb=2\n",
+	})
+	s := newSFTPTestServer(t, srv)
+
+	t.Run("list", func(t *testing.T) {
+		var items []FileItem
+		decodeResponse(t, doRequest(s.handleList, "GET", "/api/list?path=/&root=0", ""), 200, &items)
+		names := map[string]bool{}
+		for _, item := range items {
+			names[item.Name] = item.IsDir
+		}
+		if isDir, ok := names["logs"]; !ok || !isDir {
+			t.Errorf("logs directory missing: %v", names)
+		}
+		if isDir, ok := names["readme.txt"]; !ok || isDir {
+			t.Errorf("readme.txt missing: %v", names)
+		}
+	})
+
+	t.Run("view", func(t *testing.T) {
+		var content FileContent
+		decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?path=/conf/notes.conf&root=0", ""), 200, &content)
+		if strings.Join(content.Lines, "|") != "a=1|b=2" {
+			t.Errorf("view = %q", content.Lines)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		var results []SearchResult
+		decodeResponse(t, doRequest(s.handleSearch, "GET", "/api/search?path=/logs/app.log&q=error&root=0", ""), 200, &results)
+		if len(results) != 1 || results[0].LineNumber != 3 {
+			t.Errorf("search = %+v", results)
+		}
+	})
+
+	t.Run("download", func(t *testing.T) {
+		rec := doRequest(s.handleDownload, "GET", "/api/download?path=/readme.txt&root=0", "")
+		if rec.Code != 200 || rec.Body.String() != "hello\nsftp world\n" {
+			t.Errorf("download = %d %q", rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Length"); got != strconv.Itoa(len("hello\nsftp world\n")) {
+			t.Errorf("Content-Length = %s", got)
+		}
+	})
+
+	t.Run("path outside root", func(t *testing.T) {
+		rec := doRequest(s.handleDownload, "GET", "/api/download?path=/../outside/secret.txt&root=0", "")
+		if rec.Code != 403 {
+			t.Errorf("status = %d, want 403", rec.Code)
+		}
+	})
+
+	t.Run("save", func(t *testing.T) {
+		body := mustJSON(t, SaveRequest{Path: "/conf/notes.conf", Content: "a=1\nb=3\n", Version: "*", Backup: true})
+		decodeResponse(t, doRequest(s.handleSave, "POST", "/api/save?root=0", body), 200, nil)
+
+		rec := doRequest(s.handleDownload, "GET", "/api/download?path=/conf/notes.conf&root=0", "")
+		if got := rec.Body.String(); got != "a=1\nb=3\n" {
+			t.Errorf("saved content = %q", got)
+		}
+		rec = doRequest(s.handleDownload, "GET", "/api/download?path=/conf/notes.conf.bak&root=0", "")
+		if got := rec.Body.String(); got != "a=1\nb=2\n" {
+			t.Errorf("backup content = %q", got)
+		}
+	})
+}
+
+func TestSFTPKnownHosts(t *testing.T) {
+	srv := startTestSFTPServer(t)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(otherKey)
+
+	tests := []struct {
+		name       string
+		knownHosts string
+		wantErr    bool
+	}{
+		{"matching key", srv.knownHosts(t, nil), false},
+		{"different key", srv.knownHosts(t, otherSigner.PublicKey()), true},
+		{"unknown host", filepath.Join(t.TempDir(), "empty"), true},
+	}
+	os.WriteFile(tests[2].knownHosts, nil, 0600)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys, err := newSFTPFS(srv.config(tt.knownHosts))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = fsys.Stat("/")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Stat error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var keyErr *knownhosts.KeyError
+			if tt.wantErr && !errors.As(err, &keyErr) {
+				t.Errorf("error = %v, want knownhosts.KeyError", err)
+			}
+		})
+	}
+}
+
+func TestSFTPReconnect(t *testing.T) {
+	srv := startTestSFTPServer(t)
+	srv.put(t, map[string]string{"/data/a.txt": "abc"})
+	fsys, err := newSFTPFS(srv.config(srv.knownHosts(t, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fsys.Stat("/data/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	dials := srv.dialCount()
+
+	// 池中的空闲连接已断开，下一次操作应重连后成功
+	srv.dropConnections()
+	info, err := fsys.Stat("/data/a.txt")
+	if err != nil {
+		t.Fatalf("Stat after drop: %v", err)
+	}
+	if info.Size() != 3 {
+		t.Errorf("size = %d", info.Size())
+	}
+	if srv.dialCount() <= dials {
+		t.Errorf("expected a new connection after the drop")
+	}
+
+	// 文件不存在不是连接错误，不应丢弃连接
+	dials = srv.dialCount()
+	if _, err := fsys.Stat("/data/missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat missing = %v", err)
+	}
+	if _, err := fsys.Stat("/data/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if srv.dialCount() != dials {
+		t.Errorf("connection was not reused after a not-exist error")
+	}
+}
+
+func TestSFTPAcquireTimeout(t *testing.T) {
+	srv := startTestSFTPServer(t)
+	srv.put(t, map[string]string{"/data/a.txt": "abc"})
+	config := srv.config(srv.knownHosts(t, nil))
+	config.MaxConns = 1
+	fsys, err := newSFTPFS(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys.acquireTimeout = 50 * time.Millisecond
+
+	// 打开的文件占用唯一的连接，其他操作等待超时后失败而不是一直阻塞
+	file, err := fsys.Open("/data/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Stat("/data/a.txt"); !errors.Is(err, errSFTPBusy) {
+		t.Errorf("Stat while pool is busy = %v", err)
+	}
+	file.Close()
+	if _, err := fsys.Stat("/data/a.txt"); err != nil {
+		t.Errorf("Stat after release = %v", err)
+	}
+}
+
+func TestSFTPViewSingleConnection(t *testing.T) {
+	srv := startTestSFTPServer(t)
+	var sb strings.Builder
+	for i := 1; i <= 100; i++ {
+		fmt.Fprintf(&sb, "line %d\n", i)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(sb.String()))
+	zw.Close()
+	srv.put(t, map[string]string{"/data/big.log": sb.String(), "/data/big.log.gz": gz.String()})
+	config := srv.config(srv.knownHosts(t, nil))
+	config.MaxConns = 1
+	s := NewServer(&Config{RootDirs: []RootDirConfig{{
+		Name: "remote",
+		Type: RootTypeSFTP,
+		Path: "/data",
+		SFTP: config,
+		View: &ViewConfig{MaxFileSize: 100},
+	}}})
+	// 同一请求需要第二个连接时会等待超时，而不是通过
+	s.backends[0].(*sftpFS).acquireTimeout = time.Second
+
+	var content FileContent
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?path=/big.log&root=0&from=2&count=3", ""), 200, &content)
+	if strings.Join(content.Lines, "|") != "line 2|line 3|line 4" || content.TotalLines != 100 {
+		t.Errorf("view = %q, totalLines = %d", content.Lines, content.TotalLines)
+	}
+
+	decodeResponse(t, doRequest(s.handleView, "GET", "/api/view?path=/big.log&root=0&offset=end&direction=backward&count=2", ""), 200, &content)
+	if strings.Join(content.Lines, "|") != "line 99|line 100" {
+		t.Errorf("offset view = %q", content.Lines)
+	}
+
+	// 压缩文件在请求已打开的文件上解压，游标不缓存，不会长期占用连接
+	for _, from := range []int{1, 50} {
+		decodeResponse(t, doRequest(s.handleView, "GET", fmt.Sprintf("/api/view?path=/big.log.gz&root=0&from=%d&count=2", from), ""), 200, &content)
+		if want := fmt.Sprintf("line %d|line %d", from, from+1); strings.Join(content.Lines, "|") != want || content.TotalLines != 100 {
+			t.Errorf("compressed view from %d = %q, totalLines = %d", from, content.Lines, content.TotalLines)
+		}
+	}
+	if _, err := s.backends[0].Stat("/data/big.log"); err != nil {
+		t.Errorf("connection still held after compressed view: %v", err)
+	}
+}
+
+func TestSFTPSaveReplace(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions []string
+	}{
+		{"posix-rename", []string{"posix-rename@openssh.com"}},
+		{"plain rename", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 服务器在握手时宣告扩展，测试结束后恢复默认值
+			if err := sftp.SetSFTPExtensions(tt.extensions...); err != nil {
+				t.Fatal(err)
+			}
+			defer sftp.SetSFTPExtensions("hardlink@openssh.com", "posix-rename@openssh.com", "statvfs@openssh.com")
+
+			srv := startTestSFTPServer(t)
+			srv.put(t, map[string]string{"/data/a.txt": "v1\n"})
+			s := newSFTPTestServer(t, srv)
+
+			// 第二次保存时备份文件和目标文件都已存在
+			for _, content := range []string{"v2\n", "v3\n"} {
+				body := mustJSON(t, SaveRequest{Path: "/a.txt", Content: content, Version: "*", Backup: true})
+				decodeResponse(t, doRequest(s.handleSave, "POST", "/api/save?root=0", body), 200, nil)
+			}
+			for name, want := range map[string]string{"a.txt": "v3\n", "a.txt.bak": "v2\n"} {
+				rec := doRequest(s.handleDownload, "GET", "/api/download?path=/"+name+"&root=0", "")
+				if got := rec.Body.String(); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+
+			var items []FileItem
+			decodeResponse(t, doRequest(s.handleList, "GET", "/api/list?path=/&root=0", ""), 200, &items)
+			for _, item := range items {
+				if strings.HasPrefix(item.Name, ".") {
+					t.Errorf("temporary file left: %s", item.Name)
+				}
+			}
+		})
+	}
+}
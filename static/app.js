@@ -6,8 +6,29 @@ let currentPage = 1;
 let totalPages = 1;
 // 当前查看的文件路径
 let currentFilePath = '';
-// 每页显示的行数（需要与后端保持一致）
-const LinesPerPage = 1000;
+// 按字节偏移查看大文件时当前段的范围，按页查看时为 null
+let currentWindow = null;
+// 等待后台统计总行数的定时器
+let lineCountTimer = null;
+// 是否以十六进制视图查看二进制文件
+let hexMode = false;
+// 十六进制视图当前段的起始偏移
+let hexOffset = 0;
+// 十六进制视图每段的字节数
+const HexPageBytes = 4096;
+// 是否以结构化日志查看
+let logMode = false;
+// 当前日志页的起始行，以及之前各页的起始行（用于返回上一页）
+let logFrom = 1;
+let logFromStack = [];
+// 当前日志页的下一页起始行，已到文件末尾时为 0
+let logNext = 0;
+// 是否以表格查看 CSV/TSV
+let tableMode = false;
+// 表格的页码、排序和各列的过滤条件（键为列号）
+let tableState = { page: 1, sort: '', order: 'asc', filters: {} };
+// 表格当前页的响应
+let tableData = null;
 // 当前搜索结果
 let currentSearchResults = [];
 // 当前搜索结果索引
@@ -18,8 +39,20 @@ let currentRootIndex = 0;
 let rootDirs = [];
 // 当前编辑的文件内容（用于保存）
 let currentFileContent = [];
+// 当前文件是否以换行符结尾（编辑时保留）
+let currentFileFinalNewline = false;
 // 是否是JSON文件
 let isJsonFile = false;
+// 当前文件的版本（保存时用于检测他人的修改）
+let currentFileVersion = '';
+// 编辑框中打开的文件的版本
+let editFileVersion = '';
+// 高级编辑器中的数据、打开时的原始数据和文件版本，保存时比较两者生成结构化编辑操作
+let advancedEditData = null;
+let advancedEditOriginal = null;
+let advancedEditVersion = '';
+// 手动选择的查看编码，为空时自动检测
+let viewEncoding = '';
 
 // DOM 元素
 const contentView = document.getElementById('contentView');
@@ -39,6 +72,8 @@ const prevResultBtn = document.getElementById('prevResultBtn');
 const nextResultBtn = document.getElementById('nextResultBtn');
 const searchNavInfo = document.getElementById('searchNavInfo');
 const rootSelect = document.getElementById('rootSelect');
+const saveEncodingSelect = document.getElementById('saveEncodingSelect');
+const saveLineEndingSelect = document.getElementById('saveLineEndingSelect');
 
 // 工具函数：格式化文件大小
 function formatSize(bytes) {
@@ -72,6 +107,11 @@ function normalizePath(path) {
     return normalized;
 }
 
+// 工具函数：判断是否为服务端可透明解压的压缩文件
+function isCompressedFile(extension) {
+    return ['gz', 'bz2', 'zst', 'xz'].includes((extension || '').toLowerCase());
+}
+
 // 工具函数：判断是否为文本文件
 function isTextFile(extension) {
     if (!extension) return false; // 无扩展名的文件默认不是文本文件
@@ -286,7 +326,8 @@ function renderFileList(files) {
     `;
 
     files.forEach(file => {
-        const actionButtons = file.isDir ? '' : `
+        // 归档内的条目只读，不显示操作按钮
+        const actionButtons = (file.isDir || file.path.includes('!/')) ? '' : `
             <button class="btn-small btn-delete-list btn-action" data-path="${file.path}" data-action="delete" title="删除">
                 <svg width="16" height="16" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round" style="color: #f48771;">
                     <polyline points="3 6 5 6 21 6"/>
@@ -298,7 +339,7 @@ function renderFileList(files) {
         `;
 
         html += `
-            <div class="file-item" data-path="${file.path}" data-is-dir="${file.isDir}">
+            <div class="file-item" data-path="${file.path}" data-is-dir="${file.isDir}" data-is-archive="${!!file.isArchive}">
                 <div class="file-icon">${getFileIcon(file.isDir, file.extension)}</div>
                 <div class="file-name-cell">${file.name}</div>
                 <div class="file-actions">${actionButtons}</div>
@@ -313,12 +354,16 @@ function renderFileList(files) {
         item.addEventListener('click', () => {
             const path = item.getAttribute('data-path');
             const isDir = item.getAttribute('data-is-dir') === 'true';
+            const isArchive = item.getAttribute('data-is-archive') === 'true';
             const extension = path.split('.').pop().toLowerCase();
 
             if (isDir) {
                 loadDirectory(path);
-            } else if (isTextFile(extension)) {
-                // 文本文件：查看内容
+            } else if (isArchive) {
+                // 归档文件：作为虚拟目录浏览
+                loadDirectory(path + '!');
+            } else if (isTextFile(extension) || isCompressedFile(extension)) {
+                // 文本文件和压缩日志：查看内容（压缩文件由服务端解压）
                 viewFile(path);
             } else {
                 // 非文本文件：直接下载
@@ -356,12 +401,39 @@ function downloadFile(path) {
     document.body.removeChild(link);
 }
 
-// 查看文件内容
+// 查看文件内容，打开大文件的开头时按字节偏移读取，无需等待统计总行数
 async function viewFile(path, page = 1) {
+    await loadFileContent(path, page === 1 ? '&offset=0' : `&page=${page}`);
+}
+
+// 按字节偏移查看大文件，offset 为 end 时打开末尾，direction 为 backward 时读取偏移之前的行
+async function viewFileAt(path, offset, direction = 'forward') {
+    await loadFileContent(path, `&offset=${offset}&direction=${direction}`);
+}
+
+// 重新加载当前查看的页或字节范围
+async function reloadFile(path) {
+    if (logMode) {
+        await viewLog(path, logFrom);
+    } else if (tableMode) {
+        await viewTable(path);
+    } else if (currentWindow) {
+        await viewFileAt(path, currentWindow.start);
+    } else {
+        await viewFile(path, currentPage);
+    }
+}
+
+// 加载文件内容，query 为页码或偏移参数
+async function loadFileContent(path, query) {
     try {
         showLoading();
         // 规范化路径
         path = normalizePath(path);
+        // 打开其他文件时恢复自动检测编码
+        if (path !== currentFilePath) {
+            viewEncoding = '';
+        }
         currentFilePath = path; // 保存当前文件路径
 
         // 从文件路径中提取目录路径，保存到 currentPath
@@ -372,9 +444,14 @@ async function viewFile(path, page = 1) {
         // 更新面包屑导航
         updateBreadcrumb(currentPath);
 
-        const url = `/api/view?path=${encodeURIComponent(path)}&page=${page}&root=${currentRootIndex}`;
+        const url = `/api/view?path=${encodeURIComponent(path)}${query}&root=${currentRootIndex}${encodingParam()}`;
         const response = await fetch(url);
 
+        // 二进制文件改用十六进制视图
+        if (response.status === 415) {
+            await viewHex(path, 0);
+            return;
+        }
         if (!response.ok) {
             throw new Error('Failed to load file');
         }
@@ -382,9 +459,16 @@ async function viewFile(path, page = 1) {
         const data = await response.json();
         currentPage = data.page;
         totalPages = data.totalPages;
+        currentWindow = data.window || null;
+        hexMode = false;
+        setLogMode(false);
+        setTableMode(false);
+        searchInput.placeholder = '搜索...';
 
         // 保存文件内容用于编辑
         currentFileContent = data.lines;
+        currentFileVersion = data.version || '';
+        currentFileFinalNewline = data.finalNewline === true;
 
         // 检查是否是JSON文件
         isJsonFile = path.toLowerCase().endsWith('.json');
@@ -417,7 +501,7 @@ async function viewFileAndScroll(path, page, lineNumber) {
         // 更新面包屑导航
         updateBreadcrumb(currentPath);
 
-        const url = `/api/view?path=${encodeURIComponent(path)}&page=${page}&root=${currentRootIndex}`;
+        const url = `/api/view?path=${encodeURIComponent(path)}&page=${page}&root=${currentRootIndex}${encodingParam()}`;
         const response = await fetch(url);
 
         if (!response.ok) {
@@ -427,6 +511,7 @@ async function viewFileAndScroll(path, page, lineNumber) {
         const data = await response.json();
         currentPage = data.page;
         totalPages = data.totalPages;
+        currentWindow = null;
 
         renderFileContent(data);
         showContentView();
@@ -486,22 +571,526 @@ function scrollToLine(lineNumber) {
     }, 3000);
 }
 
-// 渲染文件内容
-function renderFileContent(data) {
+// 以十六进制视图查看二进制文件，offset 为负数时从末尾倒数，highlight 为要高亮的字节偏移
+async function viewHex(path, offset, highlight = -1) {
+    try {
+        showLoading();
+        const url = `/api/hex?path=${encodeURIComponent(path)}&offset=${offset}&length=${HexPageBytes}&root=${currentRootIndex}`;
+        const response = await fetch(url);
+        if (!response.ok) {
+            throw new Error('Failed to load file');
+        }
+
+        const data = await response.json();
+        currentFilePath = path;
+        currentFileVersion = data.version || '';
+        hexMode = true;
+        hexOffset = data.offset;
+        setLogMode(false);
+        setTableMode(false);
+        document.getElementById('logViewBtn').style.display = 'none';
+        document.getElementById('tableViewBtn').style.display = 'none';
+        searchInput.placeholder = '十六进制字节，或 "文本"';
+
+        renderHexDump(data, highlight);
+        showContentView();
+
+        const target = fileContent.querySelector('.line-highlight');
+        if (target) {
+            target.scrollIntoView({ block: 'center' });
+        }
+    } catch (error) {
+        showError(error.message);
+    } finally {
+        hideLoading();
+    }
+}
+
+// 格式化十六进制偏移
+function formatOffset(offset) {
+    return offset.toString(16).padStart(8, '0');
+}
+
+// 渲染十六进制视图
+function renderHexDump(data, highlight) {
+    fileName.textContent = data.name;
+    fileInfo.textContent = `${formatSize(data.size)} • 二进制（${data.contentType}）• 偏移 0x${formatOffset(data.offset)}`;
+
+    // 十六进制部分按完整一行的宽度对齐
+    const hexWidth = data.bytesPerRow * 3;
+    fileContent.innerHTML = data.rows.map(row => {
+        const hit = highlight >= row.offset && highlight < row.offset + data.bytesPerRow;
+        return `<div class="file-line hex-line${hit ? ' line-highlight' : ''}" data-offset="${row.offset}">` +
+            `${formatOffset(row.offset)}  ${row.hex.padEnd(hexWidth)} |${escapeHtml(row.text)}|</div>`;
+    }).join('');
+    fileContent.style.display = 'block';
+    fileEditor.style.display = 'none';
+
+    // 二进制文件不能编辑
+    ['editFileBtn', 'advancedEditBtn', 'historyBtn'].forEach(id => {
+        const btn = document.getElementById(id);
+        if (btn) {
+            btn.style.display = 'none';
+        }
+    });
+
+    renderHexPagination(data);
+    pagination.style.display = 'flex';
+}
+
+// 渲染十六进制视图的翻页控件
+function renderHexPagination(data) {
+    const last = Math.max(0, data.size - 1);
+    const lastOffset = last - (last % HexPageBytes);
+    const atStart = data.offset === 0;
+    const atEnd = data.offset + data.length >= data.size;
+    const button = (text, offset, disabled) =>
+        `<button class="btn btn-secondary hex-page-btn" data-offset="${offset}"${disabled ? ' disabled' : ''}>${text}</button>`;
+
+    let html = button('« 开头', 0, atStart);
+    html += button('‹ 上一段', Math.max(0, data.offset - HexPageBytes), atStart);
+    html += `<span class="pagination-info">0x${formatOffset(data.offset)} / 0x${formatOffset(data.size)}</span>`;
+    html += `<input type="text" class="hex-offset-input" placeholder="跳转到偏移（如 0x1f00）">`;
+    html += button('下一段 ›', data.offset + HexPageBytes, atEnd);
+    html += button('末尾 »', lastOffset, atEnd);
+    pagination.innerHTML = html;
+
+    pagination.querySelectorAll('.hex-page-btn').forEach(btn => {
+        btn.addEventListener('click', () => viewHex(currentFilePath, parseInt(btn.dataset.offset)));
+    });
+    pagination.querySelector('.hex-offset-input').addEventListener('keypress', (e) => {
+        if (e.key !== 'Enter') return;
+        const value = e.target.value.trim();
+        const offset = /^0x/i.test(value) ? parseInt(value, 16) : parseInt(value, 10);
+        if (!isNaN(offset)) {
+            viewHex(currentFilePath, offset - (offset % HexPageBytes), offset);
+        }
+    });
+}
+
+// 在二进制文件中搜索字节，用双引号包围时按文本搜索，否则为十六进制字节
+async function searchHex(path, query) {
+    try {
+        showLoading();
+        const text = query.length >= 2 && query.startsWith('"') && query.endsWith('"');
+        const q = text ? query.slice(1, -1) : query;
+        const url = `/api/hexSearch?path=${encodeURIComponent(path)}&q=${encodeURIComponent(q)}&mode=${text ? 'text' : 'hex'}&root=${currentRootIndex}`;
+        const response = await fetch(url);
+        const result = await response.json();
+        if (!response.ok) {
+            throw new Error(result.error || '搜索失败');
+        }
+
+        currentSearchResults = result.matches.map(offset => ({ offset }));
+        currentSearchIndex = -1;
+        renderHexSearchResults(result);
+        if (currentSearchResults.length > 0) {
+            goToSearchResult(0);
+        }
+    } catch (error) {
+        showError(error.message);
+    } finally {
+        hideLoading();
+    }
+}
+
+// 渲染字节搜索结果
+function renderHexSearchResults(result) {
+    if (result.matches.length === 0) {
+        searchResults.innerHTML = '<div class="no-results">未找到匹配的结果</div>';
+        searchResults.style.display = 'block';
+        searchNav.style.display = 'none';
+        return;
+    }
+
+    let html = `<div class="search-results-header">找到 ${result.matches.length}${result.truncated ? '+' : ''} 个结果</div>`;
+    result.matches.forEach((offset, index) => {
+        html += `
+            <div class="search-result-item" data-index="${index}">
+                <span class="search-result-line-number">偏移 0x${formatOffset(offset)}</span>
+            </div>
+        `;
+    });
+
+    searchResults.innerHTML = html;
+    searchResults.style.display = 'block';
+    searchNav.style.display = 'flex';
+    updateSearchNavInfo();
+
+    document.querySelectorAll('.search-result-item').forEach(item => {
+        item.addEventListener('click', () => goToSearchResult(parseInt(item.getAttribute('data-index'))));
+    });
+}
+
+// 切换结构化日志视图，显示或隐藏过滤条件
+function setLogMode(enabled) {
+    logMode = enabled;
+    document.getElementById('logFilters').style.display = enabled ? 'flex' : 'none';
+    document.getElementById('logViewBtn').classList.toggle('active', enabled);
+    if (!hexMode) {
+        searchInput.placeholder = enabled ? '包含文本...' : '搜索...';
+    }
+}
+
+// 根据当前根目录可用的日志格式更新格式下拉框
+function updateLogFormatSelect() {
+    const select = document.getElementById('logFormatSelect');
+    const selected = select.value;
+    const root = rootDirs[currentRootIndex];
+    const formats = (root && root.logFormats) || [];
+    select.innerHTML = '<option value="auto">自动识别</option>' +
+        formats.map(format => `<option value="${escapeHtml(format)}">${escapeHtml(format)}</option>`).join('');
+    select.value = formats.includes(selected) ? selected : 'auto';
+}
+
+// 将过滤条件转换为查询参数，时间按浏览器本地时间解释
+function logFilterQuery() {
+    const params = new URLSearchParams();
+    params.set('log', document.getElementById('logFormatSelect').value || 'auto');
+    const level = document.getElementById('logLevelSelect').value;
+    if (level) {
+        params.set('level', level);
+    }
+    for (const name of ['since', 'until']) {
+        const value = document.getElementById(name === 'since' ? 'logSinceInput' : 'logUntilInput').value;
+        if (value) {
+            params.set(name, new Date(value).toISOString());
+        }
+    }
+    document.getElementById('logFieldInput').value.split(/\s+/).filter(Boolean).forEach(field => {
+        params.append('field', field);
+    });
+    const query = document.getElementById('logQueryInput').value.trim();
+    if (query) {
+        params.set('q', query);
+    }
+    return params.toString();
+}
+
+// 以结构化日志查看文件，from 为开始读取的行
+async function viewLog(path, from = 1) {
+    try {
+        showLoading();
+        const url = `/api/view?path=${encodeURIComponent(path)}&root=${currentRootIndex}&from=${from}&${logFilterQuery()}${encodingParam()}`;
+        const response = await fetch(url);
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(data.error || '解析日志失败');
+        }
+
+        currentWindow = null;
+        setTableMode(false);
+        logFrom = data.from;
+        logNext = data.next || 0;
+        setLogMode(true);
+        renderLogRecords(data);
+        showContentView();
+    } catch (error) {
+        showError(error.message);
+    } finally {
+        hideLoading();
+    }
+}
+
+// 应用过滤条件，从文件开头重新读取
+function applyLogFilters() {
+    logFromStack = [];
+    if (currentFilePath) {
+        viewLog(currentFilePath, 1);
+    }
+}
+
+// 渲染日志记录：时间、级别、消息和字段，后续的堆栈等行显示在记录之下
+function renderLogRecords(data) {
+    fileName.textContent = data.name;
+    fileInfo.textContent = `${formatSize(data.size)} • ${data.format}`;
+    if (data.encoding) {
+        fileInfo.textContent += ` • ${data.encoding.toUpperCase()}`;
+    }
+    document.getElementById('logInfo').textContent =
+        `第 ${data.from.toLocaleString()}-${(data.from + data.scanned - 1).toLocaleString()} 行中 ${data.records.length} 条记录`;
+
+    const html = data.records.map(record => {
+        const level = record.level || '';
+        const time = record.time ? `<span class="log-time">${escapeHtml(record.time)}</span>` : '';
+        const levelTag = level ? `<span class="log-level log-level-${escapeHtml(level)}">${escapeHtml(level.toUpperCase())}</span>` : '';
+        const message = record.raw !== undefined && !record.message
+            ? escapeHtml(record.raw)
+            : escapeHtml(record.message || '');
+        const fields = Object.entries(record.fields || {}).map(([key, value]) =>
+            `<span class="log-field"><span class="log-field-key">${escapeHtml(key)}</span>=${escapeHtml(value)}</span>`).join('');
+        const more = (record.more || []).map(line => `<div class="log-more">${escapeHtml(line)}</div>`).join('');
+        return `<div class="file-line log-record" data-line-number="${record.line}">${time}${levelTag}<span class="log-message">${message}</span>${fields}${more}</div>`;
+    }).join('');
+
+    fileContent.innerHTML = html || '<div class="log-empty">没有符合条件的记录</div>';
+    fileContent.style.display = 'block';
+    fileEditor.style.display = 'none';
+    fileContent.scrollTop = 0;
+
+    renderLogPagination();
+    pagination.style.display = 'flex';
+}
+
+// 日志分页：按游标继续读取，返回时使用记录的上一页起始行
+function renderLogPagination() {
+    const atStart = logFromStack.length === 0;
+    let html = `<button class="btn btn-secondary log-page-btn" data-action="first"${atStart ? ' disabled' : ''}>« 开头</button>`;
+    html += `<button class="btn btn-secondary log-page-btn" data-action="prev"${atStart ? ' disabled' : ''}>‹ 上一页</button>`;
+    html += `<span class="pagination-info">从第 ${logFrom.toLocaleString()} 行</span>`;
+    html += `<button class="btn btn-secondary log-page-btn" data-action="next"${logNext ? '' : ' disabled'}>下一页 ›</button>`;
+    pagination.innerHTML = html;
+
+    pagination.querySelectorAll('.log-page-btn').forEach(btn => {
+        btn.addEventListener('click', () => logPage(btn.dataset.action));
+    });
+}
+
+// 日志翻页：first 回到开头，prev 返回上一页，next 继续读取
+function logPage(action) {
+    if (action === 'next' && logNext) {
+        logFromStack.push(logFrom);
+        viewLog(currentFilePath, logNext);
+    } else if (action === 'prev' && logFromStack.length > 0) {
+        viewLog(currentFilePath, logFromStack.pop());
+    } else if (action === 'first' && logFromStack.length > 0) {
+        logFromStack = [];
+        viewLog(currentFilePath, 1);
+    }
+}
+
+// 判断文件是否为 CSV/TSV（包括压缩的）
+function isTableFile(path) {
+    const name = path.toLowerCase().replace(/\.(gz|bz2|zst|xz)$/, '');
+    return /\.(csv|tsv|tab)$/.test(name);
+}
+
+// 切换表格视图
+function setTableMode(enabled) {
+    tableMode = enabled;
+    document.getElementById('tableViewBtn').classList.toggle('active', enabled);
+}
+
+// 按表格查看文件，使用 tableState 中的页码、排序和过滤条件
+async function viewTable(path) {
+    try {
+        showLoading();
+        const params = new URLSearchParams({ path, root: currentRootIndex, page: tableState.page });
+        if (tableState.sort) {
+            params.set('sort', tableState.sort);
+            params.set('order', tableState.order);
+        }
+        // 以 = 开头时要求相等，否则为包含
+        for (const [column, value] of Object.entries(tableState.filters)) {
+            if (value.startsWith('=')) {
+                params.append('filter', `${column}:${value.slice(1)}`);
+            } else if (value) {
+                params.append('filter', `${column}~${value}`);
+            }
+        }
+        const response = await fetch(`/api/table?${params}${encodingParam()}`);
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(data.error || '解析表格失败');
+        }
+
+        currentWindow = null;
+        setLogMode(false);
+        setTableMode(true);
+        tableData = data;
+        renderTable(data);
+        showContentView();
+    } catch (error) {
+        showError(error.message);
+    } finally {
+        hideLoading();
+    }
+}
+
+// 渲染表格：点击表头排序，表头下方的输入框按列过滤
+function renderTable(data) {
     fileName.textContent = data.name;
-    fileInfo.textContent = `${formatSize(data.size)} • ${data.totalLines.toLocaleString()} 行`;
+    const total = data.totalRows >= 0 ? `${data.totalRows.toLocaleString()} 行` : '行数未知';
+    const delimiter = data.delimiter === '\t' ? 'Tab' : data.delimiter;
+    fileInfo.textContent = `${formatSize(data.size)} • ${total} • 分隔符 ${delimiter}${data.header ? '' : ' • 无表头'}`;
+
+    const headerCells = data.columns.map((column, index) => {
+        const key = String(index + 1);
+        const arrow = tableState.sort === key ? (tableState.order === 'desc' ? ' ▼' : ' ▲') : '';
+        return `<th class="table-sort" data-column="${key}" title="点击排序">${escapeHtml(column)}${arrow}</th>`;
+    }).join('');
+    const filterCells = data.columns.map((column, index) => {
+        const key = String(index + 1);
+        const value = tableState.filters[key] || '';
+        return `<th><input type="text" class="table-filter" data-column="${key}" value="${escapeHtml(value)}" placeholder="过滤"></th>`;
+    }).join('');
+    const rows = data.rows.map(row => {
+        const cells = data.columns.map((column, index) => `<td>${escapeHtml(row.cells[index] || '')}</td>`).join('');
+        return `<tr><td class="table-row-number" title="第 ${row.line} 行">${row.row}</td>${cells}</tr>`;
+    }).join('');
 
-    if (data.isPartial) {
+    fileContent.innerHTML = `<table class="data-table"><thead><tr><th>#</th>${headerCells}</tr><tr><th></th>${filterCells}</tr></thead><tbody>${rows}</tbody></table>` +
+        (data.rows.length === 0 ? '<div class="log-empty">没有符合条件的行</div>' : '');
+    fileContent.style.display = 'block';
+    fileEditor.style.display = 'none';
+
+    fileContent.querySelectorAll('.table-sort').forEach(th => {
+        th.addEventListener('click', () => {
+            // 依次切换升序、降序、不排序
+            const column = th.dataset.column;
+            if (tableState.sort !== column) {
+                tableState.sort = column;
+                tableState.order = 'asc';
+            } else if (tableState.order === 'asc') {
+                tableState.order = 'desc';
+            } else {
+                tableState.sort = '';
+            }
+            tableState.page = 1;
+            viewTable(currentFilePath);
+        });
+    });
+    fileContent.querySelectorAll('.table-filter').forEach(input => {
+        input.addEventListener('keypress', (e) => {
+            if (e.key !== 'Enter') return;
+            tableState.filters[input.dataset.column] = input.value;
+            tableState.page = 1;
+            viewTable(currentFilePath);
+        });
+    });
+
+    renderTablePagination(data);
+    pagination.style.display = 'flex';
+}
+
+// 表格分页：总行数未知时只能逐页向后
+function renderTablePagination(data) {
+    const page = data.page;
+    const totalPages = data.totalPages;
+    const button = (text, target, disabled) =>
+        `<button class="btn btn-secondary table-page-btn" data-page="${target}"${disabled ? ' disabled' : ''}>${text}</button>`;
+
+    let html = button('« 首页', 1, page === 1);
+    html += button('‹ 上一页', page - 1, page === 1);
+    html += `<span class="pagination-info">第 ${page}${totalPages ? ` / ${totalPages}` : ''} 页</span>`;
+    html += button('下一页 ›', page + 1, !data.more);
+    if (totalPages) {
+        html += button('末页 »', totalPages, page >= totalPages);
+    }
+    pagination.innerHTML = html;
+
+    pagination.querySelectorAll('.table-page-btn').forEach(btn => {
+        btn.addEventListener('click', () => tablePage(parseInt(btn.dataset.page)));
+    });
+}
+
+// 表格翻到指定页
+function tablePage(page) {
+    if (page < 1) {
+        return;
+    }
+    tableState.page = page;
+    viewTable(currentFilePath);
+}
+
+// 将行合并为编辑内容，文件以换行符结尾时在末尾补上换行符
+function joinLines(lines, finalNewline) {
+    const text = lines.join('\n');
+    return finalNewline && text !== '' ? text + '\n' : text;
+}
+
+// 将编辑内容分行，结尾的换行符不产生空行
+function splitLines(text) {
+    const lines = text.split('\n');
+    if (lines.length > 1 && lines[lines.length - 1] === '') {
+        lines.pop();
+    }
+    return lines;
+}
+
+// 换行符的显示名称
+const lineEndingNames = { lf: 'LF', crlf: 'CRLF', cr: 'CR' };
+
+// 手动选择了查看编码时附加到请求的参数
+function encodingParam() {
+    return viewEncoding ? `&encoding=${encodeURIComponent(viewEncoding)}` : '';
+}
+
+// 渲染文件信息：大小、行数、分页或字节范围、编码和换行符
+function renderFileInfo(data) {
+    const totalText = data.totalLines >= 0 ? data.totalLines.toLocaleString() : '统计中…';
+    fileInfo.textContent = `${formatSize(data.size)} • ${totalText} 行`;
+
+    if (data.window) {
+        fileInfo.textContent += ` • 字节 ${data.window.start.toLocaleString()}-${data.window.end.toLocaleString()}`;
+    } else if (data.isPartial) {
         fileInfo.textContent += ` • 第 ${data.page}/${data.totalPages} 页`;
     }
+    if (data.encoding) {
+        fileInfo.textContent += ` • ${data.encoding.toUpperCase()}${data.bom ? ' (BOM)' : ''}`;
+    }
+    if (data.lineEnding) {
+        fileInfo.textContent += ` • ${lineEndingNames[data.lineEnding] || data.lineEnding}${data.mixedLineEndings ? '（混用）' : ''}`;
+    }
+}
+
+// 总行数仍在后台统计时定时查询，完成后更新文件信息
+function pollLineCount(data) {
+    clearTimeout(lineCountTimer);
+    if (data.totalLines >= 0) {
+        return;
+    }
+    const path = currentFilePath;
+    lineCountTimer = setTimeout(async () => {
+        try {
+            const url = `/api/lineCount?path=${encodeURIComponent(path)}&root=${currentRootIndex}${encodingParam()}`;
+            const response = await fetch(url);
+            if (!response.ok || path !== currentFilePath || !currentWindow) {
+                return;
+            }
+            const result = await response.json();
+            if (!result.pending) {
+                data.totalLines = result.totalLines;
+            }
+            renderFileInfo(data);
+            pollLineCount(data);
+        } catch (error) {
+            console.warn('Failed to count lines:', error);
+        }
+    }, 1000);
+}
+
+// 渲染文件内容
+function renderFileContent(data) {
+    fileName.textContent = data.name;
+    renderFileInfo(data);
+    const encodingSelect = document.getElementById('encodingSelect');
+    if (encodingSelect) {
+        encodingSelect.value = viewEncoding;
+    }
 
-    // 显示内容并标记行号（只读模式）
+    // 显示内容并标记行号（只读模式），按字节偏移查看时行号可能未知
+    const firstLine = data.window ? data.window.firstLine : (data.from || 1);
+    const longLines = new Map((data.longLines || []).map(longLine => [longLine.index, longLine]));
     const linesHtml = data.lines.map((line, index) => {
-        const lineNum = (data.page - 1) * LinesPerPage + index + 1;
-        return `<div class="file-line" data-line-number="${lineNum}">${escapeHtml(line)}</div>`;
+        // 被截断的超长行可以分段加载完整内容
+        const longLine = longLines.get(index);
+        const className = longLine ? 'file-line long-line' : 'file-line';
+        const more = longLine
+            ? `<button class="long-line-more" data-index="${index}">显示完整行（${formatSize(longLine.length)}）</button>`
+            : '';
+        if (!firstLine) {
+            return `<div class="${className}"><span class="line-text">${escapeHtml(line)}</span>${more}</div>`;
+        }
+        const lineNum = firstLine + index;
+        return `<div class="${className}" data-line-number="${lineNum}"><span class="line-text">${escapeHtml(line)}</span>${more}</div>`;
     }).join('');
 
     fileContent.innerHTML = linesHtml;
+    fileContent.querySelectorAll('.long-line-more').forEach(btn => {
+        const longLine = longLines.get(parseInt(btn.dataset.index));
+        btn.addEventListener('click', () => loadLongLine(btn, longLine, parseInt(btn.dataset.from || '0')));
+    });
     fileContent.style.display = 'block';
     fileEditor.style.display = 'none';
 
@@ -510,10 +1099,11 @@ function renderFileContent(data) {
     const advancedEditBtn = document.getElementById('advancedEditBtn');
     if (editFileBtn && advancedEditBtn) {
         const extension = currentFilePath.split('.').pop().toLowerCase();
-        if (isTextFile(extension)) {
+        // 归档内的文件只读
+        if (isTextFile(extension) && !currentFilePath.includes('!/')) {
             editFileBtn.style.display = 'inline-flex';
-            // 如果是JSON文件，显示高级编辑按钮
-            if (extension === 'json') {
+            // JSON、YAML 和 TOML 文件显示高级编辑按钮
+            if (structuredFormat(currentFilePath)) {
                 advancedEditBtn.style.display = 'inline-flex';
             } else {
                 advancedEditBtn.style.display = 'none';
@@ -524,8 +1114,24 @@ function renderFileContent(data) {
         }
     }
 
+    // 文本文件可以按日志格式查看，CSV/TSV 可以按表格查看
+    document.getElementById('logViewBtn').style.display =
+        isTextFile(currentFilePath.split('.').pop().toLowerCase()) || data.compression ? 'inline-flex' : 'none';
+    document.getElementById('tableViewBtn').style.display = isTableFile(currentFilePath) ? 'inline-flex' : 'none';
+
+    // 启用了历史版本的根目录显示历史按钮
+    const historyBtn = document.getElementById('historyBtn');
+    if (historyBtn) {
+        const root = rootDirs[currentRootIndex];
+        historyBtn.style.display = root && root.history && !currentFilePath.includes('!/') ? 'inline-flex' : 'none';
+    }
+
     // 如果是分页内容，显示分页控件
-    if (data.isPartial) {
+    if (data.window) {
+        renderWindowPagination(currentFilePath, data.window);
+        pagination.style.display = 'flex';
+        pollLineCount(data);
+    } else if (data.isPartial) {
         renderPagination(currentFilePath, data.page, data.totalPages);
         pagination.style.display = 'flex';
     } else {
@@ -563,6 +1169,61 @@ function renderPagination(path, page, totalPages) {
     });
 }
 
+// 分段加载超长行：from 为 0 时替换截断的内容，否则追加到已加载的内容之后
+async function loadLongLine(btn, longLine, from) {
+    const lineElement = btn.closest('.file-line');
+    const textElement = lineElement.querySelector('.line-text');
+    const target = longLine.line ? `line=${longLine.line}` : `at=${longLine.at}`;
+    try {
+        btn.disabled = true;
+        const url = `/api/line?path=${encodeURIComponent(currentFilePath)}&${target}&from=${from}&length=1048576&root=${currentRootIndex}${encodingParam()}`;
+        const response = await fetch(url);
+        if (!response.ok) {
+            throw new Error('加载失败');
+        }
+        const slice = await response.json();
+        textElement.textContent = (from === 0 ? '' : textElement.textContent) + slice.text;
+        if (!slice.more) {
+            btn.remove();
+            lineElement.classList.remove('long-line');
+            return;
+        }
+
+        const loaded = slice.from + new TextEncoder().encode(slice.text).length;
+        btn.textContent = `继续加载（剩余 ${formatSize(slice.length - loaded)}）`;
+        btn.dataset.from = loaded;
+        btn.disabled = false;
+    } catch (error) {
+        btn.disabled = false;
+        showError(error.message);
+    }
+}
+
+// 渲染按字节偏移查看时的翻页控件，上一段读取当前段之前的行，下一段从当前段之后开始
+function renderWindowPagination(path, window) {
+    const createButton = (text, offset, direction, disabled) => {
+        if (disabled) {
+            return `<button class="btn btn-secondary" disabled>${text}</button>`;
+        }
+        return `<button class="btn btn-secondary pagination-btn" data-path="${escapeHtml(path)}" data-offset="${offset}" data-direction="${direction}" data-root="${currentRootIndex}">${text}</button>`;
+    };
+
+    let html = createButton('« 开头', 0, 'forward', window.atStart);
+    html += createButton('‹ 上一段', window.start, 'backward', window.atStart);
+    html += `<span class="pagination-info">${window.atEnd ? '已到末尾' : window.atStart ? '文件开头' : '文件中部'}</span>`;
+    html += createButton('下一段 ›', window.end, 'forward', window.atEnd);
+    html += createButton('末尾 »', 'end', 'backward', window.atEnd);
+
+    pagination.innerHTML = html;
+
+    document.querySelectorAll('.pagination-btn').forEach(btn => {
+        btn.addEventListener('click', () => {
+            currentRootIndex = parseInt(btn.getAttribute('data-root'));
+            viewFileAt(btn.getAttribute('data-path'), btn.getAttribute('data-offset'), btn.getAttribute('data-direction'));
+        });
+    });
+}
+
 // HTML 转义
 function escapeHtml(text) {
     const div = document.createElement('div');
@@ -637,33 +1298,92 @@ document.getElementById('fileInput').addEventListener('change', (e) => {
     e.target.value = '';
 });
 
-// 搜索功能
-searchBtn.addEventListener('click', () => {
-    const query = searchInput.value.trim();
-    if (query && currentFilePath) {
-        searchFile(currentFilePath, query);
-    }
+document.getElementById('uploadDirBtn').addEventListener('click', () => {
+    document.getElementById('dirInput').click();
 });
 
-// 支持回车键搜索
-searchInput.addEventListener('keypress', (e) => {
-    if (e.key === 'Enter') {
-        const query = searchInput.value.trim();
-        if (query && currentFilePath) {
-            searchFile(currentFilePath, query);
-        }
+document.getElementById('dirInput').addEventListener('change', (e) => {
+    const files = e.target.files;
+    if (files && files.length > 0) {
+        uploadFiles(files);
     }
+    e.target.value = '';
 });
 
-// 搜索导航按钮
-prevResultBtn.addEventListener('click', prevSearchResult);
-nextResultBtn.addEventListener('click', nextSearchResult);
+// 拖放上传文件和文件夹
+fileList.addEventListener('dragover', (e) => {
+    if (!e.dataTransfer.types.includes('Files')) return;
+    e.preventDefault();
+    fileList.classList.add('drag-over');
+});
 
-// 搜索文件内容
+fileList.addEventListener('dragleave', () => {
+    fileList.classList.remove('drag-over');
+});
+
+fileList.addEventListener('drop', async (e) => {
+    if (!e.dataTransfer.types.includes('Files')) return;
+    e.preventDefault();
+    fileList.classList.remove('drag-over');
+
+    // 必须在事件处理函数返回前取出所有条目
+    const entries = Array.from(e.dataTransfer.items)
+        .map(item => item.webkitGetAsEntry && item.webkitGetAsEntry())
+        .filter(Boolean);
+
+    if (entries.length === 0) {
+        uploadFiles(e.dataTransfer.files);
+        return;
+    }
+
+    const files = [];
+    try {
+        for (const entry of entries) {
+            await collectDroppedEntry(entry, '', files);
+        }
+    } catch (error) {
+        showError('读取拖入的文件失败');
+        return;
+    }
+    if (files.length > 0) {
+        uploadFiles(files);
+    }
+});
+
+// 搜索功能（十六进制视图中按字节搜索）
+function startSearch() {
+    const query = searchInput.value.trim();
+    if (!query || !currentFilePath) {
+        return;
+    }
+    if (hexMode) {
+        searchHex(currentFilePath, query);
+    } else if (logMode) {
+        document.getElementById('logQueryInput').value = query;
+        applyLogFilters();
+    } else {
+        searchFile(currentFilePath, query);
+    }
+}
+
+searchBtn.addEventListener('click', startSearch);
+
+// 支持回车键搜索
+searchInput.addEventListener('keypress', (e) => {
+    if (e.key === 'Enter') {
+        startSearch();
+    }
+});
+
+// 搜索导航按钮
+prevResultBtn.addEventListener('click', prevSearchResult);
+nextResultBtn.addEventListener('click', nextSearchResult);
+
+// 搜索文件内容
 async function searchFile(path, query) {
     try {
         showLoading();
-        const url = `/api/search?path=${encodeURIComponent(path)}&q=${encodeURIComponent(query)}&root=${currentRootIndex}`;
+        const url = `/api/search?path=${encodeURIComponent(path)}&q=${encodeURIComponent(query)}&root=${currentRootIndex}${encodingParam()}`;
         const response = await fetch(url);
 
         if (!response.ok) {
@@ -753,6 +1473,12 @@ function goToSearchResult(index) {
     // 更新导航信息
     updateSearchNavInfo();
 
+    // 字节搜索结果跳转到所在的段并高亮该行
+    if (hexMode) {
+        viewHex(currentFilePath, result.offset - (result.offset % HexPageBytes), result.offset);
+        return;
+    }
+
     // 判断搜索结果是否在当前页面
     if (result.page === currentPage) {
         // 在当前页面，直接滚动到目标行，无需重新加载
@@ -811,6 +1537,28 @@ document.addEventListener('keydown', (e) => {
             } else {
                 showListView();
             }
+        } else if (logMode && e.target.tagName === 'INPUT') {
+            return;
+        } else if (logMode && e.key === 'ArrowLeft') {
+            logPage('prev');
+        } else if (logMode && e.key === 'ArrowRight') {
+            logPage('next');
+        } else if (logMode) {
+            return;
+        } else if (tableMode && e.target.tagName !== 'INPUT' && tableData) {
+            if (e.key === 'ArrowLeft') {
+                tablePage(tableState.page - 1);
+            } else if (e.key === 'ArrowRight' && tableData.more) {
+                tablePage(tableState.page + 1);
+            }
+        } else if (tableMode) {
+            return;
+        } else if (currentWindow && e.key === 'ArrowLeft' && !currentWindow.atStart) {
+            if (currentFilePath) viewFileAt(currentFilePath, currentWindow.start, 'backward');
+        } else if (currentWindow && e.key === 'ArrowRight' && !currentWindow.atEnd) {
+            if (currentFilePath) viewFileAt(currentFilePath, currentWindow.end);
+        } else if (currentWindow) {
+            return;
         } else if (e.key === 'ArrowLeft' && currentPage > 1) {
             if (currentFilePath) viewFile(currentFilePath, currentPage - 1);
         } else if (e.key === 'ArrowRight' && currentPage < totalPages) {
@@ -835,7 +1583,12 @@ async function editFile(path) {
         }
 
         const data = await response.json();
-        const fullContent = data.lines.join('\n');
+        // 被截断的超长行只有开头的内容，整体编辑会丢失其余部分
+        if (data.longLines) {
+            throw new Error('文件包含被截断的超长行，无法在编辑器中编辑');
+        }
+        const fullContent = joinLines(data.lines, data.finalNewline);
+        editFileVersion = data.version || '';
 
         const modal = document.createElement('div');
         modal.id = 'editModal';
@@ -848,6 +1601,7 @@ async function editFile(path) {
                 </div>
                 <div class="modal-body">
                     <textarea id="editTextarea" class="edit-textarea" style="min-height: 500px;">${escapeHtml(fullContent)}</textarea>
+                    <div class="validation-errors" id="editModalErrors" style="display: none;"></div>
                 </div>
                 <div class="modal-footer">
                     <button class="btn btn-secondary" onclick="closeEditModal()">取消</button>
@@ -858,8 +1612,10 @@ async function editFile(path) {
         document.body.appendChild(modal);
         modal.style.display = 'flex';
 
-        // 自动聚焦到文本框
-        document.getElementById('editTextarea').focus();
+        // 自动聚焦到文本框，输入时校验内容
+        const textarea = document.getElementById('editTextarea');
+        textarea.focus();
+        watchValidation(textarea, document.getElementById('editModalErrors'), path);
     } catch (error) {
         showError(error.message);
     } finally {
@@ -875,30 +1631,228 @@ function closeEditModal() {
     }
 }
 
-// 保存文件编辑
-async function saveFileEdit(path) {
-    const textarea = document.getElementById('editTextarea');
-    const newContent = textarea.value;
+// 保存文件内容，version 为打开文件时得到的版本
+// 文件在打开后被他人修改时显示差异，由用户选择覆盖或放弃
+async function saveFileContent(path, content, version) {
+    const save = (v) => fetch(`/api/save?root=${currentRootIndex}`, {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json',
+            'If-Match': `"${v}"`,
+        },
+        body: JSON.stringify({
+            path: path,
+            content: content,
+            // 选择了保存编码时转换，否则按查看时使用的编码保存
+            encoding: saveEncodingSelect.value || viewEncoding || undefined,
+            // 选择了换行符时转换，否则由服务器保持原文件的换行符
+            lineEnding: saveLineEndingSelect.value || undefined,
+        }),
+    });
+
+    let response = await save(version || '*');
+    if (response.status === 409) {
+        const conflict = await response.json();
+        const diff = conflict.diff
+            ? conflict.diff.split('\n').slice(0, 40).join('\n')
+            : '（文件过大，无法显示差异）';
+        const message = `文件在打开后已被修改。\n\n当前文件与你的内容的差异：\n${diff}\n\n` +
+            `确定：用你的内容覆盖\n取消：放弃保存（可重新打开文件后再编辑）`;
+        if (!confirm(message)) {
+            throw new Error('文件已被修改，未保存');
+        }
+        response = await save(conflict.version);
+    }
+
+    if (!response.ok) {
+        const error = await response.json().catch(() => ({}));
+        // 内容不符合 JSON Schema 时列出出错的位置
+        if (error.errors && error.errors.length > 0) {
+            const list = error.errors.slice(0, 10).map(formatValidationError).join('\n');
+            const more = error.errors.length > 10 ? `\n……共 ${error.errors.length} 处错误` : '';
+            throw new Error(`保存失败，内容校验未通过：\n${list}${more}`);
+        }
+        throw new Error(error.error ? `保存失败：${error.error}` : '保存失败');
+    }
+    return response.json();
+}
+
+// 停止输入多久后校验编辑中的内容（毫秒）
+const ValidateDelay = 500;
+
+// 编辑时校验内容：有对应的 JSON Schema 时按 Schema 校验，否则检查 JSON、YAML、TOML 的语法，错误显示在 panel 中
+// 返回停止校验的函数
+function watchValidation(textarea, panel, path) {
+    let timer = null;
+    let seq = 0;
+    const stop = () => {
+        clearTimeout(timer);
+        seq++;
+        textarea.removeEventListener('input', schedule);
+        showValidationErrors(textarea, panel, []);
+    };
+    const validate = async () => {
+        const current = ++seq;
+        try {
+            const response = await fetch(`/api/validate?root=${currentRootIndex}`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ path: path, content: textarea.value }),
+            });
+            if (!response.ok || current !== seq) {
+                return;
+            }
+            const result = await response.json();
+            if (current !== seq) {
+                return;
+            }
+            if (!result.format) {
+                // 既没有 Schema 也不是结构化格式的文件不需要校验
+                stop();
+                return;
+            }
+            showValidationErrors(textarea, panel, result.errors || []);
+        } catch (error) {
+            // 校验只是提示，失败时忽略，保存时服务器仍会校验
+        }
+    };
+    const schedule = () => {
+        clearTimeout(timer);
+        timer = setTimeout(validate, ValidateDelay);
+    };
+
+    textarea.addEventListener('input', schedule);
+    showValidationErrors(textarea, panel, []);
+    validate();
+    return stop;
+}
+
+// 显示校验错误，点击有行号的错误时将光标移到出错的位置
+function showValidationErrors(textarea, panel, errors) {
+    panel.style.display = errors.length > 0 ? 'block' : 'none';
+    panel.innerHTML = errors.map(error =>
+        `<div class="validation-error${error.line ? ' clickable' : ''}">${escapeHtml(formatValidationError(error))}</div>`).join('');
+    panel.querySelectorAll('.validation-error').forEach((item, i) => {
+        const error = errors[i];
+        if (!error.line) {
+            return;
+        }
+        item.addEventListener('click', () => {
+            const lines = textarea.value.split('\n');
+            let offset = lines.slice(0, error.line - 1).reduce((n, line) => n + line.length + 1, 0);
+            offset += Math.max((error.column || 1) - 1, 0);
+            textarea.focus();
+            textarea.setSelectionRange(offset, offset);
+        });
+    });
+}
+
+// 修改查看中的单行内容，只提交这一行，适用于只加载了一页的大文件
+async function editFileLine(path, lineNumber, oldText) {
+    const newText = prompt(`修改第 ${lineNumber} 行（清空内容并确定将删除该行）：`, oldText);
+    if (newText === null || newText === oldText) {
+        return;
+    }
 
-    // 保存到服务器
     try {
         showLoading();
-        const response = await fetch(`/api/save?root=${currentRootIndex}`, {
+        const response = await fetch(`/api/patch?root=${currentRootIndex}`, {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
+                'If-Match': `"${currentFileVersion || '*'}"`,
             },
             body: JSON.stringify({
                 path: path,
-                content: newContent,
+                op: newText === '' ? 'delete' : 'replace',
+                startLine: lineNumber,
+                content: newText,
             }),
         });
+        const result = await response.json();
+        if (response.status === 409) {
+            throw new Error('文件在打开后已被修改，请重新加载后再修改');
+        }
+        if (!response.ok) {
+            throw new Error(result.error || '修改失败');
+        }
+        await reloadFile(path);
+    } catch (error) {
+        showError(error.message);
+    } finally {
+        hideLoading();
+    }
+}
 
+// 查看文件的历史版本，选择一个版本查看差异后恢复
+async function showFileHistory(path) {
+    try {
+        showLoading();
+        const response = await fetch(`/api/history?root=${currentRootIndex}&path=${encodeURIComponent(path)}`);
         if (!response.ok) {
-            throw new Error('保存失败');
+            const error = await response.json().catch(() => ({}));
+            throw new Error(error.error || '获取历史版本失败');
+        }
+        const versions = await response.json();
+        hideLoading();
+
+        if (versions.length === 0) {
+            alert('该文件还没有历史版本');
+            return;
         }
 
-        const result = await response.json();
+        const list = versions.map((v, i) =>
+            `${i + 1}. ${new Date(v.savedAt).toLocaleString()}（${formatSize(v.size)}）`).join('\n');
+        const choice = prompt(`历史版本（按被替换的时间，最新的在前）：\n${list}\n\n输入序号查看与当前内容的差异：`);
+        const index = parseInt(choice) - 1;
+        if (isNaN(index) || index < 0 || index >= versions.length) {
+            return;
+        }
+        const version = versions[index].id;
+
+        showLoading();
+        const diffResponse = await fetch(`/api/historyDiff?root=${currentRootIndex}&path=${encodeURIComponent(path)}&version=${encodeURIComponent(version)}`);
+        if (!diffResponse.ok) {
+            const error = await diffResponse.json().catch(() => ({}));
+            throw new Error(error.error || '获取差异失败');
+        }
+        const { diff } = await diffResponse.json();
+        hideLoading();
+
+        const preview = diff ? diff.split('\n').slice(0, 40).join('\n') : '（与当前内容相同）';
+        if (!confirm(`该版本与当前内容的差异：\n${preview}\n\n确定：恢复到该版本（当前内容会保存为新的历史版本）`)) {
+            return;
+        }
+
+        showLoading();
+        const restoreResponse = await fetch(`/api/historyRestore?root=${currentRootIndex}`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ path: path, version: version }),
+        });
+        const result = await restoreResponse.json();
+        if (!restoreResponse.ok) {
+            throw new Error(result.error || '恢复失败');
+        }
+        alert(result.message);
+        await viewFile(path);
+    } catch (error) {
+        showError(error.message);
+    } finally {
+        hideLoading();
+    }
+}
+
+// 保存文件编辑
+async function saveFileEdit(path) {
+    const textarea = document.getElementById('editTextarea');
+    const newContent = textarea.value;
+
+    // 保存到服务器
+    try {
+        showLoading();
+        const result = await saveFileContent(path, newContent, editFileVersion);
+        editFileVersion = result.version || '';
         alert(result.message);
         closeEditModal();
 
@@ -940,38 +1894,73 @@ async function deleteFileFromList(path) {
     }
 }
 
-// 高级编辑文件（JSON文件）
+// 根据扩展名判断能否结构化编辑，返回 json、yaml、toml 或空字符串
+function structuredFormat(path) {
+    const extension = path.split('.').pop().toLowerCase();
+    return { json: 'json', yaml: 'yaml', yml: 'yaml', toml: 'toml' }[extension] || '';
+}
+
+// 高级编辑文件（JSON、YAML 和 TOML 文件）
 async function advancedEditFile(path) {
     try {
         showLoading();
         // 规范化路径
         path = normalizePath(path);
+        const format = structuredFormat(path);
 
-        // 加载完整文件内容
-        const url = `/api/view?path=${encodeURIComponent(path)}&root=${currentRootIndex}`;
-        const response = await fetch(url);
+        let jsonData;
+        let name = path.split('/').pop();
+        if (format === 'json') {
+            // 加载完整文件内容
+            const url = `/api/view?path=${encodeURIComponent(path)}&root=${currentRootIndex}`;
+            const response = await fetch(url);
+
+            if (!response.ok) {
+                throw new Error('Failed to load file');
+            }
 
-        if (!response.ok) {
-            throw new Error('Failed to load file');
-        }
+            const data = await response.json();
 
-        const data = await response.json();
-        const fullContent = data.lines.join('\n');
+            // 大文件无法在浏览器中解析，改为在服务端按节点浏览和查询
+            if (data.isPartial) {
+                hideLoading();
+                openJsonExplorer(path, data.name);
+                return;
+            }
+
+            const fullContent = data.lines.join('\n');
 
-        // 验证文件内容不为空
-        if (!fullContent || fullContent.trim() === '') {
-            throw new Error('JSON文件为空');
+            // 验证文件内容不为空
+            if (!fullContent || fullContent.trim() === '') {
+                throw new Error('JSON文件为空');
+            }
+
+            // 尝试解析JSON
+            try {
+                jsonData = JSON.parse(fullContent);
+            } catch (parseError) {
+                throw new Error('无效的JSON格式: ' + parseError.message);
+            }
+            name = data.name;
+            advancedEditVersion = data.version || '';
+        } else {
+            // YAML 和 TOML 由服务端解析
+            const response = await fetch(`/api/structured?path=${encodeURIComponent(path)}&root=${currentRootIndex}`);
+            const data = await response.json();
+            if (!response.ok) {
+                throw new Error(data.errors ? data.errors.map(formatValidationError).join('\n') : (data.error || '读取文件失败'));
+            }
+            jsonData = data.value;
+            advancedEditVersion = data.version || '';
         }
 
-        // 尝试解析JSON
-        let jsonData;
-        try {
-            jsonData = JSON.parse(fullContent);
-        } catch (parseError) {
-            throw new Error('无效的JSON格式: ' + parseError.message);
+        if (typeof jsonData !== 'object' || jsonData === null) {
+            throw new Error('文件内容不是对象或数组');
         }
 
         currentFilePath = path;
+        advancedEditData = jsonData;
+        advancedEditOriginal = JSON.parse(JSON.stringify(jsonData));
 
         const modal = document.createElement('div');
         modal.id = 'advancedEditModal';
@@ -979,7 +1968,7 @@ async function advancedEditFile(path) {
         modal.innerHTML = `
             <div class="modal-content">
                 <div class="modal-header">
-                    <h3>JSON 高级编辑器: ${escapeHtml(data.name)}</h3>
+                    <h3>${format.toUpperCase()} 高级编辑器: ${escapeHtml(name)}</h3>
                     <button class="modal-close" onclick="closeAdvancedEditModal()">&times;</button>
                 </div>
                 <div class="modal-body">
@@ -994,10 +1983,163 @@ async function advancedEditFile(path) {
         document.body.appendChild(modal);
         modal.style.display = 'flex';
 
-        // 渲染 JSON 编辑器
+        // 渲染编辑器
         renderJsonEditor(jsonData, document.getElementById('jsonEditor'));
     } catch (error) {
-        showError('无法打开JSON高级编辑器: ' + error.message);
+        showError('无法打开高级编辑器: ' + error.message);
+    } finally {
+        hideLoading();
+    }
+}
+
+// 格式化内容无效的位置
+function formatValidationError(error) {
+    const location = [];
+    if (error.line) {
+        location.push(`第 ${error.line} 行${error.column ? `第 ${error.column} 列` : ''}`);
+    }
+    if (error.path) {
+        location.push(error.path);
+    }
+    return location.length > 0 ? `${location.join(' ')}: ${error.message}` : error.message;
+}
+
+// 每次展开节点时加载的子节点数
+const JsonExplorerLimit = 100;
+
+// 打开大 JSON 文件的浏览器：按节点逐级展开，或者输入 JSONPath 查询（只读）
+function openJsonExplorer(path, name) {
+    currentFilePath = path;
+    const modal = document.createElement('div');
+    modal.id = 'advancedEditModal';
+    modal.className = 'modal modal-large';
+    modal.innerHTML = `
+        <div class="modal-content">
+            <div class="modal-header">
+                <h3>JSON 浏览（只读）: ${escapeHtml(name)}</h3>
+                <button class="modal-close" onclick="closeAdvancedEditModal()">&times;</button>
+            </div>
+            <div class="modal-body">
+                <div class="json-query-bar">
+                    <input type="text" id="jsonQueryInput" class="search-input" placeholder="JSONPath，如 $.items[?(@.price > 10)].name">
+                    <button id="jsonQueryBtn" class="btn btn-small btn-primary">查询</button>
+                    <button id="jsonTreeBtn" class="btn btn-small">返回树</button>
+                </div>
+                <div id="jsonExplorer" class="json-explorer"></div>
+            </div>
+            <div class="modal-footer">
+                <button class="btn btn-secondary" onclick="closeAdvancedEditModal()">关闭</button>
+            </div>
+        </div>
+    `;
+    document.body.appendChild(modal);
+    modal.style.display = 'flex';
+
+    const container = document.getElementById('jsonExplorer');
+    const showTree = () => {
+        container.innerHTML = '';
+        loadJsonChildren(path, '$', container, 0);
+    };
+    const runQuery = () => {
+        const query = document.getElementById('jsonQueryInput').value.trim();
+        if (query) {
+            runJsonQuery(path, query, container);
+        } else {
+            showTree();
+        }
+    };
+    document.getElementById('jsonQueryBtn').addEventListener('click', runQuery);
+    document.getElementById('jsonQueryInput').addEventListener('keypress', (e) => {
+        if (e.key === 'Enter') runQuery();
+    });
+    document.getElementById('jsonTreeBtn').addEventListener('click', showTree);
+    showTree();
+}
+
+// 加载节点的一段子节点并追加到 container，还有更多时显示“加载更多”
+async function loadJsonChildren(path, node, container, offset) {
+    try {
+        const url = `/api/json?path=${encodeURIComponent(path)}&root=${currentRootIndex}&node=${encodeURIComponent(node)}&offset=${offset}&limit=${JsonExplorerLimit}${encodingParam()}`;
+        const response = await fetch(url);
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(data.error || '读取 JSON 失败');
+        }
+        if (data.node.type !== 'object' && data.node.type !== 'array') {
+            container.appendChild(renderJsonNode(path, data.node));
+            return;
+        }
+        data.children.forEach(child => container.appendChild(renderJsonNode(path, child)));
+        if (data.more) {
+            const more = document.createElement('button');
+            more.className = 'btn btn-small json-load-more';
+            more.textContent = `加载更多（共 ${data.node.children.toLocaleString()} 项）`;
+            more.addEventListener('click', () => {
+                more.remove();
+                loadJsonChildren(path, node, container, offset + data.children.length);
+            });
+            container.appendChild(more);
+        }
+    } catch (error) {
+        showError(error.message);
+    }
+}
+
+// 渲染树中的一个节点，对象和数组点击后展开
+function renderJsonNode(path, node) {
+    const item = document.createElement('div');
+    item.className = 'json-item';
+    const header = document.createElement('div');
+    header.className = 'json-item-header';
+    header.title = node.path;
+
+    const container = node.type === 'object' || node.type === 'array';
+    let summary;
+    if (container) {
+        summary = `<span class="json-item-type">${node.type} · ${node.children}</span>`;
+    } else {
+        const value = node.type === 'string' ? JSON.stringify(node.value) : String(node.value);
+        summary = `<span class="json-item-value">${escapeHtml(value)}${node.truncated ? '…' : ''}</span>`;
+    }
+    header.innerHTML = `${container && node.children > 0 ? '<span class="json-toggle">▸</span>' : ''}<span class="json-item-key">${escapeHtml(node.key || '$')}</span>${summary}`;
+    item.appendChild(header);
+
+    if (container && node.children > 0) {
+        const children = document.createElement('div');
+        children.className = 'json-item-children';
+        children.style.display = 'none';
+        item.appendChild(children);
+        header.style.cursor = 'pointer';
+        header.addEventListener('click', () => {
+            const open = children.style.display === 'none';
+            children.style.display = open ? 'block' : 'none';
+            header.querySelector('.json-toggle').textContent = open ? '▾' : '▸';
+            if (open && !children.dataset.loaded) {
+                children.dataset.loaded = 'true';
+                loadJsonChildren(path, node.path, children, 0);
+            }
+        });
+    }
+    return item;
+}
+
+// 执行 JSONPath 查询并列出结果
+async function runJsonQuery(path, query, container) {
+    try {
+        showLoading();
+        const url = `/api/json?path=${encodeURIComponent(path)}&root=${currentRootIndex}&query=${encodeURIComponent(query)}&limit=${JsonExplorerLimit}${encodingParam()}`;
+        const response = await fetch(url);
+        const data = await response.json();
+        if (!response.ok) {
+            throw new Error(data.error || '查询失败');
+        }
+        const rows = data.results.map(result => {
+            const value = result.value !== undefined ? JSON.stringify(result.value) : `${result.type}（${formatSize(result.size)}，过大未显示）`;
+            return `<div class="json-query-result"><span class="json-item-key">${escapeHtml(result.path)}</span><span class="json-item-value">${escapeHtml(value)}</span></div>`;
+        }).join('');
+        container.innerHTML = `<div class="json-query-info">${data.results.length} 个结果${data.more ? `（只显示前 ${data.results.length} 个）` : ''}</div>${rows}`;
+    } catch (error) {
+        showError(error.message);
     } finally {
         hideLoading();
     }
@@ -1055,6 +2197,11 @@ function renderJsonItem(value, container, path, key) {
 
     container.appendChild(itemDiv);
 
+    const input = itemDiv.querySelector(':scope > .json-item-value > .json-value-input');
+    if (input) {
+        input.addEventListener('change', () => setJsonByPath(advancedEditData, path, parseJsonInput(input.value)));
+    }
+
     if (isNested) {
         const childrenContainer = itemDiv.querySelector('.json-item-children');
         renderJsonEditor(value, childrenContainer, path);
@@ -1081,15 +2228,10 @@ function addJsonObjectField(data, path) {
     const defaultValue = prompt('请输入字段值（支持JSON格式）:');
     if (defaultValue === null) return;
 
-    try {
-        data[key] = JSON.parse(defaultValue);
-    } catch {
-        data[key] = defaultValue;
-    }
+    data[key] = parseJsonInput(defaultValue);
 
     // 重新渲染编辑器
-    const container = document.getElementById('jsonEditor');
-    renderJsonEditor(data, container, path);
+    renderJsonEditor(advancedEditData, document.getElementById('jsonEditor'));
 }
 
 // 添加 JSON 数组项
@@ -1097,15 +2239,10 @@ function addJsonArrayItem(data, path) {
     const value = prompt('请输入新项的值（支持JSON格式）:');
     if (value === null) return;
 
-    try {
-        data.push(JSON.parse(value));
-    } catch {
-        data.push(value);
-    }
+    data.push(parseJsonInput(value));
 
     // 重新渲染编辑器
-    const container = document.getElementById('jsonEditor');
-    renderJsonEditor(data, container, path);
+    renderJsonEditor(advancedEditData, document.getElementById('jsonEditor'));
 }
 
 // 删除 JSON 字段
@@ -1114,30 +2251,22 @@ function deleteJsonField(path) {
         return;
     }
 
-    // 从路径中获取并删除字段
-    const fullContent = currentFileContent.join('\n');
-
-    // 验证JSON格式
-    let jsonData;
-    try {
-        jsonData = JSON.parse(fullContent);
-    } catch (parseError) {
-        showError('无法删除字段：无效的JSON格式 - ' + parseError.message);
-        return;
-    }
-
     const parts = path.split(/\[|\]|\./).filter(p => p);
-    let current = jsonData;
+    let current = advancedEditData;
 
     for (let i = 0; i < parts.length - 1; i++) {
         current = current[parts[i]];
     }
 
-    delete current[parts[parts.length - 1]];
+    const last = parts[parts.length - 1];
+    if (Array.isArray(current)) {
+        current.splice(parseInt(last), 1);
+    } else {
+        delete current[last];
+    }
 
     // 重新渲染编辑器
-    const container = document.getElementById('jsonEditor');
-    renderJsonEditor(jsonData, container, '');
+    renderJsonEditor(advancedEditData, document.getElementById('jsonEditor'));
 }
 
 // 关闭高级编辑模态框
@@ -1148,75 +2277,37 @@ function closeAdvancedEditModal() {
     }
 }
 
-// 保存高级编辑
+// 保存高级编辑：只提交修改过的值，由服务端在原文件中修改，保留注释、键的顺序和缩进
 async function saveAdvancedEdit() {
     try {
         showLoading();
 
-        // 从原始文件内容中解析JSON数据作为基础
-        const fullContent = currentFileContent.join('\n');
-
-        // 尝试解析原始JSON数据
-        let jsonData;
-        if (!fullContent || fullContent.trim() === '') {
-            // 文件为空，从编辑器重新构建数据结构
-            jsonData = buildJsonFromEditor();
-            if (!jsonData) {
-                jsonData = {}; // 默认创建空对象
-            }
-        } else {
-            // 验证JSON格式
-            try {
-                jsonData = JSON.parse(fullContent);
-            } catch (parseError) {
-                throw new Error('无效的JSON格式: ' + parseError.message);
-            }
-
-            // 创建一个映射来跟踪哪些值被修改了
-            const modifiedValues = {};
-
-            // 收集所有输入的值
-            document.querySelectorAll('.json-value-input').forEach(input => {
-                const path = input.dataset.path;
-                const value = input.value;
-
-                // 尝试解析为JSON，如果失败则作为字符串
-                try {
-                    const parsed = JSON.parse(value);
-                    setJsonByPath(modifiedValues, path, parsed);
-                } catch {
-                    setJsonByPath(modifiedValues, path, value);
-                }
-            });
-
-            // 将修改的值合并到原始数据中
-            mergeJsonValues(jsonData, modifiedValues);
+        const ops = jsonEditOps(advancedEditOriginal, advancedEditData, '$');
+        if (ops.length === 0) {
+            closeAdvancedEditModal();
+            return;
         }
 
-        // 保存到服务器
-        const newContent = JSON.stringify(jsonData, null, 2);
-
-        const response = await fetch(`/api/save?root=${currentRootIndex}`, {
+        const response = await fetch(`/api/structured?root=${currentRootIndex}`, {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
+                'If-Match': `"${advancedEditVersion || '*'}"`,
             },
-            body: JSON.stringify({
-                path: currentFilePath,
-                content: newContent,
-            }),
+            body: JSON.stringify({ path: currentFilePath, ops: ops }),
         });
-
+        const result = await response.json();
+        if (response.status === 409) {
+            throw new Error('文件在打开后已被修改，请重新打开后再编辑');
+        }
         if (!response.ok) {
-            throw new Error('保存失败');
+            const details = result.errors ? '\n' + result.errors.map(formatValidationError).join('\n') : '';
+            throw new Error((result.error || '保存失败') + details);
         }
 
-        const result = await response.json();
         alert(result.message);
         closeAdvancedEditModal();
-
-        // 重新加载目录列表
-        await loadDirectory(currentPath);
+        await reloadFile(currentFilePath);
     } catch (error) {
         showError(error.message);
     } finally {
@@ -1224,6 +2315,51 @@ async function saveAdvancedEdit() {
     }
 }
 
+// 比较原始数据和编辑后的数据，生成 set 和 delete 操作
+function jsonEditOps(original, edited, path) {
+    const isObject = (v) => typeof v === 'object' && v !== null && !Array.isArray(v);
+    if (isObject(original) && isObject(edited)) {
+        const ops = [];
+        Object.keys(original).forEach(key => {
+            if (!(key in edited)) {
+                ops.push({ op: 'delete', path: jsonPathKey(path, key) });
+            }
+        });
+        Object.keys(edited).forEach(key => {
+            if (key in original) {
+                ops.push(...jsonEditOps(original[key], edited[key], jsonPathKey(path, key)));
+            } else {
+                ops.push({ op: 'set', path: jsonPathKey(path, key), value: edited[key] });
+            }
+        });
+        return ops;
+    }
+    if (Array.isArray(original) && Array.isArray(edited)) {
+        const ops = [];
+        const common = Math.min(original.length, edited.length);
+        for (let i = 0; i < common; i++) {
+            ops.push(...jsonEditOps(original[i], edited[i], `${path}[${i}]`));
+        }
+        // 多出的元素依次追加，缺少的元素从后往前删除
+        for (let i = common; i < edited.length; i++) {
+            ops.push({ op: 'set', path: `${path}[${i}]`, value: edited[i] });
+        }
+        for (let i = original.length - 1; i >= common; i--) {
+            ops.push({ op: 'delete', path: `${path}[${i}]` });
+        }
+        return ops;
+    }
+    if (JSON.stringify(original) === JSON.stringify(edited)) {
+        return [];
+    }
+    return [{ op: 'set', path: path, value: edited }];
+}
+
+// 生成子节点的 JSONPath，与服务端的格式一致
+function jsonPathKey(path, key) {
+    return /^[A-Za-z_$][\w$]*$/.test(key) ? `${path}.${key}` : `${path}[${JSON.stringify(key)}]`;
+}
+
 // 根据路径设置 JSON 值
 function setJsonByPath(obj, path, value) {
     const parts = path.split(/\[|\]|\./).filter(p => p);
@@ -1241,36 +2377,12 @@ function setJsonByPath(obj, path, value) {
     current[parts[parts.length - 1]] = value;
 }
 
-// 从编辑器 DOM 构建完整的 JSON 数据
-function buildJsonFromEditor() {
-    const editor = document.getElementById('jsonEditor');
-    if (!editor) return null;
-
-    const result = {};
-
-    // 遍历所有输入框并构建 JSON 结构
-    document.querySelectorAll('.json-value-input').forEach(input => {
-        const path = input.dataset.path;
-        const value = input.value;
-
-        // 尝试解析为JSON，如果失败则作为字符串
-        let parsedValue;
-        try {
-            parsedValue = JSON.parse(value);
-        } catch {
-            parsedValue = value;
-        }
-
-        setJsonByPath(result, path, parsedValue);
-    });
-
-    return result;
-}
-
-// 合并修改的值到原始JSON数据
-function mergeJsonValues(target, source) {
-    for (const path in source) {
-        setJsonByPath(target, path, source[path]);
+// 解析输入的值，不是有效的 JSON 时作为字符串
+function parseJsonInput(value) {
+    try {
+        return JSON.parse(value);
+    } catch {
+        return value;
     }
 }
 
@@ -1339,6 +2451,9 @@ async function uploadFiles(files) {
 
     let successCount = 0;
     let failCount = 0;
+    let skipCount = 0;
+    // 用户选择应用于所有冲突的处理方式
+    let conflictMode = null;
 
     for (let i = 0; i < files.length; i++) {
         const file = files[i];
@@ -1348,7 +2463,7 @@ async function uploadFiles(files) {
         const progressItem = document.createElement('div');
         progressItem.className = 'upload-progress-item';
         progressItem.innerHTML = `
-            <div class="upload-progress-name">${escapeHtml(file.name)}</div>
+            <div class="upload-progress-name">${escapeHtml(uploadRelativePath(file) || file.name)}</div>
             <div class="upload-progress-bar">
                 <div class="upload-progress-fill" id="${progressId}" style="width: 0%"></div>
                 <div class="upload-progress-text" id="${progressId}-text">0%</div>
@@ -1357,24 +2472,51 @@ async function uploadFiles(files) {
         uploadProgress.appendChild(progressItem);
 
         try {
-            await uploadSingleFile(file, progressId, progressId + '-text');
-            successCount++;
+            const policyError = checkUploadPolicy(file);
+            if (policyError) {
+                throw new Error(policyError);
+            }
+
+            let status = await uploadSingleFile(file, progressId, progressId + '-text', conflictMode || 'fail');
+            if (status === 'conflict') {
+                // 文件已存在时询问处理方式
+                const mode = askConflictMode(uploadRelativePath(file) || file.name);
+                if (i < files.length - 1 && confirm('对其余已存在的文件使用相同的处理方式？')) {
+                    conflictMode = mode;
+                }
+                status = await uploadSingleFile(file, progressId, progressId + '-text', mode);
+            }
+            if (status === 'conflict') {
+                throw new Error('文件已存在');
+            }
+            if (status === 'skipped') {
+                skipCount++;
+                document.getElementById(progressId + '-text').textContent = '已跳过';
+            } else {
+                successCount++;
+                // 更新本地记录的已用空间，供后续文件预先检查配额
+                const root = rootDirs[currentRootIndex];
+                if (root && root.used !== undefined) {
+                    root.used += file.size;
+                }
+            }
 
             // 标记完成
             document.getElementById(progressId).parentElement.parentElement.classList.add('upload-progress-complete');
         } catch (error) {
             failCount++;
-            showError(`${file.name} 上传失败: ${error.message}`);
+            showError(`${uploadRelativePath(file) || file.name} 上传失败: ${error.message}`);
             document.getElementById(progressId).style.background = '#e74c3c';
         }
     }
 
     // 上传完成后显示总结
     setTimeout(() => {
-        if (successCount > 0 && failCount === 0) {
+        const skipped = skipCount > 0 ? `，跳过 ${skipCount} 个` : '';
+        if (successCount > 0 && failCount === 0 && skipCount === 0) {
             alert(`成功上传 ${successCount} 个文件`);
-        } else if (successCount > 0 || failCount > 0) {
-            alert(`上传完成：成功 ${successCount} 个，失败 ${failCount} 个`);
+        } else if (successCount > 0 || failCount > 0 || skipCount > 0) {
+            alert(`上传完成：成功 ${successCount} 个，失败 ${failCount} 个${skipped}`);
         }
 
         // 隐藏进度条并重新加载目录
@@ -1383,11 +2525,190 @@ async function uploadFiles(files) {
     }, 1000);
 }
 
-// 上传单个文件
-async function uploadSingleFile(file, progressId, textId) {
+// 获取文件在所选文件夹内的相对路径，单独选择的文件返回空字符串
+function uploadRelativePath(file) {
+    return file.relativePath || file.webkitRelativePath || '';
+}
+
+// 递归读取拖入的文件夹，为每个文件记录相对路径
+async function collectDroppedEntry(entry, prefix, files) {
+    if (entry.isFile) {
+        const file = await new Promise((resolve, reject) => entry.file(resolve, reject));
+        file.relativePath = prefix + file.name;
+        files.push(file);
+        return;
+    }
+
+    if (entry.isDirectory) {
+        const reader = entry.createReader();
+        // readEntries 每次只返回一部分条目，需要读到返回空数组为止
+        for (;;) {
+            const entries = await new Promise((resolve, reject) => reader.readEntries(resolve, reject));
+            if (entries.length === 0) break;
+            for (const child of entries) {
+                await collectDroppedEntry(child, prefix + entry.name + '/', files);
+            }
+        }
+    }
+}
+
+// 按当前根目录的写入限制预先检查文件，不符合时返回原因
+// 内容类型由服务端按文件内容检测，这里只检查大小、配额和扩展名
+function checkUploadPolicy(file) {
+    const root = rootDirs[currentRootIndex];
+    const policy = root && root.upload;
+    if (!policy) return null;
+
+    if (policy.maxFileSize && file.size > policy.maxFileSize) {
+        return `文件超过大小上限 ${formatSize(policy.maxFileSize)}`;
+    }
+    if (policy.quota && root.used !== undefined && root.used + file.size > policy.quota) {
+        return `剩余空间不足（配额 ${formatSize(policy.quota)}，已用 ${formatSize(root.used)}）`;
+    }
+
+    const name = file.name.toLowerCase();
+    const matches = (extensions) => (extensions || []).some(ext => {
+        ext = ext.toLowerCase();
+        return name.endsWith(ext.startsWith('.') ? ext : '.' + ext);
+    });
+    if ((policy.allowedExtensions && policy.allowedExtensions.length > 0 && !matches(policy.allowedExtensions)) ||
+        matches(policy.blockedExtensions)) {
+        return '不允许上传该类型的文件';
+    }
+    return null;
+}
+
+// 根据上传失败的状态码生成错误信息
+function uploadErrorMessage(status) {
+    switch (status) {
+        case 413:
+            return '文件过大或超出配额';
+        case 415:
+            return '不允许上传该类型的文件';
+        default:
+            return '上传失败';
+    }
+}
+
+// 询问已存在文件的处理方式，返回 overwrite、rename 或 skip
+function askConflictMode(name) {
+    const answer = prompt(`"${name}" 已存在，请选择处理方式：\n1 - 覆盖\n2 - 保留两者（自动重命名）\n3 - 跳过`, '2');
+    const modes = { '1': 'overwrite', '2': 'rename', '3': 'skip' };
+    return modes[(answer || '').trim()] || 'skip';
+}
+
+// 超过该大小的文件使用可续传上传
+const RESUMABLE_UPLOAD_THRESHOLD = 32 * 1024 * 1024;
+// 可续传上传的分块大小
+const RESUMABLE_CHUNK_SIZE = 8 * 1024 * 1024;
+// 单个分块的最大重试次数
+const RESUMABLE_MAX_RETRIES = 5;
+
+// 更新上传进度条
+function setUploadProgress(progressId, textId, percent) {
+    const progressBar = document.getElementById(progressId);
+    const progressText = document.getElementById(textId);
+    if (progressBar) {
+        progressBar.style.width = percent + '%';
+    }
+    if (progressText) {
+        progressText.textContent = percent + '%';
+    }
+}
+
+// 可续传上传：分块发送，连接中断后从服务端记录的偏移继续
+// 返回 done、skipped 或 conflict（文件已存在）
+async function uploadResumable(file, progressId, textId, overwrite) {
+    const relativePath = uploadRelativePath(file);
+    const storageKey = `upload:${currentRootIndex}:${currentPath}:${relativePath || file.name}:${file.size}:${file.lastModified}`;
+    const encode = (value) => btoa(unescape(encodeURIComponent(value)));
+
+    // 查询服务端已接收的字节数，上传不存在时返回 -1
+    const queryOffset = async (location) => {
+        const response = await fetch(location, { method: 'HEAD', headers: { 'Tus-Resumable': '1.0.0' } });
+        if (!response.ok) return -1;
+        return parseInt(response.headers.get('Upload-Offset'), 10);
+    };
+
+    // 页面刷新后继续之前未完成的上传
+    let location = localStorage.getItem(storageKey);
+    let offset = location ? await queryOffset(location) : -1;
+
+    if (offset < 0) {
+        const response = await fetch(`/api/uploads?root=${currentRootIndex}`, {
+            method: 'POST',
+            headers: {
+                'Tus-Resumable': '1.0.0',
+                'Upload-Length': String(file.size),
+                'Upload-Metadata': `filename ${encode(file.name)},path ${encode(currentPath)}` +
+                    `,lastModified ${encode(String(file.lastModified))}` +
+                    // 分块上传不支持 skip，文件已存在时由客户端跳过
+                    `,overwrite ${encode(overwrite === 'skip' ? 'fail' : overwrite)}` +
+                    (relativePath ? `,relativePath ${encode(relativePath)}` : '')
+            }
+        });
+        if (response.status === 409) {
+            return overwrite === 'skip' ? 'skipped' : 'conflict';
+        }
+        if (!response.ok) {
+            throw new Error(uploadErrorMessage(response.status));
+        }
+        location = response.headers.get('Location');
+        localStorage.setItem(storageKey, location);
+        offset = 0;
+    }
+
+    let retries = 0;
+    while (offset < file.size) {
+        const chunk = file.slice(offset, offset + RESUMABLE_CHUNK_SIZE);
+        try {
+            const response = await fetch(location, {
+                method: 'PATCH',
+                headers: {
+                    'Tus-Resumable': '1.0.0',
+                    'Content-Type': 'application/offset+octet-stream',
+                    'Upload-Offset': String(offset)
+                },
+                body: chunk
+            });
+            if (!response.ok) {
+                throw new Error('上传失败');
+            }
+            offset = parseInt(response.headers.get('Upload-Offset'), 10);
+            retries = 0;
+        } catch (error) {
+            // 网络错误或偏移不一致：稍后向服务端查询实际偏移再继续
+            if (++retries > RESUMABLE_MAX_RETRIES) {
+                throw new Error('网络错误，请稍后重新上传以继续');
+            }
+            await new Promise(resolve => setTimeout(resolve, 1000 * retries));
+            offset = await queryOffset(location);
+            if (offset < 0) {
+                localStorage.removeItem(storageKey);
+                throw new Error('上传已过期，请重新上传');
+            }
+        }
+        setUploadProgress(progressId, textId, Math.round((offset / file.size) * 100));
+    }
+
+    localStorage.removeItem(storageKey);
+    return 'done';
+}
+
+// 上传单个文件，overwrite 为文件已存在时的处理方式
+// 返回 done、skipped 或 conflict（文件已存在）
+async function uploadSingleFile(file, progressId, textId, overwrite) {
+    if (file.size > RESUMABLE_UPLOAD_THRESHOLD) {
+        return uploadResumable(file, progressId, textId, overwrite);
+    }
+
+    // 服务器在接收文件前检查目标目录，其余字段须放在文件之前
     const formData = new FormData();
-    formData.append('file', file);
     formData.append('path', currentPath);
+    formData.append('relativePath', uploadRelativePath(file));
+    formData.append('lastModified', String(file.lastModified));
+    formData.append('overwrite', overwrite);
+    formData.append('file', file);
 
     const xhr = new XMLHttpRequest();
 
@@ -1409,11 +2730,12 @@ async function uploadSingleFile(file, progressId, textId) {
 
         xhr.addEventListener('load', () => {
             if (xhr.status === 200) {
-                resolve();
+                const result = JSON.parse(xhr.responseText);
+                resolve(result.results && result.results[0].skipped ? 'skipped' : 'done');
             } else if (xhr.status === 409) {
-                reject(new Error('文件已存在'));
+                resolve('conflict');
             } else {
-                reject(new Error('上传失败'));
+                reject(new Error(uploadErrorMessage(xhr.status)));
             }
         });
 
@@ -1471,17 +2793,33 @@ window.onload = function() {
     const editFileBtn = document.getElementById('editFileBtn');
     const saveFileBtn = document.getElementById('saveFileBtn');
     const fileEditor = document.getElementById('fileEditor');
+    const editorErrors = document.getElementById('editorErrors');
     let isEditMode = false;
+    let stopValidation = null;
+
+    // 退出编辑模式时停止校验
+    const endValidation = () => {
+        if (stopValidation) {
+            stopValidation();
+            stopValidation = null;
+        }
+    };
 
     if (editFileBtn && saveFileBtn && fileEditor) {
         editFileBtn.addEventListener('click', () => {
             if (!isEditMode) {
                 // 切换到编辑模式
                 isEditMode = true;
-                fileEditor.value = currentFileContent.join('\n');
+                fileEditor.value = joinLines(currentFileContent, currentFileFinalNewline);
                 fileContent.style.display = 'none';
                 fileEditor.style.display = 'block';
                 saveFileBtn.style.display = 'inline-flex';
+                saveEncodingSelect.value = '';
+                saveEncodingSelect.style.display = 'inline-block';
+                saveLineEndingSelect.value = '';
+                saveLineEndingSelect.style.display = 'inline-block';
+                endValidation();
+                stopValidation = watchValidation(fileEditor, editorErrors, currentFilePath);
                 editFileBtn.innerHTML = `
                     <svg width="14" height="14" viewBox="0 0 16 16" fill="currentColor">
                         <path d="M16 8A8 8 0 110 8a8 8 0 0116 0zm-3.97-3.03a.75.75 0 00-1.08.022L7.477 9.417 5.384 7.323a.75.75 0 00-1.06 1.06L6.97 11.03a.75.75 0 001.079-.02l3.992-4.99a.75.75 0 00-.01-1.05z"/>
@@ -1491,9 +2829,12 @@ window.onload = function() {
             } else {
                 // 取消编辑，返回查看模式
                 isEditMode = false;
+                endValidation();
                 fileContent.style.display = 'block';
                 fileEditor.style.display = 'none';
                 saveFileBtn.style.display = 'none';
+                saveEncodingSelect.style.display = 'none';
+                saveLineEndingSelect.style.display = 'none';
                 editFileBtn.innerHTML = `
                     <svg width="14" height="14" viewBox="0 0 16 16" fill="currentColor">
                         <path d="M12.854 2.854a.5.5 0 00-.708 0L11 4l1.5 1.5 1.146-1.146a.5.5 0 000-.708l-.792-.792zM10 5l-8.5 8.5V15h1.5L11.5 6.5 10 5z"/>
@@ -1506,33 +2847,24 @@ window.onload = function() {
             try {
                 showLoading();
                 const newContent = fileEditor.value;
-                const response = await fetch('/api/save', {
-                    method: 'POST',
-                    headers: {
-                        'Content-Type': 'application/json',
-                    },
-                    body: JSON.stringify({
-                        path: currentFilePath,
-                        content: newContent,
-                        root: currentRootIndex
-                    })
-                });
-
-                if (!response.ok) {
-                    throw new Error('保存失败');
-                }
+                const result = await saveFileContent(currentFilePath, newContent, currentFileVersion);
+                currentFileVersion = result.version || '';
 
                 // 更新当前内容
-                currentFileContent = newContent.split('\n');
+                currentFileContent = splitLines(newContent);
+                currentFileFinalNewline = newContent.endsWith('\n');
 
                 // 返回查看模式
                 isEditMode = false;
+                endValidation();
                 fileContent.innerHTML = currentFileContent.map((line, index) => {
                     return `<div class="file-line" data-line-number="${index + 1}">${escapeHtml(line)}</div>`;
                 }).join('');
                 fileContent.style.display = 'block';
                 fileEditor.style.display = 'none';
                 saveFileBtn.style.display = 'none';
+                saveEncodingSelect.style.display = 'none';
+                saveLineEndingSelect.style.display = 'none';
                 editFileBtn.innerHTML = `
                     <svg width="14" height="14" viewBox="0 0 16 16" fill="currentColor">
                         <path d="M12.854 2.854a.5.5 0 00-.708 0L11 4l1.5 1.5 1.146-1.146a.5.5 0 000-.708l-.792-.792zM10 5l-8.5 8.5V15h1.5L11.5 6.5 10 5z"/>
@@ -1548,6 +2880,71 @@ window.onload = function() {
         });
     }
 
+    // 双击行修改该行内容（归档内的文件只读）
+    fileContent.addEventListener('dblclick', (e) => {
+        const line = e.target.closest('.file-line');
+        const extension = currentFilePath.split('.').pop().toLowerCase();
+        if (!line || line.classList.contains('long-line') || hexMode || logMode || tableMode || !isTextFile(extension) || currentFilePath.includes('!/')) {
+            return;
+        }
+        editFileLine(currentFilePath, parseInt(line.dataset.lineNumber), line.textContent);
+    });
+
+    // 切换查看编码后按新编码重新加载
+    const encodingSelect = document.getElementById('encodingSelect');
+    if (encodingSelect) {
+        encodingSelect.addEventListener('change', () => {
+            viewEncoding = encodingSelect.value;
+            if (currentFilePath) {
+                reloadFile(currentFilePath);
+            }
+        });
+    }
+
+    // 切换结构化日志视图
+    document.getElementById('logViewBtn').addEventListener('click', () => {
+        if (!currentFilePath) {
+            return;
+        }
+        if (logMode) {
+            viewFile(currentFilePath);
+        } else {
+            updateLogFormatSelect();
+            applyLogFilters();
+        }
+    });
+    document.getElementById('logApplyBtn').addEventListener('click', applyLogFilters);
+
+    // 切换表格视图，打开时清除之前的排序和过滤条件
+    document.getElementById('tableViewBtn').addEventListener('click', () => {
+        if (!currentFilePath) {
+            return;
+        }
+        if (tableMode) {
+            viewFile(currentFilePath);
+        } else {
+            tableState = { page: 1, sort: '', order: 'asc', filters: {} };
+            viewTable(currentFilePath);
+        }
+    });
+    document.getElementById('logFormatSelect').addEventListener('change', applyLogFilters);
+    document.getElementById('logLevelSelect').addEventListener('change', applyLogFilters);
+    ['logFieldInput', 'logQueryInput', 'logSinceInput', 'logUntilInput'].forEach(id => {
+        document.getElementById(id).addEventListener('keypress', (e) => {
+            if (e.key === 'Enter') applyLogFilters();
+        });
+    });
+
+    // 历史版本按钮事件
+    const historyBtn = document.getElementById('historyBtn');
+    if (historyBtn) {
+        historyBtn.addEventListener('click', () => {
+            if (currentFilePath) {
+                showFileHistory(currentFilePath);
+            }
+        });
+    }
+
     // 高级编辑按钮事件（JSON文件）
     const advancedEditBtn = document.getElementById('advancedEditBtn');
     if (advancedEditBtn) {
@@ -0,0 +1,2173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// 支持结构化编辑的格式
+const (
+	StructuredJSON = "json"
+	StructuredYAML = "yaml"
+	StructuredTOML = "toml"
+)
+
+// 结构化编辑的操作
+const (
+	EditSet    = "set"    // 设置值，键不存在时添加
+	EditDelete = "delete" // 删除键或数组元素
+	EditInsert = "insert" // 插入到数组的指定下标之前，或者添加不存在的键
+)
+
+// EditOp 一个结构化编辑操作
+type EditOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`            // 目标路径，如 $.server.port、$.items[2]
+	Value json.RawMessage `json:"value,omitempty"` // set 和 insert 的新值
+}
+
+// StructuredEditRequest 结构化编辑请求，按顺序执行各个操作，全部成功且结果有效时才写入
+type StructuredEditRequest struct {
+	Path    string   `json:"path"`
+	Version string   `json:"version,omitempty"` // 打开文件时得到的版本，也可以通过 If-Match 请求头提供
+	Format  string   `json:"format,omitempty"`  // json、yaml 或 toml，默认按扩展名判断
+	Ops     []EditOp `json:"ops"`
+	DryRun  bool     `json:"dryRun,omitempty"` // 只返回修改后的内容和差异，不写入
+}
+
+// ValidationError 内容无效的位置和原因
+type ValidationError struct {
+	Path    string `json:"path,omitempty"`   // 出错的值的路径
+	Line    int    `json:"line,omitempty"`   // 行号（从 1 开始）
+	Column  int    `json:"column,omitempty"` // 列号（从 1 开始）
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	location := e.Path
+	if e.Line > 0 {
+		location = fmt.Sprintf("line %d", e.Line)
+		if e.Column > 0 {
+			location += fmt.Sprintf(", column %d", e.Column)
+		}
+	}
+	if location == "" {
+		return e.Message
+	}
+	return location + ": " + e.Message
+}
+
+// editError 操作无法应用到文档（路径不存在、类型不符等）
+type editError struct {
+	index int // 操作的下标
+	op    EditOp
+	err   error
+}
+
+func (e *editError) Error() string {
+	return fmt.Sprintf("op %d (%s %s): %v", e.index, e.op.Op, e.op.Path, e.err)
+}
+
+// structuredFormat 根据扩展名判断格式，不支持时返回空字符串
+func structuredFormat(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		return StructuredJSON
+	case ".yaml", ".yml":
+		return StructuredYAML
+	case ".toml":
+		return StructuredTOML
+	}
+	return ""
+}
+
+// offsetPosition 将字节偏移转换为行号和列号（按字符计）
+func offsetPosition(text string, offset int) (int, int) {
+	offset = min(max(offset, 0), len(text))
+	line := strings.Count(text[:offset], "\n") + 1
+	return line, utf8.RuneCountInString(text[strings.LastIndexByte(text[:offset], '\n')+1:offset]) + 1
+}
+
+// yamlErrorLine 匹配 yaml 错误信息中的行号
+var yamlErrorLine = regexp.MustCompile(`line (\d+)(?:, column (\d+))?: (.*)`)
+
+// parseStructured 解析内容并返回解码后的值（对象的键为字符串），内容无效时返回 *ValidationError
+func parseStructured(format, text string) (any, error) {
+	switch format {
+	case StructuredJSON:
+		dec := newJSONDecoder(strings.NewReader(text))
+		var v any
+		err := dec.Decode(&v)
+		if err == nil {
+			if _, err = dec.Token(); err == io.EOF {
+				return v, nil
+			} else if err == nil {
+				err = fmt.Errorf("unexpected content after the top-level value")
+			}
+		}
+		verr := &ValidationError{Message: err.Error()}
+		var syntaxErr *json.SyntaxError
+		switch {
+		case errors.As(err, &syntaxErr):
+			// Offset 包括出错的字符
+			verr.Line, verr.Column = offsetPosition(text, int(syntaxErr.Offset)-1)
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			verr.Message = "unexpected end of JSON input"
+			verr.Line, verr.Column = offsetPosition(text, len(text))
+		default:
+			verr.Line, verr.Column = offsetPosition(text, int(dec.InputOffset()))
+		}
+		return nil, verr
+
+	case StructuredYAML:
+		var v any
+		if err := yaml.Unmarshal([]byte(text), &v); err != nil {
+			verr := &ValidationError{Message: strings.TrimPrefix(err.Error(), "yaml: ")}
+			if m := yamlErrorLine.FindStringSubmatch(err.Error()); m != nil {
+				verr.Line, _ = strconv.Atoi(m[1])
+				verr.Column, _ = strconv.Atoi(m[2])
+				verr.Message = m[3]
+			}
+			return nil, verr
+		}
+		return normalizeStructured(v), nil
+
+	case StructuredTOML:
+		var v map[string]any
+		if _, err := toml.Decode(text, &v); err != nil {
+			verr := &ValidationError{Message: err.Error()}
+			var parseErr toml.ParseError
+			if errors.As(err, &parseErr) {
+				verr.Line, verr.Column = parseErr.Position.Line, parseErr.Position.Col
+				verr.Message = parseErr.Message
+			}
+			return nil, verr
+		}
+		return normalizeStructured(v), nil
+	}
+	return nil, fmt.Errorf("unsupported format: %s", format)
+}
+
+// normalizeStructured 将 YAML 和 TOML 解码的值转换为 JSON 的数据模型：
+// 对象的键转换为字符串，时间转换为 RFC 3339 字符串，整数和浮点数转换为 json.Number
+func normalizeStructured(v any) any {
+	switch value := v.(type) {
+	case map[string]any:
+		for k, child := range value {
+			value[k] = normalizeStructured(child)
+		}
+		return value
+	case map[any]any:
+		m := make(map[string]any, len(value))
+		for k, child := range value {
+			m[fmt.Sprint(k)] = normalizeStructured(child)
+		}
+		return m
+	case []any:
+		for i, child := range value {
+			value[i] = normalizeStructured(child)
+		}
+		return value
+	case []map[string]any:
+		list := make([]any, len(value))
+		for i, child := range value {
+			list[i] = normalizeStructured(child)
+		}
+		return list
+	case int:
+		return json.Number(strconv.Itoa(value))
+	case int64:
+		return json.Number(strconv.FormatInt(value, 10))
+	case uint64:
+		return json.Number(strconv.FormatUint(value, 10))
+	case float64:
+		return json.Number(strconv.FormatFloat(value, 'g', -1, 64))
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return value.String()
+	}
+	return v
+}
+
+// orderedField 对象的一个成员
+type orderedField struct {
+	Key   string
+	Value any
+}
+
+// orderedMap 保留键顺序的对象，操作中的新值按请求中的顺序写入文件
+type orderedMap []orderedField
+
+func (m orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	for i, field := range m {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := enc.Encode(field.Key); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(':')
+		if err := enc.Encode(field.Value); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// decodeOrdered 解码一个 JSON 值，对象解码为 orderedMap，数字解码为 json.Number
+func decodeOrdered(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+	switch delim {
+	case '{':
+		m := orderedMap{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, orderedField{Key: key.(string), Value: value})
+		}
+		_, err = dec.Token()
+		return m, err
+	case '[':
+		list := []any{}
+		for dec.More() {
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err = dec.Token()
+		return list, err
+	}
+	return nil, fmt.Errorf("unexpected %v", delim)
+}
+
+// structuredEditor 在保留原有格式的前提下修改文档
+type structuredEditor interface {
+	set(path []jsonSegment, value any) error
+	insert(path []jsonSegment, value any) error
+	remove(path []jsonSegment) error
+	String() string
+}
+
+// newStructuredEditor 解析文档，内容无效时返回 *ValidationError
+func newStructuredEditor(format, text string) (structuredEditor, error) {
+	if _, err := parseStructured(format, text); err != nil {
+		return nil, err
+	}
+	switch format {
+	case StructuredJSON:
+		return &jsonTextEditor{src: text}, nil
+	case StructuredYAML:
+		return newYAMLEditor(text)
+	case StructuredTOML:
+		return &tomlEditor{src: text}, nil
+	}
+	return nil, fmt.Errorf("unsupported format: %s", format)
+}
+
+// parseEditPath 解析操作的路径，只能由键和下标组成且不能为根
+func parseEditPath(expr string) ([]jsonSegment, error) {
+	segments, err := parseJSONPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("path must not be the document root")
+	}
+	for _, seg := range segments {
+		if seg.descendant || (seg.kind != jsonSegName && seg.kind != jsonSegIndex) {
+			return nil, fmt.Errorf("path must consist of names and indexes: %s", expr)
+		}
+	}
+	return segments, nil
+}
+
+// segmentsPath 生成路径的规范形式，用于错误信息和比较
+func segmentsPath(segments []jsonSegment) string {
+	path := "$"
+	for _, seg := range segments {
+		path = formatJSONPath(path, seg.name, seg.index, seg.kind == jsonSegIndex)
+	}
+	return path
+}
+
+// containerKind 返回路径片段要求的父节点类型
+func containerKind(seg jsonSegment) string {
+	if seg.kind == jsonSegName {
+		return "object"
+	}
+	return "array"
+}
+
+// applyEdits 依次执行操作并校验结果
+func applyEdits(format, text string, ops []EditOp) (string, error) {
+	editor, err := newStructuredEditor(format, text)
+	if err != nil {
+		return "", err
+	}
+	for i, op := range ops {
+		segments, err := parseEditPath(op.Path)
+		if err != nil {
+			return "", &editError{index: i, op: op, err: err}
+		}
+		var value any
+		if op.Op == EditSet || op.Op == EditInsert {
+			if len(op.Value) == 0 {
+				return "", &editError{index: i, op: op, err: fmt.Errorf("value is required")}
+			}
+			value, err = decodeOrdered(newJSONDecoder(bytes.NewReader(op.Value)))
+			if err != nil {
+				return "", &editError{index: i, op: op, err: fmt.Errorf("invalid value: %v", err)}
+			}
+		}
+		switch op.Op {
+		case EditSet:
+			err = editor.set(segments, value)
+		case EditInsert:
+			err = editor.insert(segments, value)
+		case EditDelete:
+			err = editor.remove(segments)
+		default:
+			err = fmt.Errorf("unknown op: %s", op.Op)
+		}
+		if err != nil {
+			return "", &editError{index: i, op: op, err: err}
+		}
+	}
+
+	result := editor.String()
+	if _, err := parseStructured(format, result); err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+// jsonSpan JSON 文本中的一个值及其位置
+type jsonSpan struct {
+	kind    byte // '{'、'[' 或 'v'（标量）
+	start   int
+	end     int
+	keys    []string // 对象的键
+	keyPos  [][2]int // 对象各个键（含引号）的起止位置
+	members []*jsonSpan
+}
+
+// jsonSpanParser 解析 JSON 文本并记录每个值的位置
+type jsonSpanParser struct {
+	src string
+	i   int
+}
+
+func (p *jsonSpanParser) skipSpace() {
+	for p.i < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *jsonSpanParser) value() (*jsonSpan, error) {
+	p.skipSpace()
+	if p.i >= len(p.src) {
+		return nil, fmt.Errorf("unexpected end of JSON input")
+	}
+	span := &jsonSpan{start: p.i}
+	switch c := p.src[p.i]; c {
+	case '{', '[':
+		span.kind = c
+		closing := byte('}')
+		if c == '[' {
+			closing = ']'
+		}
+		p.i++
+		p.skipSpace()
+		if p.i < len(p.src) && p.src[p.i] == closing {
+			p.i++
+			span.end = p.i
+			return span, nil
+		}
+		for {
+			if c == '{' {
+				p.skipSpace()
+				keyStart := p.i
+				if err := p.string(); err != nil {
+					return nil, err
+				}
+				var key string
+				if err := json.Unmarshal([]byte(p.src[keyStart:p.i]), &key); err != nil {
+					return nil, err
+				}
+				span.keys = append(span.keys, key)
+				span.keyPos = append(span.keyPos, [2]int{keyStart, p.i})
+				p.skipSpace()
+				if p.i >= len(p.src) || p.src[p.i] != ':' {
+					return nil, fmt.Errorf("expected : at offset %d", p.i)
+				}
+				p.i++
+			}
+			member, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			span.members = append(span.members, member)
+			p.skipSpace()
+			if p.i >= len(p.src) {
+				return nil, fmt.Errorf("unexpected end of JSON input")
+			}
+			if p.src[p.i] == closing {
+				p.i++
+				span.end = p.i
+				return span, nil
+			}
+			if p.src[p.i] != ',' {
+				return nil, fmt.Errorf("expected , at offset %d", p.i)
+			}
+			p.i++
+		}
+	case '"':
+		span.kind = 'v'
+		if err := p.string(); err != nil {
+			return nil, err
+		}
+	default:
+		span.kind = 'v'
+		for p.i < len(p.src) && strings.IndexByte(" \t\r\n,]}", p.src[p.i]) < 0 {
+			p.i++
+		}
+	}
+	span.end = p.i
+	return span, nil
+}
+
+func (p *jsonSpanParser) string() error {
+	if p.i >= len(p.src) || p.src[p.i] != '"' {
+		return fmt.Errorf("expected string at offset %d", p.i)
+	}
+	for p.i++; p.i < len(p.src); p.i++ {
+		switch p.src[p.i] {
+		case '\\':
+			p.i++
+		case '"':
+			p.i++
+			return nil
+		}
+	}
+	return fmt.Errorf("unterminated string")
+}
+
+// jsonTextEditor 直接修改 JSON 文本，未修改的部分保持原样
+type jsonTextEditor struct {
+	src string
+}
+
+func (e *jsonTextEditor) String() string { return e.src }
+
+// lookup 返回路径指向的值，以及其父节点和在父节点中的位置，不存在时 node 为 nil
+func (e *jsonTextEditor) lookup(path []jsonSegment) (parent, node *jsonSpan, index int, err error) {
+	p := &jsonSpanParser{src: e.src}
+	node, err = p.value()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	for depth, seg := range path {
+		parent, node, index = node, nil, -1
+		switch {
+		case seg.kind == jsonSegName && parent.kind == '{':
+			for i, key := range parent.keys {
+				if key == seg.name {
+					index = i
+				}
+			}
+		case seg.kind == jsonSegIndex && parent.kind == '[':
+			if seg.index < len(parent.members) {
+				index = seg.index
+			}
+		default:
+			return nil, nil, 0, fmt.Errorf("%s is not an %s", segmentsPath(path[:depth]), containerKind(seg))
+		}
+		if index < 0 {
+			if depth < len(path)-1 {
+				return nil, nil, 0, fmt.Errorf("%s not found", segmentsPath(path[:depth+1]))
+			}
+			return parent, nil, 0, nil
+		}
+		node = parent.members[index]
+	}
+	return parent, node, index, nil
+}
+
+// lineIndent 返回 pos 所在行开头的空白
+func (e *jsonTextEditor) lineIndent(pos int) string {
+	start := strings.LastIndexByte(e.src[:pos], '\n') + 1
+	end := start
+	for end < len(e.src) && (e.src[end] == ' ' || e.src[end] == '\t') {
+		end++
+	}
+	return e.src[start:end]
+}
+
+// indentUnit 从文档中已有的多行对象或数组推断每层缩进，默认两个空格
+func (e *jsonTextEditor) indentUnit() string {
+	p := &jsonSpanParser{src: e.src}
+	root, err := p.value()
+	if err != nil {
+		return "  "
+	}
+	var find func(span *jsonSpan) string
+	find = func(span *jsonSpan) string {
+		if len(span.members) > 0 && e.multiline(span) {
+			first := span.members[0].start
+			if span.kind == '{' {
+				first = span.keyPos[0][0]
+			}
+			outer, inner := e.lineIndent(span.start), e.lineIndent(first)
+			if strings.HasPrefix(inner, outer) && len(inner) > len(outer) {
+				return inner[len(outer):]
+			}
+		}
+		for _, member := range span.members {
+			if unit := find(member); unit != "" {
+				return unit
+			}
+		}
+		return ""
+	}
+	if unit := find(root); unit != "" {
+		return unit
+	}
+	return "  "
+}
+
+// multiline 对象或数组的成员是否各占一行
+func (e *jsonTextEditor) multiline(span *jsonSpan) bool {
+	return strings.Contains(e.src[span.start:span.end], "\n")
+}
+
+// marshal 按所在位置的缩进格式化新值，位于单行的对象或数组中时使用紧凑格式
+func (e *jsonTextEditor) marshal(value any, indent string, pretty bool) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if pretty {
+		enc.SetIndent(indent, e.indentUnit())
+	}
+	if err := enc.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// replace 替换 [start, end) 的文本
+func (e *jsonTextEditor) replace(start, end int, text string) {
+	e.src = e.src[:start] + text + e.src[end:]
+}
+
+// memberStart 成员的起始位置（对象成员从键开始）
+func memberStart(parent *jsonSpan, i int) int {
+	if parent.kind == '{' {
+		return parent.keyPos[i][0]
+	}
+	return parent.members[i].start
+}
+
+func (e *jsonTextEditor) set(path []jsonSegment, value any) error {
+	parent, node, _, err := e.lookup(path)
+	if err != nil {
+		return err
+	}
+	if node != nil {
+		text, err := e.marshal(value, e.lineIndent(node.start), parent == nil || e.multiline(parent))
+		if err != nil {
+			return err
+		}
+		e.replace(node.start, node.end, text)
+		return nil
+	}
+	last := path[len(path)-1]
+	if last.kind == jsonSegIndex && last.index != len(parent.members) {
+		return fmt.Errorf("index %d is out of range (length %d)", last.index, len(parent.members))
+	}
+	return e.add(parent, len(parent.members), last.name, value)
+}
+
+func (e *jsonTextEditor) insert(path []jsonSegment, value any) error {
+	parent, node, _, err := e.lookup(path)
+	if err != nil {
+		return err
+	}
+	last := path[len(path)-1]
+	if last.kind == jsonSegName {
+		if node != nil {
+			return fmt.Errorf("%s already exists", segmentsPath(path))
+		}
+		return e.add(parent, len(parent.members), last.name, value)
+	}
+	if last.index > len(parent.members) {
+		return fmt.Errorf("index %d is out of range (length %d)", last.index, len(parent.members))
+	}
+	return e.add(parent, last.index, "", value)
+}
+
+// add 在父节点的第 i 个成员之前插入新成员，沿用相邻成员的分隔和缩进
+func (e *jsonTextEditor) add(parent *jsonSpan, i int, key string, value any) error {
+	n := len(parent.members)
+	pretty := e.multiline(parent) || (n == 0 && e.multilineDocument())
+	indent := e.lineIndent(parent.start) + e.indentUnit()
+	if n > 0 {
+		indent = e.lineIndent(memberStart(parent, min(i, n-1)))
+	}
+
+	text, err := e.marshal(value, indent, pretty)
+	if err != nil {
+		return err
+	}
+	if parent.kind == '{' {
+		separator := ": "
+		if n > 0 {
+			separator = e.src[parent.keyPos[n-1][1]:parent.members[n-1].start]
+		}
+		keyText, _ := json.Marshal(key)
+		text = string(keyText) + separator + text
+	}
+
+	switch {
+	case n == 0 && pretty:
+		e.replace(parent.start+1, parent.end-1, "\n"+indent+text+"\n"+e.lineIndent(parent.start))
+	case n == 0:
+		e.replace(parent.start+1, parent.end-1, text)
+	case i < n:
+		// 插入到第 i 个成员之前，沿用该成员之前的分隔
+		start := memberStart(parent, i)
+		gap := ", "
+		if i > 0 {
+			gap = e.src[parent.members[i-1].end:start]
+		} else if pretty {
+			gap = ",\n" + indent
+		}
+		e.replace(start, start, text+gap)
+	default:
+		// 追加到最后一个成员之后
+		gap := ", "
+		if n > 1 {
+			gap = e.src[parent.members[n-2].end:memberStart(parent, n-1)]
+		} else if pretty {
+			gap = ",\n" + indent
+		}
+		end := parent.members[n-1].end
+		e.replace(end, end, gap+text)
+	}
+	return nil
+}
+
+// multilineDocument 文档是否为多行格式
+func (e *jsonTextEditor) multilineDocument() bool {
+	return strings.Contains(strings.TrimSpace(e.src), "\n")
+}
+
+func (e *jsonTextEditor) remove(path []jsonSegment) error {
+	parent, node, i, err := e.lookup(path)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return fmt.Errorf("%s not found", segmentsPath(path))
+	}
+	n := len(parent.members)
+	switch {
+	case n == 1:
+		e.replace(parent.start+1, parent.end-1, "")
+	case i < n-1:
+		e.replace(memberStart(parent, i), memberStart(parent, i+1), "")
+	default:
+		e.replace(parent.members[i-1].end, node.end, "")
+	}
+	return nil
+}
+
+// yamlEditor 修改 YAML 文本：通过 yaml.v3 的节点树定位成员，只重新生成被修改的成员所在的行，
+// 其余的行（包括空行、注释和原有的格式）保持不变；每个操作之前重新解析
+type yamlEditor struct {
+	src    string
+	lines  []int // 每行的起始位置，由 parse 生成
+	indent int
+	marker bool // 原文以 --- 开头
+}
+
+func newYAMLEditor(text string) (*yamlEditor, error) {
+	e := &yamlEditor{src: text, indent: 2}
+	if _, err := e.parse(); err != nil {
+		return nil, err
+	}
+
+	// 取最小的非零缩进作为每层的缩进
+	indent, content := 0, false
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if !content {
+			e.marker, content = strings.HasPrefix(line, "---"), true
+		}
+		if n := len(line) - len(trimmed); n > 0 && (indent == 0 || n < indent) {
+			indent = n
+		}
+	}
+	if indent >= 2 {
+		e.indent = indent
+	}
+	return e, nil
+}
+
+func (e *yamlEditor) String() string { return e.src }
+
+// parse 解析当前文本并记录每行的位置，空文档视为空映射
+func (e *yamlEditor) parse() (*yaml.Node, error) {
+	dec := yaml.NewDecoder(strings.NewReader(e.src))
+	var doc yaml.Node
+	if err := dec.Decode(&doc); err != nil && err != io.EOF {
+		return nil, &ValidationError{Message: err.Error()}
+	}
+	var next yaml.Node
+	if err := dec.Decode(&next); err != io.EOF {
+		return nil, fmt.Errorf("multi-document YAML files are not supported")
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+
+	e.lines = e.lines[:0]
+	for i := 0; i < len(e.src); {
+		e.lines = append(e.lines, i)
+		n := strings.IndexByte(e.src[i:], '\n')
+		if n < 0 {
+			break
+		}
+		i += n + 1
+	}
+	return &doc, nil
+}
+
+// line 返回第 n 行（从 1 开始）的起始位置和不含换行符的内容，超出末尾时返回文本末尾
+func (e *yamlEditor) line(n int) (int, string) {
+	if n < 1 || n > len(e.lines) {
+		return len(e.src), ""
+	}
+	start, end := e.lines[n-1], len(e.src)
+	if n < len(e.lines) {
+		end = e.lines[n]
+	}
+	return start, strings.TrimSuffix(e.src[start:end], "\n")
+}
+
+// lookup 返回路径指向的节点及其父节点，index 为节点在父节点 Content 中的位置，不存在时 node 为 nil
+func (e *yamlEditor) lookup(doc *yaml.Node, path []jsonSegment) (parent, node *yaml.Node, index int, err error) {
+	node = doc.Content[0]
+	for depth, seg := range path {
+		for node.Kind == yaml.AliasNode {
+			node = node.Alias
+		}
+		parent, node, index = node, nil, -1
+		switch {
+		case seg.kind == jsonSegName && parent.Kind == yaml.MappingNode:
+			for i := 0; i+1 < len(parent.Content); i += 2 {
+				if parent.Content[i].Value == seg.name {
+					index = i + 1
+				}
+			}
+		case seg.kind == jsonSegIndex && parent.Kind == yaml.SequenceNode:
+			if seg.index < len(parent.Content) {
+				index = seg.index
+			}
+		default:
+			return nil, nil, 0, fmt.Errorf("%s is not an %s", segmentsPath(path[:depth]), containerKind(seg))
+		}
+		if index < 0 {
+			if depth < len(path)-1 {
+				return nil, nil, 0, fmt.Errorf("%s not found", segmentsPath(path[:depth+1]))
+			}
+			return parent, nil, 0, nil
+		}
+		node = parent.Content[index]
+	}
+	return parent, node, index, nil
+}
+
+// yamlEntry 块风格的映射或序列中的一个成员在原文中占据的行
+type yamlEntry struct {
+	parent *yaml.Node
+	index  int // 成员的值在 parent.Content 中的位置
+	column int // 键或 "-" 所在的列（从 0 开始）
+	start  int // 成员上方紧邻的同列注释的起始位置，没有时等于 first
+	first  int // 成员第一行的起始位置
+	end    int // 成员最后一行之后的位置，不含之后的空行和属于下一个成员的注释
+}
+
+// member 返回块风格集合 parent 中成员 index（映射为值的位置）占据的行，limit 为集合的结束位置；
+// 集合是流风格或成员的行首还有其他内容（如 "- key: value" 中的键）时返回 nil
+func (e *yamlEditor) member(parent *yaml.Node, index, limit int) *yamlEntry {
+	if parent.Style&yaml.FlowStyle != 0 || (parent.Kind != yaml.MappingNode && parent.Kind != yaml.SequenceNode) {
+		return nil
+	}
+	head := parent.Content[index]
+	if parent.Kind == yaml.MappingNode {
+		head = parent.Content[index-1]
+	}
+
+	// 行首到键或 "-" 之间只能有缩进
+	first, text := e.line(head.Line)
+	if head.Column < 1 || head.Column > len(text)+1 {
+		return nil
+	}
+	prefix := text[:head.Column-1]
+	if parent.Kind == yaml.SequenceNode {
+		prefix = strings.TrimRight(prefix, " ")
+		if !strings.HasSuffix(prefix, "-") {
+			return nil
+		}
+		prefix = prefix[:len(prefix)-1]
+	}
+	if strings.Trim(prefix, " ") != "" {
+		return nil
+	}
+	entry := &yamlEntry{parent: parent, index: index, column: len(prefix), start: first, first: first, end: limit}
+	if index+1 < len(parent.Content) {
+		// 下一个成员的键或元素
+		entry.end, _ = e.line(parent.Content[index+1].Line)
+	}
+
+	// 去掉成员之后的空行，以及不比成员更深的注释（属于下一个成员或上级）
+	for entry.end > entry.first {
+		start := strings.LastIndexByte(e.src[:entry.end-1], '\n') + 1
+		if start <= entry.first {
+			break
+		}
+		text := strings.TrimRight(e.src[start:entry.end], "\n")
+		trimmed := strings.TrimLeft(text, " \t")
+		if trimmed != "" && trimmed != "..." && !(strings.HasPrefix(trimmed, "#") && len(text)-len(trimmed) <= entry.column) {
+			break
+		}
+		entry.end = start
+	}
+
+	// 上方紧邻的同列注释属于该成员，删除时一并删除
+	for n := head.Line - 1; n >= 1; n-- {
+		start, text := e.line(n)
+		trimmed := strings.TrimLeft(text, " ")
+		if !strings.HasPrefix(trimmed, "#") || len(text)-len(trimmed) != entry.column {
+			break
+		}
+		entry.start = start
+	}
+	return entry
+}
+
+// entries 沿路径返回各层成员占据的行，遇到流风格的集合、不存在的成员或无法按行划分的写法时停止，
+// 因此返回的成员及其所有上级都是块风格，可以整行替换
+func (e *yamlEditor) entries(doc *yaml.Node, path []jsonSegment) []*yamlEntry {
+	var list []*yamlEntry
+	container, limit := doc.Content[0], len(e.src)
+	for _, seg := range path {
+		// 经过别名修改的是锚点处的节点，不在该成员的行内
+		if container.Kind == yaml.AliasNode {
+			return nil
+		}
+		index := -1
+		switch {
+		case seg.kind == jsonSegName && container.Kind == yaml.MappingNode:
+			for i := 1; i < len(container.Content); i += 2 {
+				if container.Content[i-1].Value == seg.name {
+					index = i
+				}
+			}
+		case seg.kind == jsonSegIndex && container.Kind == yaml.SequenceNode:
+			if seg.index < len(container.Content) {
+				index = seg.index
+			}
+		}
+		if index < 0 {
+			break
+		}
+		entry := e.member(container, index, limit)
+		if entry == nil {
+			break
+		}
+		list = append(list, entry)
+		container, limit = container.Content[index], entry.end
+	}
+	return list
+}
+
+// encodeMembers 将新的成员生成为从 column 列开始的块风格文本，
+// 成员上方和之后的注释仍在原文中，不再重复生成
+func (e *yamlEditor) encodeMembers(parent *yaml.Node, members []*yaml.Node, column int) string {
+	container := &yaml.Node{Kind: parent.Kind, Tag: parent.Tag}
+	for i, member := range members {
+		if i == 0 {
+			copied := *member
+			copied.HeadComment, copied.FootComment = "", ""
+			member = &copied
+		}
+		container.Content = append(container.Content, member)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(e.indent)
+	enc.Encode(container)
+	enc.Close()
+
+	var out strings.Builder
+	indent := strings.Repeat(" ", column)
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if line != "" && line != "\n" {
+			out.WriteString(indent)
+		}
+		out.WriteString(line)
+	}
+	return out.String()
+}
+
+// yamlMember 返回成员的节点，映射为键和值两个节点
+func yamlMember(parent *yaml.Node, index int) []*yaml.Node {
+	if parent.Kind == yaml.MappingNode {
+		return parent.Content[index-1 : index+1]
+	}
+	return parent.Content[index : index+1]
+}
+
+// rewrite 修改节点树之后重新生成 entries 中最深的成员，没有可以按行替换的成员时重新生成整个文档
+func (e *yamlEditor) rewrite(doc *yaml.Node, entries []*yamlEntry) {
+	if len(entries) == 0 {
+		var buf bytes.Buffer
+		if e.marker {
+			buf.WriteString("---\n")
+		}
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(e.indent)
+		enc.Encode(doc)
+		enc.Close()
+		e.src = buf.String()
+		return
+	}
+	entry := entries[len(entries)-1]
+	e.splice(entry.first, entry.end, e.encodeMembers(entry.parent, yamlMember(entry.parent, entry.index), entry.column))
+}
+
+// splice 将 [start, end) 替换为若干完整的行，原文最后一行没有换行符时保持不变
+func (e *yamlEditor) splice(start, end int, text string) {
+	if end == len(e.src) && !strings.HasSuffix(e.src, "\n") {
+		if start == end {
+			text = "\n" + text
+		}
+		text = strings.TrimSuffix(text, "\n")
+	}
+	e.src = e.src[:start] + text + e.src[end:]
+}
+
+// yamlNode 将新值转换为节点，对象保持请求中的键顺序
+func yamlNode(value any) (*yaml.Node, error) {
+	switch v := value.(type) {
+	case orderedMap:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, field := range v {
+			child, err := yamlNode(field.Value)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: field.Key}, child)
+		}
+		return node, nil
+	case []any:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v {
+			child, err := yamlNode(item)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+	case json.Number:
+		tag := "!!int"
+		if _, err := v.Int64(); err != nil {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: v.String()}, nil
+	}
+	node := &yaml.Node{}
+	if err := node.Encode(value); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (e *yamlEditor) set(path []jsonSegment, value any) error {
+	doc, err := e.parse()
+	if err != nil {
+		return err
+	}
+	parent, node, _, err := e.lookup(doc, path)
+	if err != nil {
+		return err
+	}
+	replacement, err := yamlNode(value)
+	if err != nil {
+		return err
+	}
+	if node != nil {
+		// 沿用原节点的注释、锚点和字符串风格
+		replacement.HeadComment, replacement.LineComment, replacement.FootComment = node.HeadComment, node.LineComment, node.FootComment
+		replacement.Anchor = node.Anchor
+		if node.Kind == yaml.ScalarNode && replacement.Kind == yaml.ScalarNode && node.Tag == replacement.Tag {
+			replacement.Style = node.Style
+		}
+		if node.Kind == replacement.Kind && node.Style&yaml.FlowStyle != 0 {
+			replacement.Style |= yaml.FlowStyle
+		}
+		entries := e.entries(doc, path)
+		*node = *replacement
+		e.rewrite(doc, entries)
+		return nil
+	}
+	last := path[len(path)-1]
+	if last.kind == jsonSegIndex && last.index != len(parent.Content) {
+		return fmt.Errorf("index %d is out of range (length %d)", last.index, len(parent.Content))
+	}
+	e.add(doc, path, parent, len(parent.Content), replacement)
+	return nil
+}
+
+func (e *yamlEditor) insert(path []jsonSegment, value any) error {
+	doc, err := e.parse()
+	if err != nil {
+		return err
+	}
+	parent, node, _, err := e.lookup(doc, path)
+	if err != nil {
+		return err
+	}
+	replacement, err := yamlNode(value)
+	if err != nil {
+		return err
+	}
+	last := path[len(path)-1]
+	if last.kind == jsonSegName {
+		if node != nil {
+			return fmt.Errorf("%s already exists", segmentsPath(path))
+		}
+		e.add(doc, path, parent, len(parent.Content), replacement)
+		return nil
+	}
+	if last.index > len(parent.Content) {
+		return fmt.Errorf("index %d is out of range (length %d)", last.index, len(parent.Content))
+	}
+	e.add(doc, path, parent, last.index, replacement)
+	return nil
+}
+
+// add 在 Content 的位置 i 插入新成员，映射插入键和值两个节点
+// 父节点可以按行划分且已有成员时，只在相邻成员之前或最后一个成员之后插入新成员的行，否则重新生成包含父节点的成员
+func (e *yamlEditor) add(doc *yaml.Node, path []jsonSegment, parent *yaml.Node, i int, node *yaml.Node) {
+	entries := e.entries(doc, path[:len(path)-1])
+	var neighbor *yamlEntry
+	before := i < len(parent.Content)
+	if len(entries) == len(path)-1 && len(parent.Content) > 0 {
+		limit := len(e.src)
+		if len(entries) > 0 {
+			limit = entries[len(entries)-1].end
+		}
+		if before {
+			neighbor = e.member(parent, i, limit)
+		} else {
+			neighbor = e.member(parent, len(parent.Content)-1, limit)
+		}
+	}
+
+	nodes := []*yaml.Node{node}
+	if parent.Kind == yaml.MappingNode {
+		nodes = []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[len(path)-1].name}, node}
+	}
+	parent.Content = append(parent.Content[:i], append(nodes, parent.Content[i:]...)...)
+
+	switch {
+	case neighbor == nil:
+		e.rewrite(doc, entries)
+	case before:
+		e.splice(neighbor.start, neighbor.start, e.encodeMembers(parent, nodes, neighbor.column))
+	default:
+		e.splice(neighbor.end, neighbor.end, e.encodeMembers(parent, nodes, neighbor.column))
+	}
+}
+
+func (e *yamlEditor) remove(path []jsonSegment) error {
+	doc, err := e.parse()
+	if err != nil {
+		return err
+	}
+	parent, node, i, err := e.lookup(doc, path)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		return fmt.Errorf("%s not found", segmentsPath(path))
+	}
+	entries := e.entries(doc, path)
+	start := i
+	if parent.Kind == yaml.MappingNode {
+		start = i - 1
+	}
+	parent.Content = append(parent.Content[:start], parent.Content[i+1:]...)
+
+	// 删除最后一个成员后父节点成为空集合，需要重新生成为 {} 或 []
+	switch {
+	case len(entries) == len(path) && len(parent.Content) > 0:
+		entry := entries[len(entries)-1]
+		e.src = e.src[:entry.start] + e.src[entry.end:]
+	case len(entries) == len(path):
+		e.rewrite(doc, entries[:len(entries)-1])
+	default:
+		e.rewrite(doc, entries)
+	}
+	return nil
+}
+
+// tomlTable TOML 文件中的一个表头
+type tomlTable struct {
+	path      []jsonSegment // 规范路径，数组表的元素带下标，如 $.products[1]
+	comment   int           // 表头上方紧邻的注释的起始位置，没有时等于 start
+	start     int           // 表头所在行的起始位置
+	headerEnd int           // 表头所在行之后的位置
+	end       int           // 最后一个键值对之后的位置，没有键值对时等于 headerEnd
+	next      int           // 下一个表头（含其上方的注释）的起始位置或文件末尾
+}
+
+// tomlEntry TOML 文件中的一个键值对
+type tomlEntry struct {
+	path       []jsonSegment
+	table      int // 所在表的下标，-1 表示根表
+	indent     string
+	comment    int // 上方紧邻的注释的起始位置，没有时等于所在行的起始位置
+	start      int // 键的起始位置
+	valueStart int
+	valueEnd   int
+	end        int // 所在行之后的位置
+}
+
+// tomlDocument 扫描 TOML 文件得到的表头和键值对
+type tomlDocument struct {
+	tables  []*tomlTable
+	entries []*tomlEntry
+}
+
+// tomlScanner 逐行扫描 TOML 文本，只记录位置，内容的有效性由 toml 包检查
+type tomlScanner struct {
+	src string
+	i   int
+}
+
+func (s *tomlScanner) errorf(format string, args ...any) error {
+	line, column := offsetPosition(s.src, s.i)
+	return &ValidationError{Line: line, Column: column, Message: fmt.Sprintf(format, args...)}
+}
+
+func (s *tomlScanner) at(c byte) bool {
+	return s.i < len(s.src) && s.src[s.i] == c
+}
+
+func (s *tomlScanner) skipSpace() {
+	for s.at(' ') || s.at('\t') {
+		s.i++
+	}
+}
+
+func (s *tomlScanner) skipLine() {
+	if n := strings.IndexByte(s.src[s.i:], '\n'); n >= 0 {
+		s.i += n + 1
+	} else {
+		s.i = len(s.src)
+	}
+}
+
+// lineEnd 跳过值之后的空白和注释，直到下一行
+func (s *tomlScanner) lineEnd() error {
+	s.skipSpace()
+	if s.at('#') || s.at('\r') {
+		s.skipLine()
+		return nil
+	}
+	if s.at('\n') {
+		s.i++
+		return nil
+	}
+	if s.i < len(s.src) {
+		return s.errorf("expected end of line")
+	}
+	return nil
+}
+
+// keys 读取以点分隔的键
+func (s *tomlScanner) keys() ([]string, error) {
+	var names []string
+	for {
+		s.skipSpace()
+		start := s.i
+		switch {
+		case s.at('"'):
+			if err := s.value(); err != nil {
+				return nil, err
+			}
+			name, err := strconv.Unquote(s.src[start:s.i])
+			if err != nil {
+				return nil, s.errorf("invalid key %s", s.src[start:s.i])
+			}
+			names = append(names, name)
+		case s.at('\''):
+			if err := s.value(); err != nil {
+				return nil, err
+			}
+			names = append(names, s.src[start+1:s.i-1])
+		default:
+			for s.i < len(s.src) && isBareKeyChar(s.src[s.i]) {
+				s.i++
+			}
+			if s.i == start {
+				return nil, s.errorf("expected a key")
+			}
+			names = append(names, s.src[start:s.i])
+		}
+		s.skipSpace()
+		if !s.at('.') {
+			return names, nil
+		}
+		s.i++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// value 跳过一个值：字符串、数组、内联表或其他标量
+func (s *tomlScanner) value() error {
+	rest := s.src[s.i:]
+	switch {
+	case strings.HasPrefix(rest, `"""`), strings.HasPrefix(rest, "'''"):
+		quote := rest[:3]
+		for s.i += 3; s.i < len(s.src); s.i++ {
+			if quote[0] == '"' && s.at('\\') {
+				s.i++
+				continue
+			}
+			if strings.HasPrefix(s.src[s.i:], quote) {
+				// 结束的引号之前最多可以再有两个引号
+				s.i += 3
+				for n := 0; n < 2 && s.at(quote[0]); n++ {
+					s.i++
+				}
+				return nil
+			}
+		}
+		return s.errorf("unterminated string")
+	case s.at('"'), s.at('\''):
+		quote := s.src[s.i]
+		for s.i++; s.i < len(s.src) && s.src[s.i] != '\n'; s.i++ {
+			if quote == '"' && s.at('\\') {
+				s.i++
+				continue
+			}
+			if s.src[s.i] == quote {
+				s.i++
+				return nil
+			}
+		}
+		return s.errorf("unterminated string")
+	case s.at('['), s.at('{'):
+		depth := 0
+		for s.i < len(s.src) {
+			switch s.src[s.i] {
+			case '"', '\'':
+				if err := s.value(); err != nil {
+					return err
+				}
+				continue
+			case '#':
+				s.skipLine()
+				continue
+			case '[', '{':
+				depth++
+			case ']', '}':
+				depth--
+				if depth == 0 {
+					s.i++
+					return nil
+				}
+			}
+			s.i++
+		}
+		return s.errorf("unterminated array or inline table")
+	}
+	// 其他标量到注释或行尾为止，日期时间中可以有空格
+	end := len(rest)
+	if n := strings.IndexAny(rest, "#\r\n"); n >= 0 {
+		end = n
+	}
+	end = len(strings.TrimRight(rest[:end], " \t"))
+	if end == 0 {
+		return s.errorf("expected a value")
+	}
+	s.i += end
+	return nil
+}
+
+// scanTOML 扫描文本中的表头和键值对，并计算它们的规范路径
+func scanTOML(src string) (*tomlDocument, error) {
+	doc := &tomlDocument{}
+	s := &tomlScanner{src: src}
+	arrays := map[string]int{} // 数组表的元素个数
+	var current []jsonSegment
+	table, comment := -1, -1
+	for s.i < len(src) {
+		lineStart := s.i
+		s.skipSpace()
+		if s.i >= len(src) {
+			break
+		}
+		if s.at('\n') || s.at('\r') {
+			comment = -1
+			s.skipLine()
+			continue
+		}
+		if s.at('#') {
+			if comment < 0 {
+				comment = lineStart
+			}
+			s.skipLine()
+			continue
+		}
+		if comment < 0 {
+			comment = lineStart
+		}
+
+		if s.at('[') {
+			t := &tomlTable{comment: comment, start: lineStart}
+			s.i++
+			array := s.at('[')
+			if array {
+				s.i++
+			}
+			names, err := s.keys()
+			if err != nil {
+				return nil, err
+			}
+			closing := "]"
+			if array {
+				closing = "]]"
+			}
+			if !strings.HasPrefix(src[s.i:], closing) {
+				return nil, s.errorf("expected %s", closing)
+			}
+			s.i += len(closing)
+			if err := s.lineEnd(); err != nil {
+				return nil, err
+			}
+			t.headerEnd, t.end = s.i, s.i
+
+			for i, name := range names {
+				t.path = append(t.path, jsonSegment{kind: jsonSegName, name: name})
+				key := segmentsPath(t.path)
+				if i == len(names)-1 && array {
+					t.path = append(t.path, jsonSegment{kind: jsonSegIndex, index: arrays[key]})
+					arrays[key]++
+				} else if n, ok := arrays[key]; ok {
+					t.path = append(t.path, jsonSegment{kind: jsonSegIndex, index: n - 1})
+				}
+			}
+			if len(doc.tables) > 0 {
+				doc.tables[len(doc.tables)-1].next = t.comment
+			}
+			table, current = len(doc.tables), t.path
+			doc.tables = append(doc.tables, t)
+		} else {
+			entry := &tomlEntry{table: table, indent: src[lineStart:s.i], comment: comment, start: s.i}
+			names, err := s.keys()
+			if err != nil {
+				return nil, err
+			}
+			if !s.at('=') {
+				return nil, s.errorf("expected =")
+			}
+			s.i++
+			s.skipSpace()
+			entry.valueStart = s.i
+			if err := s.value(); err != nil {
+				return nil, err
+			}
+			entry.valueEnd = s.i
+			if err := s.lineEnd(); err != nil {
+				return nil, err
+			}
+			entry.end = s.i
+			entry.path = append([]jsonSegment{}, current...)
+			for _, name := range names {
+				entry.path = append(entry.path, jsonSegment{kind: jsonSegName, name: name})
+			}
+			if table >= 0 {
+				doc.tables[table].end = s.i
+			}
+			doc.entries = append(doc.entries, entry)
+		}
+		comment = -1
+	}
+	if len(doc.tables) > 0 {
+		doc.tables[len(doc.tables)-1].next = len(src)
+	}
+	return doc, nil
+}
+
+// hasPathPrefix 判断 path 是否以 prefix 开头
+func hasPathPrefix(path, prefix []jsonSegment) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+	for i, seg := range prefix {
+		if seg.kind != path[i].kind || seg.name != path[i].name || seg.index != path[i].index {
+			return false
+		}
+	}
+	return true
+}
+
+// tomlKeyPath 生成点分隔的键，数组表的下标不出现在键中
+func tomlKeyPath(path []jsonSegment) string {
+	var keys []string
+	for _, seg := range path {
+		if seg.kind != jsonSegName {
+			continue
+		}
+		if seg.name != "" && strings.IndexFunc(seg.name, func(r rune) bool { return r > 0x7f || !isBareKeyChar(byte(r)) }) < 0 {
+			keys = append(keys, seg.name)
+		} else {
+			key, _ := json.Marshal(seg.name)
+			keys = append(keys, string(key))
+		}
+	}
+	return strings.Join(keys, ".")
+}
+
+// tomlValue 将值编码为 TOML 的内联形式
+func tomlValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", fmt.Errorf("TOML has no null value")
+	case string:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.Encode(v)
+		return strings.TrimSuffix(buf.String(), "\n"), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case json.Number:
+		return v.String(), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "nan", nil
+		case math.IsInf(v, 1):
+			return "inf", nil
+		case math.IsInf(v, -1):
+			return "-inf", nil
+		}
+		text := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(text, ".e") {
+			text += ".0"
+		}
+		return text, nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case fmt.Stringer:
+		// toml.LocalDate、LocalTime 和 LocalDateTime
+		return v.String(), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			text, err := tomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = text
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case map[string]any:
+		m := make(orderedMap, 0, len(v))
+		for _, key := range sortedKeys(v) {
+			m = append(m, orderedField{Key: key, Value: v[key]})
+		}
+		return tomlValue(m)
+	case orderedMap:
+		if len(v) == 0 {
+			return "{}", nil
+		}
+		fields := make([]string, len(v))
+		for i, field := range v {
+			text, err := tomlValue(field.Value)
+			if err != nil {
+				return "", err
+			}
+			fields[i] = tomlKeyPath([]jsonSegment{{kind: jsonSegName, name: field.Key}}) + " = " + text
+		}
+		return "{ " + strings.Join(fields, ", ") + " }", nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// tomlBody 将对象编码为表中的键值对，每行一个
+func tomlBody(value orderedMap) (string, error) {
+	var body strings.Builder
+	for _, field := range value {
+		text, err := tomlValue(field.Value)
+		if err != nil {
+			return "", err
+		}
+		body.WriteString(tomlKeyPath([]jsonSegment{{kind: jsonSegName, name: field.Key}}) + " = " + text + "\n")
+	}
+	return body.String(), nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// textEdit 替换 [start, end) 的文本
+type textEdit struct {
+	start, end int
+	text       string
+}
+
+// tomlEditor 直接修改 TOML 文本，每个操作之前重新扫描
+type tomlEditor struct {
+	src string
+}
+
+func (e *tomlEditor) String() string { return e.src }
+
+// apply 从后往前替换，重叠的删除合并为一个
+func (e *tomlEditor) apply(edits []textEdit) {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start < edits[j].start })
+	var merged []textEdit
+	for _, edit := range edits {
+		if n := len(merged); n > 0 && edit.text == "" && merged[n-1].text == "" && edit.start <= merged[n-1].end {
+			merged[n-1].end = max(merged[n-1].end, edit.end)
+			continue
+		}
+		merged = append(merged, edit)
+	}
+	for i := len(merged) - 1; i >= 0; i-- {
+		e.src = e.src[:merged[i].start] + merged[i].text + e.src[merged[i].end:]
+	}
+}
+
+// insertBlock 在 pos 处插入一个表，前后用空行分隔
+func (e *tomlEditor) insertBlock(pos int, block string) {
+	before := strings.TrimRight(e.src[:pos], " \t")
+	switch {
+	case before == "" || strings.HasSuffix(before, "\n\n"):
+	case strings.HasSuffix(before, "\n"):
+		block = "\n" + block
+	default:
+		block = "\n\n" + block
+	}
+	if pos < len(e.src) {
+		block += "\n"
+	}
+	e.src = before + block + e.src[pos:]
+}
+
+// find 返回路径上的键值对（path 等于它或位于它的值之内），以及路径指向的表
+func (doc *tomlDocument) find(path []jsonSegment) (*tomlEntry, *tomlTable) {
+	for _, entry := range doc.entries {
+		if hasPathPrefix(path, entry.path) {
+			return entry, nil
+		}
+	}
+	for _, t := range doc.tables {
+		if len(t.path) == len(path) && hasPathPrefix(path, t.path) {
+			return nil, t
+		}
+	}
+	return nil, nil
+}
+
+// removals 返回删除 path 及其下所有键值对和表所需的修改
+func (doc *tomlDocument) removals(path []jsonSegment) []textEdit {
+	var edits []textEdit
+	for _, entry := range doc.entries {
+		if hasPathPrefix(entry.path, path) {
+			edits = append(edits, textEdit{start: entry.comment, end: entry.end})
+		}
+	}
+	for _, t := range doc.tables {
+		if hasPathPrefix(t.path, path) {
+			edits = append(edits, textEdit{start: t.comment, end: t.next})
+		}
+	}
+	return edits
+}
+
+// subtreeEnd 返回 path 及其子表中最后一个表的结束位置
+func (doc *tomlDocument) subtreeEnd(path []jsonSegment, def int) int {
+	end := def
+	for _, t := range doc.tables {
+		if hasPathPrefix(t.path, path) {
+			end = max(end, t.next)
+		}
+	}
+	return end
+}
+
+// editValue 修改键值对的值之内的元素：解码整个值，修改后重新编码为内联形式
+func (e *tomlEditor) editValue(entry *tomlEntry, path []jsonSegment, op string, value any) error {
+	var holder map[string]any
+	if _, err := toml.Decode("v = "+e.src[entry.valueStart:entry.valueEnd], &holder); err != nil {
+		return err
+	}
+	updated, err := editPlain(holder["v"], entry.path, path[len(entry.path):], op, value)
+	if err != nil {
+		return err
+	}
+	text, err := tomlValue(updated)
+	if err != nil {
+		return err
+	}
+	e.apply([]textEdit{{start: entry.valueStart, end: entry.valueEnd, text: text}})
+	return nil
+}
+
+// editPlain 在解码后的值上执行操作，base 为 v 的路径
+func editPlain(v any, base, path []jsonSegment, op string, value any) (any, error) {
+	seg := path[0]
+	here := append(append([]jsonSegment{}, base...), seg)
+	switch container := v.(type) {
+	case map[string]any:
+		if seg.kind != jsonSegName {
+			return nil, fmt.Errorf("%s is not an array", segmentsPath(base))
+		}
+		child, exists := container[seg.name]
+		switch {
+		case len(path) > 1 && !exists, len(path) == 1 && op == EditDelete && !exists:
+			return nil, fmt.Errorf("%s not found", segmentsPath(here))
+		case len(path) > 1:
+			child, err := editPlain(child, here, path[1:], op, value)
+			if err != nil {
+				return nil, err
+			}
+			container[seg.name] = child
+		case op == EditDelete:
+			delete(container, seg.name)
+		case op == EditInsert && exists:
+			return nil, fmt.Errorf("%s already exists", segmentsPath(here))
+		default:
+			container[seg.name] = value
+		}
+		return container, nil
+	case []any:
+		if seg.kind != jsonSegIndex {
+			return nil, fmt.Errorf("%s is not an object", segmentsPath(base))
+		}
+		n := len(container)
+		switch {
+		case len(path) > 1 && seg.index < n:
+			child, err := editPlain(container[seg.index], here, path[1:], op, value)
+			if err != nil {
+				return nil, err
+			}
+			container[seg.index] = child
+		case len(path) == 1 && op == EditInsert && seg.index <= n:
+			return append(container[:seg.index], append([]any{value}, container[seg.index:]...)...), nil
+		case len(path) == 1 && op == EditSet && seg.index == n:
+			return append(container, value), nil
+		case len(path) == 1 && op == EditSet && seg.index < n:
+			container[seg.index] = value
+		case len(path) == 1 && op == EditDelete && seg.index < n:
+			return append(container[:seg.index], container[seg.index+1:]...), nil
+		default:
+			return nil, fmt.Errorf("index %d is out of range (length %d)", seg.index, n)
+		}
+		return container, nil
+	}
+	return nil, fmt.Errorf("%s is not an object or array", segmentsPath(base))
+}
+
+func (e *tomlEditor) set(path []jsonSegment, value any) error {
+	doc, err := scanTOML(e.src)
+	if err != nil {
+		return err
+	}
+	entry, table := doc.find(path)
+	switch {
+	case entry != nil && len(entry.path) < len(path):
+		return e.editValue(entry, path, EditSet, value)
+	case entry != nil:
+		text, err := tomlValue(value)
+		if err != nil {
+			return err
+		}
+		e.apply([]textEdit{{start: entry.valueStart, end: entry.valueEnd, text: text}})
+		return nil
+	case table != nil:
+		// 保留表头，替换表中的键值对并删除子表
+		object, ok := value.(orderedMap)
+		if !ok {
+			return fmt.Errorf("cannot replace table %s with a non-table value", segmentsPath(path))
+		}
+		body, err := tomlBody(object)
+		if err != nil {
+			return err
+		}
+		edits := []textEdit{{start: table.headerEnd, end: table.end, text: body}}
+		for _, t := range doc.tables {
+			if len(t.path) > len(path) && hasPathPrefix(t.path, path) {
+				edits = append(edits, textEdit{start: t.comment, end: t.next})
+			}
+		}
+		e.apply(edits)
+		return nil
+	}
+	// 由点分隔的键或子表隐式定义的表，先删除再添加
+	if edits := doc.removals(path); len(edits) > 0 {
+		e.apply(edits)
+		if doc, err = scanTOML(e.src); err != nil {
+			return err
+		}
+	}
+	return e.add(doc, path, value)
+}
+
+func (e *tomlEditor) insert(path []jsonSegment, value any) error {
+	doc, err := scanTOML(e.src)
+	if err != nil {
+		return err
+	}
+	entry, table := doc.find(path)
+	last := path[len(path)-1]
+	switch {
+	case entry != nil && len(entry.path) < len(path):
+		return e.editValue(entry, path, EditInsert, value)
+	case entry != nil, table != nil && last.kind == jsonSegName:
+		return fmt.Errorf("%s already exists", segmentsPath(path))
+	case table != nil:
+		// 插入到数组表的第 i 个元素之前
+		object, ok := value.(orderedMap)
+		if !ok {
+			return fmt.Errorf("elements of %s must be tables", segmentsPath(path[:len(path)-1]))
+		}
+		body, err := tomlBody(object)
+		if err != nil {
+			return err
+		}
+		e.insertBlock(table.comment, "[["+tomlKeyPath(path)+"]]\n"+body)
+		return nil
+	}
+	if len(doc.removals(path)) > 0 {
+		return fmt.Errorf("%s already exists", segmentsPath(path))
+	}
+	return e.add(doc, path, value)
+}
+
+// add 添加不存在的键或数组表的元素
+func (e *tomlEditor) add(doc *tomlDocument, path []jsonSegment, value any) error {
+	last := path[len(path)-1]
+	object, isObject := value.(orderedMap)
+	if last.kind == jsonSegIndex {
+		parent := path[:len(path)-1]
+		count, end := 0, -1
+		for _, t := range doc.tables {
+			if len(t.path) == len(path) && hasPathPrefix(t.path, parent) {
+				count++
+			}
+			if hasPathPrefix(t.path, parent) {
+				end = max(end, t.next)
+			}
+		}
+		if count == 0 {
+			return fmt.Errorf("%s not found", segmentsPath(parent))
+		}
+		if last.index != count {
+			return fmt.Errorf("index %d is out of range (length %d)", last.index, count)
+		}
+		if !isObject {
+			return fmt.Errorf("elements of %s must be tables", segmentsPath(parent))
+		}
+		body, err := tomlBody(object)
+		if err != nil {
+			return err
+		}
+		e.insertBlock(end, "[["+tomlKeyPath(path)+"]]\n"+body)
+		return nil
+	}
+
+	// 找到包含该键的最深的表
+	var parent *tomlTable
+	index := -1
+	for i, t := range doc.tables {
+		if len(t.path) < len(path) && hasPathPrefix(path, t.path) && (parent == nil || len(t.path) > len(parent.path)) {
+			parent, index = t, i
+		}
+	}
+	var base []jsonSegment
+	if parent != nil {
+		base = parent.path
+	}
+	for _, seg := range path[len(base):] {
+		if seg.kind == jsonSegIndex {
+			return fmt.Errorf("%s not found", segmentsPath(path[:len(path)-1]))
+		}
+	}
+
+	if isObject {
+		// 新的表放在父表及其子表之后
+		body, err := tomlBody(object)
+		if err != nil {
+			return err
+		}
+		end := len(e.src)
+		if parent != nil {
+			end = doc.subtreeEnd(parent.path, parent.next)
+		}
+		e.insertBlock(end, "["+tomlKeyPath(path)+"]\n"+body)
+		return nil
+	}
+
+	text, err := tomlValue(value)
+	if err != nil {
+		return err
+	}
+	pos, indent, separate := len(e.src), "", false
+	switch {
+	case parent != nil:
+		pos = parent.headerEnd
+	case len(doc.tables) > 0:
+		pos, separate = doc.tables[0].comment, true
+	}
+	for _, entry := range doc.entries {
+		if entry.table == index {
+			pos, indent, separate = entry.end, entry.indent, false
+		}
+	}
+	line := indent + tomlKeyPath(path[len(base):]) + " = " + text + "\n"
+	if pos > 0 && e.src[pos-1] != '\n' {
+		line = "\n" + line
+	}
+	if separate {
+		line += "\n"
+	}
+	e.apply([]textEdit{{start: pos, end: pos, text: line}})
+	return nil
+}
+
+func (e *tomlEditor) remove(path []jsonSegment) error {
+	doc, err := scanTOML(e.src)
+	if err != nil {
+		return err
+	}
+	if entry, _ := doc.find(path); entry != nil && len(entry.path) < len(path) {
+		return e.editValue(entry, path, EditDelete, nil)
+	}
+	edits := doc.removals(path)
+	if len(edits) == 0 {
+		return fmt.Errorf("%s not found", segmentsPath(path))
+	}
+	e.apply(edits)
+	return nil
+}
+
+// ValidationFailure 编辑或保存被拒绝的原因，Op 为无法执行的操作的下标，Errors 为内容无效的位置
+type ValidationFailure struct {
+	Error  string             `json:"error"`
+	Op     *int               `json:"op,omitempty"`
+	Errors []*ValidationError `json:"errors,omitempty"`
+}
+
+// writeValidationError 以 422 返回操作或校验失败的详细信息，其他错误按类型返回
+func (s *Server) writeValidationError(w http.ResponseWriter, err error) {
+	response := ValidationFailure{Error: err.Error()}
+	var opErr *editError
+	var validationErr *ValidationError
+	var schemaErr *schemaError
+	switch {
+	case errors.As(err, &opErr):
+		response.Op = &opErr.index
+	case errors.As(err, &validationErr):
+		response.Errors = []*ValidationError{validationErr}
+	case errors.As(err, &schemaErr):
+		response.Errors = schemaErr.errors
+	default:
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(response)
+}
+
+// readText 读取并解码整个文本文件，换行符统一为 \n
+func (s *Server) readText(rootIndex int, fullPath string) (string, error) {
+	file, err := s.fs(rootIndex).Open(fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	reader, _, err := s.decodeText(rootIndex, file, "")
+	if err != nil {
+		return "", err
+	}
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return convertLineEndings(string(content), LineEndingLF), nil
+}
+
+// handleStructured 处理 JSON、YAML 和 TOML 文件的结构化读取和编辑
+// GET 返回解析后的内容；POST 按路径执行 set、delete 和 insert 操作，尽量保留原有的注释、键的顺序和缩进，结果有效时才写入
+func (s *Server) handleStructured(w http.ResponseWriter, r *http.Request) {
+	var req StructuredEditRequest
+	switch r.Method {
+	case http.MethodGet:
+		req.Path = r.URL.Query().Get("path")
+		req.Format = r.URL.Query().Get("format")
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.handleError(w, fmt.Errorf("invalid request body"), http.StatusBadRequest)
+			return
+		}
+		if len(req.Ops) == 0 {
+			s.handleError(w, fmt.Errorf("ops is required"), http.StatusBadRequest)
+			return
+		}
+		for i, op := range req.Ops {
+			if op.Op != EditSet && op.Op != EditDelete && op.Op != EditInsert {
+				s.handleError(w, fmt.Errorf("op %d: unknown op %q", i, op.Op), http.StatusBadRequest)
+				return
+			}
+			if _, err := parseEditPath(op.Path); err != nil {
+				s.handleError(w, fmt.Errorf("op %d: %v", i, err), http.StatusBadRequest)
+				return
+			}
+		}
+	default:
+		s.handleError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if req.Path == "" {
+		s.handleError(w, fmt.Errorf("path is required"), http.StatusBadRequest)
+		return
+	}
+	format := req.Format
+	if format == "" {
+		format = structuredFormat(req.Path)
+	}
+	if format != StructuredJSON && format != StructuredYAML && format != StructuredTOML {
+		s.handleError(w, fmt.Errorf("unsupported format %q, expected json, yaml or toml", format), http.StatusBadRequest)
+		return
+	}
+
+	rootIndex := getRootIndex(r)
+
+	// 构建完整路径
+	fullPath := s.getFullPath(req.Path, rootIndex)
+
+	// 检查路径是否在根目录内
+	if !s.isPathSafe(fullPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	// 与保存串行化
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	info, err := s.fs(rootIndex).Stat(fullPath)
+	if err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+	if info.IsDir() {
+		s.handleError(w, errIsDirectory, http.StatusBadRequest)
+		return
+	}
+
+	// 压缩文件以解压后的内容展示，不能直接修改
+	if compressionKind(info.Name()) != "" {
+		s.handleError(w, fmt.Errorf("cannot edit compressed file"), http.StatusBadRequest)
+		return
+	}
+	if maxSize := s.viewConfig(rootIndex).MaxFileSize; info.Size() > maxSize {
+		s.handleError(w, fmt.Errorf("%w: structured editing is limited to %d bytes", errTooLarge, maxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		text, err := s.readText(rootIndex, fullPath)
+		if err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		value, err := parseStructured(format, text)
+		if err != nil {
+			s.writeValidationError(w, err)
+			return
+		}
+		version := s.pathVersion(rootIndex, fullPath, info)
+		w.Header().Set("ETag", `"`+version+`"`)
+		s.writeJSON(w, map[string]interface{}{
+			"path":    req.Path,
+			"format":  format,
+			"value":   value,
+			"version": version,
+		})
+		return
+	}
+
+	// 检查文件版本，"*" 表示不检查
+	version := requestVersion(r, req.Version)
+	if version == "" {
+		s.handleError(w, fmt.Errorf("version is required, reload the file and try again"), http.StatusPreconditionRequired)
+		return
+	}
+	current := s.pathVersion(rootIndex, fullPath, info)
+	if version != "*" && version != current {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(SaveConflict{
+			Error:   "file has been modified since it was opened",
+			Version: current,
+		})
+		return
+	}
+
+	// 按原文件的编码和换行符写入
+	te, lineEnding, err := s.fileTextFormat(rootIndex, fullPath)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	text, err := s.readText(rootIndex, fullPath)
+	if err != nil {
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+	result, err := applyEdits(format, text, req.Ops)
+	if err != nil {
+		s.writeValidationError(w, err)
+		return
+	}
+	if text != "" {
+		result = setFinalNewline(result, LineEndingLF, strings.HasSuffix(text, "\n"))
+	}
+	// 结果须符合文件对应的 JSON Schema
+	if err := s.validateContent(rootIndex, s.relPath(fullPath, rootIndex), result); err != nil {
+		s.writeValidationError(w, err)
+		return
+	}
+	diff := unifiedDiff("a/"+info.Name(), "b/"+info.Name(), strings.Split(text, "\n"), strings.Split(result, "\n"))
+
+	if req.DryRun {
+		s.writeJSON(w, map[string]interface{}{
+			"success": true,
+			"content": result,
+			"diff":    diff,
+			"version": current,
+		})
+		return
+	}
+
+	content, err := te.encode(convertLineEndings(result, lineEnding))
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// 检查大小和内容类型是否符合根目录的写入限制
+	if err := s.checkFileSize(rootIndex, int64(len(content))); err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+	if err := s.uploadPolicy(rootIndex).checkType(content[:min(len(content), sniffLen)]); err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+	delta := int64(len(content)) - info.Size()
+	if err := s.reserve(rootIndex, delta); err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+
+	// 保留当前内容作为历史版本
+	if err := s.recordHistory(rootIndex, fullPath, info); err != nil {
+		s.reserve(rootIndex, -delta)
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.fs(rootIndex).WriteFile(fullPath, content, false); err != nil {
+		s.reserve(rootIndex, -delta)
+		s.handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "文件修改成功",
+		"diff":    diff,
+	}
+	if info, err := s.fs(rootIndex).Stat(fullPath); err == nil {
+		version := s.pathVersion(rootIndex, fullPath, info)
+		response["version"] = version
+		w.Header().Set("ETag", `"`+version+`"`)
+	}
+	s.writeJSON(w, response)
+}
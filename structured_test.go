@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyEditsJSON(t *testing.T) {
+	const doc = `{
+    "name": "demo",
+    "server": {"host": "localhost", "port": 8080},
+    "tags": [
+        "a",
+        "b"
+    ],
+    "empty": {}
+}
+`
+	tests := []struct {
+		ops  string
+		want string
+	}{
+		{`[{"op": "set", "path": "$.server.port", "value": 9090}]`, strings.Replace(doc, "8080", "9090", 1)},
+		{`[{"op": "set", "path": "$.server.tls", "value": true}]`, strings.Replace(doc, `"port": 8080}`, `"port": 8080, "tls": true}`, 1)},
+		{`[{"op": "set", "path": "$.version", "value": {"major": 1, "minor": 2}}]`, strings.Replace(doc, `"empty": {}`, "\"empty\": {},\n    \"version\": {\n        \"major\": 1,\n        \"minor\": 2\n    }", 1)},
+		{`[{"op": "insert", "path": "$.tags[1]", "value": "x"}]`, strings.Replace(doc, `"a",`, "\"a\",\n        \"x\",", 1)},
+		{`[{"op": "insert", "path": "$.tags[0]", "value": "x"}]`, strings.Replace(doc, `"a",`, "\"x\",\n        \"a\",", 1)},
+		{`[{"op": "set", "path": "$.tags[2]", "value": "c"}]`, strings.Replace(doc, `"b"`, "\"b\",\n        \"c\"", 1)},
+		{`[{"op": "delete", "path": "$.tags[1]"}]`, strings.Replace(doc, "\"a\",\n        \"b\"", `"a"`, 1)},
+		{`[{"op": "delete", "path": "$.name"}]`, strings.Replace(doc, "\"name\": \"demo\",\n    ", "", 1)},
+		{`[{"op": "delete", "path": "$.empty"}]`, strings.Replace(doc, ",\n    \"empty\": {}", "", 1)},
+		{`[{"op": "set", "path": "$.empty.k", "value": [1, 2]}]`, strings.Replace(doc, `"empty": {}`, "\"empty\": {\n        \"k\": [\n            1,\n            2\n        ]\n    }", 1)},
+		{`[{"op": "delete", "path": "$.server.host"}, {"op": "delete", "path": "$.server.port"}]`, strings.Replace(doc, `{"host": "localhost", "port": 8080}`, `{}`, 1)},
+		{`[{"op": "set", "path": "$.name", "value": 1.50}]`, strings.Replace(doc, `"demo"`, `1.50`, 1)},
+		{`[{"op": "set", "path": "$.server.host", "value": {"name": "<a&b>"}}]`, strings.Replace(doc, `"localhost"`, `{"name":"<a&b>"}`, 1)},
+	}
+	for _, tt := range tests {
+		var ops []EditOp
+		if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+			t.Fatal(err)
+		}
+		got, err := applyEdits(StructuredJSON, doc, ops)
+		if err != nil {
+			t.Errorf("%s: %v", tt.ops, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.ops, got, tt.want)
+		}
+	}
+
+	for _, ops := range []string{
+		`[{"op": "delete", "path": "$.missing"}]`,
+		`[{"op": "set", "path": "$.missing.a", "value": 1}]`,
+		`[{"op": "insert", "path": "$.name", "value": 1}]`,
+		`[{"op": "insert", "path": "$.tags[5]", "value": 1}]`,
+		`[{"op": "set", "path": "$.tags.a", "value": 1}]`,
+		`[{"op": "set", "path": "$.name"}]`,
+	} {
+		var list []EditOp
+		json.Unmarshal([]byte(ops), &list)
+		if _, err := applyEdits(StructuredJSON, doc, list); err == nil {
+			t.Errorf("%s succeeded", ops)
+		}
+	}
+}
+
+func TestApplyEditsYAML(t *testing.T) {
+	const doc = `# 服务配置
+server:
+  host: localhost # 监听地址
+  port: 8080
+  name: 'demo'
+# 标签
+tags:
+  - a
+  - b
+`
+	ops := []EditOp{
+		{Op: EditSet, Path: "$.server.port", Value: json.RawMessage(`9090`)},
+		{Op: EditSet, Path: "$.server.name", Value: json.RawMessage(`"prod"`)},
+		{Op: EditSet, Path: "$.server.tls", Value: json.RawMessage(`{"cert": "a.pem", "enabled": true}`)},
+		{Op: EditInsert, Path: "$.tags[1]", Value: json.RawMessage(`"x"`)},
+		{Op: EditDelete, Path: "$.tags[0]"},
+	}
+	got, err := applyEdits(StructuredYAML, doc, ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# 服务配置
+server:
+  host: localhost # 监听地址
+  port: 9090
+  name: 'prod'
+  tls:
+    cert: a.pem
+    enabled: true
+# 标签
+tags:
+  - x
+  - b
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := applyEdits(StructuredYAML, "a: 1\n---\nb: 2\n", ops[:1]); err == nil {
+		t.Error("multi-document edit succeeded")
+	}
+}
+
+func TestApplyEditsYAMLKeepsLayout(t *testing.T) {
+	const doc = `# 服务配置
+
+server:
+  host:   localhost    # 监听地址
+
+  port: 8080
+  script: |
+    echo hi
+    # 脚本内容
+  # server 结束
+
+list:
+- 1
+- k: v
+  z: 2
+flow: {a: 1, b: [1, 2]}
+`
+	tests := []struct {
+		name string
+		op   EditOp
+		want string // 替换 doc 中 old 所在的行
+		old  string
+	}{
+		{"set", EditOp{Op: EditSet, Path: "$.server.port", Value: json.RawMessage(`9090`)}, "  port: 9090\n", "  port: 8080\n"},
+		{"set block scalar", EditOp{Op: EditSet, Path: "$.server.script", Value: json.RawMessage(`"x"`)}, "  script: |-\n    x\n", "  script: |\n    echo hi\n    # 脚本内容\n"},
+		{"add key", EditOp{Op: EditSet, Path: "$.server.tls", Value: json.RawMessage(`true`)}, "    # 脚本内容\n  tls: true\n", "    # 脚本内容\n"},
+		{"delete key", EditOp{Op: EditDelete, Path: "$.server.port"}, "", "  port: 8080\n"},
+		{"insert item", EditOp{Op: EditInsert, Path: "$.list[1]", Value: json.RawMessage(`"x"`)}, "- x\n- k: v\n", "- k: v\n"},
+		{"set in compact item", EditOp{Op: EditSet, Path: "$.list[1].z", Value: json.RawMessage(`3`)}, "- k: v\n  z: 3\n", "- k: v\n  z: 2\n"},
+		{"set in flow", EditOp{Op: EditSet, Path: "$.flow.b[0]", Value: json.RawMessage(`5`)}, "flow: {a: 1, b: [5, 2]}\n", "flow: {a: 1, b: [1, 2]}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyEdits(StructuredYAML, doc, []EditOp{tt.op})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 被修改的成员之外的行（空行、注释、对齐的空格和原有的缩进风格）逐字节保持不变
+			if want := strings.Replace(doc, tt.old, tt.want, 1); got != want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+
+	// 删除最后一个成员时父节点成为空集合
+	got, err := applyEdits(StructuredYAML, "a:\n  - 1\n# 结尾\n", []EditOp{{Op: EditDelete, Path: "$.a[0]"}})
+	if err != nil || got != "a: []\n# 结尾\n" {
+		t.Errorf("delete last item = %q, %v", got, err)
+	}
+}
+
+func TestApplyEditsTOML(t *testing.T) {
+	const doc = `# 应用配置
+title = "demo"
+
+[server]
+host = "localhost" # 监听地址
+port = 8080
+
+# 数据库
+[database]
+ports = [8000, 8001]
+options = { timeout = 5 }
+
+[[products]]
+name = "hammer"
+
+[[products]]
+name = "nail"
+`
+	tests := []struct {
+		op   EditOp
+		want string
+	}{
+		{EditOp{Op: EditSet, Path: "$.server.port", Value: json.RawMessage(`9090`)}, strings.Replace(doc, "8080", "9090", 1)},
+		{EditOp{Op: EditSet, Path: "$.server.tls", Value: json.RawMessage(`true`)}, strings.Replace(doc, "port = 8080\n", "port = 8080\ntls = true\n", 1)},
+		{EditOp{Op: EditSet, Path: "$.owner", Value: json.RawMessage(`"tom"`)}, strings.Replace(doc, `title = "demo"`+"\n", `title = "demo"`+"\nowner = \"tom\"\n", 1)},
+		{EditOp{Op: EditDelete, Path: "$.server.host"}, strings.Replace(doc, "host = \"localhost\" # 监听地址\n", "", 1)},
+		{EditOp{Op: EditDelete, Path: "$.database"}, strings.Replace(doc, "# 数据库\n[database]\nports = [8000, 8001]\noptions = { timeout = 5 }\n\n", "", 1)},
+		{EditOp{Op: EditInsert, Path: "$.database.ports[0]", Value: json.RawMessage(`7999`)}, strings.Replace(doc, "[8000, 8001]", "[7999, 8000, 8001]", 1)},
+		{EditOp{Op: EditSet, Path: "$.database.options.retries", Value: json.RawMessage(`3`)}, strings.Replace(doc, "{ timeout = 5 }", "{ retries = 3, timeout = 5 }", 1)},
+		{EditOp{Op: EditSet, Path: "$.products[1].name", Value: json.RawMessage(`"screw"`)}, strings.Replace(doc, `"nail"`, `"screw"`, 1)},
+		{EditOp{Op: EditDelete, Path: "$.products[0]"}, strings.Replace(doc, "[[products]]\nname = \"hammer\"\n\n", "", 1)},
+		{EditOp{Op: EditSet, Path: "$.products[2]", Value: json.RawMessage(`{"name": "saw", "price": 9.5}`)}, doc + "\n[[products]]\nname = \"saw\"\nprice = 9.5\n"},
+		{EditOp{Op: EditSet, Path: "$.server.limits", Value: json.RawMessage(`{"rate": 10}`)}, strings.Replace(doc, "# 数据库\n", "[server.limits]\nrate = 10\n\n# 数据库\n", 1)},
+		{EditOp{Op: EditSet, Path: "$.database", Value: json.RawMessage(`{"url": "x"}`)}, strings.Replace(doc, "ports = [8000, 8001]\noptions = { timeout = 5 }\n", "url = \"x\"\n", 1)},
+	}
+	for _, tt := range tests {
+		got, err := applyEdits(StructuredTOML, doc, []EditOp{tt.op})
+		if err != nil {
+			t.Errorf("%+v: %v", tt.op, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s %s:\n got %s\nwant %s", tt.op.Op, tt.op.Path, got, tt.want)
+		}
+	}
+
+	for _, op := range []EditOp{
+		{Op: EditSet, Path: "$.title", Value: json.RawMessage(`null`)},
+		{Op: EditDelete, Path: "$.missing"},
+		{Op: EditInsert, Path: "$.server", Value: json.RawMessage(`{}`)},
+		{Op: EditSet, Path: "$.products[5]", Value: json.RawMessage(`{}`)},
+	} {
+		if _, err := applyEdits(StructuredTOML, doc, []EditOp{op}); err == nil {
+			t.Errorf("%+v succeeded", op)
+		}
+	}
+}
+
+func TestStructuredEdit(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	fullPath := writeTestFile(t, dir, "config.yaml", "# comment\r\nname: demo\r\nport: 80\r\n")
+	info, _ := os.Stat(fullPath)
+
+	var data struct {
+		Format  string
+		Value   map[string]any
+		Version string
+	}
+	decodeResponse(t, doRequest(s.handleStructured, "GET", "/api/structured?root=0&path=/config.yaml", ""), 200, &data)
+	if data.Format != StructuredYAML || data.Value["port"] != float64(80) || data.Version != s.pathVersion(0, fullPath, info) {
+		t.Fatalf("get = %+v", data)
+	}
+
+	edit := func(body string, status int, v any) {
+		t.Helper()
+		decodeResponse(t, doRequest(s.handleStructured, "POST", "/api/structured?root=0", body), status, v)
+	}
+	body := func(version, ops string, dryRun bool) string {
+		return fmt.Sprintf(`{"path": "/config.yaml", "version": %q, "ops": %s, "dryRun": %v}`, version, ops, dryRun)
+	}
+
+	// 预览不写入
+	var preview struct{ Content, Diff string }
+	edit(body(data.Version, `[{"op": "set", "path": "$.port", "value": 8080}]`, true), 200, &preview)
+	if preview.Content != "# comment\nname: demo\nport: 8080\n" || !strings.Contains(preview.Diff, "+port: 8080") {
+		t.Errorf("preview = %+v", preview)
+	}
+
+	var saved struct{ Version string }
+	edit(body(data.Version, `[{"op": "set", "path": "$.port", "value": 8080}]`, false), 200, &saved)
+	content, _ := os.ReadFile(filepath.Join(dir, "config.yaml"))
+	if string(content) != "# comment\r\nname: demo\r\nport: 8080\r\n" {
+		t.Errorf("content = %q", content)
+	}
+
+	// 版本过期、路径无效、操作无法执行和结果无效
+	edit(body(data.Version, `[{"op": "delete", "path": "$.name"}]`, false), 409, nil)
+	edit(body(saved.Version, `[{"op": "delete", "path": "$..name"}]`, false), 400, nil)
+	var failed ValidationFailure
+	edit(body(saved.Version, `[{"op": "delete", "path": "$.name"}, {"op": "delete", "path": "$.nope"}]`, false), 422, &failed)
+	if failed.Op == nil || *failed.Op != 1 {
+		t.Errorf("failed = %+v", failed)
+	}
+
+	writeTestFile(t, dir, "broken.json", "{\n  \"a\": [1,\n}")
+	edit(`{"path": "/broken.json", "version": "*", "ops": [{"op": "set", "path": "$.b", "value": 1}]}`, 422, &failed)
+	if len(failed.Errors) != 1 || failed.Errors[0].Line != 3 || failed.Errors[0].Column != 1 {
+		t.Errorf("broken = %+v", failed)
+	}
+	edit(`{"path": "/notes.txt", "version": "*", "ops": [{"op": "set", "path": "$.b", "value": 1}]}`, 400, nil)
+}
@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// 自动识别分隔符和表头时检查的记录数
+	tableSniffRecords = 20
+	// 每行估算的额外内存（切片头、行号等），用于决定排序时何时写入临时文件
+	tableRowOverhead = 64
+)
+
+// tableSortMemory 排序时在内存中保留的行的大致字节数，超过时分块排序后写入临时文件再归并
+var tableSortMemory = 64 * 1024 * 1024
+
+// tableDelimiters 自动识别时尝试的分隔符
+var tableDelimiters = []rune{',', '\t', ';', '|'}
+
+// TableRow 表格中的一行
+type TableRow struct {
+	Row   int      `json:"row"`  // 数据行的序号（从 1 开始，不含表头）
+	Line  int      `json:"line"` // 在文件中的起始行号，字段内含换行时一行记录占多行
+	Cells []string `json:"cells"`
+}
+
+// TableView 按表格解析的 CSV/TSV 文件
+type TableView struct {
+	Path        string     `json:"path"`
+	Name        string     `json:"name"`
+	Size        int64      `json:"size"`
+	Delimiter   string     `json:"delimiter"` // 使用的分隔符
+	Header      bool       `json:"header"`    // 第一行是否为表头
+	Columns     []string   `json:"columns"`   // 列名，没有表头时为列号
+	Rows        []TableRow `json:"rows"`
+	From        int        `json:"from"`       // 第一行在结果中的序号（从 1 开始）
+	Page        int        `json:"page"`       // 第一行所在的页
+	TotalRows   int        `json:"totalRows"`  // 符合条件的总行数，未读完文件时为 -1
+	TotalPages  int        `json:"totalPages"` // 总页数，总行数未知时为 0
+	More        bool       `json:"more"`       // 之后是否还有符合条件的行
+	Sort        string     `json:"sort,omitempty"`
+	Order       string     `json:"order,omitempty"`
+	Compression string     `json:"compression,omitempty"`
+	Version     string     `json:"version,omitempty"`
+	Encoding    string     `json:"encoding,omitempty"`
+}
+
+// tableQuery 表格的过滤和排序条件
+type tableQuery struct {
+	filters []fieldMatch // 列条件，key 为列名或列号
+	query   string       // 任意单元格包含的文本（小写）
+	sort    string       // 排序的列
+	desc    bool
+}
+
+// parseTableQuery 解析 filter（可重复）、q、sort 和 order 参数
+func parseTableQuery(r *http.Request) (*tableQuery, error) {
+	query := r.URL.Query()
+	q := &tableQuery{query: strings.ToLower(query.Get("q")), sort: query.Get("sort")}
+	for _, filter := range query["filter"] {
+		m, err := parseFieldMatch(filter)
+		if err != nil {
+			return nil, err
+		}
+		q.filters = append(q.filters, m)
+	}
+	switch order := strings.ToLower(query.Get("order")); order {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return nil, fmt.Errorf("invalid order: %s", order)
+	}
+	return q, nil
+}
+
+// parseDelimiter 解析 delimiter 参数，为空或 auto 时返回 0 表示自动识别
+func parseDelimiter(value string) (rune, error) {
+	switch value {
+	case "", "auto":
+		return 0, nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(value)
+	if size != len(value) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+		return 0, fmt.Errorf("invalid delimiter: %s", value)
+	}
+	return r, nil
+}
+
+// newTableReader 创建 CSV 读取器，允许不规范的引号和每行字段数不同
+func newTableReader(r io.Reader, delimiter rune) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = delimiter
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	return reader
+}
+
+// sniffRecords 按指定的分隔符解析开头的记录，开头不是完整的文件时丢弃可能不完整的最后一条
+func sniffRecords(head []byte, delimiter rune, complete bool) [][]string {
+	reader := newTableReader(strings.NewReader(string(head)), delimiter)
+	var records [][]string
+	for len(records) <= tableSniffRecords {
+		record, err := reader.Read()
+		if err != nil {
+			break
+		}
+		records = append(records, record)
+	}
+	if !complete && len(records) > 1 {
+		records = records[:len(records)-1]
+	}
+	return records
+}
+
+// detectDelimiter 根据扩展名和开头的内容识别分隔符：
+// 各行字段数一致且多于一列的分隔符优先，相同时选列数多的
+func detectDelimiter(name string, head []byte, complete bool) rune {
+	// 压缩文件按去掉压缩扩展名后的文件名判断
+	if compressionKind(name) != "" {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".tsv" || ext == ".tab" {
+		return '\t'
+	}
+
+	best, bestScore, bestFields := ',', 0.0, 0
+	for _, delimiter := range tableDelimiters {
+		records := sniffRecords(head, delimiter, complete)
+		if len(records) == 0 || len(records[0]) < 2 {
+			continue
+		}
+		consistent := 0
+		for _, record := range records {
+			if len(record) == len(records[0]) {
+				consistent++
+			}
+		}
+		score := float64(consistent) / float64(len(records))
+		if score > bestScore || (score == bestScore && len(records[0]) > bestFields) {
+			best, bestScore, bestFields = delimiter, score, len(records[0])
+		}
+	}
+	return best
+}
+
+// isNumber 判断单元格是否为数字
+func isNumber(value string) bool {
+	_, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	return err == nil
+}
+
+// detectHeader 判断第一行是否为表头：
+// 逐列比较第一行与其余行，其余行都是数字而第一行不是，或者其余行长度一致而第一行不同，视为表头；
+// 无法判断时第一行各列非空、不是数字且互不相同即视为表头
+func detectHeader(records [][]string) bool {
+	if len(records) == 0 {
+		return false
+	}
+	first := records[0]
+	votes := 0
+	for col, cell := range first {
+		numeric, length := true, -1
+		rows := 0
+		for _, record := range records[1:] {
+			if col >= len(record) {
+				continue
+			}
+			rows++
+			numeric = numeric && isNumber(record[col])
+			switch n := utf8.RuneCountInString(record[col]); {
+			case length == -1:
+				length = n
+			case length != n:
+				length = -2
+			}
+		}
+		switch {
+		case rows == 0:
+		case numeric:
+			if isNumber(cell) {
+				votes--
+			} else {
+				votes++
+			}
+		case length >= 0:
+			if utf8.RuneCountInString(cell) == length {
+				votes--
+			} else {
+				votes++
+			}
+		}
+	}
+	if votes != 0 {
+		return votes > 0
+	}
+
+	seen := make(map[string]bool)
+	for _, cell := range first {
+		if cell == "" || isNumber(cell) || seen[cell] {
+			return false
+		}
+		seen[cell] = true
+	}
+	return true
+}
+
+// resolveColumn 按列名查找列，找不到时按列号（从 1 开始）
+func resolveColumn(columns []string, name string) (int, error) {
+	for i, column := range columns {
+		if column == name {
+			return i, nil
+		}
+	}
+	if n, err := strconv.Atoi(name); err == nil && n >= 1 && n <= len(columns) {
+		return n - 1, nil
+	}
+	return 0, fmt.Errorf("unknown column: %s", name)
+}
+
+// tableMatcher 解析了列号的过滤条件
+type tableMatcher struct {
+	columns []int
+	filters []fieldMatch
+	query   string
+}
+
+// match 判断一行是否符合条件，缺少的单元格按空值处理
+func (m *tableMatcher) match(cells []string) bool {
+	for i, filter := range m.filters {
+		value := ""
+		if col := m.columns[i]; col < len(cells) {
+			value = cells[col]
+		}
+		if !filter.match(value) {
+			return false
+		}
+	}
+	if m.query != "" {
+		for _, cell := range cells {
+			if strings.Contains(strings.ToLower(cell), m.query) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// compareCells 比较排序列的值：数字按大小比较且排在文本之前，文本按字节比较
+func compareCells(a, b string) int {
+	x, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	y, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	switch {
+	case errA == nil && errB == nil:
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+		return 0
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// rowSorter 按一列排序，值相同时保持原有顺序
+type rowSorter struct {
+	col  int
+	desc bool
+}
+
+func (rs rowSorter) cell(row TableRow) string {
+	if rs.col < len(row.Cells) {
+		return row.Cells[rs.col]
+	}
+	return ""
+}
+
+func (rs rowSorter) less(a, b TableRow) bool {
+	c := compareCells(rs.cell(a), rs.cell(b))
+	if rs.desc {
+		c = -c
+	}
+	if c != 0 {
+		return c < 0
+	}
+	return a.Row < b.Row
+}
+
+// externalSorter 外部归并排序：行数据超过 tableSortMemory 时分块排序写入临时文件，最后归并
+type externalSorter struct {
+	sorter rowSorter
+	rows   []TableRow
+	size   int
+	chunks []*os.File
+}
+
+func (es *externalSorter) add(row TableRow) error {
+	es.rows = append(es.rows, row)
+	es.size += tableRowOverhead
+	for _, cell := range row.Cells {
+		es.size += len(cell) + 16
+	}
+	if es.size >= tableSortMemory {
+		return es.flush()
+	}
+	return nil
+}
+
+func (es *externalSorter) sortRows() {
+	sort.Slice(es.rows, func(i, j int) bool { return es.sorter.less(es.rows[i], es.rows[j]) })
+}
+
+// flush 将内存中的行排序后写入临时文件（每行为行序号、行号和各单元格）
+func (es *externalSorter) flush() error {
+	es.sortRows()
+	tmp, err := os.CreateTemp("", "filebrowser-sort-*.csv")
+	if err != nil {
+		return err
+	}
+	es.chunks = append(es.chunks, tmp)
+
+	bw := bufio.NewWriter(tmp)
+	writer := csv.NewWriter(bw)
+	for _, row := range es.rows {
+		record := append([]string{strconv.Itoa(row.Row), strconv.Itoa(row.Line)}, row.Cells...)
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	es.rows, es.size = nil, 0
+	return nil
+}
+
+// close 删除临时文件
+func (es *externalSorter) close() {
+	for _, chunk := range es.chunks {
+		chunk.Close()
+		os.Remove(chunk.Name())
+	}
+}
+
+// read 按排序后的顺序跳过 skip 行后读取 count 行
+func (es *externalSorter) read(skip, count int) ([]TableRow, error) {
+	rows := []TableRow{}
+	if len(es.chunks) == 0 {
+		es.sortRows()
+		if skip < len(es.rows) {
+			rows = append(rows, es.rows[skip:min(skip+count, len(es.rows))]...)
+		}
+		return rows, nil
+	}
+	if len(es.rows) > 0 {
+		if err := es.flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	merge := &chunkHeap{sorter: es.sorter}
+	for _, chunk := range es.chunks {
+		source := &chunkSource{reader: csv.NewReader(bufio.NewReader(chunk))}
+		source.reader.FieldsPerRecord = -1
+		ok, err := source.next()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			merge.sources = append(merge.sources, source)
+		}
+	}
+	heap.Init(merge)
+
+	for merge.Len() > 0 && len(rows) < count {
+		source := merge.sources[0]
+		if skip > 0 {
+			skip--
+		} else {
+			rows = append(rows, source.row)
+		}
+		ok, err := source.next()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			heap.Fix(merge, 0)
+		} else {
+			heap.Pop(merge)
+		}
+	}
+	return rows, nil
+}
+
+// chunkSource 一个已排序的临时文件的当前行
+type chunkSource struct {
+	reader *csv.Reader
+	row    TableRow
+}
+
+func (cs *chunkSource) next() (bool, error) {
+	record, err := cs.reader.Read()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(record) < 2 {
+		return false, fmt.Errorf("corrupt sort chunk")
+	}
+	cs.row.Row, _ = strconv.Atoi(record[0])
+	cs.row.Line, _ = strconv.Atoi(record[1])
+	cs.row.Cells = record[2:]
+	return true, nil
+}
+
+// chunkHeap 按各临时文件的当前行排序的堆
+type chunkHeap struct {
+	sorter  rowSorter
+	sources []*chunkSource
+}
+
+func (h *chunkHeap) Len() int           { return len(h.sources) }
+func (h *chunkHeap) Less(i, j int) bool { return h.sorter.less(h.sources[i].row, h.sources[j].row) }
+func (h *chunkHeap) Swap(i, j int)      { h.sources[i], h.sources[j] = h.sources[j], h.sources[i] }
+func (h *chunkHeap) Push(x any)         { h.sources = append(h.sources, x.(*chunkSource)) }
+func (h *chunkHeap) Pop() any {
+	last := h.sources[len(h.sources)-1]
+	h.sources = h.sources[:len(h.sources)-1]
+	return last
+}
+
+// csvErrorStatus 内容格式错误时返回 422，读取失败时返回 500
+func csvErrorStatus(err error) int {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// handleTable 将 CSV/TSV 文件按表格返回，支持分页、按列过滤和排序
+// 不排序时流式读取，凑满一页后停止（小文件读完以给出总行数）；排序时读取全部符合条件的行，超过内存限制时使用外部归并排序
+func (s *Server) handleTable(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		s.handleError(w, fmt.Errorf("path parameter is required"), http.StatusBadRequest)
+		return
+	}
+
+	rootIndex := getRootIndex(r)
+	config := s.viewConfig(rootIndex)
+	lr, err := parseLineRange(r, config)
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+	tq, err := parseTableQuery(r)
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+	delimiter, err := parseDelimiter(r.URL.Query().Get("delimiter"))
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+	headerParam := r.URL.Query().Get("header")
+	if headerParam != "" && headerParam != "auto" && headerParam != "true" && headerParam != "false" {
+		s.handleError(w, fmt.Errorf("invalid header: %s", headerParam), http.StatusBadRequest)
+		return
+	}
+	encodingName := r.URL.Query().Get("encoding")
+
+	// 构建完整路径
+	fullPath := s.getFullPath(path, rootIndex)
+
+	// 检查路径是否在根目录内
+	if !s.isPathSafe(fullPath, rootIndex) {
+		s.handleError(w, fmt.Errorf("access denied"), http.StatusForbidden)
+		return
+	}
+
+	// 打开文件（支持归档内的文件）
+	file, info, err := s.openFile(rootIndex, fullPath)
+	if err != nil {
+		s.handleError(w, err, errorStatus(err))
+		return
+	}
+	defer file.Close()
+
+	// 二进制文件不能按表格查看；手动指定编码时按文本处理
+	kind := compressionKind(info.Name())
+	if encodingName == "" && kind == "" {
+		binary, mimeType, err := sniffBinary(file)
+		if err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if binary {
+			s.binaryFile(w, mimeType)
+			return
+		}
+	}
+
+	// 压缩文件解压后解析
+	var reader io.Reader = file
+	if kind != "" {
+		rc, err := newDecompressor(kind, file)
+		if err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		defer rc.Close()
+		reader = rc
+	}
+
+	reader, te, err := s.decodeText(rootIndex, reader, encodingName)
+	if err != nil {
+		s.handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// 根据开头的内容识别分隔符和表头
+	br := bufio.NewReaderSize(reader, encodingSniffLen)
+	head, peekErr := br.Peek(encodingSniffLen)
+	complete := peekErr != nil
+	if delimiter == 0 {
+		delimiter = detectDelimiter(info.Name(), head, complete)
+	}
+	header := headerParam == "true"
+	if headerParam == "" || headerParam == "auto" {
+		header = detectHeader(sniffRecords(head, delimiter, complete))
+	}
+
+	csvReader := newTableReader(br, delimiter)
+	view := TableView{
+		Path:        fullPath,
+		Name:        info.Name(),
+		Size:        info.Size(),
+		Delimiter:   string(delimiter),
+		Header:      header,
+		Rows:        []TableRow{},
+		From:        lr.start + 1,
+		Page:        lr.page(config.LinesPerPage),
+		TotalRows:   -1,
+		Sort:        tq.sort,
+		Compression: kind,
+		Version:     s.fileVersion(rootIndex, fullPath, file, info),
+		Encoding:    te.name,
+	}
+	if tq.sort != "" {
+		view.Order = "asc"
+		if tq.desc {
+			view.Order = "desc"
+		}
+	}
+
+	// 读取第一条记录以确定列
+	first, err := csvReader.Read()
+	if err != nil && err != io.EOF {
+		s.handleError(w, err, csvErrorStatus(err))
+		return
+	}
+	firstLine, _ := csvReader.FieldPos(0)
+	var pendingRow []string
+	if header {
+		view.Columns = first
+		for i, column := range view.Columns {
+			if column == "" {
+				view.Columns[i] = strconv.Itoa(i + 1)
+			}
+		}
+	} else if first != nil {
+		pendingRow = first
+	}
+	for i := len(view.Columns); i < len(first); i++ {
+		view.Columns = append(view.Columns, strconv.Itoa(i+1))
+	}
+	if view.Columns == nil {
+		view.Columns = []string{}
+	}
+
+	matcher := &tableMatcher{filters: tq.filters, query: tq.query}
+	for _, filter := range tq.filters {
+		col, err := resolveColumn(view.Columns, filter.key)
+		if err != nil {
+			s.handleError(w, err, http.StatusBadRequest)
+			return
+		}
+		matcher.columns = append(matcher.columns, col)
+	}
+
+	var sorter *externalSorter
+	if tq.sort != "" {
+		col, err := resolveColumn(view.Columns, tq.sort)
+		if err != nil {
+			s.handleError(w, err, http.StatusBadRequest)
+			return
+		}
+		sorter = &externalSorter{sorter: rowSorter{col: col, desc: tq.desc}}
+		defer sorter.close()
+	}
+
+	// 不排序的大文件凑满一页后停止读取
+	readAll := sorter != nil || (kind == "" && info.Size() <= config.MaxFileSize)
+	rowNumber, matched := 0, 0
+	for {
+		var cells []string
+		line := firstLine
+		if pendingRow != nil {
+			cells, pendingRow = pendingRow, nil
+		} else {
+			cells, err = csvReader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				s.handleError(w, err, csvErrorStatus(err))
+				return
+			}
+			line, _ = csvReader.FieldPos(0)
+		}
+		rowNumber++
+		if !matcher.match(cells) {
+			continue
+		}
+		row := TableRow{Row: rowNumber, Line: line, Cells: cells}
+
+		if sorter != nil {
+			if err := sorter.add(row); err != nil {
+				s.handleError(w, err, http.StatusInternalServerError)
+				return
+			}
+			matched++
+			continue
+		}
+
+		matched++
+		switch {
+		case matched <= lr.start:
+		case len(view.Rows) < lr.count:
+			view.Rows = append(view.Rows, row)
+		default:
+			view.More = true
+		}
+		if view.More && !readAll {
+			break
+		}
+	}
+
+	if sorter != nil {
+		rows, err := sorter.read(lr.start, lr.count)
+		if err != nil {
+			s.handleError(w, err, http.StatusInternalServerError)
+			return
+		}
+		view.Rows = rows
+		view.More = lr.start+len(rows) < matched
+	}
+	if !view.More || readAll {
+		view.TotalRows = matched
+		view.TotalPages = max(1, (matched+config.LinesPerPage-1)/config.LinesPerPage)
+	}
+
+	s.writeJSON(w, view)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDetectTable(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		delimiter rune
+		header    bool
+	}{
+		{"a.csv", "name,age\nalice,30\nbob,4\n", ',', true},
+		{"a.csv", "1,2,3\n4,5,6\n", ',', false},
+		{"a.txt", "x;y;z\n1;2;3\n4;5;6\n", ';', true},
+		{"a.tsv", "name\tage\nalice\t30\nbob\t4\n", '\t', true},
+		{"a.tsv", "a b\tc\nd e\tf\n", '\t', false},
+		{"a.tsv.gz", "", '\t', false},
+		{"a.csv", "city|country\nparis|france\nberlin|germany\n", '|', true},
+		{"a.csv", "id,note\n1,\"multi\nline, with comma\"\n2,plain\n", ',', true},
+		{"a.csv", "code,name\nAB,alpha\nCD,beta\n", ',', true},
+		{"a.csv", "AB,alpha\nCD,beta\nEF,gamma\n", ',', false},
+	}
+	for _, tt := range tests {
+		delimiter := detectDelimiter(tt.name, []byte(tt.text), true)
+		header := detectHeader(sniffRecords([]byte(tt.text), delimiter, true))
+		if delimiter != tt.delimiter || header != tt.header {
+			t.Errorf("%s %q: delimiter=%q header=%v, want %q %v", tt.name, tt.text, delimiter, header, tt.delimiter, tt.header)
+		}
+	}
+}
+
+func TestTableView(t *testing.T) {
+	s, dir := newTestServer(t, func(config *Config) {
+		config.View = ViewConfig{LinesPerPage: 5, MaxFileSize: 100}
+	})
+
+	var text strings.Builder
+	text.WriteString("id,name,score,note\n")
+	for i := 1; i <= 20; i++ {
+		note := "ok"
+		if i%7 == 0 {
+			note = "\"needs\nreview, \"\"urgent\"\"\""
+		}
+		fmt.Fprintf(&text, "%d,user%02d,%d,%s\n", i, i, (i*37)%50, note)
+	}
+	writeTestFile(t, dir, "data.csv", text.String())
+
+	table := func(query string) TableView {
+		t.Helper()
+		var view TableView
+		decodeResponse(t, doRequest(s.handleTable, "GET", "/api/table?root=0&path=/data.csv&"+query, ""), 200, &view)
+		return view
+	}
+
+	// 大文件不排序时凑满一页即停止，总行数未知
+	v := table("page=2")
+	if !v.Header || v.Delimiter != "," || strings.Join(v.Columns, "|") != "id|name|score|note" {
+		t.Fatalf("columns = %q header=%v delimiter=%q", v.Columns, v.Header, v.Delimiter)
+	}
+	if len(v.Rows) != 5 || v.Rows[0].Row != 6 || v.Rows[0].Line != 7 || !v.More || v.TotalRows != -1 || v.Page != 2 {
+		t.Errorf("page 2 = %+v more=%v total=%d", v.Rows, v.More, v.TotalRows)
+	}
+	// 引号内的换行和引号
+	if r := v.Rows[1]; r.Row != 7 || r.Cells[3] != "needs\nreview, \"urgent\"" {
+		t.Errorf("quoted row = %+v", r)
+	}
+	if r := v.Rows[2]; r.Row != 8 || r.Line != 10 {
+		t.Errorf("row after multi-line field = %+v", r)
+	}
+
+	// 过滤
+	v = table("filter=note~urgent")
+	if len(v.Rows) != 2 || v.Rows[1].Cells[0] != "14" || v.More || v.TotalRows != 2 {
+		t.Errorf("filter note~urgent = %+v total=%d", v.Rows, v.TotalRows)
+	}
+	v = table("filter=2:user03&q=OK")
+	if len(v.Rows) != 1 || v.Rows[0].Cells[0] != "3" {
+		t.Errorf("filter by column number = %+v", v.Rows)
+	}
+
+	// 排序：数字按大小，值相同时保持原有顺序
+	v = table("sort=score&order=desc&from=1&count=3")
+	if got := v.Rows[0].Cells[2] + " " + v.Rows[1].Cells[2] + " " + v.Rows[2].Cells[2]; got != "48 46 44" || v.TotalRows != 20 || v.TotalPages != 4 || !v.More || v.Order != "desc" {
+		t.Errorf("sort desc = %s total=%d more=%v", got, v.TotalRows, v.More)
+	}
+	v = table("sort=name&page=4")
+	if len(v.Rows) != 5 || v.Rows[4].Cells[1] != "user20" || v.More {
+		t.Errorf("sort by name last page = %+v", v.Rows)
+	}
+
+	decodeResponse(t, doRequest(s.handleTable, "GET", "/api/table?root=0&path=/data.csv&sort=missing", ""), 400, nil)
+	decodeResponse(t, doRequest(s.handleTable, "GET", "/api/table?root=0&path=/data.csv&filter=score", ""), 400, nil)
+	decodeResponse(t, doRequest(s.handleTable, "GET", "/api/table?root=0&path=/data.csv&order=up", ""), 400, nil)
+	decodeResponse(t, doRequest(s.handleTable, "GET", "/api/table?root=0&path=/data.csv&delimiter=ab", ""), 400, nil)
+
+	// 指定分隔符和无表头
+	writeTestFile(t, dir, "plain.txt", "b;2\na;10\nc;1\n")
+	var plain TableView
+	decodeResponse(t, doRequest(s.handleTable, "GET", "/api/table?root=0&path=/plain.txt&delimiter=;&header=false&sort=2", ""), 200, &plain)
+	if plain.Header || strings.Join(plain.Columns, "|") != "1|2" || len(plain.Rows) != 3 || plain.Rows[0].Cells[0] != "c" || plain.Rows[0].Line != 3 {
+		t.Errorf("plain = %+v", plain)
+	}
+}
+
+func TestTableExternalSort(t *testing.T) {
+	defer func(n int) { tableSortMemory = n }(tableSortMemory)
+	tableSortMemory = 1024
+
+	s, dir := newTestServer(t, nil)
+	var text strings.Builder
+	text.WriteString("n,label\n")
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&text, "%d,\"row\n%d\"\n", (i*7919)%500, i)
+	}
+	writeTestFile(t, dir, "big.csv", text.String())
+
+	var view TableView
+	decodeResponse(t, doRequest(s.handleTable, "GET", "/api/table?root=0&path=/big.csv&sort=n&from=248&count=5", ""), 200, &view)
+	if view.TotalRows != 500 || len(view.Rows) != 5 {
+		t.Fatalf("total=%d rows=%d", view.TotalRows, len(view.Rows))
+	}
+	for i, row := range view.Rows {
+		if row.Cells[0] != fmt.Sprint(247+i) || !strings.HasPrefix(row.Cells[1], "row\n") || row.Line != 2*row.Row {
+			t.Errorf("row %d = %+v", i, row)
+		}
+	}
+}
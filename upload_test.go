@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testUploadFile 上传表单中的一个文件
+type testUploadFile struct {
+	filename     string
+	content      string
+	relativePath string
+	lastModified string
+}
+
+// uploadTestFiles 以 multipart 表单上传文件，fields 为额外的表单字段
+func uploadTestFiles(t *testing.T, s *Server, fields map[string]string, files ...testUploadFile) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for key, value := range fields {
+		mw.WriteField(key, value)
+	}
+	for _, f := range files {
+		mw.WriteField("relativePath", f.relativePath)
+		mw.WriteField("lastModified", f.lastModified)
+		w, err := mw.CreateFormFile("file", f.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(f.content))
+	}
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/api/upload?root=0", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	s.handleUpload(rec, req)
+	return rec
+}
+
+// uploadResponse 批量上传的响应
+type uploadResponse struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message"`
+	Results []UploadResult `json:"results"`
+}
+
+func TestSplitRelativePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    []string
+		wantErr bool
+	}{
+		{"a.txt", []string{"a.txt"}, false},
+		{"dir/sub/a.txt", []string{"dir", "sub", "a.txt"}, false},
+		{`dir\sub\a.txt`, []string{"dir", "sub", "a.txt"}, false},
+		{"/abs/a.txt", nil, true},
+		{"dir/../a.txt", nil, true},
+		{"./a.txt", nil, true},
+		{"dir//a.txt", nil, true},
+		{"dir/", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitRelativePath(tt.name)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitRelativePath(%q) = %v, %v; want %v, wantErr %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFolderUpload(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	writeTestFile(t, dir, "dest/project/blocker", "a file where a directory is needed")
+
+	var resp uploadResponse
+	decodeResponse(t, uploadTestFiles(t, s, map[string]string{"path": "/dest"},
+		testUploadFile{filename: "a.txt", content: "a", relativePath: "project/a.txt"},
+		testUploadFile{filename: "b.txt", content: "b", relativePath: "project/src/deep/b.txt"},
+		testUploadFile{filename: "c.txt", content: "c", relativePath: "project/../../c.txt"},
+		testUploadFile{filename: "d.txt", content: "d", relativePath: "project/blocker/d.txt"},
+		testUploadFile{filename: "plain.txt", content: "p"},
+	), 200, &resp)
+
+	wantSuccess := map[string]bool{
+		"project/a.txt":          true,
+		"project/src/deep/b.txt": true,
+		"project/../../c.txt":    false,
+		"project/blocker/d.txt":  false,
+		"plain.txt":              true,
+	}
+	if resp.Success || len(resp.Results) != len(wantSuccess) {
+		t.Fatalf("response = %+v", resp)
+	}
+	for _, result := range resp.Results {
+		if want, ok := wantSuccess[result.Path]; !ok || result.Success != want {
+			t.Errorf("%s success = %v (%s), want %v", result.Path, result.Success, result.Error, want)
+		}
+		if !result.Success && filepath.IsAbs(result.Error) {
+			t.Errorf("%s error exposes a server path: %s", result.Path, result.Error)
+		}
+	}
+
+	for name, want := range map[string]string{"project/a.txt": "a", "project/src/deep/b.txt": "b", "plain.txt": "p"} {
+		if got := readTestFile(t, filepath.Join(dir, "dest", filepath.FromSlash(name))); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "c.txt")); err == nil {
+		t.Errorf("c.txt was written outside the root")
+	}
+}
+
+func TestFolderUploadSingleFileError(t *testing.T) {
+	s, _ := newTestServer(t, nil)
+	rec := uploadTestFiles(t, s, nil, testUploadFile{filename: "x.txt", content: "x", relativePath: "../x.txt"})
+	if rec.Code != 400 {
+		t.Errorf("status = %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestNumberedName(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		want string
+	}{
+		{"/d/report.txt", 1, "/d/report (1).txt"},
+		{"/d/report.txt", 12, "/d/report (12).txt"},
+		{"/d/Makefile", 2, "/d/Makefile (2)"},
+		{"/d/.bashrc", 1, "/d/.bashrc (1)"},
+		{"/d/.config.json", 1, "/d/.config (1).json"},
+	}
+	for _, tt := range tests {
+		if got := numberedName(filepath.FromSlash(tt.name), tt.n); got != filepath.FromSlash(tt.want) {
+			t.Errorf("numberedName(%q, %d) = %q, want %q", tt.name, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestUploadOverwriteModes(t *testing.T) {
+	tests := []struct {
+		overwrite   string
+		wantCode    int
+		wantSkipped bool
+		wantSavedAs string
+		wantOld     string
+	}{
+		{"", 409, false, "", "old"},
+		{OverwriteFail, 409, false, "", "old"},
+		{OverwriteSkip, 200, true, "", "old"},
+		{OverwriteReplace, 200, false, "", "new"},
+		{OverwriteRename, 200, false, "sub/a (2).txt", "old"},
+		{"merge", 400, false, "", "old"},
+	}
+	for _, tt := range tests {
+		t.Run("mode "+tt.overwrite, func(t *testing.T) {
+			s, dir := newTestServer(t, nil)
+			existing := writeTestFile(t, dir, "sub/a.txt", "old")
+			writeTestFile(t, dir, "sub/a (1).txt", "taken")
+			if err := os.Chmod(existing, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			// 两个文件时返回逐个文件的结果
+			rec := uploadTestFiles(t, s, map[string]string{"overwrite": tt.overwrite},
+				testUploadFile{filename: "a.txt", content: "new", relativePath: "sub/a.txt"},
+				testUploadFile{filename: "other.txt", content: "other"},
+			)
+			if tt.wantCode != 200 {
+				single := uploadTestFiles(t, s, map[string]string{"overwrite": tt.overwrite},
+					testUploadFile{filename: "a.txt", content: "new", relativePath: "sub/a.txt"})
+				if single.Code != tt.wantCode {
+					t.Errorf("single file status = %d, want %d", single.Code, tt.wantCode)
+				}
+			}
+			if tt.wantCode == 400 {
+				if rec.Code != 400 {
+					t.Errorf("status = %d, want 400", rec.Code)
+				}
+				return
+			}
+
+			var resp uploadResponse
+			decodeResponse(t, rec, 200, &resp)
+			result := resp.Results[0]
+			if result.Skipped != tt.wantSkipped || result.SavedAs != tt.wantSavedAs || result.Success != (tt.wantCode == 200) {
+				t.Errorf("result = %+v", result)
+			}
+			if got := readTestFile(t, existing); got != tt.wantOld {
+				t.Errorf("existing file = %q, want %q", got, tt.wantOld)
+			}
+			if tt.overwrite == OverwriteReplace {
+				if info, err := os.Stat(existing); err != nil || info.Mode().Perm() != 0600 {
+					t.Errorf("mode after overwrite = %v, want 0600", info.Mode().Perm())
+				}
+			}
+			if tt.wantSavedAs != "" {
+				if got := readTestFile(t, filepath.Join(dir, filepath.FromSlash(tt.wantSavedAs))); got != "new" {
+					t.Errorf("renamed file = %q", got)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadLastModified(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	modTime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	decodeResponse(t, uploadTestFiles(t, s, nil,
+		testUploadFile{filename: "a.txt", content: "a", lastModified: strconv.FormatInt(modTime.UnixMilli(), 10)},
+		testUploadFile{filename: "b.txt", content: "b"},
+	), 200, nil)
+	if info, err := os.Stat(filepath.Join(dir, "a.txt")); err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("a.txt modTime = %v, want %v", info.ModTime(), modTime)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "b.txt")); err != nil || info.ModTime().Equal(modTime) {
+		t.Errorf("b.txt should keep the current time")
+	}
+
+	rec := uploadTestFiles(t, s, nil, testUploadFile{filename: "c.txt", content: "c", lastModified: "yesterday"})
+	if rec.Code != 400 {
+		t.Errorf("invalid lastModified status = %d, want 400", rec.Code)
+	}
+}
+
+func TestUploadChecksDestinationFirst(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+
+	// upload 按给定顺序写入字段和文件，name 为 "file" 的项作为文件
+	upload := func(root string, parts ...[2]string) int {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for _, part := range parts {
+			if part[0] == "file" {
+				w, _ := mw.CreateFormFile("file", "a.txt")
+				w.Write([]byte(part[1]))
+			} else {
+				mw.WriteField(part[0], part[1])
+			}
+		}
+		mw.Close()
+
+		req := httptest.NewRequest("POST", "/api/upload?root="+root, &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		s.handleUpload(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name  string
+		root  string
+		parts [][2]string
+		want  int
+	}{
+		{"invalid root", "5", [][2]string{{"path", "/"}, {"file", "a"}}, 400},
+		{"negative root", "-1", [][2]string{{"path", "/"}, {"file", "a"}}, 400},
+		{"escaping path", "0", [][2]string{{"path", "/../x"}, {"file", "a"}}, 403},
+		{"staging dir", "0", [][2]string{{"path", "/" + defaultStagingDir}, {"file", "a"}}, 403},
+		{"path after file", "0", [][2]string{{"file", "a"}, {"path", "/sub"}}, 400},
+	}
+	for _, tt := range tests {
+		if got := upload(tt.root, tt.parts...); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// 被拒绝的上传不会在根目录内留下任何文件
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.Name() != defaultStagingDir {
+			t.Errorf("unexpected entry %s", entry.Name())
+		}
+	}
+	if parts, _ := os.ReadDir(filepath.Join(dir, defaultStagingDir)); len(parts) != 0 {
+		t.Errorf("spooled files left: %d", len(parts))
+	}
+
+	if got := upload("0", [2]string{"path", "/"}, [2]string{"file", "a"}); got != 200 {
+		t.Errorf("upload status = %d", got)
+	}
+}
+
+func TestUploadOverwriteThroughSymlink(t *testing.T) {
+	s, dir := newTestServer(t, nil)
+	target := writeTestFile(t, dir, "real.txt", "old")
+	if err := os.Chmod(target, 0640); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Symlink("real.txt", link); err != nil {
+		t.Fatal(err)
+	}
+	outside := writeTestFile(t, t.TempDir(), "secret.txt", "secret")
+	if err := os.Symlink(outside, filepath.Join(dir, "out.txt")); err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]string{"overwrite": OverwriteReplace}
+
+	// 替换链接指向的文件，链接和文件权限保持不变
+	decodeResponse(t, uploadTestFiles(t, s, fields, testUploadFile{filename: "link.txt", content: "new"}), 200, nil)
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link replaced: %v", err)
+	}
+	info, err := os.Stat(target)
+	if err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("target mode = %v, %v", info.Mode(), err)
+	}
+	if got := readTestFile(t, target); got != "new" {
+		t.Errorf("target content = %q", got)
+	}
+
+	// 指向根目录之外的链接不能覆盖
+	decodeResponse(t, uploadTestFiles(t, s, fields, testUploadFile{filename: "out.txt", content: "x"}), 403, nil)
+	if got := readTestFile(t, outside); got != "secret" {
+		t.Errorf("outside content = %q", got)
+	}
+}